@@ -0,0 +1,126 @@
+package usim_go
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Authentication and Key Management for Applications of TS 33.535
+
+// AKMAContext holds the AKMA keys of a subscriber, on the UE or in the AAnF
+type AKMAContext struct {
+	SUPI  string
+	KAKMA []byte
+	ATID  []byte
+	AKID  string
+}
+
+// AKMASUPI returns the SUPI used as KDF input, the IMSI digits for "imsi-" SUPIs
+func AKMASUPI(supi string) string {
+	return strings.TrimPrefix(supi, "imsi-")
+}
+
+// DeriveKAKMA derives KAKMA from KAUSF, see TS 33.535 A.2
+func DeriveKAKMA(kausf []byte, supi string) []byte {
+	return KDF(kausf, FC_KAKMA, []byte("AKMA"), []byte(AKMASUPI(supi)))
+}
+
+// DeriveATID derives A-TID from KAUSF, see TS 33.535 A.3
+func DeriveATID(kausf []byte, supi string) []byte {
+	return KDF(kausf, FC_A_TID, []byte("A-TID"), []byte(AKMASUPI(supi)))
+}
+
+// DeriveKAF derives KAF for the AF_ID (FQDN || Ua* protocol id, see NAFID), see TS 33.535 A.4
+func DeriveKAF(kakma []byte, afID []byte) []byte {
+	return KDF(kakma, FC_KAF, afID)
+}
+
+// AKID returns the A-KID "RID.A-TID@realm" with the base64url A-TID and the
+// home network realm, see TS 33.535 6.1
+func AKID(rid string, atid []byte, mcc, mnc string) string {
+	return rid + "." + base64.RawURLEncoding.EncodeToString(atid) + "@5gc." + HomeNetworkDomain(mcc, mnc)
+}
+
+// ParseAKID splits an A-KID into the routing indicator, A-TID and realm
+func ParseAKID(akid string) (rid string, atid []byte, realm string, err error) {
+	at := strings.LastIndexByte(akid, '@')
+	dot := strings.IndexByte(akid, '.')
+	if at < 0 || dot < 0 || dot > at {
+		err = fmt.Errorf("AKMA: malformed A-KID %q", akid)
+		return
+	}
+	rid, realm = akid[:dot], akid[at+1:]
+	if atid, err = base64.RawURLEncoding.DecodeString(akid[dot+1 : at]); err != nil {
+		err = fmt.Errorf("AKMA: malformed A-TID in %q", akid)
+	}
+	return
+}
+
+// NewAKMAContext derives the AKMA context from KAUSF
+func NewAKMAContext(kausf []byte, supi, rid, mcc, mnc string) *AKMAContext {
+	c := &AKMAContext{
+		SUPI:  supi,
+		KAKMA: DeriveKAKMA(kausf, supi),
+		ATID:  DeriveATID(kausf, supi),
+	}
+	c.AKID = AKID(rid, c.ATID, mcc, mnc)
+	return c
+}
+
+// KAF derives the key of the application function fqdn with the Ua* protocol id
+func (c *AKMAContext) KAF(fqdn string, uaProtocol [5]byte) []byte {
+	return DeriveKAF(c.KAKMA, NAFID(fqdn, uaProtocol))
+}
+
+// AKMAAuthenticate runs 5G AKA on u for the serving network snName and
+// derives KAUSF and the AKMA context
+func (u *USIM) AKMAAuthenticate(rand, autn [16]byte, snName, rid string) (c *AKMAContext, kausf, resStar []byte, err error) {
+	if resStar, kausf, _, err = u.GenAuth5G(rand, autn, snName); err != nil {
+		return
+	}
+	c = NewAKMAContext(kausf, "imsi-"+u.IMSI(), rid, u.mccStr, u.mncStr)
+	return
+}
+
+// AAnF is an AKMA anchor function stand-in holding the contexts pushed by
+// the AUSF and answering key requests of AFs.
+type AAnF struct {
+	mu       sync.Mutex
+	contexts map[string]*AKMAContext
+}
+
+func NewAAnF() *AAnF {
+	return &AAnF{contexts: map[string]*AKMAContext{}}
+}
+
+// Register stores the context derived from KAUSF after primary authentication
+// and returns the A-KID, see TS 33.535 6.1
+func (a *AAnF) Register(kausf []byte, supi, rid, mcc, mnc string) string {
+	c := NewAKMAContext(kausf, supi, rid, mcc, mnc)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for akid, old := range a.contexts {
+		if old.SUPI == supi {
+			delete(a.contexts, akid)
+		}
+	}
+	a.contexts[c.AKID] = c
+	logrus.Debugf("AAnF: stored AKMA context %s for %s", c.AKID, supi)
+	return c.AKID
+}
+
+// AFKey returns KAF and the SUPI for an AF request with A-KID, see TS 33.535 6.2
+func (a *AAnF) AFKey(akid string, fqdn string, uaProtocol [5]byte) (kaf []byte, supi string, err error) {
+	a.mu.Lock()
+	c := a.contexts[akid]
+	a.mu.Unlock()
+	if c == nil {
+		return nil, "", errors.New("AKMA: unknown A-KID " + akid)
+	}
+	return c.KAF(fqdn, uaProtocol), c.SUPI, nil
+}
@@ -0,0 +1,45 @@
+package usim_go
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestAKMA(t *testing.T) {
+	u, store := testSubscriber(t)
+	snName := ServingNetworkName("208", "93")
+	var v HEAV
+	if err := store.Lookup("208930000000001", func(a *AuC) (err error) {
+		v, err = a.GenHEAV(snName)
+		return
+	}); err != nil {
+		t.Fatal(err)
+	}
+	ue, kausf, _, err := u.AKMAAuthenticate(v.RAND, v.AUTN, snName, "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(kausf, v.KAUSF[:]) {
+		t.Fatalf("KAUSF mismatch %x != %x", kausf, v.KAUSF)
+	}
+	aanf := NewAAnF()
+	akid := aanf.Register(v.KAUSF[:], "imsi-208930000000001", "0", "208", "93")
+	if akid != ue.AKID {
+		t.Fatalf("A-KID mismatch %s != %s", akid, ue.AKID)
+	}
+	rid, atid, realm, err := ParseAKID(akid)
+	if err != nil || rid != "0" || !bytes.Equal(atid, ue.ATID) || realm != "5gc.mnc093.mcc208.3gppnetwork.org" {
+		t.Errorf("unexpected A-KID parts %s %x %s %v", rid, atid, realm, err)
+	}
+	proto := GBATLSProtocolID(0x00ae)
+	kaf, supi, err := aanf.AFKey(akid, "af.example.org", proto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if supi != "imsi-208930000000001" || !bytes.Equal(kaf, ue.KAF("af.example.org", proto)) {
+		t.Errorf("KAF mismatch for %s", supi)
+	}
+	if _, _, err = aanf.AFKey("0.AAAA@5gc.mnc093.mcc208.3gppnetwork.org", "af.example.org", proto); err == nil {
+		t.Error("unknown A-KID accepted")
+	}
+}
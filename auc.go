@@ -0,0 +1,251 @@
+package usim_go
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/free5gc/milenage"
+	"github.com/sirupsen/logrus"
+)
+
+// SQN_IND_LEN is the length of the IND field of SQN = SEQ || IND, see TS 33.102 Annex C.3.2
+const SQN_IND_LEN = 5
+
+// AuC generates authentication vectors for a single subscriber using Milenage
+type AuC struct {
+	k   [16]byte
+	opc [16]byte
+	amf [2]byte
+	sqn uint64
+	// Rand is the source of RAND, crypto/rand if nil
+	Rand io.Reader
+}
+
+// UMTS authentication vector, see TS 33.102 6.3.2
+type Quintet struct {
+	RAND [16]byte
+	XRES []byte
+	CK   [16]byte
+	IK   [16]byte
+	AUTN [16]byte
+}
+
+// E-UTRAN authentication vector, see TS 33.401 6.1.1
+type EUTRANVector struct {
+	RAND  [16]byte
+	XRES  []byte
+	AUTN  [16]byte
+	KASME [32]byte
+}
+
+// 5G home environment authentication vector, see TS 33.501 6.1.3.2
+type HEAV struct {
+	RAND     [16]byte
+	AUTN     [16]byte
+	XRESStar [16]byte
+	KAUSF    [32]byte
+}
+
+// GSM authentication triplet
+type Triplet struct {
+	RAND [16]byte
+	SRES [4]byte
+	Kc   [8]byte
+}
+
+// InitAuC creates an AuC from hex encoded K, OP or OPc and AMF. SQN is the
+// next sequence number to use.
+func InitAuC(k string, op string, opc string, amf string, sqn uint64) (a AuC, err error) {
+	if a.k, err = convert_k(k); err != nil {
+		err = errors.New("failed convert k")
+		return
+	}
+	if len(opc) == 32 {
+		if a.opc, err = convert_op(opc); err != nil {
+			err = errors.New("failed convert opc")
+			return
+		}
+	} else if len(op) == 32 {
+		var op_ [16]byte
+		if op_, err = convert_op(op); err != nil {
+			err = errors.New("failed convert op")
+			return
+		}
+		var opc_ []byte
+		if opc_, err = milenage.GenerateOPC(a.k[:], op_[:]); err != nil {
+			return
+		}
+		copy(a.opc[:], opc_)
+	} else {
+		err = errors.New("either op or opc is required")
+		return
+	}
+	var amf_ []byte
+	if amf_, err = hex.DecodeString(amf); err != nil || len(amf_) != 2 {
+		err = errors.New("failed convert amf")
+		return
+	}
+	copy(a.amf[:], amf_)
+	a.sqn = sqn & 0xFFFFFFFFFFFF
+	return
+}
+
+// SQN returns the sequence number the next vector will use
+func (a AuC) SQN() uint64 {
+	return a.sqn
+}
+
+func (a *AuC) nextSQN() (sqn [6]byte) {
+	putSQN(sqn[:], a.sqn)
+	a.sqn = (a.sqn + 1<<SQN_IND_LEN) & 0xFFFFFFFFFFFF
+	return
+}
+
+func (a *AuC) genRand() (r [16]byte, err error) {
+	src := a.Rand
+	if src == nil {
+		src = rand.Reader
+	}
+	_, err = io.ReadFull(src, r[:])
+	return
+}
+
+// GenQuintet generates a UMTS authentication vector and advances SQN
+func (a *AuC) GenQuintet() (q Quintet, err error) {
+	if q.RAND, err = a.genRand(); err != nil {
+		return
+	}
+	sqn := a.nextSQN()
+	q.XRES = make([]byte, 8)
+	ak := make([]byte, AK_LEN)
+	macA := make([]byte, MAC_LEN)
+	if err = milenage.F1(a.opc[:], a.k[:], q.RAND[:], sqn[:], a.amf[:], macA, nil); err != nil {
+		return
+	}
+	if err = milenage.F2345(a.opc[:], a.k[:], q.RAND[:], q.XRES, q.CK[:], q.IK[:], ak, nil); err != nil {
+		return
+	}
+	for i := 0; i < SQN_LEN; i++ {
+		q.AUTN[i] = sqn[i] ^ ak[i]
+	}
+	copy(q.AUTN[6:8], a.amf[:])
+	copy(q.AUTN[8:], macA)
+	logrus.Debugf("AuC: generated vector SQN=%X RAND=%X", sqn, q.RAND)
+	return
+}
+
+// GenEUTRANVector generates an E-UTRAN authentication vector for the serving network mcc/mnc.
+// The AMF separation bit is set as required by TS 33.401 6.1.1.
+func (a *AuC) GenEUTRANVector(mcc, mnc string) (v EUTRANVector, err error) {
+	var mcc_, mnc_ uint16
+	if mcc_, mnc_, err = convert_mcc_mnc(mcc, mnc); err != nil {
+		return
+	}
+	amf := a.amf
+	a.amf[0] |= 0x80
+	q, err := a.GenQuintet()
+	a.amf = amf
+	if err != nil {
+		return
+	}
+	v.RAND, v.XRES, v.AUTN = q.RAND, q.XRES, q.AUTN
+	copy(v.KASME[:], DeriveKASME(q.CK[:], q.IK[:], encode_plmn(mcc_, mnc_), q.AUTN[:6]))
+	return
+}
+
+// GenHEAV generates a 5G HE AV for the serving network name snName, see ServingNetworkName
+func (a *AuC) GenHEAV(snName string) (v HEAV, err error) {
+	amf := a.amf
+	a.amf[0] |= 0x80
+	q, err := a.GenQuintet()
+	a.amf = amf
+	if err != nil {
+		return
+	}
+	v.RAND, v.AUTN = q.RAND, q.AUTN
+	copy(v.XRESStar[:], DeriveRESStar(q.CK[:], q.IK[:], snName, q.RAND[:], q.XRES))
+	copy(v.KAUSF[:], DeriveKAUSF(q.CK[:], q.IK[:], snName, q.AUTN[:6]))
+	return
+}
+
+// GenTriplet generates a GSM triplet with the GSM-Milenage conversion of TS 55.205
+func (a *AuC) GenTriplet() (t Triplet, err error) {
+	if t.RAND, err = a.genRand(); err != nil {
+		return
+	}
+	if milenage.Gsm_milenage(a.opc[:], a.k[:], t.RAND[:], t.SRES[:], t.Kc[:]) != 0 {
+		err = errors.New("AuC: GSM-Milenage failed")
+	}
+	return
+}
+
+// Resync verifies AUTS sent by the UE for rand and recovers SQN_MS. On
+// success the AuC continues with the sequence number following SQN_MS.
+func (a *AuC) Resync(rand [16]byte, auts []byte) (sqnMS uint64, err error) {
+	if len(auts) != AKA_AUTS_LEN {
+		err = fmt.Errorf("AuC: invalid AUTS length %d", len(auts))
+		return
+	}
+	sqn := make([]byte, SQN_LEN)
+	if milenage.Milenage_auts(a.opc[:], a.k[:], rand[:], auts, sqn) != 0 {
+		err = errors.New("AuC: AUTS verification failed - MAC-S != XMAC-S")
+		return
+	}
+	sqnMS = getSQN(sqn)
+	a.sqn = (sqnMS + 1<<SQN_IND_LEN) & 0xFFFFFFFFFFFF
+	logrus.Debugf("AuC: resynchronised, SQN_MS=%X", sqn)
+	return
+}
+
+func putSQN(buf []byte, sqn uint64) {
+	var tmp [8]byte
+	binary.BigEndian.PutUint64(tmp[:], sqn)
+	copy(buf, tmp[2:])
+}
+
+func getSQN(buf []byte) uint64 {
+	var tmp [8]byte
+	copy(tmp[2:], buf[:SQN_LEN])
+	return binary.BigEndian.Uint64(tmp[:])
+}
+
+// SubscriberStore is an in-memory subscriber database holding an AuC per IMSI
+type SubscriberStore struct {
+	mu   sync.Mutex
+	subs map[string]*AuC
+}
+
+func NewSubscriberStore() *SubscriberStore {
+	return &SubscriberStore{subs: map[string]*AuC{}}
+}
+
+// Add adds or replaces a subscriber, see InitAuC for the parameters
+func (s *SubscriberStore) Add(imsi string, k string, op string, opc string, amf string, sqn uint64) error {
+	if _, err := convert_imsi(imsi); err != nil {
+		return err
+	}
+	a, err := InitAuC(k, op, opc, amf, sqn)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subs[imsi] = &a
+	return nil
+}
+
+// Lookup runs f with the AuC of imsi while holding the store lock
+func (s *SubscriberStore) Lookup(imsi string, f func(a *AuC) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.subs[imsi]
+	if !ok {
+		return fmt.Errorf("unknown subscriber %s", imsi)
+	}
+	return f(a)
+}
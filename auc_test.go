@@ -0,0 +1,117 @@
+package usim_go
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/free5gc/milenage"
+)
+
+func unhex(s string) []byte {
+	b, _ := hex.DecodeString(s)
+	return b
+}
+
+// TS 35.208 test set 1
+func TestAuCGenQuintet(t *testing.T) {
+	a, err := InitAuC("465b5ce8b199b49faa5f0a2ee238a6bc", "cdc202d5123e20f62b6d676ac72cb318", "", "b9b9", 0xff9bb4d0b607)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Rand = bytes.NewReader(unhex("23553cbe9637a89d218ae64dae47bf35"))
+	q, err := a.GenQuintet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(q.XRES, unhex("a54211d5e3ba50bf")) {
+		t.Errorf("XRES failed. got %X", q.XRES)
+	}
+	if !bytes.Equal(q.CK[:], unhex("b40ba9a3c58b2a05bbf0d987b21bf8cb")) {
+		t.Errorf("CK failed. got %X", q.CK)
+	}
+	if !bytes.Equal(q.IK[:], unhex("f769bcd751044604127672711c6d3441")) {
+		t.Errorf("IK failed. got %X", q.IK)
+	}
+	// SQN ^ AK || AMF || MAC-A
+	if !bytes.Equal(q.AUTN[:], unhex("55f328b43577b9b94a9ffac354dfafb3")) {
+		t.Errorf("AUTN failed. got %X", q.AUTN)
+	}
+	if a.SQN() != 0xff9bb4d0b607+32 {
+		t.Errorf("SQN not advanced: %X", a.SQN())
+	}
+}
+
+func TestDeriveKASME(t *testing.T) {
+	ck := unhex("05d3533dfe7be72d42c7bb02f28eda7f")
+	ik := unhex("2633a20bdca89d7858ba42478be4d24d")
+	kasme := DeriveKASME(ck, ik, [3]byte{0x02, 0xf8, 0x39}, unhex("d744519b25aa"))
+	if !bytes.Equal(kasme, unhex("a827575eea1a10173aa1bfce4b0c2185e051efbd917ffef51f742961f9037a35")) {
+		t.Errorf("KASME failed. got %X", kasme)
+	}
+	mcc, mnc, _ := convert_mcc_mnc("208", "93")
+	if encode_plmn(mcc, mnc) != [3]byte{0x02, 0xf8, 0x39} {
+		t.Errorf("encode plmn failed. got %X", encode_plmn(mcc, mnc))
+	}
+}
+
+func TestAuCResync(t *testing.T) {
+	a, _ := InitAuC("465b5ce8b199b49faa5f0a2ee238a6bc", "", "cd63cb71954a9f4e48a5994e37a02baf", "8000", 0x20)
+	rand := [16]byte{1, 2, 3}
+	sqnMS := unhex("000000001240")
+	// AUTS = SQN_MS ^ AK* || MAC-S
+	akStar := make([]byte, 6)
+	macS := make([]byte, 8)
+	milenage.F2345(a.opc[:], a.k[:], rand[:], nil, nil, nil, nil, akStar)
+	milenage.F1(a.opc[:], a.k[:], rand[:], sqnMS, []byte{0, 0}, nil, macS)
+	var auts []byte
+	for i := range akStar {
+		auts = append(auts, sqnMS[i]^akStar[i])
+	}
+	auts = append(auts, macS...)
+	sqn, err := a.Resync(rand, auts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sqn != 0x1240 || a.SQN() != 0x1260 {
+		t.Errorf("unexpected SQN_MS %X, next SQN %X", sqn, a.SQN())
+	}
+	auts[13] ^= 1
+	if _, err = a.Resync(rand, auts); err == nil {
+		t.Error("expect MAC-S failure")
+	}
+}
+
+func TestAuCRoundTrip(t *testing.T) {
+	u, err := InitSoftUSIM(Milenage, "356092040793011", "208930000000001", "8BAF473F2F8FD09487CCCBD7097C6862", "", "8e27b6af0e692e750f32667a3b14605d", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, _ := InitAuC("8BAF473F2F8FD09487CCCBD7097C6862", "", "8e27b6af0e692e750f32667a3b14605d", "8000", 0x1b57)
+	snName := ServingNetworkName("208", "93")
+	v, err := a.GenHEAV(snName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, ik, ck, _, err := u.GenAuthResMilenage(v.RAND, v.AUTN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resStar := DeriveRESStar(ck, ik, snName, v.RAND[:], res); !bytes.Equal(resStar, v.XRESStar[:]) {
+		t.Errorf("RES* %X != XRES* %X", resStar, v.XRESStar)
+	}
+	if kausf := DeriveKAUSF(ck, ik, snName, v.AUTN[:6]); !bytes.Equal(kausf, v.KAUSF[:]) {
+		t.Errorf("KAUSF mismatch")
+	}
+	tr, err := a.GenTriplet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sres, kc, err := u.GenGSMAlg(tr.RAND[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sres, tr.SRES[:]) || !bytes.Equal(kc, tr.Kc[:]) {
+		t.Errorf("triplet mismatch")
+	}
+}
@@ -0,0 +1,481 @@
+package usim_go
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Dialling numbers in the ADN format of TS 31.102 4.4.2.3 with extension
+// records of 4.4.2.4, used by EF_MSISDN, EF_ADN, EF_FDN and EF_SDN
+
+const (
+	// octets of an ADN record after the alpha identifier
+	dialNumberLen    = 14
+	dialNumberMaxBCD = 10
+	extRecordLen     = 13
+
+	EXT_RECORD_SUBADDRESS = 0x01
+	EXT_RECORD_ADDITIONAL = 0x02
+
+	// type of number and numbering plan, TS 24.008 10.5.4.7
+	TON_UNKNOWN       = 0x00
+	TON_INTERNATIONAL = 0x10
+	TON_NATIONAL      = 0x20
+	NPI_ISDN          = 0x01
+
+	TONNPI_DEFAULT       = 0x80 | TON_UNKNOWN | NPI_ISDN
+	TONNPI_INTERNATIONAL = 0x80 | TON_INTERNATIONAL | NPI_ISDN
+
+	noExtension = 0xFF
+)
+
+// bcdDialDigits maps the BCD values of a dialling number to characters, 'C' is
+// the DTMF separator and 'D' the wild value
+const bcdDialDigits = "0123456789*#,?"
+
+// DialNumber is an ADN format record. Record is the record number, 0 for
+// entries not written yet. Number holds the digits without the international
+// prefix, which is given by TONNPI.
+type DialNumber struct {
+	Record int
+	Alpha  string
+	Number string
+	TONNPI byte
+	// CCP is the capability/configuration identifier, 'FF' if none
+	CCP byte
+	// ext is the first extension record, 'FF' if none
+	ext byte
+}
+
+// String returns the number with a leading "+" for international numbers
+func (d DialNumber) String() string {
+	if d.TONNPI&0x70 == TON_INTERNATIONAL {
+		return "+" + d.Number
+	}
+	return d.Number
+}
+
+// NewDialNumber parses a number, a leading "+" makes it international
+func NewDialNumber(alpha, number string) (d DialNumber, err error) {
+	d = DialNumber{Alpha: alpha, TONNPI: TONNPI_DEFAULT, CCP: noExtension, ext: noExtension}
+	if strings.HasPrefix(number, "+") {
+		d.TONNPI, number = TONNPI_INTERNATIONAL, number[1:]
+	}
+	if _, err = encodeDialDigits(number); err != nil {
+		return
+	}
+	d.Number = number
+	return
+}
+
+func encodeDialDigits(digits string) ([]byte, error) {
+	nibbles := make([]byte, len(digits))
+	for i := 0; i < len(digits); i++ {
+		n := strings.IndexByte(bcdDialDigits, digits[i])
+		if n < 0 {
+			return nil, fmt.Errorf("dialling number: invalid digit %q", digits[i])
+		}
+		nibbles[i] = byte(n)
+	}
+	out := make([]byte, (len(nibbles)+1)/2)
+	for i := range out {
+		out[i] = 0xF0 | nibbles[2*i]
+		if 2*i+1 < len(nibbles) {
+			out[i] = nibbles[2*i+1]<<4 | nibbles[2*i]
+		}
+	}
+	return out, nil
+}
+
+func decodeDialDigits(b []byte) string {
+	var out []byte
+	for _, c := range b {
+		for _, n := range []byte{c & 0x0F, c >> 4} {
+			if n == 0x0F {
+				return string(out)
+			}
+			if int(n) < len(bcdDialDigits) {
+				out = append(out, bcdDialDigits[n])
+			}
+		}
+	}
+	return string(out)
+}
+
+// decodeDialNumber decodes an ADN format record, empty is set for unused records
+func decodeDialNumber(r []byte, alphaLen int) (d DialNumber, empty bool, err error) {
+	if len(r) < alphaLen+dialNumberLen {
+		return d, false, fmt.Errorf("dialling number: record too short (%d bytes)", len(r))
+	}
+	d.Alpha, d.ext = decodeAlphaID(r[:alphaLen]), noExtension
+	n := r[alphaLen:]
+	if n[0] == 0xFF || n[0] == 0 {
+		return d, d.Alpha == "", nil
+	}
+	l := int(n[0])
+	if l > dialNumberMaxBCD+1 {
+		return d, false, fmt.Errorf("dialling number: invalid length %d", l)
+	}
+	d.TONNPI, d.CCP, d.ext = n[1], n[12], n[13]
+	d.Number = decodeDialDigits(n[2 : 1+l])
+	return
+}
+
+// encodeDialNumber encodes the record with the first 20 digits, the remaining
+// digits go to the extension records starting at d.ext
+func encodeDialNumber(d DialNumber, alphaLen int) (r []byte, rest string, err error) {
+	if alphaLen >= 0 && len(encodeAlphaID(d.Alpha, -1)) > alphaLen {
+		logrus.Warnf("dialling number: alpha identifier %q cut to %d octets", d.Alpha, alphaLen)
+	}
+	number := d.Number
+	if len(number) > 2*dialNumberMaxBCD {
+		number, rest = number[:2*dialNumberMaxBCD], number[2*dialNumberMaxBCD:]
+	}
+	bcd, err := encodeDialDigits(number)
+	if err != nil {
+		return
+	}
+	r = encodeAlphaID(d.Alpha, alphaLen)
+	tonnpi := d.TONNPI
+	if tonnpi == 0 {
+		tonnpi = TONNPI_DEFAULT
+	}
+	if number == "" {
+		r = append(r, 0xFF, 0xFF)
+	} else {
+		r = append(r, byte(len(bcd)+1), tonnpi)
+	}
+	r = append(r, bcd...)
+	r = append(r, bytes.Repeat([]byte{0xFF}, dialNumberMaxBCD-len(bcd))...)
+	ccp := d.CCP
+	if ccp == 0 {
+		ccp = noExtension
+	}
+	ext := d.ext
+	if rest == "" || ext == 0 {
+		ext = noExtension
+	}
+	return append(r, ccp, ext), rest, nil
+}
+
+func emptyDialNumber(alphaLen int) []byte {
+	return bytes.Repeat([]byte{0xFF}, alphaLen+dialNumberLen)
+}
+
+// extChain follows the extension records from id and returns the additional
+// digits and the records used, records are numbered from 1
+func extChain(ext [][]byte, id byte) (digits string, used []int, err error) {
+	seen := map[byte]bool{}
+	for id != noExtension && id != 0 {
+		if seen[id] || int(id) > len(ext) {
+			return digits, used, fmt.Errorf("dialling number: invalid extension record %d", id)
+		}
+		seen[id] = true
+		r := ext[id-1]
+		if len(r) < extRecordLen {
+			return digits, used, errors.New("dialling number: extension record too short")
+		}
+		used = append(used, int(id))
+		if r[0] == EXT_RECORD_ADDITIONAL && int(r[1]) <= dialNumberMaxBCD {
+			digits += decodeDialDigits(r[2 : 2+int(r[1])])
+		}
+		id = r[12]
+	}
+	return
+}
+
+// allocExt writes the digits to free extension records and returns the first one
+func allocExt(ext [][]byte, digits string) (first byte, err error) {
+	var free []int
+	for i, r := range ext {
+		if len(r) > 0 && (r[0] == 0xFF || r[0] == 0) {
+			free = append(free, i)
+		}
+	}
+	var chunks []string
+	for len(digits) > 0 {
+		n := 2 * dialNumberMaxBCD
+		if n > len(digits) {
+			n = len(digits)
+		}
+		chunks, digits = append(chunks, digits[:n]), digits[n:]
+	}
+	if len(chunks) > len(free) {
+		return noExtension, errors.New("dialling number: no free extension record")
+	}
+	next := byte(noExtension)
+	for i := len(chunks) - 1; i >= 0; i-- {
+		bcd, err := encodeDialDigits(chunks[i])
+		if err != nil {
+			return noExtension, err
+		}
+		r := append([]byte{EXT_RECORD_ADDITIONAL, byte(len(bcd))}, bcd...)
+		r = append(r, bytes.Repeat([]byte{0xFF}, dialNumberMaxBCD-len(bcd))...)
+		ext[free[i]] = append(r, next)
+		next = byte(free[i] + 1)
+	}
+	return next, nil
+}
+
+func freeExt(ext [][]byte, used []int) {
+	for _, i := range used {
+		ext[i-1] = bytes.Repeat([]byte{0xFF}, extRecordLen)
+	}
+}
+
+// dialNumberFile holds the records of an ADN format file, Records is the
+// number of records of the file and AlphaLen the alpha identifier length
+type dialNumberFile struct {
+	Entries  []DialNumber
+	AlphaLen int
+	Records  int
+}
+
+func (f *dialNumberFile) decode(name string, records [][]byte) error {
+	f.Entries, f.Records = nil, len(records)
+	for i, r := range records {
+		if len(r) < dialNumberLen {
+			return fmt.Errorf("%s: record %d too short", name, i+1)
+		}
+		f.AlphaLen = len(r) - dialNumberLen
+		d, empty, err := decodeDialNumber(r, f.AlphaLen)
+		if err != nil {
+			return fmt.Errorf("%s: record %d: %v", name, i+1, err)
+		}
+		if !empty {
+			d.Record = i + 1
+			f.Entries = append(f.Entries, d)
+		}
+	}
+	return nil
+}
+
+// layout places the entries at their records, new entries take free records
+func (f *dialNumberFile) layout(name string) ([]*DialNumber, error) {
+	n := f.Records
+	for _, d := range f.Entries {
+		if d.Record > n {
+			n = d.Record
+		}
+	}
+	slots := make([]*DialNumber, n)
+	for i := range f.Entries {
+		if d := &f.Entries[i]; d.Record > 0 {
+			if slots[d.Record-1] != nil {
+				return nil, fmt.Errorf("%s: record %d used twice", name, d.Record)
+			}
+			slots[d.Record-1] = d
+		}
+	}
+	next := 0
+	for i := range f.Entries {
+		if d := &f.Entries[i]; d.Record == 0 {
+			for next < len(slots) && slots[next] != nil {
+				next++
+			}
+			if next == len(slots) {
+				if f.Records > 0 {
+					return nil, fmt.Errorf("%s: no free record", name)
+				}
+				slots = append(slots, nil)
+			}
+			d.Record, slots[next] = next+1, d
+		}
+	}
+	return slots, nil
+}
+
+func (f *dialNumberFile) encode(name string) ([][]byte, error) {
+	slots, err := f.layout(name)
+	if err != nil {
+		return nil, err
+	}
+	alphaLen := f.AlphaLen
+	if alphaLen == 0 {
+		for _, d := range f.Entries {
+			if n := len(encodeAlphaID(d.Alpha, -1)); n > alphaLen {
+				alphaLen = n
+			}
+		}
+	}
+	records := make([][]byte, len(slots))
+	for i, d := range slots {
+		if d == nil {
+			records[i] = emptyDialNumber(alphaLen)
+			continue
+		}
+		r, rest, err := encodeDialNumber(*d, alphaLen)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		if rest != "" && d.ext == noExtension {
+			return nil, fmt.Errorf("%s: number of record %d needs extension records", name, d.Record)
+		}
+		records[i] = r
+	}
+	return records, nil
+}
+
+// EFMSISDN is EF_MSISDN with extensions in EF_EXT5, TS 31.102 4.2.26
+type EFMSISDN struct{ dialNumberFile }
+
+func (e *EFMSISDN) Info() EFInfo                  { return efInfo("EF_MSISDN") }
+func (e *EFMSISDN) Decode(records [][]byte) error { return e.decode("EF_MSISDN", records) }
+func (e *EFMSISDN) Encode() ([][]byte, error)     { return e.encode("EF_MSISDN") }
+
+// EFADN is EF_ADN of DF_TELECOM with extensions in EF_EXT1, TS 51.011 10.5.1
+type EFADN struct{ dialNumberFile }
+
+func (e *EFADN) Info() EFInfo                  { return efInfo("EF_ADN") }
+func (e *EFADN) Decode(records [][]byte) error { return e.decode("EF_ADN", records) }
+func (e *EFADN) Encode() ([][]byte, error)     { return e.encode("EF_ADN") }
+
+// EFFDN is EF_FDN with extensions in EF_EXT2, TS 31.102 4.2.24
+type EFFDN struct{ dialNumberFile }
+
+func (e *EFFDN) Info() EFInfo                  { return efInfo("EF_FDN") }
+func (e *EFFDN) Decode(records [][]byte) error { return e.decode("EF_FDN", records) }
+func (e *EFFDN) Encode() ([][]byte, error)     { return e.encode("EF_FDN") }
+
+// EFSDN is EF_SDN with extensions in EF_EXT3, TS 31.102 4.2.29
+type EFSDN struct{ dialNumberFile }
+
+func (e *EFSDN) Info() EFInfo                  { return efInfo("EF_SDN") }
+func (e *EFSDN) Decode(records [][]byte) error { return e.decode("EF_SDN", records) }
+func (e *EFSDN) Encode() ([][]byte, error)     { return e.encode("EF_SDN") }
+
+// dialNumberExt maps the ADN format files to their extension files
+var dialNumberExt = map[string]string{
+	"EF_MSISDN": "EF_EXT5",
+	"EF_ADN":    "EF_EXT1",
+	"EF_FDN":    "EF_EXT2",
+	"EF_SDN":    "EF_EXT3",
+	// EF_MSISDN of DF_TELECOM on cards without one in the USIM
+	"EF_MSISDN_TELECOM": "EF_EXT1",
+}
+
+// resolveExt appends the extension digits to the entries
+func resolveExt(name string, entries []DialNumber, ext [][]byte) {
+	for i := range entries {
+		d := &entries[i]
+		if d.ext == noExtension {
+			continue
+		}
+		digits, _, err := extChain(ext, d.ext)
+		if err != nil {
+			logrus.Warnf("%s: record %d: %v", name, d.Record, err)
+		}
+		d.Number += digits
+	}
+}
+
+// ReadDialNumbers reads EF_MSISDN, EF_ADN, EF_FDN or EF_SDN with the digits
+// of the extension records
+func (u *USIM) ReadDialNumbers(name string) (entries []DialNumber, err error) {
+	extName, ok := dialNumberExt[name]
+	if !ok {
+		return nil, fmt.Errorf("%s is not a dialling number file", name)
+	}
+	records, err := u.ReadEFRaw(name)
+	if err != nil {
+		return
+	}
+	var f dialNumberFile
+	if err = f.decode(name, records); err != nil {
+		return
+	}
+	for _, d := range f.Entries {
+		if d.ext != noExtension {
+			ext, err := u.ReadEFRaw(extName)
+			if err != nil {
+				logrus.Warnf("%s: extension records not read: %v", name, err)
+				break
+			}
+			resolveExt(name, f.Entries, ext)
+			break
+		}
+	}
+	return f.Entries, nil
+}
+
+// UpdateDialNumbers writes the entries to EF_MSISDN, EF_ADN, EF_FDN or EF_SDN,
+// records without an entry are cleared. Numbers beyond 20 digits use
+// extension records, the extension records of the old entries are freed.
+func (u *USIM) UpdateDialNumbers(name string, entries []DialNumber) (err error) {
+	extName, ok := dialNumberExt[name]
+	if !ok {
+		return fmt.Errorf("%s is not a dialling number file", name)
+	}
+	records, err := u.ReadEFRaw(name)
+	if err != nil {
+		return
+	}
+	var f dialNumberFile
+	if err = f.decode(name, records); err != nil {
+		return
+	}
+	var ext [][]byte
+	needExt := false
+	for _, d := range f.Entries {
+		needExt = needExt || d.ext != noExtension
+	}
+	for _, d := range entries {
+		needExt = needExt || len(d.Number) > 2*dialNumberMaxBCD
+	}
+	if needExt {
+		if ext, err = u.ReadEFRaw(extName); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		for _, d := range f.Entries {
+			_, used, _ := extChain(ext, d.ext)
+			freeExt(ext, used)
+		}
+	}
+	f.Entries = append([]DialNumber{}, entries...)
+	for i := range f.Entries {
+		d := &f.Entries[i]
+		d.ext = noExtension
+		if len(d.Number) > 2*dialNumberMaxBCD {
+			if d.ext, err = allocExt(ext, d.Number[2*dialNumberMaxBCD:]); err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+		}
+	}
+	if records, err = f.encode(name); err != nil {
+		return
+	}
+	if needExt {
+		if err = u.UpdateEFRaw(extName, ext); err != nil {
+			return
+		}
+	}
+	return u.UpdateEFRaw(name, records)
+}
+
+// MSISDNs returns the numbers of EF_MSISDN, or of EF_MSISDN of DF_TELECOM
+// if the USIM has none
+func (u *USIM) MSISDNs() (entries []DialNumber, err error) {
+	if entries, err = u.ReadDialNumbers("EF_MSISDN"); err != nil {
+		if telecom, e := u.ReadDialNumbers("EF_MSISDN_TELECOM"); e == nil {
+			return telecom, nil
+		}
+	}
+	return
+}
+
+// SetMSISDN writes the number to the first record of EF_MSISDN, "+" makes it international
+func (u *USIM) SetMSISDN(alpha, number string) error {
+	d, err := NewDialNumber(alpha, number)
+	if err != nil {
+		return err
+	}
+	d.Record = 1
+	if err = u.UpdateDialNumbers("EF_MSISDN", []DialNumber{d}); err == nil {
+		u.msisdn = d.Number
+	}
+	return err
+}
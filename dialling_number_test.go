@@ -0,0 +1,102 @@
+package usim_go
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDialNumberRecord(t *testing.T) {
+	raw := unhex("54657374ffff" + "07812143658709f1ffffffffffff")
+	d, empty, err := decodeDialNumber(raw, 6)
+	if err != nil || empty {
+		t.Fatal(empty, err)
+	}
+	if d.Alpha != "Test" || d.Number != "12345678901" || d.String() != "12345678901" || d.ext != noExtension {
+		t.Fatalf("decoded %+v", d)
+	}
+	out, rest, err := encodeDialNumber(d, 6)
+	if err != nil || rest != "" || !bytes.Equal(out, raw) {
+		t.Errorf("encoded %x %q, %v", out, rest, err)
+	}
+	if _, empty, _ := decodeDialNumber(emptyDialNumber(6), 6); !empty {
+		t.Error("empty record not detected")
+	}
+	// a name without number keeps no extension record
+	raw = unhex("4e616d65ffff" + "ffffffffffffffffffffffffffff")
+	if d, empty, err = decodeDialNumber(raw, 6); err != nil || empty || d.ext != noExtension {
+		t.Fatalf("alpha only %+v, %v", d, err)
+	}
+	if out, _, err = encodeDialNumber(d, 6); err != nil || !bytes.Equal(out, raw) {
+		t.Errorf("alpha only encoded %x, %v", out, err)
+	}
+}
+
+func TestDialDigits(t *testing.T) {
+	b, err := encodeDialDigits("*21#,5?")
+	if err != nil || !bytes.Equal(b, unhex("2ab15cfd")) {
+		t.Fatalf("encoded %x, %v", b, err)
+	}
+	if s := decodeDialDigits(b); s != "*21#,5?" {
+		t.Errorf("decoded %q", s)
+	}
+	if _, err = NewDialNumber("", "12a"); err == nil {
+		t.Error("invalid digit accepted")
+	}
+}
+
+func TestMSISDN(t *testing.T) {
+	u, _ := testSubscriber(t)
+	if err := u.UpdateEFRaw("EF_MSISDN", [][]byte{emptyDialNumber(8), emptyDialNumber(8)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.SetMSISDN("Own", "+33612345678"); err != nil {
+		t.Fatal(err)
+	}
+	records, _ := u.ReadEFRaw("EF_MSISDN")
+	if !bytes.Equal(records[0], unhex("4f776effffffffff"+"07913316325476f8ffffffffffff")) {
+		t.Errorf("EF_MSISDN %x", records[0])
+	}
+	entries, err := u.MSISDNs()
+	if err != nil || len(entries) != 1 || entries[0].String() != "+33612345678" || entries[0].Alpha != "Own" {
+		t.Errorf("MSISDNs %+v, %v", entries, err)
+	}
+	if u.MSISDN() != "33612345678" {
+		t.Errorf("MSISDN %q", u.MSISDN())
+	}
+}
+
+func TestDialNumberExtension(t *testing.T) {
+	u, _ := testSubscriber(t)
+	empty := [][]byte{emptyDialNumber(4), emptyDialNumber(4)}
+	ext := [][]byte{unhex("ffffffffffffffffffffffffff"), unhex("ffffffffffffffffffffffffff")}
+	if err := u.UpdateEFRaw("EF_MSISDN", empty); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.UpdateEFRaw("EF_EXT5", ext); err != nil {
+		t.Fatal(err)
+	}
+	long := "1234567890123456789012345"
+	d, _ := NewDialNumber("Long", long)
+	if err := u.UpdateDialNumbers("EF_MSISDN", []DialNumber{d}); err != nil {
+		t.Fatal(err)
+	}
+	records, _ := u.ReadEFRaw("EF_EXT5")
+	if !bytes.Equal(records[0], unhex("02032143f5ffffffffffffffff")) {
+		t.Errorf("EF_EXT5 %x", records[0])
+	}
+	entries, err := u.ReadDialNumbers("EF_MSISDN")
+	if err != nil || len(entries) != 1 || entries[0].Number != long || entries[0].Record != 1 {
+		t.Fatalf("entries %+v, %v", entries, err)
+	}
+	// replacing the entry frees the extension record
+	d, _ = NewDialNumber("Short", "112")
+	if err = u.UpdateDialNumbers("EF_MSISDN", []DialNumber{d}); err != nil {
+		t.Fatal(err)
+	}
+	if records, _ = u.ReadEFRaw("EF_EXT5"); !isEmptyRecord(records[0]) {
+		t.Errorf("EF_EXT5 not freed %x", records[0])
+	}
+	if entries, _ = u.ReadDialNumbers("EF_MSISDN"); len(entries) != 1 || entries[0].Number != "112" || entries[0].Alpha != "Shor" {
+		t.Errorf("entries %+v", entries)
+	}
+}
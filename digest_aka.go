@@ -0,0 +1,269 @@
+package usim_go
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// HTTP Digest AKA of RFC 3310 (AKAv1-MD5) and RFC 4169 (AKAv2-MD5)
+
+const (
+	DIGEST_AKAV1_MD5 = "AKAv1-MD5"
+	DIGEST_AKAV2_MD5 = "AKAv2-MD5"
+
+	digestAKAv2Password = "http-digest-akav2-password"
+)
+
+// AKAAuthenticator runs UMTS AKA, it is implemented by USIM and may be
+// implemented by an ISIM application. err is UNSYNC on synchronisation
+// failure, with auts set.
+type AKAAuthenticator interface {
+	GenAuthResMilenage(rand, autn [16]byte) (res, ik, ck, auts []byte, err error)
+}
+
+// DigestChallenge is a parsed WWW-Authenticate or Proxy-Authenticate header
+type DigestChallenge struct {
+	Proxy     bool
+	Realm     string
+	Nonce     string
+	Opaque    string
+	Algorithm string
+	Domain    string
+	QOP       []string
+	Stale     bool
+	// Params holds all parameters as received
+	Params map[string]string
+}
+
+// ParseAKANonce decodes a Digest AKA nonce, base64(RAND || AUTN || server data)
+func ParseAKANonce(nonce string) (rand, autn [16]byte, serverData []byte, err error) {
+	var dec []byte
+	if dec, err = base64.StdEncoding.DecodeString(nonce); err != nil {
+		err = fmt.Errorf("Digest AKA: nonce is not base64 encoded: %v", err)
+		return
+	}
+	if len(dec) < AKA_RAND_LEN+AKA_AUTN_LEN {
+		err = fmt.Errorf("Digest AKA: nonce too short (%d bytes)", len(dec))
+		return
+	}
+	copy(rand[:], dec[:AKA_RAND_LEN])
+	copy(autn[:], dec[AKA_RAND_LEN:AKA_RAND_LEN+AKA_AUTN_LEN])
+	serverData = dec[AKA_RAND_LEN+AKA_AUTN_LEN:]
+	return
+}
+
+// ParseDigestChallenge parses a challenge header. The header name is optional,
+// Proxy is set for Proxy-Authenticate.
+func ParseDigestChallenge(header string) (c DigestChallenge, err error) {
+	header = strings.TrimSpace(header)
+	if i := strings.IndexByte(header, ':'); i > 0 && !strings.ContainsAny(header[:i], " =\"") {
+		name := strings.TrimSpace(header[:i])
+		c.Proxy = strings.EqualFold(name, "Proxy-Authenticate")
+		header = strings.TrimSpace(header[i+1:])
+	}
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Digest ") {
+		err = errors.New("Digest: not a Digest challenge")
+		return
+	}
+	if c.Params, err = parseDigestParams(header[7:]); err != nil {
+		return
+	}
+	c.Realm = c.Params["realm"]
+	c.Nonce = c.Params["nonce"]
+	c.Opaque = c.Params["opaque"]
+	c.Algorithm = c.Params["algorithm"]
+	c.Domain = c.Params["domain"]
+	c.Stale = strings.EqualFold(c.Params["stale"], "true")
+	for _, q := range strings.Split(c.Params["qop"], ",") {
+		if q = strings.TrimSpace(q); q != "" {
+			c.QOP = append(c.QOP, q)
+		}
+	}
+	if c.Nonce == "" {
+		err = errors.New("Digest: challenge without nonce")
+	}
+	return
+}
+
+func parseDigestParams(s string) (params map[string]string, err error) {
+	params = map[string]string{}
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimSpace(s) {
+		eq := strings.IndexByte(s, '=')
+		if eq <= 0 {
+			return nil, fmt.Errorf("Digest: malformed parameter %q", s)
+		}
+		name := strings.ToLower(strings.TrimSpace(s[:eq]))
+		s = strings.TrimSpace(s[eq+1:])
+		var value string
+		if strings.HasPrefix(s, "\"") {
+			end := 1
+			var b strings.Builder
+			for ; end < len(s) && s[end] != '"'; end++ {
+				if s[end] == '\\' && end+1 < len(s) {
+					end++
+				}
+				b.WriteByte(s[end])
+			}
+			if end >= len(s) {
+				return nil, fmt.Errorf("Digest: unterminated value of %s", name)
+			}
+			value, s = b.String(), s[end+1:]
+		} else {
+			end := strings.IndexByte(s, ',')
+			if end < 0 {
+				end = len(s)
+			}
+			value, s = strings.TrimSpace(s[:end]), s[end:]
+		}
+		params[name] = value
+		s = strings.TrimPrefix(strings.TrimSpace(s), ",")
+	}
+	return
+}
+
+// AuthorizationHeaderName returns the header answering the challenge
+func (c DigestChallenge) AuthorizationHeaderName() string {
+	if c.Proxy {
+		return "Proxy-Authorization"
+	}
+	return "Authorization"
+}
+
+// DigestAKAClient answers Digest AKA challenges for one private user identity
+type DigestAKAClient struct {
+	Auth AKAAuthenticator
+	// Username is the private user identity (IMPI)
+	Username string
+	// CNonce returns the client nonce, random if nil
+	CNonce func() string
+
+	nonce string
+	nc    uint32
+}
+
+// DigestAKAResult is the outcome of answering a challenge
+type DigestAKAResult struct {
+	// Header is the value of the Authorization or Proxy-Authorization header
+	Header string
+	RES    []byte
+	IK     []byte
+	CK     []byte
+	// AUTS is set on synchronisation failure
+	AUTS []byte
+	// MACFailure is set when the network could not be authenticated
+	MACFailure bool
+}
+
+func NewDigestAKAClient(auth AKAAuthenticator, username string) *DigestAKAClient {
+	return &DigestAKAClient{Auth: auth, Username: username}
+}
+
+// Authorize runs AKA for the challenge and builds the authorization header
+// for a request with the given method, uri and body (for qop auth-int).
+func (d *DigestAKAClient) Authorize(c DigestChallenge, method, uri string, body []byte) (r DigestAKAResult, err error) {
+	algo := c.Algorithm
+	if !strings.EqualFold(algo, DIGEST_AKAV1_MD5) && !strings.EqualFold(algo, DIGEST_AKAV2_MD5) {
+		err = fmt.Errorf("Digest AKA: unsupported algorithm %q", algo)
+		return
+	}
+	rand_, autn, _, err := ParseAKANonce(c.Nonce)
+	if err != nil {
+		return
+	}
+	var password []byte
+	r.RES, r.IK, r.CK, r.AUTS, err = d.Auth.GenAuthResMilenage(rand_, autn)
+	if err == UNSYNC {
+		// RFC 3310 3.4, answer with an empty password and the auts parameter
+		r.RES, r.IK, r.CK = nil, nil, nil
+	} else if err != nil {
+		// RFC 3310 3.3, an empty password tells the server the network was not authenticated
+		r.MACFailure = true
+		r.RES, r.IK, r.CK, r.AUTS = nil, nil, nil, nil
+	} else {
+		r.AUTS = nil
+		if strings.EqualFold(algo, DIGEST_AKAV2_MD5) {
+			password = akaV2Password(r.RES, r.IK, r.CK)
+		} else {
+			password = r.RES
+		}
+	}
+	err = nil
+
+	if c.Nonce != d.nonce {
+		d.nonce, d.nc = c.Nonce, 0
+	}
+	qop := ""
+	for _, q := range c.QOP {
+		if q == "auth" || (q == "auth-int" && qop == "") {
+			qop = q
+		}
+	}
+	var cnonce, nc string
+	if qop != "" {
+		d.nc++
+		nc = fmt.Sprintf("%08x", d.nc)
+		cnonce = d.cnonce()
+	}
+	response := digestResponse(d.Username, c.Realm, password, method, uri, c.Nonce, nc, cnonce, qop, body)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Digest username=%q, realm=%q, nonce=%q, uri=%q, response=%q, algorithm=%s",
+		d.Username, c.Realm, c.Nonce, uri, response, algo)
+	if qop != "" {
+		fmt.Fprintf(&b, ", qop=%s, nc=%s, cnonce=%q", qop, nc, cnonce)
+	}
+	if c.Opaque != "" {
+		fmt.Fprintf(&b, ", opaque=%q", c.Opaque)
+	}
+	if r.AUTS != nil {
+		fmt.Fprintf(&b, ", auts=%q", base64.StdEncoding.EncodeToString(r.AUTS))
+	}
+	r.Header = b.String()
+	return
+}
+
+func (d *DigestAKAClient) cnonce() string {
+	if d.CNonce != nil {
+		return d.CNonce()
+	}
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// akaV2Password is base64(PRF(RES || IK || CK, "http-digest-akav2-password")) of RFC 4169 3
+func akaV2Password(res, ik, ck []byte) []byte {
+	key := append(append(append([]byte{}, res...), ik...), ck...)
+	mac := hmac.New(md5.New, key)
+	mac.Write([]byte(digestAKAv2Password))
+	return []byte(base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+}
+
+// digestResponse computes the request-digest of RFC 2617 3.2.2.1 with MD5
+func digestResponse(username, realm string, password []byte, method, uri, nonce, nc, cnonce, qop string, body []byte) string {
+	md5Hex := func(parts ...[]byte) string {
+		h := md5.New()
+		for i, p := range parts {
+			if i > 0 {
+				h.Write([]byte(":"))
+			}
+			h.Write(p)
+		}
+		return hex.EncodeToString(h.Sum(nil))
+	}
+	ha1 := md5Hex([]byte(username), []byte(realm), password)
+	ha2 := md5Hex([]byte(method), []byte(uri))
+	if qop == "auth-int" {
+		ha2 = md5Hex([]byte(method), []byte(uri), []byte(md5Hex(body)))
+	}
+	if qop == "" {
+		return md5Hex([]byte(ha1), []byte(nonce), []byte(ha2))
+	}
+	return md5Hex([]byte(ha1), []byte(nonce), []byte(nc), []byte(cnonce), []byte(qop), []byte(ha2))
+}
@@ -0,0 +1,114 @@
+package usim_go
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// RFC 2617 3.5
+func TestDigestResponse(t *testing.T) {
+	r := digestResponse("Mufasa", "testrealm@host.com", []byte("Circle Of Life"), "GET", "/dir/index.html",
+		"dcd98b7102dd2f0e8b11d0f600bfb0c093", "00000001", "0a4f113b", "auth", nil)
+	if r != "6629fae49393a05397450978507c4ef1" {
+		t.Errorf("unexpected response %s", r)
+	}
+}
+
+func TestParseAKANonce(t *testing.T) {
+	if _, _, _, err := ParseAKANonce(base64.StdEncoding.EncodeToString(make([]byte, 20))); err == nil {
+		t.Error("short nonce accepted")
+	}
+	if rand, autn := ExtractRandAutn("c2hvcnQ="); rand != ([16]byte{}) || autn != ([16]byte{}) {
+		t.Error("short nonce not rejected")
+	}
+}
+
+func TestDigestAKAClient(t *testing.T) {
+	u, store := testSubscriber(t)
+	var q Quintet
+	store.Lookup("208930000000001", func(a *AuC) (err error) {
+		q, err = a.GenQuintet()
+		return
+	})
+	nonce := base64.StdEncoding.EncodeToString(append(q.RAND[:], q.AUTN[:]...))
+	c, err := ParseDigestChallenge(`WWW-Authenticate: Digest realm="ims.mnc093.mcc208.3gppnetwork.org", nonce="` + nonce + `", algorithm=AKAv1-MD5, qop="auth,auth-int", opaque="abc"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Proxy || c.Realm != "ims.mnc093.mcc208.3gppnetwork.org" || len(c.QOP) != 2 || c.Opaque != "abc" {
+		t.Fatalf("unexpected challenge %+v", c)
+	}
+	d := NewDigestAKAClient(u, "208930000000001@ims.mnc093.mcc208.3gppnetwork.org")
+	d.CNonce = func() string { return "0a4f113b" }
+	r, err := d.Authorize(c, "REGISTER", "sip:ims.mnc093.mcc208.3gppnetwork.org", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := digestResponse(d.Username, c.Realm, q.XRES, "REGISTER", "sip:ims.mnc093.mcc208.3gppnetwork.org", nonce, "00000001", "0a4f113b", "auth", nil)
+	if !strings.Contains(r.Header, `response="`+expect+`"`) || !strings.Contains(r.Header, "nc=00000001") {
+		t.Errorf("unexpected header %s", r.Header)
+	}
+	c.Algorithm = DIGEST_AKAV2_MD5
+	if r, err = d.Authorize(c, "REGISTER", "sip:ims.mnc093.mcc208.3gppnetwork.org", nil); err != nil {
+		t.Fatal(err)
+	}
+	expect = digestResponse(d.Username, c.Realm, akaV2Password(q.XRES, q.IK[:], q.CK[:]), "REGISTER", "sip:ims.mnc093.mcc208.3gppnetwork.org", nonce, "00000002", "0a4f113b", "auth", nil)
+	if !strings.Contains(r.Header, `response="`+expect+`"`) {
+		t.Errorf("unexpected AKAv2 header %s", r.Header)
+	}
+}
+
+// akaStub answers AKA with fixed values
+type akaStub struct {
+	res, ik, ck, auts []byte
+	err               error
+}
+
+func (s akaStub) GenAuthResMilenage(rand, autn [16]byte) ([]byte, []byte, []byte, []byte, error) {
+	return s.res, s.ik, s.ck, s.auts, s.err
+}
+
+func TestDigestAKAClientFailures(t *testing.T) {
+	nonce := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	c := DigestChallenge{Realm: "ims.example.org", Nonce: nonce, Algorithm: DIGEST_AKAV1_MD5}
+	auts := unhex("0102030405060708090a0b0c0d0e")
+	uri := "sip:ims.example.org"
+
+	// a left-over AUTS must not be sent with a successful response
+	d := NewDigestAKAClient(akaStub{res: []byte{1, 2, 3, 4}, ik: make([]byte, 16), ck: make([]byte, 16), auts: auts}, "user@ims.example.org")
+	r, err := d.Authorize(c, "REGISTER", uri, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.AUTS != nil || r.MACFailure || strings.Contains(r.Header, "auts=") {
+		t.Errorf("unexpected success result %+v", r)
+	}
+	if expect := digestResponse(d.Username, c.Realm, []byte{1, 2, 3, 4}, "REGISTER", uri, nonce, "", "", "", nil); !strings.Contains(r.Header, `response="`+expect+`"`) {
+		t.Errorf("unexpected header %s", r.Header)
+	}
+
+	// RFC 3310 3.4
+	d = NewDigestAKAClient(akaStub{auts: auts, err: UNSYNC}, "user@ims.example.org")
+	if r, err = d.Authorize(c, "REGISTER", uri, nil); err != nil {
+		t.Fatal(err)
+	}
+	expect := digestResponse(d.Username, c.Realm, nil, "REGISTER", uri, nonce, "", "", "", nil)
+	if r.MACFailure || r.RES != nil || !bytes.Equal(r.AUTS, auts) ||
+		!strings.Contains(r.Header, `response="`+expect+`"`) ||
+		!strings.HasSuffix(r.Header, `, auts="`+base64.StdEncoding.EncodeToString(auts)+`"`) {
+		t.Errorf("unexpected synchronisation failure result %+v", r)
+	}
+
+	// RFC 3310 3.3
+	d = NewDigestAKAClient(akaStub{auts: auts, err: errors.New("MAC failure")}, "user@ims.example.org")
+	if r, err = d.Authorize(c, "REGISTER", uri, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !r.MACFailure || r.RES != nil || r.AUTS != nil ||
+		!strings.Contains(r.Header, `response="`+expect+`"`) || strings.Contains(r.Header, "auts=") {
+		t.Errorf("unexpected MAC failure result %+v", r)
+	}
+}
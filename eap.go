@@ -0,0 +1,436 @@
+package usim_go
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+)
+
+// EAP (RFC 3748) packets carrying the EAP-SIM (RFC 4186), EAP-AKA (RFC 4187)
+// and EAP-AKA' (RFC 9048) attribute format.
+
+const (
+	EAP_CODE_REQUEST  = 1
+	EAP_CODE_RESPONSE = 2
+	EAP_CODE_SUCCESS  = 3
+	EAP_CODE_FAILURE  = 4
+
+	EAP_TYPE_IDENTITY     = 1
+	EAP_TYPE_NOTIFICATION = 2
+	EAP_TYPE_NAK          = 3
+	EAP_TYPE_SIM          = 18
+	EAP_TYPE_AKA          = 23
+	EAP_TYPE_AKA_PRIME    = 50
+
+	EAP_AKA_SUBTYPE_CHALLENGE          = 1
+	EAP_AKA_SUBTYPE_AUTHENTICATION_REJ = 2
+	EAP_AKA_SUBTYPE_SYNC_FAILURE       = 4
+	EAP_AKA_SUBTYPE_IDENTITY           = 5
+	EAP_SIM_SUBTYPE_START              = 10
+	EAP_SIM_SUBTYPE_CHALLENGE          = 11
+	EAP_SUBTYPE_NOTIFICATION           = 12
+	EAP_SUBTYPE_REAUTHENTICATION       = 13
+	EAP_SUBTYPE_CLIENT_ERROR           = 14
+
+	AT_RAND              = 1
+	AT_AUTN              = 2
+	AT_RES               = 3
+	AT_AUTS              = 4
+	AT_PADDING           = 6
+	AT_NONCE_MT          = 7
+	AT_PERMANENT_ID_REQ  = 10
+	AT_MAC               = 11
+	AT_NOTIFICATION      = 12
+	AT_ANY_ID_REQ        = 13
+	AT_IDENTITY          = 14
+	AT_VERSION_LIST      = 15
+	AT_SELECTED_VERSION  = 16
+	AT_FULLAUTH_ID_REQ   = 17
+	AT_COUNTER           = 19
+	AT_COUNTER_TOO_SMALL = 20
+	AT_NONCE_S           = 21
+	AT_CLIENT_ERROR_CODE = 22
+	AT_KDF_INPUT         = 23
+	AT_KDF               = 24
+	AT_IV                = 129
+	AT_ENCR_DATA         = 130
+	AT_NEXT_PSEUDONYM    = 132
+	AT_NEXT_REAUTH_ID    = 133
+	AT_CHECKCODE         = 134
+	AT_RESULT_IND        = 135
+	AT_BIDDING           = 136
+
+	// AT_NOTIFICATION codes
+	EAP_NOTIFICATION_GENERAL_FAILURE_AFTER_AUTH = 0
+	EAP_NOTIFICATION_GENERAL_FAILURE            = 16384
+	EAP_NOTIFICATION_SUCCESS                    = 32768
+	EAP_NOTIFICATION_USER_DENIED                = 1026
+	EAP_NOTIFICATION_USER_NO_SUBSCRIPTION       = 1031
+
+	// AT_CLIENT_ERROR_CODE codes
+	EAP_CLIENT_ERROR_UNABLE_TO_PROCESS   = 0
+	EAP_CLIENT_ERROR_UNSUPPORTED_VERSION = 1
+	EAP_CLIENT_ERROR_INSUFFICIENT_CHALS  = 2
+	EAP_CLIENT_ERROR_RANDS_NOT_FRESH     = 3
+
+	EAP_MAC_LEN       = 16
+	EAP_NONCE_LEN     = 16
+	EAP_IV_LEN        = 16
+	EAP_KDF_AKA_PRIME = 1
+)
+
+type EAPAttribute struct {
+	Type uint8
+	// Value holds the attribute contents following the Type and Length octets
+	Value []byte
+}
+
+type EAPPacket struct {
+	Code       uint8
+	Identifier uint8
+	Type       uint8
+	Subtype    uint8
+	// Data is the type data of packets not using the SIM/AKA attribute format
+	Data       []byte
+	Attributes []EAPAttribute
+}
+
+func isSimAka(t uint8) bool {
+	return t == EAP_TYPE_SIM || t == EAP_TYPE_AKA || t == EAP_TYPE_AKA_PRIME
+}
+
+// DecodeEAP decodes an EAP packet
+func DecodeEAP(buf []byte) (p EAPPacket, err error) {
+	if len(buf) < 4 {
+		err = fmt.Errorf("EAP: packet too short (%d bytes)", len(buf))
+		return
+	}
+	p.Code = buf[0]
+	p.Identifier = buf[1]
+	length := int(binary.BigEndian.Uint16(buf[2:4]))
+	if length < 4 || length > len(buf) {
+		err = fmt.Errorf("EAP: invalid length %d (have %d bytes)", length, len(buf))
+		return
+	}
+	buf = buf[:length]
+	if p.Code == EAP_CODE_SUCCESS || p.Code == EAP_CODE_FAILURE {
+		return
+	}
+	if length < 5 {
+		err = errors.New("EAP: missing type")
+		return
+	}
+	p.Type = buf[4]
+	if !isSimAka(p.Type) {
+		p.Data = append([]byte{}, buf[5:]...)
+		return
+	}
+	if length < 8 {
+		err = errors.New("EAP: missing subtype")
+		return
+	}
+	p.Subtype = buf[5]
+	p.Attributes, err = decodeEAPAttributes(buf[8:])
+	return
+}
+
+func decodeEAPAttributes(buf []byte) (attrs []EAPAttribute, err error) {
+	for pos := 0; pos < len(buf); {
+		if len(buf)-pos < 4 {
+			err = errors.New("EAP: truncated attribute")
+			return
+		}
+		aLen := int(buf[pos+1]) * 4
+		if aLen == 0 || pos+aLen > len(buf) {
+			err = fmt.Errorf("EAP: invalid length of attribute %d", buf[pos])
+			return
+		}
+		attrs = append(attrs, EAPAttribute{Type: buf[pos], Value: append([]byte{}, buf[pos+2:pos+aLen]...)})
+		pos += aLen
+	}
+	return
+}
+
+func encodeEAPAttributes(attrs []EAPAttribute) (buf []byte) {
+	for _, a := range attrs {
+		aLen := 2 + len(a.Value)
+		pad := (4 - aLen%4) % 4
+		buf = append(buf, a.Type, byte((aLen+pad)/4))
+		buf = append(buf, a.Value...)
+		buf = append(buf, make([]byte, pad)...)
+	}
+	return
+}
+
+// Encode encodes the packet, an AT_MAC attribute is encoded with a zero MAC
+func (p EAPPacket) Encode() []byte {
+	buf := []byte{p.Code, p.Identifier, 0, 0}
+	if p.Code == EAP_CODE_REQUEST || p.Code == EAP_CODE_RESPONSE {
+		buf = append(buf, p.Type)
+		if isSimAka(p.Type) {
+			buf = append(buf, p.Subtype, 0, 0)
+			buf = append(buf, encodeEAPAttributes(p.Attributes)...)
+		} else {
+			buf = append(buf, p.Data...)
+		}
+	}
+	binary.BigEndian.PutUint16(buf[2:4], uint16(len(buf)))
+	return buf
+}
+
+// Attr returns the first attribute of type t
+func (p EAPPacket) Attr(t uint8) (EAPAttribute, bool) {
+	for _, a := range p.Attributes {
+		if a.Type == t {
+			return a, true
+		}
+	}
+	return EAPAttribute{}, false
+}
+
+// attribute with two reserved octets before the data
+func eapAttrReserved(t uint8, data []byte) EAPAttribute {
+	return EAPAttribute{Type: t, Value: append([]byte{0, 0}, data...)}
+}
+
+// attribute with the actual data length (in octets, or bits for AT_RES) before the data
+func eapAttrLength(t uint8, length int, data []byte) EAPAttribute {
+	v := []byte{byte(length >> 8), byte(length)}
+	return EAPAttribute{Type: t, Value: append(v, data...)}
+}
+
+// attribute carrying a 16 bit value
+func eapAttrUint16(t uint8, v uint16) EAPAttribute {
+	return EAPAttribute{Type: t, Value: []byte{byte(v >> 8), byte(v)}}
+}
+
+func (a EAPAttribute) uint16() uint16 {
+	if len(a.Value) < 2 {
+		return 0
+	}
+	return binary.BigEndian.Uint16(a.Value)
+}
+
+// lengthData returns the data of an attribute using an actual length field, unit is 1 or 8 (bits)
+func (a EAPAttribute) lengthData(unit int) ([]byte, error) {
+	if len(a.Value) < 2 {
+		return nil, fmt.Errorf("EAP: attribute %d too short", a.Type)
+	}
+	l := (int(binary.BigEndian.Uint16(a.Value)) + unit - 1) / unit
+	if l > len(a.Value)-2 {
+		return nil, fmt.Errorf("EAP: attribute %d actual length %d exceeds attribute", a.Type, l)
+	}
+	return a.Value[2 : 2+l], nil
+}
+
+// eapMACOffset finds the MAC field of AT_MAC in an encoded SIM/AKA packet
+func eapMACOffset(buf []byte) int {
+	for pos := 8; pos+4 <= len(buf) && buf[pos+1] != 0; pos += int(buf[pos+1]) * 4 {
+		if buf[pos] == AT_MAC && int(buf[pos+1])*4 == 4+EAP_MAC_LEN && pos+4+EAP_MAC_LEN <= len(buf) {
+			return pos + 4
+		}
+	}
+	return -1
+}
+
+// eapMAC computes the AT_MAC value over the packet (with a zero MAC field) and extra data
+func eapMAC(newHash func() hash.Hash, kAut, pkt, extra []byte) []byte {
+	tmp := append([]byte{}, pkt...)
+	if off := eapMACOffset(tmp); off >= 0 {
+		copy(tmp[off:off+EAP_MAC_LEN], make([]byte, EAP_MAC_LEN))
+	}
+	mac := hmac.New(newHash, kAut)
+	mac.Write(tmp)
+	mac.Write(extra)
+	return mac.Sum(nil)[:EAP_MAC_LEN]
+}
+
+// eapSign fills in the AT_MAC value of an encoded packet
+func eapSign(newHash func() hash.Hash, kAut, pkt, extra []byte) []byte {
+	if off := eapMACOffset(pkt); off >= 0 {
+		copy(pkt[off:off+EAP_MAC_LEN], eapMAC(newHash, kAut, pkt, extra))
+	}
+	return pkt
+}
+
+// eapVerify checks the AT_MAC value of an encoded packet
+func eapVerify(newHash func() hash.Hash, kAut, pkt, extra []byte) bool {
+	off := eapMACOffset(pkt)
+	if off < 0 {
+		return false
+	}
+	return hmac.Equal(pkt[off:off+EAP_MAC_LEN], eapMAC(newHash, kAut, pkt, extra))
+}
+
+// eapEncrypt encrypts attributes into AT_IV and AT_ENCR_DATA
+func eapEncrypt(kEncr, iv []byte, attrs []EAPAttribute) (ivAttr, encrAttr EAPAttribute, err error) {
+	plain := encodeEAPAttributes(attrs)
+	if pad := (aes.BlockSize - len(plain)%aes.BlockSize) % aes.BlockSize; pad > 0 {
+		// AT_PADDING, all octets zero
+		plain = append(plain, AT_PADDING, byte(pad/4))
+		plain = append(plain, make([]byte, pad-2)...)
+	}
+	var block cipher.Block
+	if block, err = aes.NewCipher(kEncr); err != nil {
+		return
+	}
+	encr := make([]byte, len(plain))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encr, plain)
+	return eapAttrReserved(AT_IV, iv), eapAttrReserved(AT_ENCR_DATA, encr), nil
+}
+
+// eapDecrypt decrypts the AT_ENCR_DATA attribute of p
+func eapDecrypt(kEncr []byte, p EAPPacket) (attrs []EAPAttribute, err error) {
+	iv, ok1 := p.Attr(AT_IV)
+	encr, ok2 := p.Attr(AT_ENCR_DATA)
+	if !ok1 || !ok2 {
+		return nil, nil
+	}
+	if len(iv.Value) != 2+EAP_IV_LEN || len(encr.Value) < 2 || (len(encr.Value)-2)%aes.BlockSize != 0 {
+		err = errors.New("EAP: invalid AT_IV or AT_ENCR_DATA")
+		return
+	}
+	var block cipher.Block
+	if block, err = aes.NewCipher(kEncr); err != nil {
+		return
+	}
+	plain := make([]byte, len(encr.Value)-2)
+	cipher.NewCBCDecrypter(block, iv.Value[2:]).CryptBlocks(plain, encr.Value[2:])
+	return decodeEAPAttributes(plain)
+}
+
+func findAttr(attrs []EAPAttribute, t uint8) (EAPAttribute, bool) {
+	return EAPPacket{Attributes: attrs}.Attr(t)
+}
+
+// fips186PRF is the pseudo-random function of FIPS 186-2 change notice 1
+// as used by RFC 4186 and RFC 4187 for key derivation.
+func fips186PRF(xkey []byte, outLen int) []byte {
+	var key [20]byte
+	copy(key[:], xkey)
+	out := make([]byte, 0, outLen+40)
+	for len(out) < outLen {
+		for i := 0; i < 2; i++ {
+			w := sha1G(key)
+			out = append(out, w[:]...)
+			// XKEY = (1 + XKEY + w) mod 2^160
+			carry := 1
+			for j := 19; j >= 0; j-- {
+				sum := int(key[j]) + int(w[j]) + carry
+				key[j] = byte(sum)
+				carry = sum >> 8
+			}
+		}
+	}
+	return out[:outLen]
+}
+
+// sha1G is the SHA-1 compression function applied to a zero padded 160 bit block
+func sha1G(x [20]byte) (out [20]byte) {
+	var w [80]uint32
+	for i := 0; i < 5; i++ {
+		w[i] = binary.BigEndian.Uint32(x[i*4:])
+	}
+	for i := 16; i < 80; i++ {
+		t := w[i-3] ^ w[i-8] ^ w[i-14] ^ w[i-16]
+		w[i] = t<<1 | t>>31
+	}
+	h := [5]uint32{0x67452301, 0xEFCDAB89, 0x98BADCFE, 0x10325476, 0xC3D2E1F0}
+	a, b, c, d, e := h[0], h[1], h[2], h[3], h[4]
+	for i := 0; i < 80; i++ {
+		var f, k uint32
+		switch {
+		case i < 20:
+			f, k = (b&c)|(^b&d), 0x5A827999
+		case i < 40:
+			f, k = b^c^d, 0x6ED9EBA1
+		case i < 60:
+			f, k = (b&c)|(b&d)|(c&d), 0x8F1BBCDC
+		default:
+			f, k = b^c^d, 0xCA62C1D6
+		}
+		t := (a<<5 | a>>27) + f + e + k + w[i]
+		a, b, c, d, e = t, a, b<<30|b>>2, c, d
+	}
+	h[0] += a
+	h[1] += b
+	h[2] += c
+	h[3] += d
+	h[4] += e
+	for i := 0; i < 5; i++ {
+		binary.BigEndian.PutUint32(out[i*4:], h[i])
+	}
+	return
+}
+
+// akaPrimePRF is PRF' of RFC 9048 3.4
+func akaPrimePRF(key, s []byte, outLen int) []byte {
+	var out, t []byte
+	for i := byte(1); len(out) < outLen; i++ {
+		mac := hmac.New(sha256.New, key)
+		mac.Write(t)
+		mac.Write(s)
+		mac.Write([]byte{i})
+		t = mac.Sum(nil)
+		out = append(out, t...)
+	}
+	return out[:outLen]
+}
+
+// eapKeys holds the keys shared by EAP-SIM, EAP-AKA and EAP-AKA'
+type eapKeys struct {
+	mk    []byte
+	kEncr []byte
+	kAut  []byte
+	kRe   []byte
+	msk   []byte
+	emsk  []byte
+}
+
+// eapFullAuthKeys derives the keys of RFC 4186 7 / RFC 4187 7 from MK
+func eapFullAuthKeys(mk []byte) (k eapKeys) {
+	buf := fips186PRF(mk, 160)
+	k.mk = mk
+	k.kEncr = buf[:16]
+	k.kAut = buf[16:32]
+	k.msk = buf[32:96]
+	k.emsk = buf[96:160]
+	return
+}
+
+// eapReauthKeys derives MSK and EMSK for fast re-authentication of EAP-SIM and EAP-AKA
+func eapReauthKeys(k eapKeys, identity string, counter uint16, nonceS []byte) (msk, emsk []byte) {
+	h := sha1.New()
+	h.Write([]byte(identity))
+	h.Write([]byte{byte(counter >> 8), byte(counter)})
+	h.Write(nonceS)
+	h.Write(k.mk)
+	buf := fips186PRF(h.Sum(nil), 128)
+	return buf[:64], buf[64:]
+}
+
+// akaPrimeFullAuthKeys derives the EAP-AKA' keys of RFC 9048 3.3 from CK' and IK'
+func akaPrimeFullAuthKeys(ckp, ikp []byte, identity string) (k eapKeys) {
+	buf := akaPrimePRF(ckik(ikp, ckp), append([]byte("EAP-AKA'"), identity...), 208)
+	k.kEncr = buf[:16]
+	k.kAut = buf[16:48]
+	k.kRe = buf[48:80]
+	k.msk = buf[80:144]
+	k.emsk = buf[144:208]
+	return
+}
+
+// akaPrimeReauthKeys derives MSK and EMSK for EAP-AKA' fast re-authentication
+func akaPrimeReauthKeys(k eapKeys, identity string, counter uint16, nonceS []byte) (msk, emsk []byte) {
+	s := append([]byte("EAP-AKA' re-auth"), identity...)
+	s = append(s, byte(counter>>8), byte(counter))
+	s = append(s, nonceS...)
+	buf := akaPrimePRF(k.kRe, s, 128)
+	return buf[:64], buf[64:]
+}
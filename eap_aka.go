@@ -0,0 +1,214 @@
+package usim_go
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
+	"hash"
+	"io"
+
+	"github.com/sirupsen/logrus"
+)
+
+// EAPAKAPeer is an EAP-AKA (RFC 4187) and EAP-AKA' (RFC 9048) peer using a USIM.
+// The method is chosen by the EAP type of the requests it is given.
+type EAPAKAPeer struct {
+	eapPeer
+	// Auth runs AKA, it is the USIM given to NewEAPAKAPeer
+	Auth AKAAuthenticator
+	// NetworkName is the access network identity expected in AT_KDF_INPUT, any is accepted if empty
+	NetworkName string
+
+	eapType      uint8
+	identityMsgs []byte
+}
+
+func NewEAPAKAPeer(u *USIM, identity string) *EAPAKAPeer {
+	return &EAPAKAPeer{eapPeer: eapPeer{usim: u, Identity: identity}, Auth: u}
+}
+
+func (p *EAPAKAPeer) prime() bool {
+	return p.eapType == EAP_TYPE_AKA_PRIME
+}
+
+func (p *EAPAKAPeer) hash() func() hash.Hash {
+	if p.prime() {
+		return sha256.New
+	}
+	return sha1.New
+}
+
+// KAUSF returns the KAUSF of an EAP-AKA' authentication for 5G, see TS 33.501 6.1.3.1
+func (p *EAPAKAPeer) KAUSF() ([]byte, error) {
+	if !p.prime() || len(p.emsk) < 32 {
+		return nil, errors.New("EAP-AKA: KAUSF requires a completed EAP-AKA' authentication")
+	}
+	return p.emsk[:32], nil
+}
+
+// Process handles an EAP request and returns the response to send, which
+// is nil for EAP-Success.
+func (p *EAPAKAPeer) Process(buf []byte) (resp []byte, err error) {
+	var req EAPPacket
+	if req, err = DecodeEAP(buf); err != nil {
+		return
+	}
+	var done bool
+	if resp, done, err = p.handleEAP(req, EAP_TYPE_AKA_PRIME, EAP_TYPE_AKA); done {
+		if req.Type == EAP_TYPE_IDENTITY {
+			p.identityMsgs = nil
+		}
+		return
+	}
+	if p.eapType != req.Type {
+		// keys are not shared between EAP-AKA and EAP-AKA'
+		p.keys, p.reauthID = eapKeys{}, ""
+		p.eapType = req.Type
+	}
+	switch req.Subtype {
+	case EAP_AKA_SUBTYPE_IDENTITY:
+		return p.identity(req, buf)
+	case EAP_AKA_SUBTYPE_CHALLENGE:
+		return p.challenge(req, buf)
+	case EAP_SUBTYPE_NOTIFICATION:
+		return p.notification(req, buf, p.hash())
+	case EAP_SUBTYPE_REAUTHENTICATION:
+		return p.reauthentication(req, buf, p.hash(), p.prime())
+	}
+	logrus.Debugf("EAP-AKA: unsupported subtype %d", req.Subtype)
+	return p.clientError(req, EAP_CLIENT_ERROR_UNABLE_TO_PROCESS), nil
+}
+
+func (p *EAPAKAPeer) identity(req EAPPacket, buf []byte) ([]byte, error) {
+	id, ok := p.requestedIdentity(req)
+	if !ok {
+		return p.clientError(req, EAP_CLIENT_ERROR_UNABLE_TO_PROCESS), nil
+	}
+	p.lastIdentity = id
+	resp := p.response(req, EAP_AKA_SUBTYPE_IDENTITY, eapAttrLength(AT_IDENTITY, len(id), []byte(id))).Encode()
+	p.identityMsgs = append(p.identityMsgs, buf...)
+	p.identityMsgs = append(p.identityMsgs, resp...)
+	return resp, nil
+}
+
+func hasAttr(p EAPPacket, t uint8) bool {
+	_, ok := p.Attr(t)
+	return ok
+}
+
+func (p *EAPAKAPeer) checkcode() []byte {
+	if p.identityMsgs == nil {
+		return nil
+	}
+	h := p.hash()()
+	h.Write(p.identityMsgs)
+	return h.Sum(nil)
+}
+
+func (p *EAPAKAPeer) challenge(req EAPPacket, buf []byte) (resp []byte, err error) {
+	randAttr, ok1 := req.Attr(AT_RAND)
+	autnAttr, ok2 := req.Attr(AT_AUTN)
+	if !ok1 || !ok2 || len(randAttr.Value) != 2+AKA_RAND_LEN || len(autnAttr.Value) != 2+AKA_AUTN_LEN || !hasAttr(req, AT_MAC) {
+		return p.clientError(req, EAP_CLIENT_ERROR_UNABLE_TO_PROCESS), nil
+	}
+	var netName string
+	if p.prime() {
+		// the first AT_KDF is the server's choice, only the KDF of RFC 9048 is supported
+		var kdfs []uint16
+		for _, a := range req.Attributes {
+			if a.Type == AT_KDF {
+				kdfs = append(kdfs, a.uint16())
+			}
+		}
+		if len(kdfs) == 0 {
+			return p.clientError(req, EAP_CLIENT_ERROR_UNABLE_TO_PROCESS), nil
+		}
+		if kdfs[0] != EAP_KDF_AKA_PRIME {
+			for _, k := range kdfs[1:] {
+				if k == EAP_KDF_AKA_PRIME {
+					return p.response(req, EAP_AKA_SUBTYPE_CHALLENGE, eapAttrUint16(AT_KDF, EAP_KDF_AKA_PRIME)).Encode(), nil
+				}
+			}
+			return p.response(req, EAP_AKA_SUBTYPE_AUTHENTICATION_REJ).Encode(), nil
+		}
+		kdfInput, ok := req.Attr(AT_KDF_INPUT)
+		if !ok {
+			return p.clientError(req, EAP_CLIENT_ERROR_UNABLE_TO_PROCESS), nil
+		}
+		var name []byte
+		if name, err = kdfInput.lengthData(1); err != nil || len(name) == 0 {
+			return p.clientError(req, EAP_CLIENT_ERROR_UNABLE_TO_PROCESS), nil
+		}
+		netName = string(name)
+		if p.NetworkName != "" && netName != p.NetworkName {
+			logrus.Errorf("EAP-AKA': network name %q does not match %q", netName, p.NetworkName)
+			return p.response(req, EAP_AKA_SUBTYPE_AUTHENTICATION_REJ).Encode(), nil
+		}
+	} else if bidding, ok := req.Attr(AT_BIDDING); ok && bidding.uint16()&0x8000 != 0 {
+		// the server supports EAP-AKA' as well, see RFC 9048 4
+		logrus.Error("EAP-AKA: bidding down attack detected")
+		return p.response(req, EAP_AKA_SUBTYPE_AUTHENTICATION_REJ).Encode(), nil
+	}
+
+	var rand_, autn [16]byte
+	copy(rand_[:], randAttr.Value[2:])
+	copy(autn[:], autnAttr.Value[2:])
+	res, ik, ck, auts, err := p.Auth.GenAuthResMilenage(rand_, autn)
+	if err == UNSYNC {
+		return p.response(req, EAP_AKA_SUBTYPE_SYNC_FAILURE, EAPAttribute{Type: AT_AUTS, Value: auts}).Encode(), nil
+	} else if err != nil {
+		logrus.Error("EAP-AKA: ", err)
+		return p.response(req, EAP_AKA_SUBTYPE_AUTHENTICATION_REJ).Encode(), nil
+	}
+
+	identity := p.lastIdentity
+	if identity == "" {
+		identity = p.Identity
+	}
+	var keys eapKeys
+	if p.prime() {
+		ckp, ikp := DeriveCKIKPrime(ck, ik, netName, autn[:6])
+		keys = akaPrimeFullAuthKeys(ckp, ikp, identity)
+	} else {
+		h := sha1.New()
+		h.Write([]byte(identity))
+		h.Write(ik)
+		h.Write(ck)
+		keys = eapFullAuthKeys(h.Sum(nil))
+	}
+	if !eapVerify(p.hash(), keys.kAut, buf, nil) {
+		logrus.Error("EAP-AKA: AT_MAC verification failed")
+		return p.clientError(req, EAP_CLIENT_ERROR_UNABLE_TO_PROCESS), nil
+	}
+	checkcode, hasCheckcode := req.Attr(AT_CHECKCODE)
+	if hasCheckcode && len(checkcode.Value) > 2 {
+		if string(checkcode.Value[2:]) != string(p.checkcode()) {
+			logrus.Error("EAP-AKA: AT_CHECKCODE mismatch")
+			return p.clientError(req, EAP_CLIENT_ERROR_UNABLE_TO_PROCESS), nil
+		}
+	}
+	var encrAttrs []EAPAttribute
+	if encrAttrs, err = eapDecrypt(keys.kEncr, req); err != nil {
+		return p.clientError(req, EAP_CLIENT_ERROR_UNABLE_TO_PROCESS), nil
+	}
+	p.setKeys(keys, identity, encrAttrs)
+
+	attrs := []EAPAttribute{eapAttrLength(AT_RES, len(res)*8, res)}
+	if hasCheckcode {
+		attrs = append(attrs, eapAttrReserved(AT_CHECKCODE, p.checkcode()))
+	}
+	if hasAttr(req, AT_RESULT_IND) {
+		attrs = append(attrs, eapAttrReserved(AT_RESULT_IND, nil))
+	}
+	attrs = append(attrs, eapAttrReserved(AT_MAC, make([]byte, EAP_MAC_LEN)))
+	return eapSign(p.hash(), keys.kAut, p.response(req, EAP_AKA_SUBTYPE_CHALLENGE, attrs...).Encode(), nil), nil
+}
+
+func eapEncryptRandom(kEncr []byte, attrs []EAPAttribute) (ivAttr, encrAttr EAPAttribute, err error) {
+	iv := make([]byte, EAP_IV_LEN)
+	if _, err = io.ReadFull(rand.Reader, iv); err != nil {
+		return
+	}
+	return eapEncrypt(kEncr, iv, attrs)
+}
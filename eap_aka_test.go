@@ -0,0 +1,244 @@
+package usim_go
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
+	"testing"
+)
+
+// RFC 9048 Appendix C test case 1
+func TestAKAPrimeKeys(t *testing.T) {
+	ck := unhex("5349fbe098649f948f5d2e973a81c00f")
+	ik := unhex("9744871ad32bf9bbd1dd5ce54e3e2e5a")
+	autn := unhex("bb52e91c747ac3ab2a5c23d15ee351d5")
+	ckp, ikp := DeriveCKIKPrime(ck, ik, "WLAN", autn[:6])
+	if !bytes.Equal(ckp, unhex("0093962d0dd84aa5684b045c9edffa04")) {
+		t.Errorf("CK' failed. got %X", ckp)
+	}
+	if !bytes.Equal(ikp, unhex("ccfc230ca74fcc96c0a5d61164f5a76c")) {
+		t.Errorf("IK' failed. got %X", ikp)
+	}
+	k := akaPrimeFullAuthKeys(ckp, ikp, "0555444333222111")
+	if !bytes.Equal(k.kEncr, unhex("766fa0a6c317174b812d52fbcd11a179")) {
+		t.Errorf("K_encr failed. got %X", k.kEncr)
+	}
+	if !bytes.Equal(k.kAut, unhex("0842ea722ff6835bfa2032499fc3ec23c2f0e388b4f07543ffc677f1696d71ea")) {
+		t.Errorf("K_aut failed. got %X", k.kAut)
+	}
+	if !bytes.Equal(k.kRe, unhex("cf83aa8bc7e0aced892acc98e76a9b2095b558c7795c7094715cb3393aa7d17a")) {
+		t.Errorf("K_re failed. got %X", k.kRe)
+	}
+	if !bytes.Equal(k.msk, unhex("67c42d9aa56c1b79e295e3459fc3d187d42be0bf818d3070e362c5e967a4d544e8ecfe19358ab3039aff03b7c930588c055babee58a02650b067ec4e9347c75a")) {
+		t.Errorf("MSK failed. got %X", k.msk)
+	}
+	if !bytes.Equal(k.emsk, unhex("f861703cd775590e16c7679ea3874ada866311de290764d760cf76df647ea01c313f69924bdd7650ca9bac141ea075c4ef9e8029c0e290cdbad5638b63bc23fb")) {
+		t.Errorf("EMSK failed. got %X", k.emsk)
+	}
+}
+
+func TestEAPAKAPrimePeerChallenge(t *testing.T) {
+	u, err := InitSoftUSIM(Milenage, "356092040793011", "208930000000001", "8BAF473F2F8FD09487CCCBD7097C6862", "", "8e27b6af0e692e750f32667a3b14605d", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, _ := InitAuC("8BAF473F2F8FD09487CCCBD7097C6862", "", "8e27b6af0e692e750f32667a3b14605d", "8000", 0x1b57)
+	q, _ := a.GenQuintet()
+	identity := "6208930000000001@nai.5gc.mnc093.mcc208.3gppnetwork.org"
+	peer := NewEAPAKAPeer(&u, identity)
+
+	netName := ServingNetworkName("208", "93")[3:]
+	ckp, ikp := DeriveCKIKPrime(q.CK[:], q.IK[:], netName, q.AUTN[:6])
+	keys := akaPrimeFullAuthKeys(ckp, ikp, identity)
+	iv, encr, _ := eapEncrypt(keys.kEncr, make([]byte, 16), []EAPAttribute{
+		eapAttrLength(AT_NEXT_PSEUDONYM, 6, []byte("pseudo")),
+	})
+	req := EAPPacket{Code: EAP_CODE_REQUEST, Identifier: 7, Type: EAP_TYPE_AKA_PRIME, Subtype: EAP_AKA_SUBTYPE_CHALLENGE, Attributes: []EAPAttribute{
+		eapAttrReserved(AT_RAND, q.RAND[:]),
+		eapAttrReserved(AT_AUTN, q.AUTN[:]),
+		eapAttrLength(AT_KDF_INPUT, len(netName), []byte(netName)),
+		eapAttrUint16(AT_KDF, EAP_KDF_AKA_PRIME),
+		iv, encr,
+		eapAttrReserved(AT_MAC, make([]byte, EAP_MAC_LEN)),
+	}}
+	buf, err := peer.Process(eapSign(sha256.New, keys.kAut, req.Encode(), nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := DecodeEAP(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Subtype != EAP_AKA_SUBTYPE_CHALLENGE || resp.Identifier != 7 {
+		t.Fatalf("unexpected response subtype %d", resp.Subtype)
+	}
+	res, _ := resp.Attributes[0].lengthData(8)
+	if !bytes.Equal(res, q.XRES) {
+		t.Errorf("RES %X != XRES %X", res, q.XRES)
+	}
+	if !eapVerify(sha256.New, keys.kAut, buf, nil) {
+		t.Error("response AT_MAC verification failed")
+	}
+	if peer.Pseudonym() != "pseudo" {
+		t.Errorf("unexpected pseudonym %q", peer.Pseudonym())
+	}
+	if kausf, err := peer.KAUSF(); err != nil || !bytes.Equal(kausf, keys.emsk[:32]) {
+		t.Error("KAUSF failed")
+	}
+	if _, err := peer.Process([]byte{EAP_CODE_SUCCESS, 8, 0, 4}); err != nil || !peer.Success() {
+		t.Error("EAP-Success not handled")
+	}
+}
+
+func TestEAPAKAPeer(t *testing.T) {
+	identity := "0208930000000001@wlan.mnc093.mcc208.3gppnetwork.org"
+	stub := akaStub{res: unhex("a54211d5e3ba50bf"), ck: unhex("b40ba9a3c58b2a05bbf0d987b21bf8cb"), ik: unhex("f769bcd751044604127672711c6d3441")}
+	peer := NewEAPAKAPeer(nil, identity)
+	peer.Auth = stub
+	process := func(buf []byte) []byte {
+		out, err := peer.Process(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	idReq := EAPPacket{Code: EAP_CODE_REQUEST, Identifier: 1, Type: EAP_TYPE_AKA, Subtype: EAP_AKA_SUBTYPE_IDENTITY, Attributes: []EAPAttribute{
+		eapAttrReserved(AT_PERMANENT_ID_REQ, nil),
+	}}.Encode()
+	idResp := process(idReq)
+	expect := EAPPacket{Code: EAP_CODE_RESPONSE, Identifier: 1, Type: EAP_TYPE_AKA, Subtype: EAP_AKA_SUBTYPE_IDENTITY, Attributes: []EAPAttribute{
+		eapAttrLength(AT_IDENTITY, len(identity), []byte(identity)),
+	}}.Encode()
+	if !bytes.Equal(idResp, expect) {
+		t.Fatalf("identity response %x", idResp)
+	}
+
+	// RFC 4187 7: MK = SHA1(Identity | IK | CK)
+	h := sha1.New()
+	h.Write([]byte(identity))
+	h.Write(stub.ik)
+	h.Write(stub.ck)
+	keys := eapFullAuthKeys(h.Sum(nil))
+	h = sha1.New()
+	h.Write(idReq)
+	h.Write(idResp)
+	checkcode := h.Sum(nil)
+	iv, encr, _ := eapEncrypt(keys.kEncr, make([]byte, EAP_IV_LEN), []EAPAttribute{
+		eapAttrLength(AT_NEXT_REAUTH_ID, 7, []byte("reauth1")),
+	})
+	rand_, autn := make([]byte, AKA_RAND_LEN), make([]byte, AKA_AUTN_LEN)
+	challenge := EAPPacket{Code: EAP_CODE_REQUEST, Identifier: 2, Type: EAP_TYPE_AKA, Subtype: EAP_AKA_SUBTYPE_CHALLENGE, Attributes: []EAPAttribute{
+		eapAttrReserved(AT_RAND, rand_),
+		eapAttrReserved(AT_AUTN, autn),
+		eapAttrReserved(AT_CHECKCODE, checkcode),
+		iv, encr,
+		eapAttrReserved(AT_MAC, make([]byte, EAP_MAC_LEN)),
+	}}
+	resp := process(eapSign(sha1.New, keys.kAut, challenge.Encode(), nil))
+	expect = eapSign(sha1.New, keys.kAut, EAPPacket{Code: EAP_CODE_RESPONSE, Identifier: 2, Type: EAP_TYPE_AKA, Subtype: EAP_AKA_SUBTYPE_CHALLENGE, Attributes: []EAPAttribute{
+		eapAttrLength(AT_RES, 64, stub.res),
+		eapAttrReserved(AT_CHECKCODE, checkcode),
+		eapAttrReserved(AT_MAC, make([]byte, EAP_MAC_LEN)),
+	}}.Encode(), nil)
+	if !bytes.Equal(resp, expect) {
+		t.Fatalf("challenge response %x", resp)
+	}
+	if _, err := peer.Process([]byte{EAP_CODE_SUCCESS, 3, 0, 4}); err != nil || !peer.Success() {
+		t.Fatal("EAP-Success not handled")
+	}
+	if !bytes.Equal(peer.MSK(), keys.msk) || !bytes.Equal(peer.EMSK(), keys.emsk) || peer.ReauthID() != "reauth1" {
+		t.Errorf("MSK %x, EMSK %x, re-authentication identity %q", peer.MSK(), peer.EMSK(), peer.ReauthID())
+	}
+	if _, err := peer.KAUSF(); err == nil {
+		t.Error("KAUSF of EAP-AKA")
+	}
+
+	// fast re-authentication with the identity handed out
+	if resp = process([]byte{EAP_CODE_REQUEST, 4, 0, 5, EAP_TYPE_IDENTITY}); !bytes.Equal(resp, append([]byte{EAP_CODE_RESPONSE, 4, 0, 12, EAP_TYPE_IDENTITY}, "reauth1"...)) {
+		t.Fatalf("identity %x", resp)
+	}
+	nonceS := unhex("000102030405060708090a0b0c0d0e0f")
+	iv, encr, _ = eapEncrypt(keys.kEncr, make([]byte, EAP_IV_LEN), []EAPAttribute{
+		eapAttrUint16(AT_COUNTER, 1),
+		eapAttrReserved(AT_NONCE_S, nonceS),
+	})
+	reauth := EAPPacket{Code: EAP_CODE_REQUEST, Identifier: 5, Type: EAP_TYPE_AKA, Subtype: EAP_SUBTYPE_REAUTHENTICATION, Attributes: []EAPAttribute{
+		iv, encr, eapAttrReserved(AT_MAC, make([]byte, EAP_MAC_LEN)),
+	}}
+	resp = process(eapSign(sha1.New, keys.kAut, reauth.Encode(), nil))
+	if !eapVerify(sha1.New, keys.kAut, resp, nonceS) {
+		t.Fatal("re-authentication response AT_MAC verification failed")
+	}
+	r, _ := DecodeEAP(resp)
+	attrs, err := eapDecrypt(keys.kEncr, r)
+	if c, _ := findAttr(attrs, AT_COUNTER); err != nil || r.Subtype != EAP_SUBTYPE_REAUTHENTICATION || c.uint16() != 1 || hasAttr(EAPPacket{Attributes: attrs}, AT_COUNTER_TOO_SMALL) {
+		t.Fatalf("re-authentication response %+v, encrypted %+v", r, attrs)
+	}
+	msk, emsk := eapReauthKeys(keys, "reauth1", 1, nonceS)
+	if !bytes.Equal(peer.MSK(), msk) || !bytes.Equal(peer.EMSK(), emsk) || peer.ReauthID() != "" {
+		t.Errorf("re-authentication MSK %x, EMSK %x", peer.MSK(), peer.EMSK())
+	}
+}
+
+func TestEAPAKAPeerFailures(t *testing.T) {
+	auts := unhex("0102030405060708090a0b0c0d0e")
+	challenge := EAPPacket{Code: EAP_CODE_REQUEST, Identifier: 3, Type: EAP_TYPE_AKA, Subtype: EAP_AKA_SUBTYPE_CHALLENGE, Attributes: []EAPAttribute{
+		eapAttrReserved(AT_RAND, make([]byte, AKA_RAND_LEN)),
+		eapAttrReserved(AT_AUTN, make([]byte, AKA_AUTN_LEN)),
+		eapAttrReserved(AT_MAC, make([]byte, EAP_MAC_LEN)),
+	}}.Encode()
+	for _, v := range []struct {
+		name   string
+		stub   akaStub
+		expect string
+	}{
+		// RFC 4187 9.6, AKA-Synchronization-Failure with AT_AUTS
+		{"synchronisation failure", akaStub{auts: auts, err: UNSYNC}, "0203001817040000" + "0404" + "0102030405060708090a0b0c0d0e"},
+		// RFC 4187 9.5, AKA-Authentication-Reject
+		{"authentication reject", akaStub{err: errors.New("MAC failure")}, "0203000817020000"},
+	} {
+		peer := NewEAPAKAPeer(nil, "0208930000000001@wlan.mnc093.mcc208.3gppnetwork.org")
+		peer.Auth = v.stub
+		resp, err := peer.Process(challenge)
+		if err != nil || !bytes.Equal(resp, unhex(v.expect)) {
+			t.Errorf("%s: response %x %v", v.name, resp, err)
+		}
+		if peer.MSK() != nil {
+			t.Errorf("%s: keys derived", v.name)
+		}
+	}
+}
+
+// RFC 9048 Appendix C test case 1 run through the peer, KAUSF is the first
+// 256 bits of EMSK, see TS 33.501 6.1.3.1
+func TestEAPAKAPrimePeerKAUSF(t *testing.T) {
+	peer := NewEAPAKAPeer(nil, "0555444333222111")
+	peer.Auth = akaStub{res: unhex("28d7b0f2a2ec3de5"), ck: unhex("5349fbe098649f948f5d2e973a81c00f"), ik: unhex("9744871ad32bf9bbd1dd5ce54e3e2e5a")}
+	kAut := unhex("0842ea722ff6835bfa2032499fc3ec23c2f0e388b4f07543ffc677f1696d71ea")
+	challenge := EAPPacket{Code: EAP_CODE_REQUEST, Identifier: 1, Type: EAP_TYPE_AKA_PRIME, Subtype: EAP_AKA_SUBTYPE_CHALLENGE, Attributes: []EAPAttribute{
+		eapAttrReserved(AT_RAND, unhex("81e92b6c0ee0e12ebceba8d92a99dfa5")),
+		eapAttrReserved(AT_AUTN, unhex("bb52e91c747ac3ab2a5c23d15ee351d5")),
+		eapAttrLength(AT_KDF_INPUT, 4, []byte("WLAN")),
+		eapAttrUint16(AT_KDF, EAP_KDF_AKA_PRIME),
+		eapAttrReserved(AT_MAC, make([]byte, EAP_MAC_LEN)),
+	}}
+	resp, err := peer.Process(eapSign(sha256.New, kAut, challenge.Encode(), nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := eapSign(sha256.New, kAut, EAPPacket{Code: EAP_CODE_RESPONSE, Identifier: 1, Type: EAP_TYPE_AKA_PRIME, Subtype: EAP_AKA_SUBTYPE_CHALLENGE, Attributes: []EAPAttribute{
+		eapAttrLength(AT_RES, 64, unhex("28d7b0f2a2ec3de5")),
+		eapAttrReserved(AT_MAC, make([]byte, EAP_MAC_LEN)),
+	}}.Encode(), nil)
+	if !bytes.Equal(resp, expect) {
+		t.Fatalf("challenge response %x", resp)
+	}
+	if !bytes.Equal(peer.MSK(), unhex("67c42d9aa56c1b79e295e3459fc3d187d42be0bf818d3070e362c5e967a4d544e8ecfe19358ab3039aff03b7c930588c055babee58a02650b067ec4e9347c75a")) {
+		t.Errorf("MSK %x", peer.MSK())
+	}
+	if kausf, err := peer.KAUSF(); err != nil || !bytes.Equal(kausf, unhex("f861703cd775590e16c7679ea3874ada866311de290764d760cf76df647ea01c")) {
+		t.Errorf("KAUSF %x %v", kausf, err)
+	}
+}
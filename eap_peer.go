@@ -0,0 +1,225 @@
+package usim_go
+
+import (
+	"errors"
+	"hash"
+
+	"github.com/sirupsen/logrus"
+)
+
+// eapPeer is the state shared by the EAP-SIM, EAP-AKA and EAP-AKA' peers:
+// identities, keys and the handling of notification and fast re-authentication.
+type eapPeer struct {
+	usim *USIM
+	// Identity is the permanent identity, see IMSI based NAI in TS 23.003 14.2
+	Identity string
+
+	pseudonym    string
+	reauthID     string
+	lastIdentity string
+	keys         eapKeys
+	counter      uint16
+	// identity used to derive the current keys
+	keyIdentity string
+	msk         []byte
+	emsk        []byte
+	success     bool
+}
+
+// MSK returns the master session key of the last successful authentication
+func (p *eapPeer) MSK() []byte {
+	return p.msk
+}
+
+// EMSK returns the extended master session key of the last successful authentication
+func (p *eapPeer) EMSK() []byte {
+	return p.emsk
+}
+
+// Pseudonym returns the pseudonym received in AT_NEXT_PSEUDONYM
+func (p *eapPeer) Pseudonym() string {
+	return p.pseudonym
+}
+
+// ReauthID returns the fast re-authentication identity received in AT_NEXT_REAUTH_ID
+func (p *eapPeer) ReauthID() string {
+	return p.reauthID
+}
+
+// Success reports whether EAP-Success has been received
+func (p *eapPeer) Success() bool {
+	return p.success
+}
+
+func (p *eapPeer) anyIdentity() string {
+	if p.reauthID != "" && p.keys.kAut != nil {
+		return p.reauthID
+	}
+	return p.fullAuthIdentity()
+}
+
+func (p *eapPeer) fullAuthIdentity() string {
+	if p.pseudonym != "" {
+		return p.pseudonym
+	}
+	return p.Identity
+}
+
+// requestedIdentity selects the identity asked for by AT_*_ID_REQ, ok is false if none is requested
+func (p *eapPeer) requestedIdentity(req EAPPacket) (id string, ok bool) {
+	switch {
+	case hasAttr(req, AT_PERMANENT_ID_REQ):
+		return p.Identity, true
+	case hasAttr(req, AT_FULLAUTH_ID_REQ):
+		return p.fullAuthIdentity(), true
+	case hasAttr(req, AT_ANY_ID_REQ):
+		return p.anyIdentity(), true
+	}
+	return "", false
+}
+
+// handleEAP processes the generic EAP layer. done is false if the request
+// is of type eapTypes and has to be handled by the method.
+func (p *eapPeer) handleEAP(req EAPPacket, eapTypes ...uint8) (resp []byte, done bool, err error) {
+	switch req.Code {
+	case EAP_CODE_SUCCESS:
+		p.success = p.msk != nil
+		return nil, true, nil
+	case EAP_CODE_FAILURE:
+		p.success = false
+		return nil, true, errors.New("EAP: received EAP-Failure")
+	case EAP_CODE_REQUEST:
+	default:
+		return nil, true, errors.New("EAP: unexpected code")
+	}
+	switch req.Type {
+	case EAP_TYPE_IDENTITY:
+		p.lastIdentity = p.anyIdentity()
+		return EAPPacket{Code: EAP_CODE_RESPONSE, Identifier: req.Identifier, Type: EAP_TYPE_IDENTITY, Data: []byte(p.lastIdentity)}.Encode(), true, nil
+	case EAP_TYPE_NOTIFICATION:
+		return EAPPacket{Code: EAP_CODE_RESPONSE, Identifier: req.Identifier, Type: EAP_TYPE_NOTIFICATION}.Encode(), true, nil
+	}
+	for _, t := range eapTypes {
+		if req.Type == t {
+			return nil, false, nil
+		}
+	}
+	logrus.Debugf("EAP: NAK for EAP type %d", req.Type)
+	return EAPPacket{Code: EAP_CODE_RESPONSE, Identifier: req.Identifier, Type: EAP_TYPE_NAK, Data: eapTypes}.Encode(), true, nil
+}
+
+func (p *eapPeer) response(req EAPPacket, subtype uint8, attrs ...EAPAttribute) EAPPacket {
+	return EAPPacket{Code: EAP_CODE_RESPONSE, Identifier: req.Identifier, Type: req.Type, Subtype: subtype, Attributes: attrs}
+}
+
+func (p *eapPeer) clientError(req EAPPacket, code uint16) []byte {
+	return p.response(req, EAP_SUBTYPE_CLIENT_ERROR, eapAttrUint16(AT_CLIENT_ERROR_CODE, code)).Encode()
+}
+
+// setKeys installs the keys of a successful full authentication and the
+// identities received in AT_ENCR_DATA.
+func (p *eapPeer) setKeys(keys eapKeys, identity string, encrAttrs []EAPAttribute) {
+	if a, ok := findAttr(encrAttrs, AT_NEXT_PSEUDONYM); ok {
+		if id, err := a.lengthData(1); err == nil {
+			p.pseudonym = string(id)
+		}
+	}
+	p.reauthID = ""
+	if a, ok := findAttr(encrAttrs, AT_NEXT_REAUTH_ID); ok {
+		if id, err := a.lengthData(1); err == nil {
+			p.reauthID = string(id)
+		}
+	}
+	p.keys, p.keyIdentity, p.counter = keys, identity, 0
+	p.msk, p.emsk = keys.msk, keys.emsk
+}
+
+func (p *eapPeer) notification(req EAPPacket, buf []byte, newHash func() hash.Hash) ([]byte, error) {
+	n, ok := req.Attr(AT_NOTIFICATION)
+	if !ok {
+		return p.clientError(req, EAP_CLIENT_ERROR_UNABLE_TO_PROCESS), nil
+	}
+	code := n.uint16()
+	logrus.Infof("EAP: notification %d", code)
+	if code&0x8000 == 0 {
+		p.success = false
+	}
+	if code&0x4000 != 0 {
+		// notification before authentication, no AT_MAC
+		return p.response(req, EAP_SUBTYPE_NOTIFICATION).Encode(), nil
+	}
+	if p.keys.kAut == nil || !eapVerify(newHash, p.keys.kAut, buf, nil) {
+		return p.clientError(req, EAP_CLIENT_ERROR_UNABLE_TO_PROCESS), nil
+	}
+	attrs := []EAPAttribute{}
+	if p.counter > 0 {
+		// after fast re-authentication the response carries the encrypted counter
+		iv, encr, err := eapEncryptRandom(p.keys.kEncr, []EAPAttribute{eapAttrUint16(AT_COUNTER, p.counter)})
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, iv, encr)
+	}
+	attrs = append(attrs, eapAttrReserved(AT_MAC, make([]byte, EAP_MAC_LEN)))
+	return eapSign(newHash, p.keys.kAut, p.response(req, EAP_SUBTYPE_NOTIFICATION, attrs...).Encode(), nil), nil
+}
+
+// reauthentication handles a Re-authentication request of EAP-SIM, EAP-AKA or EAP-AKA'
+func (p *eapPeer) reauthentication(req EAPPacket, buf []byte, newHash func() hash.Hash, prime bool) ([]byte, error) {
+	if p.keys.kAut == nil {
+		logrus.Error("EAP: re-authentication without previous full authentication")
+		return p.clientError(req, EAP_CLIENT_ERROR_UNABLE_TO_PROCESS), nil
+	}
+	if !eapVerify(newHash, p.keys.kAut, buf, nil) {
+		logrus.Error("EAP: AT_MAC verification failed")
+		return p.clientError(req, EAP_CLIENT_ERROR_UNABLE_TO_PROCESS), nil
+	}
+	attrs, err := eapDecrypt(p.keys.kEncr, req)
+	if err != nil {
+		return p.clientError(req, EAP_CLIENT_ERROR_UNABLE_TO_PROCESS), nil
+	}
+	counterAttr, ok1 := findAttr(attrs, AT_COUNTER)
+	nonceAttr, ok2 := findAttr(attrs, AT_NONCE_S)
+	if !ok1 || !ok2 || len(nonceAttr.Value) != 2+EAP_NONCE_LEN {
+		logrus.Error("EAP: missing AT_COUNTER or AT_NONCE_S")
+		return p.clientError(req, EAP_CLIENT_ERROR_UNABLE_TO_PROCESS), nil
+	}
+	nonceS := nonceAttr.Value[2:]
+	counter := counterAttr.uint16()
+	encrAttrs := []EAPAttribute{eapAttrUint16(AT_COUNTER, counter)}
+	tooSmall := counter <= p.counter
+	if tooSmall {
+		encrAttrs = append(encrAttrs, eapAttrReserved(AT_COUNTER_TOO_SMALL, nil))
+	}
+	iv, encr, err := eapEncryptRandom(p.keys.kEncr, encrAttrs)
+	if err != nil {
+		return nil, err
+	}
+	respAttrs := []EAPAttribute{iv, encr}
+	if hasAttr(req, AT_RESULT_IND) && !tooSmall {
+		respAttrs = append(respAttrs, eapAttrReserved(AT_RESULT_IND, nil))
+	}
+	respAttrs = append(respAttrs, eapAttrReserved(AT_MAC, make([]byte, EAP_MAC_LEN)))
+	resp := eapSign(newHash, p.keys.kAut, p.response(req, EAP_SUBTYPE_REAUTHENTICATION, respAttrs...).Encode(), nonceS)
+	if tooSmall {
+		logrus.Infof("EAP: re-authentication counter %d too small", counter)
+		return resp, nil
+	}
+	identity := p.lastIdentity
+	if identity == "" {
+		identity = p.keyIdentity
+	}
+	p.counter = counter
+	if prime {
+		p.msk, p.emsk = akaPrimeReauthKeys(p.keys, identity, counter, nonceS)
+	} else {
+		p.msk, p.emsk = eapReauthKeys(p.keys, identity, counter, nonceS)
+	}
+	p.reauthID = ""
+	if a, ok := findAttr(attrs, AT_NEXT_REAUTH_ID); ok {
+		if id, err := a.lengthData(1); err == nil {
+			p.reauthID = string(id)
+		}
+	}
+	return resp, nil
+}
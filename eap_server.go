@@ -0,0 +1,363 @@
+package usim_go
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// EAPServer is an EAP-SIM/AKA/AKA' authenticator backed by a local
+// SubscriberStore, generating its own vectors.
+type EAPServer struct {
+	Store *SubscriberStore
+	// NetworkName is the access network identity sent in AT_KDF_INPUT for EAP-AKA'
+	NetworkName string
+	// DefaultType is the method used when the identity does not select one
+	DefaultType uint8
+}
+
+func NewEAPServer(store *SubscriberStore, networkName string) *EAPServer {
+	return &EAPServer{Store: store, NetworkName: networkName, DefaultType: EAP_TYPE_AKA_PRIME}
+}
+
+// EAPSession is the server side of a single EAP conversation
+type EAPSession struct {
+	server     *EAPServer
+	identifier uint8
+	eapType    uint8
+	identity   string
+	imsi       string
+	// last request sent, used to detect retransmissions
+	lastReq []byte
+	keys    eapKeys
+	xres    []byte
+	rand    [16]byte
+	nonceMT []byte
+	sres    []byte
+	idReqs  int
+	done    bool
+	success bool
+}
+
+// NewSession starts a conversation, the returned request is EAP-Request/Identity
+func (s *EAPServer) NewSession() (*EAPSession, []byte, error) {
+	sess := &EAPSession{server: s}
+	var id [1]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, nil, err
+	}
+	sess.identifier = id[0]
+	return sess, sess.request(EAPPacket{Code: EAP_CODE_REQUEST, Type: EAP_TYPE_IDENTITY}), nil
+}
+
+// MSK returns the master session key once the session succeeded
+func (sess *EAPSession) MSK() []byte {
+	if !sess.success {
+		return nil
+	}
+	return sess.keys.msk
+}
+
+// EMSK returns the extended master session key once the session succeeded
+func (sess *EAPSession) EMSK() []byte {
+	if !sess.success {
+		return nil
+	}
+	return sess.keys.emsk
+}
+
+// Identity returns the identity the peer authenticated with
+func (sess *EAPSession) Identity() string {
+	return sess.identity
+}
+
+// Done reports whether EAP-Success or EAP-Failure has been sent, and which
+func (sess *EAPSession) Done() (done, success bool) {
+	return sess.done, sess.success
+}
+
+func (sess *EAPSession) request(p EAPPacket) []byte {
+	sess.identifier++
+	p.Code = EAP_CODE_REQUEST
+	p.Identifier = sess.identifier
+	sess.lastReq = p.Encode()
+	return sess.lastReq
+}
+
+func (sess *EAPSession) signedRequest(p EAPPacket, extra []byte) []byte {
+	buf := sess.request(p)
+	sess.lastReq = eapSign(sess.hash(), sess.keys.kAut, buf, extra)
+	return sess.lastReq
+}
+
+func (sess *EAPSession) finish(success bool) []byte {
+	sess.done, sess.success = true, success
+	sess.identifier++
+	code := uint8(EAP_CODE_FAILURE)
+	if success {
+		code = EAP_CODE_SUCCESS
+	}
+	return EAPPacket{Code: code, Identifier: sess.identifier}.Encode()
+}
+
+func (sess *EAPSession) hash() func() hash.Hash {
+	if sess.eapType == EAP_TYPE_AKA_PRIME {
+		return sha256.New
+	}
+	return sha1.New
+}
+
+// Process handles an EAP response and returns the next request, or the
+// final EAP-Success/Failure.
+func (sess *EAPSession) Process(buf []byte) (req []byte, err error) {
+	if sess.done {
+		return nil, errors.New("EAP: session already finished")
+	}
+	var resp EAPPacket
+	if resp, err = DecodeEAP(buf); err != nil {
+		return
+	}
+	if resp.Code != EAP_CODE_RESPONSE {
+		return nil, fmt.Errorf("EAP: unexpected code %d", resp.Code)
+	}
+	if resp.Identifier != sess.identifier {
+		logrus.Debugf("EAP: dropping response with identifier %d (expected %d)", resp.Identifier, sess.identifier)
+		return sess.lastReq, nil
+	}
+	switch resp.Type {
+	case EAP_TYPE_IDENTITY:
+		return sess.start(string(resp.Data))
+	case EAP_TYPE_NAK:
+		for _, t := range resp.Data {
+			if (t == EAP_TYPE_SIM || t == EAP_TYPE_AKA || t == EAP_TYPE_AKA_PRIME) && sess.eapType != t {
+				sess.eapType = t
+				return sess.fullAuth()
+			}
+		}
+		return sess.finish(false), nil
+	case sess.eapType:
+	default:
+		return sess.finish(false), nil
+	}
+	switch resp.Subtype {
+	case EAP_AKA_SUBTYPE_IDENTITY, EAP_SIM_SUBTYPE_START:
+		if id, ok := resp.Attr(AT_IDENTITY); ok {
+			var data []byte
+			if data, err = id.lengthData(1); err != nil {
+				return sess.finish(false), nil
+			}
+			sess.identity = string(data)
+		}
+		if resp.Subtype == EAP_SIM_SUBTYPE_START {
+			nonce, ok := resp.Attr(AT_NONCE_MT)
+			if !ok || len(nonce.Value) != 2+EAP_NONCE_LEN {
+				return sess.finish(false), nil
+			}
+			sess.nonceMT = nonce.Value[2:]
+			if v, ok := resp.Attr(AT_SELECTED_VERSION); !ok || v.uint16() != EAP_SIM_VERSION {
+				return sess.finish(false), nil
+			}
+		}
+		return sess.fullAuth()
+	case EAP_AKA_SUBTYPE_CHALLENGE, EAP_SIM_SUBTYPE_CHALLENGE:
+		return sess.challengeResponse(resp, buf)
+	case EAP_AKA_SUBTYPE_SYNC_FAILURE:
+		auts, ok := resp.Attr(AT_AUTS)
+		if !ok {
+			return sess.finish(false), nil
+		}
+		if err = sess.server.Store.Lookup(sess.imsi, func(a *AuC) error {
+			_, err := a.Resync(sess.rand, auts.Value)
+			return err
+		}); err != nil {
+			logrus.Error("EAP: ", err)
+			return sess.finish(false), nil
+		}
+		return sess.fullAuth()
+	case EAP_AKA_SUBTYPE_AUTHENTICATION_REJ:
+		logrus.Info("EAP: peer rejected authentication")
+		return sess.finish(false), nil
+	case EAP_SUBTYPE_CLIENT_ERROR:
+		if code, ok := resp.Attr(AT_CLIENT_ERROR_CODE); ok {
+			logrus.Infof("EAP: client error %d", code.uint16())
+		}
+		return sess.finish(false), nil
+	}
+	return sess.finish(false), nil
+}
+
+// identityIMSI extracts the IMSI of a permanent identity, see TS 23.003 14.2 and 19.3.2
+func identityIMSI(identity string) (eapType uint8, imsi string, ok bool) {
+	user := identity
+	if i := strings.IndexByte(identity, '@'); i >= 0 {
+		user = identity[:i]
+	}
+	if len(user) != 16 {
+		return 0, "", false
+	}
+	switch user[0] {
+	case '0':
+		eapType = EAP_TYPE_AKA
+	case '1':
+		eapType = EAP_TYPE_SIM
+	case '6':
+		eapType = EAP_TYPE_AKA_PRIME
+	default:
+		return 0, "", false
+	}
+	if _, err := convert_imsi(user[1:]); err != nil || strings.Trim(user[1:], "0123456789") != "" {
+		return 0, "", false
+	}
+	return eapType, user[1:], true
+}
+
+func (sess *EAPSession) start(identity string) ([]byte, error) {
+	sess.identity = identity
+	if t, _, ok := identityIMSI(identity); ok {
+		sess.eapType = t
+	} else if sess.eapType == 0 {
+		sess.eapType = sess.server.DefaultType
+	}
+	return sess.fullAuth()
+}
+
+// fullAuth requests the permanent identity if needed, then sends the challenge
+func (sess *EAPSession) fullAuth() ([]byte, error) {
+	t, imsi, ok := identityIMSI(sess.identity)
+	if ok && t == EAP_TYPE_SIM && sess.eapType != EAP_TYPE_SIM {
+		ok = false
+	}
+	if !ok || (sess.eapType == EAP_TYPE_SIM && sess.nonceMT == nil) {
+		// EAP-SIM always starts with Start, the other methods only if the identity is unusable
+		if sess.idReqs >= 3 {
+			return sess.finish(false), nil
+		}
+		sess.idReqs++
+		var attrs []EAPAttribute
+		if !ok {
+			attrs = append(attrs, eapAttrReserved(AT_PERMANENT_ID_REQ, nil))
+		}
+		if sess.eapType == EAP_TYPE_SIM {
+			attrs = append(attrs, eapAttrLength(AT_VERSION_LIST, 2, []byte{0, EAP_SIM_VERSION}))
+			return sess.request(EAPPacket{Type: EAP_TYPE_SIM, Subtype: EAP_SIM_SUBTYPE_START, Attributes: attrs}), nil
+		}
+		return sess.request(EAPPacket{Type: sess.eapType, Subtype: EAP_AKA_SUBTYPE_IDENTITY, Attributes: attrs}), nil
+	}
+	sess.imsi = imsi
+	if sess.eapType == EAP_TYPE_SIM {
+		return sess.simChallenge()
+	}
+	return sess.akaChallenge()
+}
+
+func (sess *EAPSession) akaChallenge() ([]byte, error) {
+	var q Quintet
+	if err := sess.server.Store.Lookup(sess.imsi, func(a *AuC) (err error) {
+		if sess.eapType == EAP_TYPE_AKA_PRIME {
+			// AMF separation bit, see TS 33.402 6.2
+			amf := a.amf
+			a.amf[0] |= 0x80
+			q, err = a.GenQuintet()
+			a.amf = amf
+			return
+		}
+		q, err = a.GenQuintet()
+		return
+	}); err != nil {
+		logrus.Error("EAP: ", err)
+		return sess.finish(false), nil
+	}
+	sess.rand, sess.xres = q.RAND, q.XRES
+	attrs := []EAPAttribute{eapAttrReserved(AT_RAND, q.RAND[:]), eapAttrReserved(AT_AUTN, q.AUTN[:])}
+	if sess.eapType == EAP_TYPE_AKA_PRIME {
+		name := sess.server.NetworkName
+		ckp, ikp := DeriveCKIKPrime(q.CK[:], q.IK[:], name, q.AUTN[:6])
+		sess.keys = akaPrimeFullAuthKeys(ckp, ikp, sess.identity)
+		attrs = append(attrs, eapAttrLength(AT_KDF_INPUT, len(name), []byte(name)), eapAttrUint16(AT_KDF, EAP_KDF_AKA_PRIME))
+	} else {
+		h := sha1.New()
+		h.Write([]byte(sess.identity))
+		h.Write(q.IK[:])
+		h.Write(q.CK[:])
+		sess.keys = eapFullAuthKeys(h.Sum(nil))
+	}
+	attrs = append(attrs, eapAttrReserved(AT_MAC, make([]byte, EAP_MAC_LEN)))
+	return sess.signedRequest(EAPPacket{Type: sess.eapType, Subtype: EAP_AKA_SUBTYPE_CHALLENGE, Attributes: attrs}, nil), nil
+}
+
+func (sess *EAPSession) simChallenge() ([]byte, error) {
+	var rands, kc []byte
+	sess.sres = nil
+	if err := sess.server.Store.Lookup(sess.imsi, func(a *AuC) error {
+		for i := 0; i < EAP_SIM_MAX_RAND; i++ {
+			t, err := a.GenTriplet()
+			if err != nil {
+				return err
+			}
+			rands = append(rands, t.RAND[:]...)
+			sess.sres = append(sess.sres, t.SRES[:]...)
+			kc = append(kc, t.Kc[:]...)
+		}
+		return nil
+	}); err != nil {
+		logrus.Error("EAP: ", err)
+		return sess.finish(false), nil
+	}
+	mk := eapSIMMasterKey(sess.identity, kc, sess.nonceMT, []byte{0, EAP_SIM_VERSION}, EAP_SIM_VERSION)
+	sess.keys = eapFullAuthKeys(mk)
+	attrs := []EAPAttribute{eapAttrReserved(AT_RAND, rands), eapAttrReserved(AT_MAC, make([]byte, EAP_MAC_LEN))}
+	return sess.signedRequest(EAPPacket{Type: EAP_TYPE_SIM, Subtype: EAP_SIM_SUBTYPE_CHALLENGE, Attributes: attrs}, sess.nonceMT), nil
+}
+
+func (sess *EAPSession) challengeResponse(resp EAPPacket, buf []byte) ([]byte, error) {
+	if sess.keys.kAut == nil {
+		return sess.finish(false), nil
+	}
+	var extra []byte
+	if sess.eapType == EAP_TYPE_SIM {
+		extra = sess.sres
+	}
+	if !eapVerify(sess.hash(), sess.keys.kAut, buf, extra) {
+		logrus.Error("EAP: AT_MAC verification failed")
+		return sess.finish(false), nil
+	}
+	if sess.eapType != EAP_TYPE_SIM {
+		resAttr, ok := resp.Attr(AT_RES)
+		if !ok {
+			return sess.finish(false), nil
+		}
+		res, err := resAttr.lengthData(8)
+		if err != nil || !hmac.Equal(res, sess.xres) {
+			logrus.Error("EAP: RES does not match XRES")
+			return sess.finish(false), nil
+		}
+	}
+	logrus.Infof("EAP: %s authenticated", sess.identity)
+	return sess.finish(true), nil
+}
+
+func readRandom(n int) ([]byte, error) {
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(rand.Reader, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// randomBytes is readRandom for values that must never be left predictable,
+// it panics if the system random source fails
+func randomBytes(n int) []byte {
+	buf, err := readRandom(n)
+	if err != nil {
+		panic(err)
+	}
+	return buf
+}
@@ -0,0 +1,184 @@
+package usim_go
+
+import (
+	"bytes"
+	"crypto/md5"
+	"net"
+	"testing"
+	"time"
+)
+
+func testSubscriber(t *testing.T) (*USIM, *SubscriberStore) {
+	u, err := InitSoftUSIM(Milenage, "356092040793011", "208930000000001", "8BAF473F2F8FD09487CCCBD7097C6862", "", "8e27b6af0e692e750f32667a3b14605d", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewSubscriberStore()
+	if err = store.Add("208930000000001", "8BAF473F2F8FD09487CCCBD7097C6862", "", "8e27b6af0e692e750f32667a3b14605d", "8000", 0x1b57); err != nil {
+		t.Fatal(err)
+	}
+	return &u, store
+}
+
+type eapTestPeer interface {
+	Process([]byte) ([]byte, error)
+	MSK() []byte
+	Success() bool
+}
+
+func runEAP(t *testing.T, sess *EAPSession, req []byte, peer eapTestPeer) {
+	for i := 0; i < 10; i++ {
+		resp, err := peer.Process(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp == nil {
+			break
+		}
+		if req, err = sess.Process(resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if done, success := sess.Done(); !done || !success || !peer.Success() {
+		t.Fatalf("EAP failed: done %v success %v peer %v", done, success, peer.Success())
+	}
+	if !bytes.Equal(sess.MSK(), peer.MSK()) {
+		t.Error("MSK mismatch")
+	}
+}
+
+func TestEAPServerConversations(t *testing.T) {
+	u, store := testSubscriber(t)
+	server := NewEAPServer(store, "WLAN")
+	for _, identity := range []string{
+		"6208930000000001@wlan.mnc093.mcc208.3gppnetwork.org",
+		"0208930000000001@wlan.mnc093.mcc208.3gppnetwork.org",
+	} {
+		peer := NewEAPAKAPeer(u, identity)
+		peer.NetworkName = "WLAN"
+		sess, req, err := server.NewSession()
+		if err != nil {
+			t.Fatal(err)
+		}
+		runEAP(t, sess, req, peer)
+	}
+	peer := NewEAPSIMPeer(u, "1208930000000001@wlan.mnc093.mcc208.3gppnetwork.org")
+	sess, req, err := server.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	runEAP(t, sess, req, peer)
+
+	// unknown identity, the server asks for the permanent one
+	akaPeer := NewEAPAKAPeer(u, "6208930000000001@wlan.mnc093.mcc208.3gppnetwork.org")
+	akaPeer.pseudonym = "unknown-pseudonym"
+	if sess, req, err = server.NewSession(); err != nil {
+		t.Fatal(err)
+	}
+	runEAP(t, sess, req, akaPeer)
+}
+
+// radiusRequest builds an Access-Request with Message-Authenticator
+func radiusRequest(id uint8, eapMsg, state, secret []byte) (RADIUSPacket, []byte) {
+	req := RADIUSPacket{Code: RADIUS_ACCESS_REQUEST, Identifier: id, Authenticator: [16]byte{id}}
+	req.addEAPMessage(eapMsg)
+	if state != nil {
+		req.Attributes = append(req.Attributes, RADIUSAttribute{Type: RADIUS_ATTR_STATE, Value: state})
+	}
+	req.Attributes = append(req.Attributes, RADIUSAttribute{Type: RADIUS_ATTR_MESSAGE_AUTHENTICATOR, Value: make([]byte, 16)})
+	buf := req.Encode()
+	mac, off := radiusMessageAuthenticator(buf, req.Authenticator, secret)
+	copy(buf[off:], mac)
+	return req, buf
+}
+
+func TestRADIUSServer(t *testing.T) {
+	u, store := testSubscriber(t)
+	secret := []byte("testing123")
+	server := NewRADIUSServer(string(secret), NewEAPServer(store, "WLAN"))
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(conn)
+	defer server.Close()
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	peer := NewEAPAKAPeer(u, "6208930000000001@wlan.mnc093.mcc208.3gppnetwork.org")
+	eapReq := EAPPacket{Code: EAP_CODE_REQUEST, Identifier: 1, Type: EAP_TYPE_IDENTITY}.Encode()
+	var state []byte
+	for i := uint8(0); i < 10; i++ {
+		eapResp, err := peer.Process(eapReq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, buf := radiusRequest(i, eapResp, state, secret)
+		if _, err = client.Write(buf); err != nil {
+			t.Fatal(err)
+		}
+
+		rbuf := make([]byte, RADIUS_MAX_PACKET_LEN)
+		client.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, err := client.Read(rbuf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reply, err := DecodeRADIUS(rbuf[:n])
+		if err != nil {
+			t.Fatal(err)
+		}
+		check := append([]byte{}, rbuf[:n]...)
+		copy(check[4:20], req.Authenticator[:])
+		sum := md5.Sum(append(check, secret...))
+		if !bytes.Equal(sum[:], reply.Authenticator[:]) {
+			t.Fatal("invalid response authenticator")
+		}
+		switch reply.Code {
+		case RADIUS_ACCESS_ACCEPT:
+			if _, err := peer.Process(reply.EAPMessage()); err != nil || !peer.Success() {
+				t.Fatal("EAP-Success not accepted")
+			}
+			if server.mu.Lock(); len(server.sessions) != 0 {
+				t.Error("finished session not removed")
+			}
+			server.mu.Unlock()
+			return
+		case RADIUS_ACCESS_REJECT:
+			t.Fatal("Access-Reject")
+		}
+		state, _ = reply.Attr(RADIUS_ATTR_STATE)
+		eapReq = reply.EAPMessage()
+	}
+	t.Fatal("no Access-Accept")
+}
+
+func TestRADIUSSessionTimeout(t *testing.T) {
+	_, store := testSubscriber(t)
+	secret := []byte("testing123")
+	server := NewRADIUSServer(string(secret), NewEAPServer(store, "WLAN"))
+	identity := EAPPacket{Code: EAP_CODE_RESPONSE, Identifier: 1, Type: EAP_TYPE_IDENTITY, Data: []byte("6208930000000001@wlan.mnc093.mcc208.3gppnetwork.org")}.Encode()
+	_, buf := radiusRequest(1, identity, nil, secret)
+	out, err := server.Handle(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, _ := DecodeRADIUS(out)
+	state, _ := reply.Attr(RADIUS_ATTR_STATE)
+	if reply.Code != RADIUS_ACCESS_CHALLENGE || len(server.sessions) != 1 {
+		t.Fatalf("reply %d, %d sessions", reply.Code, len(server.sessions))
+	}
+	// the abandoned conversation expires and is swept by the next request
+	server.sessions[string(state)].expires = time.Now().Add(-time.Second)
+	server.nextSweep = time.Time{}
+	_, buf = radiusRequest(2, identity, nil, secret)
+	if _, err = server.Handle(buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := server.sessions[string(state)]; ok || len(server.sessions) != 1 {
+		t.Errorf("expired session kept, %d sessions", len(server.sessions))
+	}
+}
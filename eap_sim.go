@@ -0,0 +1,168 @@
+package usim_go
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"io"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	EAP_SIM_VERSION  = 1
+	GSM_RAND_LEN     = 16
+	GSM_SRES_LEN     = 4
+	GSM_KC_LEN       = 8
+	EAP_SIM_MIN_RAND = 2
+	EAP_SIM_MAX_RAND = 3
+)
+
+// EAPSIMPeer is an EAP-SIM (RFC 4186) peer running the GSM algorithm of a
+// soft or PC/SC SIM.
+type EAPSIMPeer struct {
+	eapPeer
+
+	nonceMT     []byte
+	versionList []byte
+	// identity sent in the last AT_IDENTITY of this conversation
+	startIdentity string
+}
+
+func NewEAPSIMPeer(u *USIM, identity string) *EAPSIMPeer {
+	return &EAPSIMPeer{eapPeer: eapPeer{usim: u, Identity: identity}}
+}
+
+// Process handles an EAP request and returns the response to send, which
+// is nil for EAP-Success.
+func (p *EAPSIMPeer) Process(buf []byte) (resp []byte, err error) {
+	var req EAPPacket
+	if req, err = DecodeEAP(buf); err != nil {
+		return
+	}
+	var done bool
+	if resp, done, err = p.handleEAP(req, EAP_TYPE_SIM); done {
+		if req.Type == EAP_TYPE_IDENTITY {
+			p.startIdentity = ""
+		}
+		return
+	}
+	switch req.Subtype {
+	case EAP_SIM_SUBTYPE_START:
+		return p.start(req)
+	case EAP_SIM_SUBTYPE_CHALLENGE:
+		return p.challenge(req, buf)
+	case EAP_SUBTYPE_NOTIFICATION:
+		return p.notification(req, buf, sha1.New)
+	case EAP_SUBTYPE_REAUTHENTICATION:
+		return p.reauthentication(req, buf, sha1.New, false)
+	}
+	logrus.Debugf("EAP-SIM: unsupported subtype %d", req.Subtype)
+	return p.clientError(req, EAP_CLIENT_ERROR_UNABLE_TO_PROCESS), nil
+}
+
+func (p *EAPSIMPeer) start(req EAPPacket) (resp []byte, err error) {
+	versions, ok := req.Attr(AT_VERSION_LIST)
+	if !ok {
+		return p.clientError(req, EAP_CLIENT_ERROR_UNABLE_TO_PROCESS), nil
+	}
+	var list []byte
+	if list, err = versions.lengthData(1); err != nil {
+		return p.clientError(req, EAP_CLIENT_ERROR_UNABLE_TO_PROCESS), nil
+	}
+	supported := false
+	for i := 0; i+1 < len(list); i += 2 {
+		if uint16(list[i])<<8|uint16(list[i+1]) == EAP_SIM_VERSION {
+			supported = true
+		}
+	}
+	if !supported {
+		return p.clientError(req, EAP_CLIENT_ERROR_UNSUPPORTED_VERSION), nil
+	}
+	p.versionList = list
+
+	var attrs []EAPAttribute
+	if id, ok := p.requestedIdentity(req); ok {
+		p.startIdentity = id
+		attrs = append(attrs, eapAttrLength(AT_IDENTITY, len(id), []byte(id)))
+	}
+	// a re-authentication identity does not need NONCE_MT as no full authentication follows
+	if p.reauthID == "" || p.startIdentity != p.reauthID {
+		p.nonceMT = make([]byte, EAP_NONCE_LEN)
+		if _, err = io.ReadFull(rand.Reader, p.nonceMT); err != nil {
+			return
+		}
+		attrs = append(attrs, eapAttrReserved(AT_NONCE_MT, p.nonceMT))
+	}
+	attrs = append(attrs, eapAttrUint16(AT_SELECTED_VERSION, EAP_SIM_VERSION))
+	return p.response(req, EAP_SIM_SUBTYPE_START, attrs...).Encode(), nil
+}
+
+func (p *EAPSIMPeer) challenge(req EAPPacket, buf []byte) (resp []byte, err error) {
+	randAttr, ok := req.Attr(AT_RAND)
+	if !ok || !hasAttr(req, AT_MAC) || p.nonceMT == nil || len(randAttr.Value) < 2 || (len(randAttr.Value)-2)%GSM_RAND_LEN != 0 {
+		return p.clientError(req, EAP_CLIENT_ERROR_UNABLE_TO_PROCESS), nil
+	}
+	rands := randAttr.Value[2:]
+	n := len(rands) / GSM_RAND_LEN
+	if n < EAP_SIM_MIN_RAND {
+		return p.clientError(req, EAP_CLIENT_ERROR_INSUFFICIENT_CHALS), nil
+	}
+	if n > EAP_SIM_MAX_RAND {
+		return p.clientError(req, EAP_CLIENT_ERROR_UNABLE_TO_PROCESS), nil
+	}
+	for i := 0; i < n; i++ {
+		for j := i + 1; j < n; j++ {
+			if string(rands[i*GSM_RAND_LEN:(i+1)*GSM_RAND_LEN]) == string(rands[j*GSM_RAND_LEN:(j+1)*GSM_RAND_LEN]) {
+				return p.clientError(req, EAP_CLIENT_ERROR_RANDS_NOT_FRESH), nil
+			}
+		}
+	}
+
+	var sres, kc []byte
+	for i := 0; i < n; i++ {
+		s, k, err := p.usim.GenGSMAlg(rands[i*GSM_RAND_LEN : (i+1)*GSM_RAND_LEN])
+		if err != nil || len(s) < GSM_SRES_LEN || len(k) < GSM_KC_LEN {
+			logrus.Error("EAP-SIM: RUN GSM ALGORITHM failed: ", err)
+			return p.clientError(req, EAP_CLIENT_ERROR_UNABLE_TO_PROCESS), nil
+		}
+		sres = append(sres, s[:GSM_SRES_LEN]...)
+		kc = append(kc, k[:GSM_KC_LEN]...)
+	}
+
+	identity := p.startIdentity
+	if identity == "" {
+		identity = p.lastIdentity
+	}
+	if identity == "" {
+		identity = p.Identity
+	}
+	keys := eapFullAuthKeys(eapSIMMasterKey(identity, kc, p.nonceMT, p.versionList, EAP_SIM_VERSION))
+	if !eapVerify(sha1.New, keys.kAut, buf, p.nonceMT) {
+		logrus.Error("EAP-SIM: AT_MAC verification failed")
+		return p.clientError(req, EAP_CLIENT_ERROR_UNABLE_TO_PROCESS), nil
+	}
+	var encrAttrs []EAPAttribute
+	if encrAttrs, err = eapDecrypt(keys.kEncr, req); err != nil {
+		return p.clientError(req, EAP_CLIENT_ERROR_UNABLE_TO_PROCESS), nil
+	}
+	p.setKeys(keys, identity, encrAttrs)
+	p.nonceMT = nil
+
+	var attrs []EAPAttribute
+	if hasAttr(req, AT_RESULT_IND) {
+		attrs = append(attrs, eapAttrReserved(AT_RESULT_IND, nil))
+	}
+	attrs = append(attrs, eapAttrReserved(AT_MAC, make([]byte, EAP_MAC_LEN)))
+	return eapSign(sha1.New, keys.kAut, p.response(req, EAP_SIM_SUBTYPE_CHALLENGE, attrs...).Encode(), sres), nil
+}
+
+// eapSIMMasterKey computes MK = SHA1(Identity|n*Kc|NONCE_MT|Version List|Selected Version)
+func eapSIMMasterKey(identity string, kc, nonceMT, versionList []byte, selected uint16) []byte {
+	h := sha1.New()
+	h.Write([]byte(identity))
+	h.Write(kc)
+	h.Write(nonceMT)
+	h.Write(versionList)
+	h.Write([]byte{byte(selected >> 8), byte(selected)})
+	return h.Sum(nil)
+}
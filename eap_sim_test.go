@@ -0,0 +1,133 @@
+package usim_go
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"testing"
+)
+
+// RFC 4186 Appendix A
+func TestEAPSIMKeys(t *testing.T) {
+	kc := unhex("a0a1a2a3a4a5a6a7b0b1b2b3b4b5b6b7c0c1c2c3c4c5c6c7")
+	mk := eapSIMMasterKey("1244070100000001@eapsim.foo", kc, unhex("0123456789abcdeffedcba9876543210"), []byte{0, 1}, EAP_SIM_VERSION)
+	if !bytes.Equal(mk, unhex("e576d5ca332e9930018bf1baee2763c795b3c712")) {
+		t.Errorf("MK failed. got %X", mk)
+	}
+	k := eapFullAuthKeys(mk)
+	if !bytes.Equal(k.kEncr, unhex("536e5ebc4465582aa6a8ec9986ebb620")) {
+		t.Errorf("K_encr failed. got %X", k.kEncr)
+	}
+	if !bytes.Equal(k.kAut, unhex("25af1942efcbf4bc72b3943421f2a974")) {
+		t.Errorf("K_aut failed. got %X", k.kAut)
+	}
+	if !bytes.Equal(k.msk, unhex("39d45aeaf4e30601983e972b6cfd46d1c363773365690d09cd44976b525f47d3a60a985e955c53b090b2e4b73719196a402542968fd14a888f46b9a7886e4488")) {
+		t.Errorf("MSK failed. got %X", k.msk)
+	}
+	if !bytes.Equal(k.emsk, unhex("5949eab0fff69d52315c6c634fd14a7f0d52023d56f79698fa6596abeed4f93fbb48eb534d985414ceed0d9a8ed33c387c9dfdab92ffbdf240fcecf65a2c93b9")) {
+		t.Errorf("EMSK failed. got %X", k.emsk)
+	}
+}
+
+func TestEAPSIMPeerReauthentication(t *testing.T) {
+	u, _ := testSubscriber(t)
+	a, _ := InitAuC("8BAF473F2F8FD09487CCCBD7097C6862", "", "8e27b6af0e692e750f32667a3b14605d", "8000", 0x1b57)
+	identity := "1208930000000001@wlan.mnc093.mcc208.3gppnetwork.org"
+	peer := NewEAPSIMPeer(u, identity)
+	process := func(req EAPPacket, extra []byte, kAut []byte) EAPPacket {
+		buf := req.Encode()
+		if kAut != nil {
+			buf = eapSign(sha1.New, kAut, buf, extra)
+		}
+		out, err := peer.Process(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := DecodeEAP(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	// full authentication handing out a fast re-authentication identity
+	resp := process(EAPPacket{Code: EAP_CODE_REQUEST, Identifier: 1, Type: EAP_TYPE_SIM, Subtype: EAP_SIM_SUBTYPE_START, Attributes: []EAPAttribute{
+		eapAttrReserved(AT_PERMANENT_ID_REQ, nil),
+		eapAttrLength(AT_VERSION_LIST, 2, []byte{0, EAP_SIM_VERSION}),
+	}}, nil, nil)
+	nonceMT, ok := resp.Attr(AT_NONCE_MT)
+	if !ok {
+		t.Fatal("no AT_NONCE_MT")
+	}
+	var rands, sres, kc []byte
+	for i := 0; i < EAP_SIM_MAX_RAND; i++ {
+		tr, _ := a.GenTriplet()
+		rands = append(rands, tr.RAND[:]...)
+		sres = append(sres, tr.SRES[:]...)
+		kc = append(kc, tr.Kc[:]...)
+	}
+	keys := eapFullAuthKeys(eapSIMMasterKey(identity, kc, nonceMT.Value[2:], []byte{0, EAP_SIM_VERSION}, EAP_SIM_VERSION))
+	iv, encr, _ := eapEncrypt(keys.kEncr, make([]byte, EAP_IV_LEN), []EAPAttribute{
+		eapAttrLength(AT_NEXT_REAUTH_ID, 7, []byte("reauth1")),
+	})
+	challenge := EAPPacket{Code: EAP_CODE_REQUEST, Identifier: 2, Type: EAP_TYPE_SIM, Subtype: EAP_SIM_SUBTYPE_CHALLENGE, Attributes: []EAPAttribute{
+		eapAttrReserved(AT_RAND, rands), iv, encr,
+		eapAttrReserved(AT_MAC, make([]byte, EAP_MAC_LEN)),
+	}}
+	if resp = process(challenge, nonceMT.Value[2:], keys.kAut); resp.Subtype != EAP_SIM_SUBTYPE_CHALLENGE || !eapVerify(sha1.New, keys.kAut, resp.Encode(), sres) {
+		t.Fatalf("challenge response %+v", resp)
+	}
+	if _, err := peer.Process([]byte{EAP_CODE_SUCCESS, 3, 0, 4}); err != nil || !peer.Success() || peer.ReauthID() != "reauth1" {
+		t.Fatal("full authentication failed")
+	}
+
+	// the re-authentication identity is offered and Start carries no NONCE_MT
+	if resp = process(EAPPacket{Code: EAP_CODE_REQUEST, Identifier: 4, Type: EAP_TYPE_IDENTITY}, nil, nil); string(resp.Data) != "reauth1" {
+		t.Fatalf("identity %q", resp.Data)
+	}
+	resp = process(EAPPacket{Code: EAP_CODE_REQUEST, Identifier: 5, Type: EAP_TYPE_SIM, Subtype: EAP_SIM_SUBTYPE_START, Attributes: []EAPAttribute{
+		eapAttrReserved(AT_ANY_ID_REQ, nil),
+		eapAttrLength(AT_VERSION_LIST, 2, []byte{0, EAP_SIM_VERSION}),
+	}}, nil, nil)
+	id, _ := resp.Attr(AT_IDENTITY)
+	if data, _ := id.lengthData(1); hasAttr(resp, AT_NONCE_MT) || string(data) != "reauth1" {
+		t.Fatalf("start response %+v", resp)
+	}
+
+	reauth := func(id uint8, counter uint16, nonceS []byte) (EAPPacket, []EAPAttribute) {
+		iv, encr, _ := eapEncrypt(keys.kEncr, make([]byte, EAP_IV_LEN), []EAPAttribute{
+			eapAttrUint16(AT_COUNTER, counter),
+			eapAttrReserved(AT_NONCE_S, nonceS),
+			eapAttrLength(AT_NEXT_REAUTH_ID, 7, []byte("reauth2")),
+		})
+		req := EAPPacket{Code: EAP_CODE_REQUEST, Identifier: id, Type: EAP_TYPE_SIM, Subtype: EAP_SUBTYPE_REAUTHENTICATION, Attributes: []EAPAttribute{
+			iv, encr, eapAttrReserved(AT_MAC, make([]byte, EAP_MAC_LEN)),
+		}}
+		resp := process(req, nil, keys.kAut)
+		if resp.Subtype != EAP_SUBTYPE_REAUTHENTICATION || !eapVerify(sha1.New, keys.kAut, resp.Encode(), nonceS) {
+			t.Fatalf("re-authentication response %+v", resp)
+		}
+		attrs, err := eapDecrypt(keys.kEncr, resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c, _ := findAttr(attrs, AT_COUNTER); c.uint16() != counter {
+			t.Errorf("AT_COUNTER %d", c.uint16())
+		}
+		return resp, attrs
+	}
+	nonceS := unhex("000102030405060708090a0b0c0d0e0f")
+	if _, attrs := reauth(6, 1, nonceS); hasAttr(EAPPacket{Attributes: attrs}, AT_COUNTER_TOO_SMALL) {
+		t.Fatal("counter 1 rejected")
+	}
+	msk, _ := eapReauthKeys(keys, "reauth1", 1, nonceS)
+	if !bytes.Equal(peer.MSK(), msk) || peer.ReauthID() != "reauth2" {
+		t.Errorf("re-authentication MSK %x, next id %q", peer.MSK(), peer.ReauthID())
+	}
+	// a replayed counter is refused and the keys are kept
+	if _, attrs := reauth(7, 1, unhex("101112131415161718191a1b1c1d1e1f")); !hasAttr(EAPPacket{Attributes: attrs}, AT_COUNTER_TOO_SMALL) {
+		t.Error("replayed counter accepted")
+	}
+	if !bytes.Equal(peer.MSK(), msk) {
+		t.Error("MSK changed after AT_COUNTER_TOO_SMALL")
+	}
+}
@@ -0,0 +1,158 @@
+package usim_go
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+)
+
+// EPS and NR ciphering and integrity algorithms of TS 33.401 Annex B and
+// TS 33.501 Annex D. 128-NEA/NIA x are identical to 128-EEA/EIA x.
+
+const (
+	EEA0 = 0
+	EEA1 = 1
+	EEA2 = 2
+	EEA3 = 3
+	EIA0 = 0
+	EIA1 = 1
+	EIA2 = 2
+	EIA3 = 3
+
+	NEA0 = EEA0
+	NEA1 = EEA1
+	NEA2 = EEA2
+	NEA3 = EEA3
+	NIA0 = EIA0
+	NIA1 = EIA1
+	NIA2 = EIA2
+	NIA3 = EIA3
+
+	DIRECTION_UPLINK   = 0
+	DIRECTION_DOWNLINK = 1
+)
+
+// EEA ciphers (or deciphers) the first length bits of data with algorithm alg
+func EEA(alg byte, key []byte, count uint32, bearer, dir byte, data []byte, length int) ([]byte, error) {
+	if length < 0 || len(data)*8 < length {
+		return nil, fmt.Errorf("EEA: length %d exceeds data", length)
+	}
+	if alg != EEA0 && len(key) != 16 {
+		return nil, fmt.Errorf("EEA: invalid key length %d", len(key))
+	}
+	switch alg {
+	case EEA0:
+		return xorKeystream(make([]uint32, (length+31)/32), data, length), nil
+	case EEA1:
+		return SNOW3GF8(key, count, bearer, dir, data, length), nil
+	case EEA2:
+		return aesEEA2(key, count, bearer, dir, data, length), nil
+	case EEA3:
+		return ZUCEEA3(key, count, bearer, dir, data, length), nil
+	}
+	return nil, fmt.Errorf("EEA: unsupported algorithm %d", alg)
+}
+
+// EIA computes the 32 bit MAC over the first length bits of data with algorithm alg
+func EIA(alg byte, key []byte, count uint32, bearer, dir byte, data []byte, length int) (mac [4]byte, err error) {
+	if length < 0 || len(data)*8 < length {
+		return mac, fmt.Errorf("EIA: length %d exceeds data", length)
+	}
+	if alg != EIA0 && len(key) != 16 {
+		return mac, fmt.Errorf("EIA: invalid key length %d", len(key))
+	}
+	switch alg {
+	case EIA0:
+		return
+	case EIA1:
+		return SNOW3GF9(key, count, uint32(bearer)<<27, dir, data, length), nil
+	case EIA2:
+		return aesEIA2(key, count, bearer, dir, data, length), nil
+	case EIA3:
+		return ZUCEIA3(key, count, bearer, dir, data, length), nil
+	}
+	return mac, fmt.Errorf("EIA: unsupported algorithm %d", alg)
+}
+
+// aesEEA2 is 128-EEA2, AES in counter mode
+func aesEEA2(key []byte, count uint32, bearer, dir byte, data []byte, length int) []byte {
+	block, _ := aes.NewCipher(key)
+	var iv [16]byte
+	binary.BigEndian.PutUint32(iv[:], count)
+	iv[4] = bearer<<3 | (dir&1)<<2
+	n := (length + 7) / 8
+	out := make([]byte, n)
+	cipher.NewCTR(block, iv[:]).XORKeyStream(out, data[:n])
+	if length%8 != 0 {
+		out[n-1] &= 0xff << (8 - length%8)
+	}
+	return out
+}
+
+// aesEIA2 is 128-EIA2, AES-CMAC over COUNT || BEARER || DIRECTION || 0^26 || MESSAGE
+func aesEIA2(key []byte, count uint32, bearer, dir byte, data []byte, length int) (mac [4]byte) {
+	m := make([]byte, 8, 8+(length+7)/8)
+	binary.BigEndian.PutUint32(m, count)
+	m[4] = bearer<<3 | (dir&1)<<2
+	m = append(m, data[:(length+7)/8]...)
+	t := aesCMAC(key, m, 64+length)
+	copy(mac[:], t)
+	return
+}
+
+// aesCMAC computes AES-CMAC of RFC 4493 over the first length bits of m
+func aesCMAC(key, m []byte, length int) []byte {
+	block, _ := aes.NewCipher(key)
+	dbl := func(b []byte) []byte {
+		out := make([]byte, 16)
+		var carry byte
+		for i := 15; i >= 0; i-- {
+			out[i] = b[i]<<1 | carry
+			carry = b[i] >> 7
+		}
+		if b[0]&0x80 != 0 {
+			out[15] ^= 0x87
+		}
+		return out
+	}
+	l := make([]byte, 16)
+	block.Encrypt(l, l)
+	k1 := dbl(l)
+	k2 := dbl(k1)
+
+	n := (length + 127) / 128
+	complete := n > 0 && length%128 == 0
+	if n == 0 {
+		n = 1
+	}
+	last := make([]byte, 16)
+	rem := length - (n-1)*128
+	copy(last, m[(n-1)*16:(length+7)/8])
+	if complete {
+		for i := range last {
+			last[i] ^= k1[i]
+		}
+	} else {
+		// clear the bits past length and pad with 10*
+		if rem%8 != 0 {
+			last[rem/8] &= 0xff << (8 - rem%8)
+		}
+		last[rem/8] |= 0x80 >> (rem % 8)
+		for i := range last {
+			last[i] ^= k2[i]
+		}
+	}
+	x := make([]byte, 16)
+	for i := 0; i < n-1; i++ {
+		for j := 0; j < 16; j++ {
+			x[j] ^= m[16*i+j]
+		}
+		block.Encrypt(x, x)
+	}
+	for j := 0; j < 16; j++ {
+		x[j] ^= last[j]
+	}
+	block.Encrypt(x, x)
+	return x
+}
@@ -0,0 +1,88 @@
+package usim_go
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSNOW3GKeystream(t *testing.T) {
+	// SNOW 3G specification test set 1
+	g := newSNOW3G([4]uint32{0x2BD6459F, 0x82C5B300, 0x952C4910, 0x4881FF48}, [4]uint32{0xEA024714, 0xAD5C4D84, 0xDF1F9B25, 0x1C0BF45F})
+	if z := g.keystream(2); z[0] != 0xABEE9704 || z[1] != 0x7AC31373 {
+		t.Errorf("unexpected keystream %08x", z)
+	}
+}
+
+func TestZUCKeystream(t *testing.T) {
+	for _, v := range []struct {
+		key, iv string
+		z1, z2  uint32
+	}{
+		{"00000000000000000000000000000000", "00000000000000000000000000000000", 0x27bede74, 0x018082da},
+		{"ffffffffffffffffffffffffffffffff", "ffffffffffffffffffffffffffffffff", 0x0657cfa0, 0x7096398b},
+	} {
+		if z := newZUC(unhex(v.key), unhex(v.iv)).keystream(2); z[0] != v.z1 || z[1] != v.z2 {
+			t.Errorf("unexpected keystream %08x", z)
+		}
+	}
+	if mac := ZUCEIA3(make([]byte, 16), 0, 0, 0, []byte{0}, 1); !bytes.Equal(mac[:], unhex("c8a9595e")) {
+		t.Errorf("unexpected 128-EIA3 MAC %x", mac)
+	}
+}
+
+func TestAESCMAC(t *testing.T) {
+	// RFC 4493 4
+	key := unhex("2b7e151628aed2a6abf7158809cf4f3c")
+	if mac := aesCMAC(key, nil, 0); !bytes.Equal(mac, unhex("bb1d6929e95937287fa37d129b756746")) {
+		t.Errorf("unexpected CMAC %x", mac)
+	}
+	if mac := aesCMAC(key, unhex("6bc1bee22e409f96e93d7e117393172a"), 128); !bytes.Equal(mac, unhex("070a16b46b4d4144f79bdd9dd04a287c")) {
+		t.Errorf("unexpected CMAC %x", mac)
+	}
+}
+
+// TS 33.401 Annex C test data
+func TestEEAEIA(t *testing.T) {
+	key := unhex("d3c5d592327fb11c4035c6680af8c6d1")
+	pt := unhex("981ba6824c1bfb1ab485472029b71d808ce33e2cc3c0b5fc1f3de8a6dc66b1f0")
+	for _, v := range []struct {
+		alg byte
+		ct  string
+	}{
+		{EEA1, "5d5bfe75eb04f68ce0a12377ea00b37d47c6a0ba06309155086a859c4341b378"},
+		{EEA2, "e9fed8a63d155304d71df20bf3e82214b20ed7dad2f233dc3c22d7bdeeed8e78"},
+	} {
+		ct, err := EEA(v.alg, key, 0x398a59b4, 0x15, DIRECTION_DOWNLINK, pt, 253)
+		if err != nil || !bytes.Equal(ct, unhex(v.ct)) {
+			t.Errorf("128-EEA%d: unexpected ciphertext %x %v", v.alg, ct, err)
+		}
+	}
+	ct, err := EEA(EEA3, unhex("173d14ba5003731d7a60049470f00a29"), 0x66035492, 0x0f, DIRECTION_UPLINK,
+		unhex("6cf65340735552ab0c9752fa6f9025fe0bd675d9005875b200"), 193)
+	if err != nil || !bytes.Equal(ct, unhex("a6c85fc66afb8533aafc2518dfe784940ee1e4b030238cc800")) {
+		t.Errorf("128-EEA3: unexpected ciphertext %x %v", ct, err)
+	}
+	for _, v := range []struct {
+		alg         byte
+		key         string
+		count       uint32
+		bearer, dir byte
+		msg         string
+		length      int
+		mac         string
+	}{
+		// 128-EIA1 test set 1
+		{EIA1, "2bd6459f82c5b300952c49104881ff48", 0x38a6f056, 0x1f, DIRECTION_UPLINK, "3332346263393861373479", 88, "731f1165"},
+		// 128-EIA2 test set 2
+		{EIA2, "d3c5d592327fb11c4035c6680af8c6d1", 0x398a59b4, 0x1a, DIRECTION_DOWNLINK, "484583d5afe082ae", 64, "b93787e6"},
+		// 128-EIA3 test sets 2 and 3
+		{EIA3, "47054125561eb2dda94059da05097850", 0x561eb2dd, 0x14, DIRECTION_UPLINK, "000000000000000000000000", 90, "6719a088"},
+		{EIA3, "c9e6cec4607c72db000aefa88385ab0a", 0xa94059da, 0x0a, DIRECTION_DOWNLINK,
+			"983b41d47d780c9e1ad11d7eb70391b1de0b35da2dc62f83e7b78d6306ca0ea07e941b7be91348f9fcb170e2217fecd97f9f68adb16e5d7d21e569d280ed775cebde3f4093c5388100000000", 577, "fae8ff0b"},
+	} {
+		mac, err := EIA(v.alg, unhex(v.key), v.count, v.bearer, v.dir, unhex(v.msg), v.length)
+		if err != nil || !bytes.Equal(mac[:], unhex(v.mac)) {
+			t.Errorf("128-EIA%d: unexpected MAC %x %v", v.alg, mac, err)
+		}
+	}
+}
@@ -0,0 +1,742 @@
+package usim_go
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	smartcard "github.com/sf1/go-card/smartcard"
+	"github.com/sirupsen/logrus"
+)
+
+// Elementary files of TS 31.102 (USIM), TS 102 221 (MF) and TS 51.011 (GSM)
+
+type EFStructure uint8
+
+const (
+	EF_TRANSPARENT EFStructure = iota
+	EF_LINEAR_FIXED
+	EF_CYCLIC
+)
+
+const (
+	// EF_PATH_ADF_USIM stands for the USIM application in a path, it is selected by AID
+	EF_PATH_ADF_USIM     = 0x7FFF
+	EF_PATH_ADF_ISIM     = 0x7FFE
+	EF_PATH_DF_5GS       = 0x5FC0
+	EF_PATH_DF_PHONEBOOK = 0x5F3A
+)
+
+// EFInfo describes an elementary file, Path lists the DFs below the MF
+type EFInfo struct {
+	Name      string
+	FID       uint16
+	Path      []uint16
+	Structure EFStructure
+}
+
+// DF_PHONEBOOK of the global (DF_TELECOM) and the local (ADF_USIM) phonebook
+var (
+	pathPhonebook      = []uint16{uint16(SCARD_FILE_TELECOMM_DF), EF_PATH_DF_PHONEBOOK}
+	pathLocalPhonebook = []uint16{EF_PATH_ADF_USIM, EF_PATH_DF_PHONEBOOK}
+)
+
+var (
+	pathMF       = []uint16{}
+	pathUSIM     = []uint16{EF_PATH_ADF_USIM}
+	pathUSIM5GS  = []uint16{EF_PATH_ADF_USIM, EF_PATH_DF_5GS}
+	pathISIM     = []uint16{EF_PATH_ADF_ISIM}
+	pathTelecom  = []uint16{uint16(SCARD_FILE_TELECOMM_DF)}
+	pathGSM      = []uint16{uint16(SCARD_FILE_GSM_DF)}
+	efRegistry   = map[string]EFInfo{}
+	efRegistryID = []EFInfo{
+		{"EF_DIR", 0x2F00, pathMF, EF_LINEAR_FIXED},
+		{"EF_ICCID", 0x2FE2, pathMF, EF_TRANSPARENT},
+		{"EF_PL", 0x2F05, pathMF, EF_TRANSPARENT},
+
+		{"EF_LI", 0x6F05, pathUSIM, EF_TRANSPARENT},
+		{"EF_IMSI", 0x6F07, pathUSIM, EF_TRANSPARENT},
+		{"EF_KEYS", 0x6F08, pathUSIM, EF_TRANSPARENT},
+		{"EF_KEYSPS", 0x6F09, pathUSIM, EF_TRANSPARENT},
+		{"EF_HPPLMN", 0x6F31, pathUSIM, EF_TRANSPARENT},
+		{"EF_UST", 0x6F38, pathUSIM, EF_TRANSPARENT},
+		{"EF_FDN", 0x6F3B, pathUSIM, EF_LINEAR_FIXED},
+		{"EF_SMS", 0x6F3C, pathUSIM, EF_LINEAR_FIXED},
+		{"EF_GID1", 0x6F3E, pathUSIM, EF_TRANSPARENT},
+		{"EF_GID2", 0x6F3F, pathUSIM, EF_TRANSPARENT},
+		{"EF_MSISDN", 0x6F40, pathUSIM, EF_LINEAR_FIXED},
+		{"EF_SMSP", 0x6F42, pathUSIM, EF_LINEAR_FIXED},
+		{"EF_SMSS", 0x6F43, pathUSIM, EF_TRANSPARENT},
+		{"EF_SPN", 0x6F46, pathUSIM, EF_TRANSPARENT},
+		{"EF_SMSR", 0x6F47, pathUSIM, EF_LINEAR_FIXED},
+		{"EF_SDN", 0x6F49, pathUSIM, EF_LINEAR_FIXED},
+		{"EF_EXT2", 0x6F4B, pathUSIM, EF_LINEAR_FIXED},
+		{"EF_EXT3", 0x6F4C, pathUSIM, EF_LINEAR_FIXED},
+		{"EF_EXT5", 0x6F4E, pathUSIM, EF_LINEAR_FIXED},
+		{"EF_EST", 0x6F56, pathUSIM, EF_TRANSPARENT},
+		{"EF_ACL", 0x6F57, pathUSIM, EF_TRANSPARENT},
+		{"EF_START-HFN", 0x6F5B, pathUSIM, EF_TRANSPARENT},
+		{"EF_THRESHOLD", 0x6F5C, pathUSIM, EF_TRANSPARENT},
+		{"EF_PLMNwAcT", 0x6F60, pathUSIM, EF_TRANSPARENT},
+		{"EF_OPLMNwAcT", 0x6F61, pathUSIM, EF_TRANSPARENT},
+		{"EF_HPLMNwAcT", 0x6F62, pathUSIM, EF_TRANSPARENT},
+		{"EF_PSLOCI", 0x6F73, pathUSIM, EF_TRANSPARENT},
+		{"EF_ACC", 0x6F78, pathUSIM, EF_TRANSPARENT},
+		{"EF_FPLMN", 0x6F7B, pathUSIM, EF_TRANSPARENT},
+		{"EF_LOCI", 0x6F7E, pathUSIM, EF_TRANSPARENT},
+		{"EF_AD", 0x6FAD, pathUSIM, EF_TRANSPARENT},
+		{"EF_ECC", 0x6FB7, pathUSIM, EF_LINEAR_FIXED},
+		{"EF_NETPAR", 0x6FC4, pathUSIM, EF_TRANSPARENT},
+		{"EF_PNN", 0x6FC5, pathUSIM, EF_LINEAR_FIXED},
+		{"EF_OPL", 0x6FC6, pathUSIM, EF_LINEAR_FIXED},
+		{"EF_MBDN", 0x6FC7, pathUSIM, EF_LINEAR_FIXED},
+		{"EF_SPDI", 0x6FCD, pathUSIM, EF_TRANSPARENT},
+		{"EF_EHPLMN", 0x6FD9, pathUSIM, EF_TRANSPARENT},
+		{"EF_LRPLMNSI", 0x6FDC, pathUSIM, EF_TRANSPARENT},
+		{"EF_EPSLOCI", 0x6FE3, pathUSIM, EF_TRANSPARENT},
+		{"EF_EPSNSC", 0x6FE4, pathUSIM, EF_LINEAR_FIXED},
+
+		{"EF_5GS3GPPLOCI", 0x4F01, pathUSIM5GS, EF_TRANSPARENT},
+		{"EF_5GSN3GPPLOCI", 0x4F02, pathUSIM5GS, EF_TRANSPARENT},
+		{"EF_5GS3GPPNSC", 0x4F03, pathUSIM5GS, EF_LINEAR_FIXED},
+		{"EF_5GSN3GPPNSC", 0x4F04, pathUSIM5GS, EF_LINEAR_FIXED},
+		{"EF_5GAUTHKEYS", 0x4F05, pathUSIM5GS, EF_TRANSPARENT},
+		{"EF_UAC_AIC", 0x4F06, pathUSIM5GS, EF_TRANSPARENT},
+		{"EF_SUCI_Calc_Info", 0x4F07, pathUSIM5GS, EF_TRANSPARENT},
+		{"EF_OPL5G", 0x4F08, pathUSIM5GS, EF_LINEAR_FIXED},
+		{"EF_SUPI_NAI", 0x4F09, pathUSIM5GS, EF_TRANSPARENT},
+		{"EF_Routing_Indicator", 0x4F0A, pathUSIM5GS, EF_TRANSPARENT},
+
+		{"EF_IMPI", 0x6F02, pathISIM, EF_TRANSPARENT},
+		{"EF_DOMAIN", 0x6F03, pathISIM, EF_TRANSPARENT},
+		{"EF_IMPU", 0x6F04, pathISIM, EF_LINEAR_FIXED},
+		{"EF_IST", 0x6F07, pathISIM, EF_TRANSPARENT},
+
+		{"EF_ADN", 0x6F3A, pathTelecom, EF_LINEAR_FIXED},
+		{"EF_EXT1", 0x6F4A, pathTelecom, EF_LINEAR_FIXED},
+		{"EF_MSISDN_TELECOM", 0x6F40, pathTelecom, EF_LINEAR_FIXED},
+		{"EF_PBR", 0x4F30, pathPhonebook, EF_LINEAR_FIXED},
+		{"EF_PBR_LOCAL", 0x4F30, pathLocalPhonebook, EF_LINEAR_FIXED},
+
+		{"EF_Kc", 0x6F20, pathGSM, EF_TRANSPARENT},
+		{"EF_PLMNsel", 0x6F30, pathGSM, EF_TRANSPARENT},
+		{"EF_SST", 0x6F38, pathGSM, EF_TRANSPARENT},
+		{"EF_KcGPRS", 0x6F52, pathGSM, EF_TRANSPARENT},
+		{"EF_LOCIGPRS", 0x6F53, pathGSM, EF_TRANSPARENT},
+		{"EF_Phase", 0x6FAE, pathGSM, EF_TRANSPARENT},
+	}
+)
+
+// efServices maps EFs to the UST service they depend on, see TS 31.102 4.2 and 4.4.11
+var efServices = map[string]Service{
+	"EF_FDN":               SERVICE_FDN,
+	"EF_EXT2":              SERVICE_EXT2,
+	"EF_SDN":               SERVICE_SDN,
+	"EF_EXT3":              SERVICE_EXT3,
+	"EF_SMS":               SERVICE_SMS,
+	"EF_SMSR":              SERVICE_SMSR,
+	"EF_SMSP":              SERVICE_SMSP,
+	"EF_SMSS":              SERVICE_SMS,
+	"EF_GID1":              SERVICE_GID1,
+	"EF_GID2":              SERVICE_GID2,
+	"EF_SPN":               SERVICE_SPN,
+	"EF_PLMNwAcT":          SERVICE_PLMNWACT,
+	"EF_MSISDN":            SERVICE_MSISDN,
+	"EF_EXT5":              SERVICE_EXT5,
+	"EF_PBR_LOCAL":         SERVICE_LOCAL_PHONE_BOOK,
+	"EF_EST":               SERVICE_EST,
+	"EF_ACL":               SERVICE_ACL,
+	"EF_OPLMNwAcT":         SERVICE_OPLMNWACT,
+	"EF_HPLMNwAcT":         SERVICE_HPLMNWACT,
+	"EF_PNN":               SERVICE_PNN,
+	"EF_OPL":               SERVICE_OPL,
+	"EF_MBDN":              SERVICE_MBDN,
+	"EF_SPDI":              SERVICE_SPDI,
+	"EF_EHPLMN":            SERVICE_EHPLMN,
+	"EF_LRPLMNSI":          SERVICE_LAST_RPLMN_IND,
+	"EF_EPSLOCI":           SERVICE_EPS_MM_INFO,
+	"EF_EPSNSC":            SERVICE_EPS_MM_INFO,
+	"EF_5GS3GPPLOCI":       SERVICE_5GS_MM_INFO,
+	"EF_5GSN3GPPLOCI":      SERVICE_5GS_MM_INFO,
+	"EF_5GS3GPPNSC":        SERVICE_5GS_MM_INFO,
+	"EF_5GSN3GPPNSC":       SERVICE_5GS_MM_INFO,
+	"EF_5GAUTHKEYS":        SERVICE_5G_SECURITY,
+	"EF_UAC_AIC":           SERVICE_UAC_AIC,
+	"EF_SUCI_Calc_Info":    SERVICE_SUCI_PRIVACY,
+	"EF_Routing_Indicator": SERVICE_SUCI_PRIVACY,
+}
+
+func init() {
+	for _, e := range efRegistryID {
+		efRegistry[e.Name] = e
+	}
+}
+
+// Service returns the UST service the EF depends on, 0 if none
+func (info EFInfo) Service() Service {
+	return efServices[info.Name]
+}
+
+// LookupEF returns the description of a standard EF by name, e.g. "EF_ECC"
+func LookupEF(name string) (info EFInfo, ok bool) {
+	info, ok = efRegistry[name]
+	return
+}
+
+// efInfo returns a registered EF, unknown names are programming errors
+func efInfo(name string) EFInfo {
+	info, ok := efRegistry[name]
+	if !ok {
+		panic("unknown EF " + name)
+	}
+	return info
+}
+
+// ElementaryFile is the decoded content of an EF. Transparent files are
+// coded as a single record.
+type ElementaryFile interface {
+	Info() EFInfo
+	Decode(records [][]byte) error
+	Encode() ([][]byte, error)
+}
+
+// ReadEF reads and decodes the EF of type T, e.g. ReadEF[EFECC](u)
+func ReadEF[T any, P interface {
+	*T
+	ElementaryFile
+}](u *USIM) (v T, err error) {
+	p := P(&v)
+	records, err := u.ReadEFRaw(p.Info().Name)
+	if err != nil {
+		return
+	}
+	err = p.Decode(records)
+	return
+}
+
+// UpdateEF encodes and writes an EF
+func UpdateEF(u *USIM, ef ElementaryFile) error {
+	records, err := ef.Encode()
+	if err != nil {
+		return err
+	}
+	return u.UpdateEFRaw(ef.Info().Name, records)
+}
+
+// ReadEFRaw returns the records of the named EF, a transparent EF has one
+func (u *USIM) ReadEFRaw(name string) (records [][]byte, err error) {
+	info, ok := LookupEF(name)
+	if !ok {
+		return nil, fmt.Errorf("EF: unknown file %s", name)
+	}
+	return u.readEFInfo(info)
+}
+
+// UpdateEFRaw writes the records of the named EF
+func (u *USIM) UpdateEFRaw(name string, records [][]byte) (err error) {
+	info, ok := LookupEF(name)
+	if !ok {
+		return fmt.Errorf("EF: unknown file %s", name)
+	}
+	return u.updateEFInfo(info, records)
+}
+
+// FILE_NOT_FOUND is wrapped by errors for EFs the card or soft profile does not have
+var FILE_NOT_FOUND = errors.New("file not found")
+
+// readEFInfo reads an EF that need not be registered, e.g. one found in EF_PBR
+func (u *USIM) readEFInfo(info EFInfo) (records [][]byte, err error) {
+	if err = u.checkService(info); err != nil {
+		return
+	}
+	if u.soft {
+		var ok bool
+		if records, ok = u.files[info.Name]; !ok {
+			return nil, fmt.Errorf("EF: %s %w in soft profile", info.Name, FILE_NOT_FOUND)
+		}
+		out := make([][]byte, len(records))
+		for i, r := range records {
+			out[i] = append([]byte{}, r...)
+		}
+		return out, nil
+	}
+	var card *smartcard.Card
+	if card, err = u.reader.Connect(); err != nil {
+		logrus.Error(err)
+		return
+	}
+	defer card.Disconnect()
+	return readEF(card, u.aid, info)
+}
+
+func (u *USIM) updateEFInfo(info EFInfo, records [][]byte) (err error) {
+	if info.Structure == EF_TRANSPARENT && len(records) != 1 {
+		return fmt.Errorf("EF: transparent %s takes one record", info.Name)
+	}
+	if err = u.checkService(info); err != nil {
+		return
+	}
+	if info.Name == "EF_UST" {
+		u.ust, u.ustRead = nil, false
+	}
+	if u.soft {
+		if u.files == nil {
+			u.files = map[string][][]byte{}
+		}
+		stored := make([][]byte, len(records))
+		for i, r := range records {
+			stored[i] = append([]byte{}, r...)
+		}
+		u.files[info.Name] = stored
+		return nil
+	}
+	var card *smartcard.Card
+	if card, err = u.reader.Connect(); err != nil {
+		logrus.Error(err)
+		return
+	}
+	defer card.Disconnect()
+	return updateEF(card, u.aid, info, records)
+}
+
+func singleRecord(name string, records [][]byte, minLen int) ([]byte, error) {
+	if len(records) != 1 {
+		return nil, fmt.Errorf("%s: expected one record, got %d", name, len(records))
+	}
+	if len(records[0]) < minLen {
+		return nil, fmt.Errorf("%s: too short (%d bytes)", name, len(records[0]))
+	}
+	return records[0], nil
+}
+
+// isEmptyRecord reports whether a record is unused, i.e. all 'FF'
+func isEmptyRecord(r []byte) bool {
+	return len(bytes.TrimLeft(r, "\xff")) == 0
+}
+
+// EFIMSI is EF_IMSI, TS 31.102 4.2.2
+type EFIMSI struct {
+	IMSI string
+}
+
+func (e *EFIMSI) Info() EFInfo { return efInfo("EF_IMSI") }
+
+func (e *EFIMSI) Decode(records [][]byte) error {
+	r, err := singleRecord("EF_IMSI", records, 2)
+	if err != nil {
+		return err
+	}
+	if int(r[0]) > len(r)-1 || r[0] == 0 {
+		return errors.New("EF_IMSI: invalid length")
+	}
+	e.IMSI, err = decodeDigitIdentity(r[1 : 1+int(r[0])])
+	return err
+}
+
+func (e *EFIMSI) Encode() ([][]byte, error) {
+	// TS 23.003 2.2, the file holds at most 15 digits
+	if n := len(e.IMSI); n < 6 || n > 15 {
+		return nil, fmt.Errorf("EF_IMSI: IMSI %q must have 6 to 15 digits", e.IMSI)
+	}
+	id, err := encodeDigitIdentity(EPS_IDENTITY_IMSI, e.IMSI)
+	if err != nil {
+		return nil, err
+	}
+	r := append([]byte{byte(len(id))}, id...)
+	return [][]byte{append(r, bytes.Repeat([]byte{0xFF}, 8-len(id))...)}, nil
+}
+
+// EFICCID is EF_ICCID, TS 102 221 13.2
+type EFICCID struct {
+	ICCID string
+}
+
+func (e *EFICCID) Info() EFInfo { return efInfo("EF_ICCID") }
+
+func (e *EFICCID) Decode(records [][]byte) error {
+	r, err := singleRecord("EF_ICCID", records, 1)
+	if err != nil {
+		return err
+	}
+	e.ICCID = strings.TrimRight(decodeBCD(r), "f")
+	return nil
+}
+
+func (e *EFICCID) Encode() ([][]byte, error) {
+	if !isDigits(e.ICCID) || len(e.ICCID) > 20 {
+		return nil, fmt.Errorf("EF_ICCID: invalid ICCID %q", e.ICCID)
+	}
+	r := bytes.Repeat([]byte{0xFF}, 10)
+	copy(r, encodeBCD(e.ICCID))
+	return [][]byte{r}, nil
+}
+
+// EFAD is EF_AD, TS 31.102 4.2.18
+type EFAD struct {
+	UEOperationMode byte
+	AdditionalInfo  uint16
+	MNCLength       int
+	// RFU holds the bytes after the MNC length
+	RFU []byte
+}
+
+func (e *EFAD) Info() EFInfo { return efInfo("EF_AD") }
+
+func (e *EFAD) Decode(records [][]byte) error {
+	r, err := singleRecord("EF_AD", records, 3)
+	if err != nil {
+		return err
+	}
+	e.UEOperationMode = r[0]
+	e.AdditionalInfo = binary.BigEndian.Uint16(r[1:])
+	if len(r) > 3 {
+		e.MNCLength = int(r[3] & 0x0F)
+		if len(r) > 4 {
+			e.RFU = append([]byte{}, r[4:]...)
+		}
+	}
+	return nil
+}
+
+func (e *EFAD) Encode() ([][]byte, error) {
+	r := []byte{e.UEOperationMode, byte(e.AdditionalInfo >> 8), byte(e.AdditionalInfo)}
+	if e.MNCLength != 0 {
+		r = append(r, byte(e.MNCLength))
+		r = append(r, e.RFU...)
+	}
+	return [][]byte{r}, nil
+}
+
+// EFACC is EF_ACC, bit n of Classes is access control class n, TS 31.102 4.2.15
+type EFACC struct {
+	Classes uint16
+}
+
+func (e *EFACC) Info() EFInfo { return efInfo("EF_ACC") }
+
+func (e *EFACC) Decode(records [][]byte) error {
+	r, err := singleRecord("EF_ACC", records, 2)
+	if err == nil {
+		e.Classes = binary.BigEndian.Uint16(r)
+	}
+	return err
+}
+
+func (e *EFACC) Encode() ([][]byte, error) {
+	return [][]byte{{byte(e.Classes >> 8), byte(e.Classes)}}, nil
+}
+
+// HasClass reports whether the access control class is allocated
+func (e EFACC) HasClass(class int) bool {
+	return class >= 0 && class < 16 && e.Classes>>class&1 != 0
+}
+
+// EFHPPLMN is EF_HPPLMN, the higher priority PLMN search period, TS 31.102 4.2.6
+type EFHPPLMN struct {
+	Interval byte
+}
+
+func (e *EFHPPLMN) Info() EFInfo { return efInfo("EF_HPPLMN") }
+
+func (e *EFHPPLMN) Decode(records [][]byte) error {
+	r, err := singleRecord("EF_HPPLMN", records, 1)
+	if err == nil {
+		e.Interval = r[0]
+	}
+	return err
+}
+
+func (e *EFHPPLMN) Encode() ([][]byte, error) {
+	return [][]byte{{e.Interval}}, nil
+}
+
+// Period returns the search period, 0 means no search for higher priority PLMNs
+func (e EFHPPLMN) Period() time.Duration {
+	return time.Duration(e.Interval) * 6 * time.Minute
+}
+
+// EFGID1 is EF_GID1, group identifier level 1, TS 31.102 4.2.10
+type EFGID1 struct {
+	Data []byte
+}
+
+func (e *EFGID1) Info() EFInfo { return efInfo("EF_GID1") }
+
+func (e *EFGID1) Decode(records [][]byte) error {
+	r, err := singleRecord("EF_GID1", records, 0)
+	e.Data = r
+	return err
+}
+
+func (e *EFGID1) Encode() ([][]byte, error) {
+	return [][]byte{e.Data}, nil
+}
+
+// EFGID2 is EF_GID2, group identifier level 2, TS 31.102 4.2.11
+type EFGID2 struct {
+	Data []byte
+}
+
+func (e *EFGID2) Info() EFInfo { return efInfo("EF_GID2") }
+
+func (e *EFGID2) Decode(records [][]byte) error {
+	r, err := singleRecord("EF_GID2", records, 0)
+	e.Data = r
+	return err
+}
+
+func (e *EFGID2) Encode() ([][]byte, error) {
+	return [][]byte{e.Data}, nil
+}
+
+// PLMNID is a PLMN identity as MCC and 2 or 3 digit MNC
+type PLMNID struct {
+	MCC string
+	MNC string
+}
+
+func (p PLMNID) String() string {
+	return p.MCC + "-" + p.MNC
+}
+
+// decodePLMNID decodes a 3 octet PLMN, ok is false for unused 'FFFFFF' entries
+func decodePLMNID(b []byte) (p PLMNID, ok bool, err error) {
+	if isEmptyRecord(b[:3]) {
+		return
+	}
+	p.MCC, p.MNC, err = decodePLMN(b)
+	return p, err == nil, err
+}
+
+func (p PLMNID) encode() ([]byte, error) {
+	b, err := EncodePLMN(p.MCC, p.MNC)
+	return b[:], err
+}
+
+// EFSPDI is EF_SPDI, the service provider PLMN list, TS 31.102 4.2.66
+type EFSPDI struct {
+	PLMNs []PLMNID
+}
+
+const (
+	spdiTag         = 0xA3
+	spdiPLMNListTag = 0x80
+)
+
+func (e *EFSPDI) Info() EFInfo { return efInfo("EF_SPDI") }
+
+func (e *EFSPDI) Decode(records [][]byte) error {
+	r, err := singleRecord("EF_SPDI", records, 0)
+	if err != nil {
+		return err
+	}
+	e.PLMNs = nil
+	if len(r) < 4 || r[0] != spdiTag || r[2] != spdiPLMNListTag {
+		if isEmptyRecord(r) {
+			return nil
+		}
+		return errors.New("EF_SPDI: missing service provider PLMN list")
+	}
+	n := int(r[3])
+	if 4+n > len(r) {
+		return errors.New("EF_SPDI: truncated PLMN list")
+	}
+	for i := 4; i+3 <= 4+n; i += 3 {
+		p, ok, err := decodePLMNID(r[i : i+3])
+		if err != nil {
+			return fmt.Errorf("EF_SPDI: %v", err)
+		}
+		if ok {
+			e.PLMNs = append(e.PLMNs, p)
+		}
+	}
+	return nil
+}
+
+func (e *EFSPDI) Encode() ([][]byte, error) {
+	var list []byte
+	for _, p := range e.PLMNs {
+		b, err := p.encode()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, b...)
+	}
+	r := []byte{spdiTag, byte(len(list) + 2), spdiPLMNListTag, byte(len(list))}
+	return [][]byte{append(r, list...)}, nil
+}
+
+// ECCEntry is an emergency call code with its alpha identifier and the
+// emergency service category of TS 24.008 10.5.4.33
+type ECCEntry struct {
+	Number   string
+	Alpha    string
+	Category byte
+}
+
+// EFECC is EF_ECC, TS 31.102 4.2.21. AlphaLen is the alpha identifier length
+// of the records, the longest alpha identifier is used if it is 0.
+type EFECC struct {
+	Entries  []ECCEntry
+	AlphaLen int
+}
+
+const eccNumberLen = 3
+
+func (e *EFECC) Info() EFInfo { return efInfo("EF_ECC") }
+
+func (e *EFECC) Decode(records [][]byte) error {
+	e.Entries = nil
+	for _, r := range records {
+		if len(r) < eccNumberLen+1 {
+			return fmt.Errorf("EF_ECC: record too short (%d bytes)", len(r))
+		}
+		e.AlphaLen = len(r) - eccNumberLen - 1
+		if isEmptyRecord(r[:eccNumberLen]) {
+			continue
+		}
+		e.Entries = append(e.Entries, ECCEntry{
+			Number:   strings.TrimRight(decodeBCD(r[:eccNumberLen]), "f"),
+			Alpha:    decodeAlphaID(r[eccNumberLen : len(r)-1]),
+			Category: r[len(r)-1],
+		})
+	}
+	return nil
+}
+
+func (e *EFECC) Encode() ([][]byte, error) {
+	alphaLen := e.AlphaLen
+	if alphaLen == 0 {
+		for _, c := range e.Entries {
+			if n := len(encodeAlphaID(c.Alpha, -1)); n > alphaLen {
+				alphaLen = n
+			}
+		}
+	}
+	var records [][]byte
+	for _, c := range e.Entries {
+		if !isDigits(c.Number) || len(c.Number) > 2*eccNumberLen {
+			return nil, fmt.Errorf("EF_ECC: invalid emergency number %q", c.Number)
+		}
+		r := bytes.Repeat([]byte{0xFF}, eccNumberLen)
+		copy(r, encodeBCD(c.Number))
+		r = append(r, encodeAlphaID(c.Alpha, alphaLen)...)
+		records = append(records, append(r, c.Category))
+	}
+	return records, nil
+}
+
+// EFLI is EF_LI, the preferred languages as ISO 639 codes, TS 31.102 4.2.1
+type EFLI struct {
+	Languages []string
+}
+
+func (e *EFLI) Info() EFInfo { return efInfo("EF_LI") }
+
+func (e *EFLI) Decode(records [][]byte) error {
+	r, err := singleRecord("EF_LI", records, 0)
+	if err != nil {
+		return err
+	}
+	e.Languages = nil
+	for i := 0; i+2 <= len(r); i += 2 {
+		if !isEmptyRecord(r[i : i+2]) {
+			e.Languages = append(e.Languages, string(r[i:i+2]))
+		}
+	}
+	return nil
+}
+
+func (e *EFLI) Encode() ([][]byte, error) {
+	var r []byte
+	for _, l := range e.Languages {
+		if len(l) != 2 {
+			return nil, fmt.Errorf("EF_LI: invalid language code %q", l)
+		}
+		r = append(r, l...)
+	}
+	return [][]byte{r}, nil
+}
+
+// EFSTARTHFN is EF_START-HFN, the 20 bit START values, TS 31.102 4.2.51
+type EFSTARTHFN struct {
+	CS uint32
+	PS uint32
+}
+
+func (e *EFSTARTHFN) Info() EFInfo { return efInfo("EF_START-HFN") }
+
+func uint24(b []byte) uint32 {
+	return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+}
+
+func putUint24(v uint32) []byte {
+	return []byte{byte(v >> 16), byte(v >> 8), byte(v)}
+}
+
+func (e *EFSTARTHFN) Decode(records [][]byte) error {
+	r, err := singleRecord("EF_START-HFN", records, 6)
+	if err == nil {
+		e.CS, e.PS = uint24(r), uint24(r[3:])
+	}
+	return err
+}
+
+func (e *EFSTARTHFN) Encode() ([][]byte, error) {
+	return [][]byte{append(putUint24(e.CS), putUint24(e.PS)...)}, nil
+}
+
+// EFThreshold is EF_THRESHOLD, the maximum value of START, TS 31.102 4.2.52
+type EFThreshold struct {
+	Threshold uint32
+}
+
+func (e *EFThreshold) Info() EFInfo { return efInfo("EF_THRESHOLD") }
+
+func (e *EFThreshold) Decode(records [][]byte) error {
+	r, err := singleRecord("EF_THRESHOLD", records, 3)
+	if err == nil {
+		e.Threshold = uint24(r)
+	}
+	return err
+}
+
+func (e *EFThreshold) Encode() ([][]byte, error) {
+	return [][]byte{putUint24(e.Threshold)}, nil
+}
+
+// EFKeys is EF_KEYS (CS domain) or EF_KEYSPS (PS domain), TS 31.102 4.2.4 and 4.2.5
+type EFKeys struct {
+	PS  bool
+	KSI byte
+	CK  []byte
+	IK  []byte
+}
+
+func (e *EFKeys) Info() EFInfo {
+	if e.PS {
+		return efInfo("EF_KEYSPS")
+	}
+	return efInfo("EF_KEYS")
+}
+
+func (e *EFKeys) Decode(records [][]byte) error {
+	r, err := singleRecord(e.Info().Name, records, 1+CK_LEN+IK_LEN)
+	if err == nil {
+		e.KSI = r[0] & 0x07
+		e.CK = append([]byte{}, r[1:1+CK_LEN]...)
+		e.IK = append([]byte{}, r[1+CK_LEN:1+CK_LEN+IK_LEN]...)
+	}
+	return err
+}
+
+func (e *EFKeys) Encode() ([][]byte, error) {
+	if len(e.CK) != CK_LEN || len(e.IK) != IK_LEN {
+		return nil, fmt.Errorf("%s: invalid key length", e.Info().Name)
+	}
+	r := append([]byte{e.KSI & 0x07}, e.CK...)
+	return [][]byte{append(r, e.IK...)}, nil
+}
@@ -0,0 +1,121 @@
+package usim_go
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEFRoundTrip(t *testing.T) {
+	cases := []struct {
+		ef  ElementaryFile
+		raw string
+	}{
+		{&EFIMSI{IMSI: "208930000000001"}, "082980390000000010"},
+		{&EFICCID{ICCID: "8933010000000000017"}, "983310000000000010f7"},
+		{&EFAD{UEOperationMode: 0x80, MNCLength: 3}, "80000003"},
+		{&EFACC{Classes: 1 << 5}, "0020"},
+		{&EFHPPLMN{Interval: 10}, "0a"},
+		{&EFGID1{Data: []byte{0x12, 0x34}}, "1234"},
+		{&EFSPDI{PLMNs: []PLMNID{{"208", "93"}, {"310", "410"}}}, "a308800602f839130014"},
+		{&EFLI{Languages: []string{"en", "fr"}}, "656e6672"},
+		{&EFSTARTHFN{CS: 0xF00000, PS: 0x000123}, "f00000000123"},
+		{&EFThreshold{Threshold: 0xFFFFF}, "0fffff"},
+	}
+	for _, c := range cases {
+		records, err := c.ef.Encode()
+		if err != nil {
+			t.Fatalf("%s: %v", c.ef.Info().Name, err)
+		}
+		if len(records) != 1 || !bytes.Equal(records[0], unhex(c.raw)) {
+			t.Errorf("%s: encoded %x, want %s", c.ef.Info().Name, records, c.raw)
+		}
+		decoded := reflect.New(reflect.TypeOf(c.ef).Elem()).Interface().(ElementaryFile)
+		if err = decoded.Decode(records); err != nil {
+			t.Fatalf("%s: %v", c.ef.Info().Name, err)
+		}
+		if !reflect.DeepEqual(decoded, c.ef) {
+			t.Errorf("%s: decoded %+v, want %+v", c.ef.Info().Name, decoded, c.ef)
+		}
+	}
+	for _, imsi := range []string{"20893", "2089300000000001"} {
+		if _, err := (&EFIMSI{IMSI: imsi}).Encode(); err == nil {
+			t.Errorf("EF_IMSI: IMSI %s accepted", imsi)
+		}
+	}
+}
+
+func TestEFECC(t *testing.T) {
+	records := [][]byte{
+		unhex("11f2ff" + "456d657267656e6379" + "ffffff" + "00"),
+		unhex("19f1ff" + "506f6c696365ffffffffffff" + "01"),
+		unhex("ffffff" + "ffffffffffffffffffffffff" + "ff"),
+	}
+	var ecc EFECC
+	if err := ecc.Decode(records); err != nil {
+		t.Fatal(err)
+	}
+	want := []ECCEntry{{"112", "Emergency", 0}, {"911", "Police", 1}}
+	if !reflect.DeepEqual(ecc.Entries, want) || ecc.AlphaLen != 12 {
+		t.Fatalf("EF_ECC decoded %+v alpha %d", ecc.Entries, ecc.AlphaLen)
+	}
+	out, err := ecc.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out, records[:2]) {
+		t.Errorf("EF_ECC encoded %x", out)
+	}
+}
+
+func TestEFKeys(t *testing.T) {
+	k := EFKeys{PS: true, KSI: 2, CK: bytes.Repeat([]byte{1}, CK_LEN), IK: bytes.Repeat([]byte{2}, IK_LEN)}
+	if k.Info().FID != 0x6F09 {
+		t.Errorf("EF_KEYSPS FID %04X", k.Info().FID)
+	}
+	records, err := k.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := EFKeys{PS: true}
+	if err = got.Decode(records); err != nil || !reflect.DeepEqual(got, k) {
+		t.Errorf("EF_KEYSPS decoded %+v, %v", got, err)
+	}
+	if (EFHPPLMN{Interval: 10}).Period() != time.Hour {
+		t.Error("EF_HPPLMN period")
+	}
+}
+
+func TestLookupEF(t *testing.T) {
+	info, ok := LookupEF("EF_5GS3GPPLOCI")
+	if !ok || info.FID != 0x4F01 || !reflect.DeepEqual(info.Path, []uint16{EF_PATH_ADF_USIM, EF_PATH_DF_5GS}) {
+		t.Errorf("EF_5GS3GPPLOCI: %+v", info)
+	}
+	if _, ok = LookupEF("EF_NOPE"); ok {
+		t.Error("unknown EF found")
+	}
+}
+
+func TestSoftReadEF(t *testing.T) {
+	u, _ := testSubscriber(t)
+	ad, err := ReadEF[EFAD](u)
+	if err != nil || ad.MNCLength != 2 {
+		t.Fatalf("EF_AD: %+v, %v", ad, err)
+	}
+	imsi, err := ReadEF[EFIMSI](u)
+	if err != nil || imsi.IMSI != "208930000000001" {
+		t.Fatalf("EF_IMSI: %+v, %v", imsi, err)
+	}
+	if _, err = ReadEF[EFECC](u); err == nil {
+		t.Error("EF_ECC read from empty profile")
+	}
+	ecc := EFECC{Entries: []ECCEntry{{Number: "112", Category: 0}}}
+	if err = UpdateEF(u, &ecc); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadEF[EFECC](u)
+	if err != nil || len(got.Entries) != 1 || got.Entries[0].Number != "112" {
+		t.Errorf("EF_ECC: %+v, %v", got, err)
+	}
+}
@@ -0,0 +1,385 @@
+package usim_go
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Operator name display of TS 22.101 A.4 with EF_SPN, EF_PNN, EF_OPL and
+// EF_SPDI of TS 31.102 4.2.12, 4.2.58, 4.2.59 and 4.2.66
+
+const (
+	spnLen = 16
+
+	// display conditions of EF_SPN
+	SPN_DISPLAY_PLMN_AT_HOME = 0x01 // show the PLMN name in the HPLMN and SPDI PLMNs
+	SPN_HIDE_WHEN_ROAMING    = 0x02 // do not show the SPN in other PLMNs
+
+	pnnFullNameTag  = 0x43
+	pnnShortNameTag = 0x45
+	pnnAddInfoTag   = 0x80
+
+	// wildcard digit of EF_OPL PLMN entries
+	OPL_WILDCARD = 'D'
+)
+
+// EFSPN is EF_SPN, the service provider name and its display condition
+type EFSPN struct {
+	Condition byte
+	Name      string
+}
+
+func (e *EFSPN) Info() EFInfo { return efInfo("EF_SPN") }
+
+func (e *EFSPN) Decode(records [][]byte) error {
+	r, err := singleRecord("EF_SPN", records, 1)
+	if err != nil {
+		return err
+	}
+	e.Condition, e.Name = r[0], decodeAlphaID(r[1:])
+	return nil
+}
+
+func (e *EFSPN) Encode() ([][]byte, error) {
+	return [][]byte{append([]byte{e.Condition}, encodeAlphaID(e.Name, spnLen)...)}, nil
+}
+
+// NetworkName is a record of EF_PNN
+type NetworkName struct {
+	Full  string
+	Short string
+	// AddInfo is the PLMN additional information, coded as an alpha identifier
+	AddInfo string
+}
+
+// EFPNN is EF_PNN, Names[i] is record i+1 and empty for unused records
+type EFPNN struct {
+	Names []NetworkName
+}
+
+func (e *EFPNN) Info() EFInfo { return efInfo("EF_PNN") }
+
+func (e *EFPNN) Decode(records [][]byte) error {
+	e.Names = nil
+	for i, r := range records {
+		var n NetworkName
+		for pos := 0; pos+2 <= len(r) && r[pos] != 0xFF; {
+			tag, l := r[pos], int(r[pos+1])
+			pos += 2
+			if pos+l > len(r) {
+				return fmt.Errorf("EF_PNN: record %d truncated", i+1)
+			}
+			v := r[pos : pos+l]
+			pos += l
+			var err error
+			switch tag {
+			case pnnFullNameTag:
+				n.Full, err = decodeNetworkName(v)
+			case pnnShortNameTag:
+				n.Short, err = decodeNetworkName(v)
+			case pnnAddInfoTag:
+				n.AddInfo = decodeAlphaID(v)
+			}
+			if err != nil {
+				return fmt.Errorf("EF_PNN: record %d: %v", i+1, err)
+			}
+		}
+		e.Names = append(e.Names, n)
+	}
+	return nil
+}
+
+func (e *EFPNN) Encode() ([][]byte, error) {
+	var records [][]byte
+	for _, n := range e.Names {
+		var r []byte
+		add := func(tag byte, v []byte) {
+			r = append(append(r, tag, byte(len(v))), v...)
+		}
+		if n.Full != "" {
+			add(pnnFullNameTag, encodeNetworkName(n.Full))
+		}
+		if n.Short != "" {
+			add(pnnShortNameTag, encodeNetworkName(n.Short))
+		}
+		if n.AddInfo != "" {
+			add(pnnAddInfoTag, encodeAlphaID(n.AddInfo, -1))
+		}
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+// OPLEntry is a record of EF_OPL or EF_OPL5G. MCC and MNC may hold the
+// wildcard digit 'D'. The area is the LAC or TAC range, PNNRecord 0 means
+// the name comes from other sources.
+type OPLEntry struct {
+	MCC       string
+	MNC       string
+	AreaStart uint32
+	AreaEnd   uint32
+	PNNRecord int
+}
+
+// decodePLMNPattern decodes a PLMN of EF_OPL keeping wildcard digits
+func decodePLMNPattern(b []byte) (mcc, mnc string) {
+	digits := strings.ToUpper(decodeBCD(b[:3]))
+	mcc, mnc = digits[0:3], digits[4:6]
+	if digits[3] != 'F' {
+		mnc += digits[3:4]
+	}
+	return
+}
+
+func encodePLMNPattern(mcc, mnc string) ([]byte, error) {
+	valid := func(s string) bool {
+		for i := 0; i < len(s); i++ {
+			if (s[i] < '0' || s[i] > '9') && s[i] != OPL_WILDCARD {
+				return false
+			}
+		}
+		return true
+	}
+	if len(mcc) != 3 || len(mnc) < 2 || len(mnc) > 3 || !valid(mcc+mnc) {
+		return nil, fmt.Errorf("EF_OPL: invalid PLMN %s/%s", mcc, mnc)
+	}
+	nib := func(c byte) byte {
+		if c == OPL_WILDCARD {
+			return 0xD
+		}
+		return c - '0'
+	}
+	mnc3 := byte(0xF)
+	if len(mnc) == 3 {
+		mnc3 = nib(mnc[2])
+	}
+	return []byte{nib(mcc[1])<<4 | nib(mcc[0]), mnc3<<4 | nib(mcc[2]), nib(mnc[1])<<4 | nib(mnc[0])}, nil
+}
+
+func matchPattern(pattern, digits string) bool {
+	if len(pattern) != len(digits) {
+		return false
+	}
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] != OPL_WILDCARD && pattern[i] != digits[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Matches reports whether the entry covers the PLMN and LAC or TAC
+func (o OPLEntry) Matches(p PLMNID, area uint32) bool {
+	return matchPattern(o.MCC, p.MCC) && matchPattern(o.MNC, p.MNC) && area >= o.AreaStart && area <= o.AreaEnd
+}
+
+// oplList holds the records of EF_OPL (2 octet LAC) or EF_OPL5G (3 octet TAC)
+type oplList struct {
+	Entries []OPLEntry
+}
+
+func (l *oplList) decode(name string, records [][]byte, areaLen int) error {
+	l.Entries = nil
+	for i, r := range records {
+		if len(r) < 3+2*areaLen+1 {
+			return fmt.Errorf("%s: record %d too short", name, i+1)
+		}
+		if isEmptyRecord(r[:3]) {
+			continue
+		}
+		var o OPLEntry
+		o.MCC, o.MNC = decodePLMNPattern(r)
+		for j := 0; j < areaLen; j++ {
+			o.AreaStart = o.AreaStart<<8 | uint32(r[3+j])
+			o.AreaEnd = o.AreaEnd<<8 | uint32(r[3+areaLen+j])
+		}
+		o.PNNRecord = int(r[3+2*areaLen])
+		l.Entries = append(l.Entries, o)
+	}
+	return nil
+}
+
+func (l *oplList) encode(areaLen int) ([][]byte, error) {
+	var records [][]byte
+	for _, o := range l.Entries {
+		r, err := encodePLMNPattern(o.MCC, o.MNC)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range []uint32{o.AreaStart, o.AreaEnd} {
+			for j := areaLen - 1; j >= 0; j-- {
+				r = append(r, byte(v>>(8*j)))
+			}
+		}
+		records = append(records, append(r, byte(o.PNNRecord)))
+	}
+	return records, nil
+}
+
+// lookup returns the PNN record of the first matching entry, 0 if none
+func (l *oplList) lookup(p PLMNID, area uint32) (pnn int, ok bool) {
+	for _, o := range l.Entries {
+		if o.Matches(p, area) {
+			return o.PNNRecord, true
+		}
+	}
+	return 0, false
+}
+
+// EFOPL is EF_OPL, the operator PLMN list with LAC ranges (TAC in E-UTRAN)
+type EFOPL struct {
+	oplList
+}
+
+func (e *EFOPL) Info() EFInfo                  { return efInfo("EF_OPL") }
+func (e *EFOPL) Decode(records [][]byte) error { return e.decode("EF_OPL", records, 2) }
+func (e *EFOPL) Encode() ([][]byte, error)     { return e.encode(2) }
+
+// EFOPL5G is EF_OPL5G of DF_5GS with 3 octet TAC ranges, TS 31.102 4.4.11.9
+type EFOPL5G struct {
+	oplList
+}
+
+func (e *EFOPL5G) Info() EFInfo                  { return efInfo("EF_OPL5G") }
+func (e *EFOPL5G) Decode(records [][]byte) error { return e.decode("EF_OPL5G", records, 3) }
+func (e *EFOPL5G) Encode() ([][]byte, error)     { return e.encode(3) }
+
+// NameFiles holds the card content used for the operator name, nil files are absent
+type NameFiles struct {
+	HPLMN   PLMNID
+	EHPLMNs []PLMNID
+	SPN     *EFSPN
+	PNN     *EFPNN
+	OPL     *EFOPL
+	OPL5G   *EFOPL5G
+	SPDI    *EFSPDI
+}
+
+// OperatorName is the name to display for the registered PLMN
+type OperatorName struct {
+	PLMNName  string
+	ShortName string
+	// PLMNSource tells where the PLMN name comes from: "PNN", "table" or "MCC/MNC"
+	PLMNSource string
+	SPN        string
+	ShowPLMN   bool
+	ShowSPN    bool
+}
+
+// Display returns the text shown to the user
+func (n OperatorName) Display() string {
+	switch {
+	case n.ShowPLMN && n.ShowSPN && n.SPN != n.PLMNName:
+		return n.PLMNName + " - " + n.SPN
+	case n.ShowSPN:
+		return n.SPN
+	}
+	return n.PLMNName
+}
+
+func (f *NameFiles) isHome(p PLMNID) bool {
+	return p == f.HPLMN || containsPLMN(f.EHPLMNs, p)
+}
+
+// plmnName looks up the EONS name, EF_OPL is consulted first and without it
+// the first EF_PNN record names the home network
+func (f *NameFiles) plmnName(p PLMNID, area uint32, fiveG bool) (n NetworkName, ok bool) {
+	if f.PNN == nil {
+		return
+	}
+	var rec int
+	switch {
+	case fiveG && f.OPL5G != nil:
+		rec, ok = f.OPL5G.lookup(p, area)
+	case f.OPL != nil:
+		rec, ok = f.OPL.lookup(p, area)
+	case f.isHome(p):
+		rec, ok = 1, true
+	}
+	if !ok || rec < 1 || rec > len(f.PNN.Names) || f.PNN.Names[rec-1].Full == "" {
+		return n, false
+	}
+	return f.PNN.Names[rec-1], true
+}
+
+// ResolveOperatorName returns the name and display conditions for the
+// registered PLMN with its LAC or TAC, fiveG selects EF_OPL5G if present
+func ResolveOperatorName(f NameFiles, p PLMNID, area uint32, fiveG bool) (name OperatorName) {
+	if n, ok := f.plmnName(p, area, fiveG); ok {
+		name.PLMNName, name.ShortName, name.PLMNSource = n.Full, n.Short, "PNN"
+	} else if info, ok := LookupPLMN(p.MCC, p.MNC); ok {
+		name.PLMNName, name.PLMNSource = info.Operator, "table"
+	} else {
+		name.PLMNName, name.PLMNSource = p.String(), "MCC/MNC"
+	}
+	name.ShowPLMN = true
+	if f.SPN == nil || f.SPN.Name == "" {
+		return
+	}
+	name.SPN = f.SPN.Name
+	home := f.isHome(p)
+	if !home && f.SPDI != nil {
+		home = containsPLMN(f.SPDI.PLMNs, p)
+	}
+	if home {
+		name.ShowSPN = true
+		name.ShowPLMN = f.SPN.Condition&SPN_DISPLAY_PLMN_AT_HOME != 0
+	} else {
+		name.ShowSPN = f.SPN.Condition&SPN_HIDE_WHEN_ROAMING == 0
+	}
+	return
+}
+
+// NameFiles reads the files for ResolveOperatorName, absent files are left nil
+func (u *USIM) NameFiles() (f NameFiles, err error) {
+	if u.mccStr == "" {
+		return f, errors.New("operator name: HPLMN unknown")
+	}
+	f.HPLMN = PLMNID{u.mccStr, u.mncStr}
+	skipped := func(name string, err error) {
+		logrus.Debugf("operator name: %s not used: %v", name, err)
+	}
+	if e, err := ReadEF[EFEHPLMN](u); err == nil {
+		f.EHPLMNs = e.PLMNs
+	} else {
+		skipped("EF_EHPLMN", err)
+	}
+	if e, err := ReadEF[EFSPN](u); err == nil {
+		f.SPN = &e
+	} else {
+		skipped("EF_SPN", err)
+	}
+	if e, err := ReadEF[EFPNN](u); err == nil {
+		f.PNN = &e
+	} else {
+		skipped("EF_PNN", err)
+	}
+	if e, err := ReadEF[EFOPL](u); err == nil {
+		f.OPL = &e
+	} else {
+		skipped("EF_OPL", err)
+	}
+	if e, err := ReadEF[EFOPL5G](u); err == nil {
+		f.OPL5G = &e
+	} else {
+		skipped("EF_OPL5G", err)
+	}
+	if e, err := ReadEF[EFSPDI](u); err == nil {
+		f.SPDI = &e
+	} else {
+		skipped("EF_SPDI", err)
+	}
+	return
+}
+
+// OperatorName returns the name to display when registered on the PLMN in the LAC or TAC area
+func (u *USIM) OperatorName(p PLMNID, area uint32, fiveG bool) (OperatorName, error) {
+	f, err := u.NameFiles()
+	if err != nil {
+		return OperatorName{}, err
+	}
+	return ResolveOperatorName(f, p, area, fiveG), nil
+}
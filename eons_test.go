@@ -0,0 +1,98 @@
+package usim_go
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestEFPNN(t *testing.T) {
+	// "Test" in GSM 7 bit packed, "Лаб" in UCS2
+	raw := unhex("430584d4f29c0e" + "450790041b04300431" + "ffff")
+	var pnn EFPNN
+	if err := pnn.Decode([][]byte{raw, unhex("ffffffff")}); err != nil {
+		t.Fatal(err)
+	}
+	if len(pnn.Names) != 2 || pnn.Names[0].Full != "Test" || pnn.Names[0].Short != "Лаб" || pnn.Names[1].Full != "" {
+		t.Fatalf("EF_PNN %+v", pnn.Names)
+	}
+	out, err := pnn.Encode()
+	if err != nil || !bytes.Equal(out[0], raw[:len(raw)-2]) || len(out[1]) != 0 {
+		t.Errorf("EF_PNN encoded %x, %v", out, err)
+	}
+	// spare bits in the coding octet of a name without text
+	if err := pnn.Decode([][]byte{unhex("430187ff")}); err == nil {
+		t.Error("EF_PNN name with spare bits and no text accepted")
+	}
+}
+
+func TestEFOPL(t *testing.T) {
+	raw := [][]byte{
+		unhex("02f8390000fffe01"),
+		unhex("62fdd00100010002"),
+		unhex("ffffffffffffffff"),
+	}
+	var opl EFOPL
+	if err := opl.Decode(raw); err != nil {
+		t.Fatal(err)
+	}
+	want := []OPLEntry{{"208", "93", 0, 0xFFFE, 1}, {"26D", "0D", 0x0100, 0x0100, 2}}
+	if !reflect.DeepEqual(opl.Entries, want) {
+		t.Fatalf("EF_OPL %+v", opl.Entries)
+	}
+	out, err := opl.Encode()
+	if err != nil || !reflect.DeepEqual(out, raw[:2]) {
+		t.Errorf("EF_OPL encoded %x, %v", out, err)
+	}
+	if !want[1].Matches(PLMNID{"262", "03"}, 0x100) || want[1].Matches(PLMNID{"262", "03"}, 0x101) {
+		t.Error("wildcard match")
+	}
+}
+
+func TestOperatorName(t *testing.T) {
+	home, partner, roam := PLMNID{"208", "93"}, PLMNID{"262", "03"}, PLMNID{"234", "15"}
+	f := NameFiles{
+		HPLMN: home,
+		SPN:   &EFSPN{Condition: SPN_DISPLAY_PLMN_AT_HOME, Name: "Lab MVNO"},
+		PNN:   &EFPNN{Names: []NetworkName{{Full: "Lab Network"}, {Full: "Partner", Short: "P"}}},
+		OPL:   &EFOPL{oplList{[]OPLEntry{{"208", "93", 0, 0xFFFE, 1}, {"26D", "0D", 0x100, 0x1FF, 2}}}},
+		SPDI:  &EFSPDI{PLMNs: []PLMNID{partner}},
+	}
+	n := ResolveOperatorName(f, home, 0x10, false)
+	if n.PLMNName != "Lab Network" || !n.ShowPLMN || !n.ShowSPN || n.Display() != "Lab Network - Lab MVNO" {
+		t.Errorf("home %+v", n)
+	}
+	// the SPDI PLMN counts as home, the PLMN name is not required without condition bit 1
+	f.SPN.Condition = 0
+	n = ResolveOperatorName(f, partner, 0x150, false)
+	if n.PLMNName != "Partner" || n.ShortName != "P" || n.ShowPLMN || n.Display() != "Lab MVNO" {
+		t.Errorf("partner %+v", n)
+	}
+	// outside the OPL area the name comes from the table
+	n = ResolveOperatorName(f, partner, 0x200, false)
+	if n.PLMNName != "O2" || n.PLMNSource != "table" {
+		t.Errorf("partner outside area %+v", n)
+	}
+	f.SPN.Condition = SPN_HIDE_WHEN_ROAMING
+	n = ResolveOperatorName(f, roam, 1, false)
+	if n.ShowSPN || n.Display() != "Vodafone" {
+		t.Errorf("roaming %+v", n)
+	}
+	if n = ResolveOperatorName(f, PLMNID{"999", "99"}, 1, false); n.Display() != "999-99" {
+		t.Errorf("unknown %+v", n)
+	}
+}
+
+func TestSoftOperatorName(t *testing.T) {
+	u, _ := testSubscriber(t)
+	if err := UpdateEF(u, &EFSPN{Name: "Lab"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateEF(u, &EFPNN{Names: []NetworkName{{Full: "Lab Network"}}}); err != nil {
+		t.Fatal(err)
+	}
+	n, err := u.OperatorName(PLMNID{"208", "93"}, 1, false)
+	if err != nil || n.Display() != "Lab" || n.PLMNName != "Lab Network" {
+		t.Errorf("%+v, %v", n, err)
+	}
+}
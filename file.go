@@ -48,6 +48,9 @@ func _select_file(ctx *smartcard.Card, fileId int, simType int, aid []byte) ([]b
 	if resp[0] == 0x6e {
 		return nil, errors.New("SCARD: used CLA not supported")
 	}
+	if resp[0] == 0x6a && resp[1] == 0x82 {
+		return nil, FILE_NOT_FOUND
+	}
 	if resp[0] != 0x6c && resp[0] != 0x9f && resp[0] != 0x61 {
 		return nil, fmt.Errorf("SCARD: unexpected response 0x%02x (expected 0x61, 0x6c, or 0x9f)", resp[0])
 	}
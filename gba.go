@@ -0,0 +1,225 @@
+package usim_go
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Generic Bootstrapping Architecture of TS 33.220, Ub of TS 24.109
+
+const (
+	FC_GBA = 0x01
+
+	GBA_ME = "gba-me"
+	GBA_U  = "gba-u"
+
+	// PSK identity hints of TS 33.222 5.4
+	GBA_PSK_IDENTITY_HINT   = "3GPP-bootstrapping"
+	GBA_U_PSK_IDENTITY_HINT = "3GPP-bootstrapping-uicc"
+
+	gbaUserAgent     = "usim_go 3gpp-gba"
+	gbaUUserAgent    = "usim_go 3gpp-gba-uicc"
+	gbaBootstrapURI  = "/"
+	gbaMaxBodyLength = 4096
+)
+
+// GBAUICC runs GBA_U on the card, implemented by USIM
+type GBAUICC interface {
+	GBABootstrap(rand, autn [16]byte) (res, auts []byte, err error)
+	GBANAFDerivation(nafID []byte, impi string) (ksExtNAF []byte, err error)
+}
+
+// BootstrappingInfo is the body of the final Ub response
+type BootstrappingInfo struct {
+	XMLName  xml.Name  `xml:"uri:3gpp-gba BootstrappingInfo"`
+	BTID     string    `xml:"btid"`
+	Lifetime time.Time `xml:"lifetime"`
+}
+
+// BSFAddress returns the BSF domain name of TS 23.003 16.2
+func BSFAddress(mcc, mnc string) string {
+	return "bsf." + pubDomain(mcc, mnc)
+}
+
+// NAFID returns NAF_Id = FQDN || Ua security protocol identifier, see TS 33.220 Annex H
+func NAFID(fqdn string, uaProtocol [5]byte) []byte {
+	return append([]byte(fqdn), uaProtocol[:]...)
+}
+
+// GBATLSProtocolID returns the Ua security protocol identifier of shared key
+// based TLS (TS 33.222) with the given cipher suite
+func GBATLSProtocolID(cipherSuite uint16) [5]byte {
+	return [5]byte{0x01, 0x00, 0x01, byte(cipherSuite >> 8), byte(cipherSuite)}
+}
+
+// DeriveKsNAF derives Ks_NAF (GBA_ME, Ks_ext_NAF for GBA_U) with gba = GBA_ME
+// or Ks_int_NAF with gba = GBA_U, see TS 33.220 4.5.2 and 5.3.2
+func DeriveKsNAF(ks []byte, gba string, rand []byte, impi string, nafID []byte) []byte {
+	return KDF(ks, FC_GBA, []byte(gba), rand, []byte(impi), nafID)
+}
+
+// gbaUnmaskMAC converts MAC* of a GBA_U AUTN to MAC and back, MAC* = MAC ^ Trunc(SHA-1(IK))
+func gbaUnmaskMAC(mac []byte, ik []byte) {
+	h := sha1.Sum(ik)
+	for i := 0; i < MAC_LEN; i++ {
+		mac[i] ^= h[i]
+	}
+}
+
+// gbaUAuthenticator runs Digest AKA with the bootstrapping mode of the card
+type gbaUAuthenticator struct {
+	uicc GBAUICC
+}
+
+func (g gbaUAuthenticator) GenAuthResMilenage(rand, autn [16]byte) (res, ik, ck, auts []byte, err error) {
+	res, auts, err = g.uicc.GBABootstrap(rand, autn)
+	return
+}
+
+// GBAClient bootstraps over Ub and derives NAF keys for Ua
+type GBAClient struct {
+	Auth AKAAuthenticator
+	// UICC runs GBA_U on the card when set, GBA_ME is used otherwise
+	UICC GBAUICC
+	IMPI string
+	// BSF is the base URL of the BSF
+	BSF  string
+	HTTP *http.Client
+
+	BTID     string
+	Lifetime time.Time
+
+	rand []byte
+	ks   []byte
+}
+
+// NewGBAClient creates a client with the IMPI derived from the IMSI of u. The
+// BSF address of TS 23.003 is used if bsfURL is empty.
+func NewGBAClient(u *USIM, bsfURL string) *GBAClient {
+	impi, _, _ := IMSIdentities(u.IMSI(), u.mccStr, u.mncStr)
+	if bsfURL == "" {
+		bsfURL = "http://" + BSFAddress(u.mccStr, u.mncStr)
+	}
+	return &GBAClient{Auth: u, IMPI: impi, BSF: bsfURL, HTTP: http.DefaultClient}
+}
+
+// Bootstrap runs the bootstrapping procedure of TS 33.220 4.5.2 (5.3.2 for GBA_U)
+func (c *GBAClient) Bootstrap() (err error) {
+	c.BTID, c.ks, c.rand = "", nil, nil
+	auth := c.Auth
+	if c.UICC != nil {
+		auth = gbaUAuthenticator{c.UICC}
+	}
+	host := strings.TrimPrefix(strings.TrimPrefix(c.BSF, "https://"), "http://")
+	if i := strings.IndexAny(host, ":/"); i >= 0 {
+		host = host[:i]
+	}
+	digest := NewDigestAKAClient(auth, c.IMPI)
+	authz := fmt.Sprintf("Digest username=%q, realm=%q, nonce=\"\", uri=%q, response=\"\"", c.IMPI, host, gbaBootstrapURI)
+
+	var r DigestAKAResult
+	var ch DigestChallenge
+	for attempt := 0; ; attempt++ {
+		var rsp *http.Response
+		if rsp, _, err = c.ub(authz); err != nil {
+			return
+		}
+		if rsp.StatusCode != http.StatusUnauthorized {
+			return fmt.Errorf("GBA: unexpected Ub response %s", rsp.Status)
+		}
+		if ch, err = ParseDigestChallenge(rsp.Header.Get("WWW-Authenticate")); err != nil {
+			return
+		}
+		if r, err = digest.Authorize(ch, http.MethodGet, gbaBootstrapURI, nil); err != nil {
+			return
+		}
+		authz = r.Header
+		if r.MACFailure {
+			c.ub(authz)
+			return errors.New("GBA: BSF authentication failed")
+		}
+		if r.AUTS == nil {
+			break
+		}
+		if attempt > 0 {
+			return errors.New("GBA: repeated synchronisation failure")
+		}
+		logrus.Info("GBA: synchronisation failure, sending AUTS")
+	}
+
+	rsp, body, err := c.ub(authz)
+	if err != nil {
+		return
+	}
+	if rsp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GBA: bootstrapping failed: %s", rsp.Status)
+	}
+	var info BootstrappingInfo
+	if err = xml.Unmarshal(body, &info); err != nil {
+		return fmt.Errorf("GBA: invalid BootstrappingInfo: %v", err)
+	}
+	rand, _, _, _ := ParseAKANonce(ch.Nonce)
+	c.BTID, c.Lifetime, c.rand = info.BTID, info.Lifetime, rand[:]
+	if c.UICC == nil {
+		c.ks = ckik(r.CK, r.IK)
+	}
+	logrus.Infof("GBA: bootstrapped B-TID %s until %s", c.BTID, c.Lifetime)
+	return
+}
+
+func (c *GBAClient) ub(authz string) (rsp *http.Response, body []byte, err error) {
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(c.BSF, "/")+gbaBootstrapURI, nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set("Authorization", authz)
+	if c.UICC != nil {
+		req.Header.Set("User-Agent", gbaUUserAgent)
+	} else {
+		req.Header.Set("User-Agent", gbaUserAgent)
+	}
+	if rsp, err = c.HTTP.Do(req); err != nil {
+		return
+	}
+	defer rsp.Body.Close()
+	body, err = io.ReadAll(io.LimitReader(rsp.Body, gbaMaxBodyLength))
+	return
+}
+
+// NAFKey returns Ks_NAF, or Ks_ext_NAF with GBA_U, for the NAF fqdn and Ua protocol
+func (c *GBAClient) NAFKey(fqdn string, uaProtocol [5]byte) (key []byte, err error) {
+	if c.BTID == "" {
+		return nil, errors.New("GBA: not bootstrapped")
+	}
+	if !c.Lifetime.IsZero() && time.Now().After(c.Lifetime) {
+		return nil, errors.New("GBA: bootstrapped key expired")
+	}
+	nafID := NAFID(fqdn, uaProtocol)
+	if c.UICC != nil {
+		return c.UICC.GBANAFDerivation(nafID, c.IMPI)
+	}
+	return DeriveKsNAF(c.ks, GBA_ME, c.rand, c.IMPI, nafID), nil
+}
+
+// TLSPSK returns the PSK identity and key for shared key TLS to the NAF fqdn
+// with the given cipher suite, see TS 33.222 5.4
+func (c *GBAClient) TLSPSK(fqdn string, cipherSuite uint16) (identity string, key []byte, err error) {
+	if key, err = c.NAFKey(fqdn, GBATLSProtocolID(cipherSuite)); err != nil {
+		return
+	}
+	return c.BTID, key, nil
+}
+
+// btidRAND returns the B-TID for rand and the BSF domain, see TS 33.220 4.5.2
+func btidRAND(rand []byte, domain string) string {
+	return base64.StdEncoding.EncodeToString(rand) + "@" + domain
+}
@@ -0,0 +1,165 @@
+package usim_go
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// BSF is a bootstrapping server function stand-in answering Ub with
+// AKAv1-MD5 and providing the NAF keys of Zn.
+type BSF struct {
+	Store *SubscriberStore
+	// Domain is the BSF server domain used in the realm and B-TID
+	Domain   string
+	Lifetime time.Duration
+
+	mu       sync.Mutex
+	pending  map[string]*bsfChallenge
+	sessions map[string]*bsfSession
+}
+
+type bsfChallenge struct {
+	impi string
+	imsi string
+	q    Quintet
+	gbaU bool
+}
+
+type bsfSession struct {
+	impi     string
+	rand     []byte
+	ks       []byte
+	gbaU     bool
+	lifetime time.Time
+}
+
+func NewBSF(store *SubscriberStore, domain string) *BSF {
+	return &BSF{
+		Store:    store,
+		Domain:   domain,
+		Lifetime: 24 * time.Hour,
+		pending:  map[string]*bsfChallenge{},
+		sessions: map[string]*bsfSession{},
+	}
+}
+
+func (b *BSF) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(io.LimitReader(r.Body, gbaMaxBodyLength))
+	authz := r.Header.Get("Authorization")
+	if len(authz) < 7 || !strings.EqualFold(authz[:7], "Digest ") {
+		http.Error(w, "Digest AKA required", http.StatusBadRequest)
+		return
+	}
+	params, err := parseDigestParams(authz[7:])
+	if err != nil || params["username"] == "" {
+		http.Error(w, "malformed Authorization", http.StatusBadRequest)
+		return
+	}
+	gbaU := strings.Contains(r.Header.Get("User-Agent"), "3gpp-gba-uicc")
+
+	b.mu.Lock()
+	ch := b.pending[params["nonce"]]
+	delete(b.pending, params["nonce"])
+	b.mu.Unlock()
+	if ch == nil || ch.impi != params["username"] {
+		b.challenge(w, params["username"], gbaU, nil)
+		return
+	}
+	if auts, ok := params["auts"]; ok {
+		b.challenge(w, ch.impi, gbaU, func(a *AuC) error {
+			buf, err := base64.StdEncoding.DecodeString(auts)
+			if err != nil {
+				return err
+			}
+			_, err = a.Resync(ch.q.RAND, buf)
+			return err
+		})
+		return
+	}
+	expect := digestResponse(ch.impi, params["realm"], ch.q.XRES, r.Method, params["uri"], params["nonce"],
+		params["nc"], params["cnonce"], params["qop"], body)
+	if params["response"] != expect {
+		logrus.Warn("BSF: authentication failed for ", ch.impi)
+		http.Error(w, "authentication failed", http.StatusForbidden)
+		return
+	}
+
+	s := &bsfSession{
+		impi:     ch.impi,
+		rand:     append([]byte{}, ch.q.RAND[:]...),
+		ks:       ckik(ch.q.CK[:], ch.q.IK[:]),
+		gbaU:     ch.gbaU,
+		lifetime: time.Now().Add(b.Lifetime).UTC().Truncate(time.Second),
+	}
+	info := BootstrappingInfo{BTID: btidRAND(s.rand, b.Domain), Lifetime: s.lifetime}
+	b.mu.Lock()
+	b.sessions[info.BTID] = s
+	b.mu.Unlock()
+	out, _ := xml.Marshal(info)
+	logrus.Infof("BSF: bootstrapped %s as %s", ch.impi, info.BTID)
+	w.Header().Set("Content-Type", "application/vnd.3gpp.bsf+xml")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	w.Write(out)
+}
+
+// challenge sends a 401 with a fresh vector, resync runs on the AuC first
+func (b *BSF) challenge(w http.ResponseWriter, impi string, gbaU bool, resync func(a *AuC) error) {
+	ch := &bsfChallenge{impi: impi, imsi: impi, gbaU: gbaU}
+	if i := strings.IndexByte(impi, '@'); i >= 0 {
+		ch.imsi = impi[:i]
+	}
+	err := b.Store.Lookup(ch.imsi, func(a *AuC) (err error) {
+		if resync != nil {
+			if err = resync(a); err != nil {
+				return
+			}
+		}
+		ch.q, err = a.GenQuintet()
+		return
+	})
+	if err != nil {
+		logrus.Warn("BSF: ", err)
+		http.Error(w, "unknown subscriber", http.StatusForbidden)
+		return
+	}
+	if gbaU {
+		gbaUnmaskMAC(ch.q.AUTN[8:], ch.q.IK[:])
+	}
+	nonce := base64.StdEncoding.EncodeToString(append(ch.q.RAND[:], ch.q.AUTN[:]...))
+	b.mu.Lock()
+	b.pending[nonce] = ch
+	b.mu.Unlock()
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf("Digest realm=%q, nonce=%q, algorithm=%s, qop=\"auth-int\"", b.Domain, nonce, DIGEST_AKAV1_MD5))
+	w.WriteHeader(http.StatusUnauthorized)
+}
+
+// NAFKeys returns the keys of B-TID for nafID as a NAF would get them over Zn.
+// ksIntNAF is only set for GBA_U.
+func (b *BSF) NAFKeys(btid string, nafID []byte) (impi string, ksNAF, ksIntNAF []byte, err error) {
+	b.mu.Lock()
+	s := b.sessions[btid]
+	b.mu.Unlock()
+	if s == nil {
+		err = fmt.Errorf("BSF: unknown B-TID %s", btid)
+		return
+	}
+	if time.Now().After(s.lifetime) {
+		err = errors.New("BSF: B-TID expired")
+		return
+	}
+	ksNAF = DeriveKsNAF(s.ks, GBA_ME, s.rand, s.impi, nafID)
+	if s.gbaU {
+		ksIntNAF = DeriveKsNAF(s.ks, GBA_U, s.rand, s.impi, nafID)
+	}
+	return s.impi, ksNAF, ksIntNAF, nil
+}
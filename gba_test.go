@@ -0,0 +1,42 @@
+package usim_go
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGBABootstrap(t *testing.T) {
+	u, store := testSubscriber(t)
+	bsf := NewBSF(store, BSFAddress("208", "93"))
+	srv := httptest.NewServer(bsf)
+	defer srv.Close()
+
+	for _, gbaU := range []bool{false, true} {
+		c := NewGBAClient(u, srv.URL)
+		if gbaU {
+			c.UICC = u
+		}
+		if err := c.Bootstrap(); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasSuffix(c.BTID, "@bsf.mnc093.mcc208.pub.3gppnetwork.org") || c.Lifetime.IsZero() {
+			t.Errorf("unexpected bootstrapping info %s %s", c.BTID, c.Lifetime)
+		}
+		identity, key, err := c.TLSPSK("naf.example.org", 0x00ae)
+		if err != nil {
+			t.Fatal(err)
+		}
+		impi, ksNAF, ksIntNAF, err := bsf.NAFKeys(identity, NAFID("naf.example.org", GBATLSProtocolID(0x00ae)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if impi != "208930000000001@ims.mnc093.mcc208.3gppnetwork.org" || !bytes.Equal(key, ksNAF) {
+			t.Errorf("GBA_U=%v: NAF key mismatch %x != %x", gbaU, key, ksNAF)
+		}
+		if gbaU == (ksIntNAF == nil) {
+			t.Errorf("GBA_U=%v: unexpected Ks_int_NAF %x", gbaU, ksIntNAF)
+		}
+	}
+}
@@ -1,6 +1,6 @@
 module github.com/WingPig99/usim_go
 
-go 1.18
+go 1.20
 
 require (
 	github.com/free5gc/milenage v1.0.0
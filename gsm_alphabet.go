@@ -0,0 +1,225 @@
+package usim_go
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"unicode/utf16"
+)
+
+// GSM 7 bit default alphabet of TS 23.038 6.2.1 and the alpha identifier
+// coding of TS 102 221 annex A
+
+const gsm7Escape = 0x1B
+
+var gsm7Basic = []rune("@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞ\x1bÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?" +
+	"¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà")
+
+// gsm7Ext is the extension table reached by the escape septet
+var gsm7Ext = map[byte]rune{
+	0x0A: '\f', 0x14: '^', 0x28: '{', 0x29: '}', 0x2F: '\\',
+	0x3C: '[', 0x3D: '~', 0x3E: ']', 0x40: '|', 0x65: '€',
+}
+
+var (
+	gsm7BasicRev = map[rune]byte{}
+	gsm7ExtRev   = map[rune]byte{}
+)
+
+func init() {
+	for i, r := range gsm7Basic {
+		if i != gsm7Escape {
+			gsm7BasicRev[r] = byte(i)
+		}
+	}
+	for s, r := range gsm7Ext {
+		gsm7ExtRev[r] = s
+	}
+}
+
+// decodeGSM7 decodes unpacked septets, one per byte
+func decodeGSM7(septets []byte) string {
+	out := make([]rune, 0, len(septets))
+	for i := 0; i < len(septets); i++ {
+		s := septets[i] & 0x7F
+		if s == gsm7Escape && i+1 < len(septets) {
+			i++
+			if r, ok := gsm7Ext[septets[i]&0x7F]; ok {
+				out = append(out, r)
+			} else {
+				// unknown extension characters are shown from the basic table
+				out = append(out, gsm7Basic[septets[i]&0x7F])
+			}
+			continue
+		}
+		out = append(out, gsm7Basic[s])
+	}
+	return string(out)
+}
+
+// encodeGSM7 encodes s as unpacked septets, characters of the extension
+// table take two septets
+func encodeGSM7(s string) ([]byte, error) {
+	out := make([]byte, 0, len(s))
+	for _, r := range s {
+		if b, ok := gsm7BasicRev[r]; ok {
+			out = append(out, b)
+		} else if b, ok := gsm7ExtRev[r]; ok {
+			out = append(out, gsm7Escape, b)
+		} else {
+			return nil, fmt.Errorf("GSM 7 bit: %q not in the default alphabet", r)
+		}
+	}
+	return out, nil
+}
+
+// packGSM7 packs septets into octets, see TS 23.038 6.1.2.1
+func packGSM7(septets []byte) []byte {
+	out := make([]byte, (len(septets)*7+7)/8)
+	for i, s := range septets {
+		bit := i * 7
+		v := uint16(s&0x7F) << (bit % 8)
+		out[bit/8] |= byte(v)
+		if v>>8 != 0 {
+			out[bit/8+1] |= byte(v >> 8)
+		}
+	}
+	return out
+}
+
+// unpackGSM7 returns the first n septets packed in b
+func unpackGSM7(b []byte, n int) []byte {
+	if avail := len(b) * 8 / 7; n > avail {
+		n = avail
+	}
+	out := make([]byte, n)
+	for i := range out {
+		bit := i * 7
+		v := uint16(b[bit/8])
+		if bit/8+1 < len(b) {
+			v |= uint16(b[bit/8+1]) << 8
+		}
+		out[i] = byte(v>>(bit%8)) & 0x7F
+	}
+	return out
+}
+
+func encodeUCS2(s string) []byte {
+	var out []byte
+	for _, c := range utf16.Encode([]rune(s)) {
+		out = append(out, byte(c>>8), byte(c))
+	}
+	return out
+}
+
+func decodeUCS2(b []byte) string {
+	u := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		c := uint16(b[i])<<8 | uint16(b[i+1])
+		if c == 0xFFFF {
+			break
+		}
+		u = append(u, c)
+	}
+	return string(utf16.Decode(u))
+}
+
+// decodeAlphaID decodes an alpha identifier in the GSM default alphabet or one
+// of the UCS2 codings '80', '81' and '82', unused 'FF' octets are dropped
+func decodeAlphaID(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	switch b[0] {
+	case 0x80:
+		return decodeUCS2(b[1:])
+	case 0x81, 0x82:
+		hdr, base := 3, rune(0)
+		if b[0] == 0x81 && len(b) >= 3 {
+			base = rune(b[2]) << 7
+		} else if b[0] == 0x82 && len(b) >= 4 {
+			hdr, base = 4, rune(b[2])<<8|rune(b[3])
+		} else {
+			return ""
+		}
+		n := int(b[1])
+		if hdr+n > len(b) {
+			n = len(b) - hdr
+		}
+		var out []rune
+		for _, c := range b[hdr : hdr+n] {
+			if c&0x80 != 0 {
+				out = append(out, base+rune(c&0x7F))
+			} else {
+				out = append(out, []rune(decodeGSM7([]byte{c}))...)
+			}
+		}
+		return string(out)
+	}
+	return decodeGSM7(bytes.TrimRight(b, "\xff"))
+}
+
+// encodeAlphaID encodes an alpha identifier in the GSM default alphabet, or in
+// UCS2 coding '80' if s has other characters. The result is cut and padded
+// with 'FF' to n octets, n < 0 means no padding.
+func encodeAlphaID(s string, n int) []byte {
+	b, err := encodeGSM7(s)
+	if err != nil {
+		b = append([]byte{0x80}, encodeUCS2(s)...)
+	}
+	if n < 0 {
+		return b
+	}
+	if len(b) > n {
+		keep := n
+		if b[0] == 0x80 {
+			// whole UCS2 characters only, less than 3 octets hold none
+			if keep = 1 + (n-1)/2*2; keep < 3 {
+				keep = 0
+			}
+		}
+		b = b[:keep]
+	}
+	return append(b, bytes.Repeat([]byte{0xFF}, n-len(b))...)
+}
+
+// Network name coding schemes of TS 24.008 10.5.3.5a
+const (
+	NETWORK_NAME_GSM7 = 0x00
+	NETWORK_NAME_UCS2 = 0x01
+)
+
+// decodeNetworkName decodes the value of a network name IE, a coding octet
+// followed by the GSM 7 bit packed or UCS2 text
+func decodeNetworkName(v []byte) (string, error) {
+	if len(v) < 1 {
+		return "", errors.New("network name: empty")
+	}
+	text := v[1:]
+	switch v[0] >> 4 & 0x07 {
+	case NETWORK_NAME_GSM7:
+		spare := int(v[0] & 0x07)
+		if spare != 0 && len(text) == 0 {
+			return "", errors.New("network name: spare bits without text")
+		}
+		n := (len(text)*8 - spare) / 7
+		if n < 0 {
+			n = 0
+		}
+		return decodeGSM7(unpackGSM7(text, n)), nil
+	case NETWORK_NAME_UCS2:
+		return decodeUCS2(text), nil
+	}
+	return "", fmt.Errorf("network name: unknown coding scheme %d", v[0]>>4&0x07)
+}
+
+// encodeNetworkName encodes a network name IE value, GSM 7 bit packed if
+// possible and UCS2 otherwise
+func encodeNetworkName(s string) []byte {
+	septets, err := encodeGSM7(s)
+	if err != nil {
+		return append([]byte{0x80 | NETWORK_NAME_UCS2<<4}, encodeUCS2(s)...)
+	}
+	spare := (8 - len(septets)*7%8) % 8
+	return append([]byte{0x80 | NETWORK_NAME_GSM7<<4 | byte(spare)}, packGSM7(septets)...)
+}
@@ -0,0 +1,71 @@
+package usim_go
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestGSM7(t *testing.T) {
+	if len(gsm7Basic) != 128 {
+		t.Fatalf("default alphabet has %d characters", len(gsm7Basic))
+	}
+	septets, err := encodeGSM7("hellohello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	packed := packGSM7(septets)
+	if !bytes.Equal(packed, unhex("e8329bfd4697d9ec37")) {
+		t.Errorf("packed %x", packed)
+	}
+	if s := decodeGSM7(unpackGSM7(packed, 10)); s != "hellohello" {
+		t.Errorf("unpacked %q", s)
+	}
+	septets, _ = encodeGSM7("[1€]")
+	if !bytes.Equal(septets, []byte{0x1B, 0x3C, 0x31, 0x1B, 0x65, 0x1B, 0x3E}) || decodeGSM7(septets) != "[1€]" {
+		t.Errorf("extension table %x", septets)
+	}
+	if _, err = encodeGSM7("Москва"); err == nil {
+		t.Error("cyrillic encoded in the default alphabet")
+	}
+}
+
+func TestAlphaID(t *testing.T) {
+	cases := []struct {
+		raw  string
+		text string
+	}{
+		{"4f72616e6765ffff", "Orange"},
+		{"80041f043e043b043804460438044fffff", "Полиция"},
+		// '81' coding with base 0x0400 and '82' coding with base 0x0410
+		{"8103089fbb92ff", "ПлВ"},
+		{"820304108fac82", "ПмВ"},
+	}
+	for _, c := range cases {
+		if got := decodeAlphaID(unhex(c.raw)); got != c.text {
+			t.Errorf("%s: decoded %q, want %q", c.raw, got, c.text)
+		}
+	}
+	if b := encodeAlphaID("Orange", 8); !bytes.Equal(b, unhex("4f72616e6765ffff")) {
+		t.Errorf("encoded %x", b)
+	}
+	if b := encodeAlphaID("Полиция", 8); !bytes.Equal(b, unhex("80041f043e043bff")) {
+		t.Errorf("encoded %x", b)
+	}
+	// fields too short for the text, a UCS2 field needs 3 octets
+	for _, c := range []struct {
+		text string
+		n    int
+		raw  string
+	}{
+		{"Orange", 0, ""},
+		{"Полиция", 0, ""},
+		{"Полиция", 1, "ff"},
+		{"Полиция", 2, "ffff"},
+		{"Полиция", 3, "80041f"},
+		{"Полиция", 4, "80041fff"},
+	} {
+		if b := encodeAlphaID(c.text, c.n); !bytes.Equal(b, unhex(c.raw)) {
+			t.Errorf("%q in %d octets encoded %x", c.text, c.n, b)
+		}
+	}
+}
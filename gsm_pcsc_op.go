@@ -27,9 +27,10 @@ func GSMAlg(ctx *smartcard.Card, simType int, rand []byte) (sres, kc []byte, err
 		return
 	}
 	logrus.Debug("Got response:\n", hex.Dump(resp))
-	if resp[0] != 0x9F {
+	if len(resp) != 2 || resp[0] != 0x9F {
 		errStr := "GSMAlg: run alg failed"
 		logrus.Error(errStr)
+		err = errors.New(errStr)
 		return
 	}
 	getResp = append(getResp, resp[1])
@@ -41,6 +42,10 @@ func GSMAlg(ctx *smartcard.Card, simType int, rand []byte) (sres, kc []byte, err
 		return
 	}
 	logrus.Debug("Got response: \n", hex.Dump(resp))
+	if len(resp) < 12 {
+		err = errors.New("GSMAlg: unexpected response length")
+		return
+	}
 	sres = resp[0:4]
 	kc = resp[4:12]
 	return
@@ -0,0 +1,189 @@
+package usim_go
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Identity formats of TS 23.003
+
+const (
+	// leading digits of the IMSI based NAI, see TS 23.003 14.2 and RFC 4186/4187/5448
+	NAI_PREFIX_EAP_AKA              = '0'
+	NAI_PREFIX_EAP_SIM              = '1'
+	NAI_PREFIX_EAP_AKA_PSEUDONYM    = '2'
+	NAI_PREFIX_EAP_SIM_PSEUDONYM    = '3'
+	NAI_PREFIX_EAP_AKA_REAUTH       = '4'
+	NAI_PREFIX_EAP_SIM_REAUTH       = '5'
+	NAI_PREFIX_EAP_AKA_PRIME        = '6'
+	NAI_PREFIX_EAP_AKA_PRIME_PSEUDO = '7'
+	NAI_PREFIX_EAP_AKA_PRIME_REAUTH = '8'
+)
+
+// isDigits reports whether s is non-empty and only holds decimal digits
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// EncodePLMN returns the 3 octet PLMN identity of TS 24.008 10.5.1.3
+func EncodePLMN(mcc, mnc string) (plmn [3]byte, err error) {
+	if !isDigits(mcc) || !isDigits(mnc) {
+		err = fmt.Errorf("PLMN: invalid MCC/MNC %s/%s", mcc, mnc)
+		return
+	}
+	bcdMCC, bcdMNC, err := convert_mcc_mnc(mcc, mnc)
+	if err != nil {
+		return
+	}
+	return encode_plmn(bcdMCC, bcdMNC), nil
+}
+
+// DecodePLMN decodes a 3 octet PLMN identity into MCC and the 2 or 3 digit MNC
+func DecodePLMN(plmn []byte) (mcc, mnc string, err error) {
+	return decodePLMN(plmn)
+}
+
+// mnc3 pads a 2 digit MNC with a leading zero as used in domain names
+func mnc3(mnc string) string {
+	if len(mnc) == 2 {
+		return "0" + mnc
+	}
+	return mnc
+}
+
+// HomeNetworkDomain returns "mncXXX.mccYYY.3gppnetwork.org", see TS 23.003 13.2
+func HomeNetworkDomain(mcc, mnc string) string {
+	return fmt.Sprintf("mnc%s.mcc%s.3gppnetwork.org", mnc3(mnc), mcc)
+}
+
+// pubDomain returns the domain for externally resolvable names, see TS 23.003 19.4.2
+func pubDomain(mcc, mnc string) string {
+	return fmt.Sprintf("mnc%s.mcc%s.pub.3gppnetwork.org", mnc3(mnc), mcc)
+}
+
+// WLANRealm returns the realm of WLAN access NAIs, see TS 23.003 14.2
+func WLANRealm(mcc, mnc string) string {
+	return "wlan." + HomeNetworkDomain(mcc, mnc)
+}
+
+// EPCRealm returns the realm of EPC non-3GPP access NAIs, see TS 23.003 19.3.2
+func EPCRealm(mcc, mnc string) string {
+	return "nai.epc." + HomeNetworkDomain(mcc, mnc)
+}
+
+// IMSIBasedNAI returns the permanent identity prefix || IMSI @ realm with one
+// of the NAI_PREFIX_* values. The WLAN realm is used if realm is empty.
+func IMSIBasedNAI(prefix byte, imsi, mcc, mnc, realm string) string {
+	if realm == "" {
+		realm = WLANRealm(mcc, mnc)
+	}
+	return string(prefix) + imsi + "@" + realm
+}
+
+// PseudonymNAI decorates a pseudonym received from the server with the realm
+// of the permanent identity, see TS 23.003 14.3. Pseudonyms that already carry
+// a realm are returned unchanged.
+func PseudonymNAI(pseudonym, permanent string) string {
+	if strings.IndexByte(pseudonym, '@') >= 0 {
+		return pseudonym
+	}
+	if i := strings.IndexByte(permanent, '@'); i >= 0 {
+		return pseudonym + permanent[i:]
+	}
+	return pseudonym
+}
+
+// ReauthNAI returns the fast re-authentication identity, see TS 23.003 14.4.
+// The re-authentication identity normally carries the realm of the server.
+func ReauthNAI(reauthID, permanent string) string {
+	return PseudonymNAI(reauthID, permanent)
+}
+
+// IMSPrivateIdentity returns the IMPI derived from the IMSI, see TS 23.003 13.3
+func IMSPrivateIdentity(imsi, mcc, mnc string) string {
+	return imsi + "@" + IMSDomain(mcc, mnc)
+}
+
+// IMSPublicIdentity returns the temporary IMPU derived from the IMSI, see TS 23.003 13.4B
+func IMSPublicIdentity(imsi, mcc, mnc string) string {
+	return "sip:" + IMSPrivateIdentity(imsi, mcc, mnc)
+}
+
+// IMSDomain returns the IMS home network domain, see TS 23.003 13.2
+func IMSDomain(mcc, mnc string) string {
+	return "ims." + HomeNetworkDomain(mcc, mnc)
+}
+
+// IMSTelURI returns the tel URI public identity for an MSISDN in international format
+func IMSTelURI(msisdn string) string {
+	return "tel:+" + strings.TrimPrefix(msisdn, "+")
+}
+
+// SUPIFromIMSI returns the IMSI type SUPI "imsi-<IMSI>", see TS 23.003 2.2A
+func SUPIFromIMSI(imsi string) string {
+	return "imsi-" + imsi
+}
+
+// SUPINAI returns the NAI type SUPI "nai-username@realm" with the 5GC realm
+// of the home network if realm is empty, see TS 23.003 28.7.2
+func SUPINAI(username, mcc, mnc, realm string) string {
+	if realm == "" {
+		realm = "nai.5gc." + HomeNetworkDomain(mcc, mnc)
+	}
+	return "nai-" + username + "@" + realm
+}
+
+// EPDGFQDN returns the operator identifier based ePDG FQDN, see TS 23.003 19.4.2.9
+func EPDGFQDN(mcc, mnc string) string {
+	return "epdg.epc." + pubDomain(mcc, mnc)
+}
+
+// N3IWFFQDN returns the operator identifier based N3IWF FQDN, see TS 23.003 28.3.2.2.2
+func N3IWFFQDN(mcc, mnc string) string {
+	return "n3iwf.5gc." + pubDomain(mcc, mnc)
+}
+
+// LuhnCheckDigit returns the Luhn check digit of a digit string
+func LuhnCheckDigit(digits string) (byte, error) {
+	if !isDigits(digits) {
+		return 0, fmt.Errorf("Luhn: invalid digits %q", digits)
+	}
+	sum := 0
+	for i := 0; i < len(digits); i++ {
+		d := int(digits[len(digits)-1-i] - '0')
+		if i%2 == 0 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return byte('0' + (10-sum%10)%10), nil
+}
+
+// luhnValid reports whether the last digit of s is the Luhn check digit of the others
+func luhnValid(s string) bool {
+	if len(s) < 2 {
+		return false
+	}
+	cd, err := LuhnCheckDigit(s[:len(s)-1])
+	return err == nil && cd == s[len(s)-1]
+}
+
+// ValidIMEI checks a 15 digit IMEI including its check digit, see TS 23.003 6.2.1
+func ValidIMEI(imei string) bool {
+	return len(imei) == 15 && luhnValid(imei)
+}
+
+// ValidICCID checks an ICCID of 19 or 20 digits including its check digit, see ITU-T E.118
+func ValidICCID(iccid string) bool {
+	return (len(iccid) == 19 || len(iccid) == 20) && strings.HasPrefix(iccid, "89") && luhnValid(iccid)
+}
@@ -0,0 +1,68 @@
+package usim_go
+
+import "testing"
+
+func TestPLMNAndDomains(t *testing.T) {
+	for _, v := range []struct {
+		mcc, mnc string
+		plmn     [3]byte
+	}{
+		{"208", "93", [3]byte{0x02, 0xF8, 0x39}},
+		{"310", "410", [3]byte{0x13, 0x00, 0x14}},
+	} {
+		plmn, err := EncodePLMN(v.mcc, v.mnc)
+		if err != nil || plmn != v.plmn {
+			t.Errorf("EncodePLMN(%s, %s) = %x %v", v.mcc, v.mnc, plmn, err)
+		}
+		if mcc, mnc, err := DecodePLMN(plmn[:]); err != nil || mcc != v.mcc || mnc != v.mnc {
+			t.Errorf("DecodePLMN(%x) = %s %s %v", plmn, mcc, mnc, err)
+		}
+	}
+	if _, err := EncodePLMN("2a8", "93"); err == nil {
+		t.Error("invalid MCC accepted")
+	}
+
+	for got, want := range map[string]string{
+		HomeNetworkDomain("208", "93"):                                                                "mnc093.mcc208.3gppnetwork.org",
+		IMSIBasedNAI(NAI_PREFIX_EAP_AKA, "208930000000001", "208", "93", ""):                          "0208930000000001@wlan.mnc093.mcc208.3gppnetwork.org",
+		IMSIBasedNAI(NAI_PREFIX_EAP_AKA_PRIME, "208930000000001", "208", "93", EPCRealm("208", "93")): "6208930000000001@nai.epc.mnc093.mcc208.3gppnetwork.org",
+		PseudonymNAI("2abcdef", "0208930000000001@wlan.mnc093.mcc208.3gppnetwork.org"):                "2abcdef@wlan.mnc093.mcc208.3gppnetwork.org",
+		ReauthNAI("4xyz@aaa.example.org", "0208930000000001@wlan.mnc093.mcc208.3gppnetwork.org"):      "4xyz@aaa.example.org",
+		IMSPublicIdentity("208930000000001", "208", "93"):                                             "sip:208930000000001@ims.mnc093.mcc208.3gppnetwork.org",
+		SUPIFromIMSI("208930000000001"):                                                               "imsi-208930000000001",
+		SUPINAI("user", "208", "93", ""):                                                              "nai-user@nai.5gc.mnc093.mcc208.3gppnetwork.org",
+		EPDGFQDN("208", "93"):                                                                         "epdg.epc.mnc093.mcc208.pub.3gppnetwork.org",
+		N3IWFFQDN("310", "410"):                                                                       "n3iwf.5gc.mnc410.mcc310.pub.3gppnetwork.org",
+		BSFAddress("208", "93"):                                                                       "bsf.mnc093.mcc208.pub.3gppnetwork.org",
+	} {
+		if got != want {
+			t.Errorf("got %s, want %s", got, want)
+		}
+	}
+}
+
+func TestLuhn(t *testing.T) {
+	if cd, err := LuhnCheckDigit("49015420323751"); err != nil || cd != '8' {
+		t.Errorf("unexpected check digit %c %v", cd, err)
+	}
+	for _, v := range []struct {
+		id   string
+		imei bool
+		ok   bool
+	}{
+		{"490154203237518", true, true},
+		{"490154203237517", true, false},
+		{"35693803564380", true, false},
+		{"8944500102198304826", false, true},
+		{"8944500102198304825", false, false},
+		{"1944500102198304826", false, false},
+	} {
+		ok := ValidICCID(v.id)
+		if v.imei {
+			ok = ValidIMEI(v.id)
+		}
+		if ok != v.ok {
+			t.Errorf("validation of %s = %v", v.id, ok)
+		}
+	}
+}
@@ -0,0 +1,471 @@
+package usim_go
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// IMS registration of TS 24.229 5.1.1 with the security agreement of
+// TS 33.203 (RFC 3329 sec-agree, mechanism ipsec-3gpp) over UDP.
+
+const (
+	IMS_ALG_HMAC_MD5_96  = "hmac-md5-96"
+	IMS_ALG_HMAC_SHA1_96 = "hmac-sha-1-96"
+	IMS_EALG_NULL        = "null"
+	IMS_EALG_AES_CBC     = "aes-cbc"
+	IMS_EALG_DES_EDE3    = "des-ede3-cbc"
+
+	imsSecMechanism = "ipsec-3gpp"
+	imsDefExpires   = 600000
+	imsDefTimeout   = 5 * time.Second
+	imsMaxMsgLen    = 65535
+)
+
+// IMSIdentities derives the private and public user identities and the home
+// network domain from the IMSI, see TS 23.003 13.3 - 13.5
+func IMSIdentities(imsi, mcc, mnc string) (impi, impu, domain string) {
+	return IMSPrivateIdentity(imsi, mcc, mnc), IMSPublicIdentity(imsi, mcc, mnc), IMSDomain(mcc, mnc)
+}
+
+// DeriveIPsecKeys derives the ESP keys from IK and CK for the negotiated
+// algorithms, see TS 33.203 Annex I
+func DeriveIPsecKeys(alg, ealg string, ik, ck []byte) (ikESP, ckESP []byte, err error) {
+	if len(ik) != 16 || len(ck) != 16 {
+		err = errors.New("IMS: IK and CK must be 128 bits")
+		return
+	}
+	switch alg {
+	case IMS_ALG_HMAC_MD5_96:
+		ikESP = append([]byte{}, ik...)
+	case IMS_ALG_HMAC_SHA1_96:
+		ikESP = append(append([]byte{}, ik...), 0, 0, 0, 0)
+	default:
+		err = fmt.Errorf("IMS: unsupported integrity algorithm %q", alg)
+		return
+	}
+	switch ealg {
+	case IMS_EALG_NULL, "":
+	case IMS_EALG_AES_CBC:
+		ckESP = append([]byte{}, ck...)
+	case IMS_EALG_DES_EDE3:
+		ckESP = append(append([]byte{}, ck...), ck[:8]...)
+	default:
+		err = fmt.Errorf("IMS: unsupported encryption algorithm %q", ealg)
+	}
+	return
+}
+
+// IMSSecurityAssociation holds the parameters of the SA pair between UE and P-CSCF
+type IMSSecurityAssociation struct {
+	Alg   string
+	EAlg  string
+	IKESP []byte
+	CKESP []byte
+
+	UEAddr    net.IP
+	PCSCFAddr net.IP
+	SPIUC     uint32
+	SPIUS     uint32
+	SPIPC     uint32
+	SPIPS     uint32
+	PortUC    uint16
+	PortUS    uint16
+	PortPC    uint16
+	PortPS    uint16
+}
+
+// XfrmAuth returns the ip xfrm name of the integrity algorithm
+func (sa *IMSSecurityAssociation) XfrmAuth() string {
+	if sa.Alg == IMS_ALG_HMAC_MD5_96 {
+		return "hmac(md5)"
+	}
+	return "hmac(sha1)"
+}
+
+// XfrmEnc returns the ip xfrm name of the encryption algorithm
+func (sa *IMSSecurityAssociation) XfrmEnc() string {
+	switch sa.EAlg {
+	case IMS_EALG_AES_CBC:
+		return "cbc(aes)"
+	case IMS_EALG_DES_EDE3:
+		return "cbc(des3_ede)"
+	}
+	return "ecb(cipher_null)"
+}
+
+// IMSRegistration is the result of a successful REGISTER
+type IMSRegistration struct {
+	SA             *IMSSecurityAssociation
+	Expires        int
+	AssociatedURIs []string
+	ServiceRoute   []string
+}
+
+// IMSClient registers a subscriber at a P-CSCF
+type IMSClient struct {
+	Auth   AKAAuthenticator
+	IMPI   string
+	IMPU   string
+	Domain string
+	PCSCF  *net.UDPAddr
+	// LocalIP is the UE address, the unspecified address if nil
+	LocalIP net.IP
+	// Algs and EAlgs are offered in Security-Client in order
+	Algs  []string
+	EAlgs []string
+	// SetupSA is called before the protected REGISTER is sent, e.g. to create the SAs with ip xfrm
+	SetupSA func(sa *IMSSecurityAssociation) error
+	Expires int
+	Timeout time.Duration
+
+	callID string
+	tag    string
+	cseq   int
+}
+
+// NewIMSClient creates a client using the identities of the ISIM of u, they
+// are derived from the IMSI if there is no ISIM, see TS 24.229 5.1.1.1A and
+// 5.1.1.1B
+func NewIMSClient(u *USIM, pcscf string) (c *IMSClient, err error) {
+	c = &IMSClient{
+		Auth:  u,
+		Algs:  []string{IMS_ALG_HMAC_SHA1_96, IMS_ALG_HMAC_MD5_96},
+		EAlgs: []string{IMS_EALG_AES_CBC, IMS_EALG_NULL},
+	}
+	if c.PCSCF, err = net.ResolveUDPAddr("udp", pcscf); err != nil {
+		return nil, err
+	}
+	if c.IMPI, c.IMPU, c.Domain, err = u.ISIMIdentities(); errors.Is(err, FILE_NOT_FOUND) {
+		logrus.Info("IMS: no ISIM, deriving the identities from the IMSI")
+		c.IMPI, c.IMPU, c.Domain = IMSIdentities(u.IMSI(), u.mccStr, u.mncStr)
+	} else if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Register runs the initial registration: an unprotected REGISTER, the
+// Digest AKA challenge, and the REGISTER protected by the negotiated SAs.
+func (c *IMSClient) Register() (reg IMSRegistration, err error) {
+	if c.Expires == 0 {
+		c.Expires = imsDefExpires
+	}
+	if c.Timeout == 0 {
+		c.Timeout = imsDefTimeout
+	}
+	c.callID = hex.EncodeToString(randomBytes(12))
+	c.tag = hex.EncodeToString(randomBytes(4))
+	c.cseq = 0
+
+	local := &net.UDPAddr{IP: c.LocalIP}
+	var unprot, protC, protS *net.UDPConn
+	for _, conn := range []**net.UDPConn{&unprot, &protC, &protS} {
+		if *conn, err = net.ListenUDP("udp", local); err != nil {
+			return
+		}
+		defer (*conn).Close()
+	}
+	sa := &IMSSecurityAssociation{
+		UEAddr:    c.LocalIP,
+		PCSCFAddr: c.PCSCF.IP,
+		SPIUC:     imsSPI(),
+		SPIUS:     imsSPI(),
+		PortUC:    uint16(protC.LocalAddr().(*net.UDPAddr).Port),
+		PortUS:    uint16(protS.LocalAddr().(*net.UDPAddr).Port),
+	}
+	secClient := c.securityClient(sa)
+
+	auth := fmt.Sprintf("Digest username=%q, realm=%q, uri=%q, nonce=\"\", response=\"\"", c.IMPI, c.Domain, "sip:"+c.Domain)
+	authName := "Authorization"
+	digest := NewDigestAKAClient(c.Auth, c.IMPI)
+	var rsp *sipMessage
+	var r DigestAKAResult
+	for attempt := 0; ; attempt++ {
+		req := c.register(unprot, sa.PortUS, authName, auth, "Security-Client", secClient)
+		if rsp, err = sipTransaction(unprot, unprot, c.PCSCF, req, c.Timeout); err != nil {
+			return
+		}
+		if rsp.status != 401 && rsp.status != 407 {
+			err = fmt.Errorf("IMS: unexpected response to REGISTER: %d %s", rsp.status, rsp.reason)
+			return
+		}
+		// 401 carries WWW-Authenticate, 407 Proxy-Authenticate, RFC 3261 22.3
+		challenge := rsp.get("WWW-Authenticate")
+		if rsp.status == 407 {
+			challenge = rsp.get("Proxy-Authenticate")
+		}
+		var ch DigestChallenge
+		if ch, err = ParseDigestChallenge(challenge); err != nil {
+			return
+		}
+		ch.Proxy = rsp.status == 407
+		if r, err = digest.Authorize(ch, "REGISTER", "sip:"+c.Domain, nil); err != nil {
+			return
+		}
+		auth, authName = r.Header, ch.AuthorizationHeaderName()
+		if r.MACFailure {
+			// tell the network and give up
+			unprot.WriteToUDP(c.register(unprot, sa.PortUS, authName, auth, "Security-Client", secClient), c.PCSCF)
+			err = errors.New("IMS: network authentication failed")
+			return
+		}
+		if r.AUTS == nil {
+			break
+		}
+		if attempt > 0 {
+			err = errors.New("IMS: repeated synchronisation failure")
+			return
+		}
+		logrus.Info("IMS: synchronisation failure, sending AUTS")
+	}
+
+	secServer := rsp.getAll("Security-Server")
+	if err = c.selectMechanism(sa, secServer); err != nil {
+		return
+	}
+	if sa.IKESP, sa.CKESP, err = DeriveIPsecKeys(sa.Alg, sa.EAlg, r.IK, r.CK); err != nil {
+		return
+	}
+	reg.SA = sa
+	if c.SetupSA != nil {
+		if err = c.SetupSA(sa); err != nil {
+			return
+		}
+	}
+
+	pcscf := &net.UDPAddr{IP: c.PCSCF.IP, Port: int(sa.PortPS), Zone: c.PCSCF.Zone}
+	req := c.register(protS, sa.PortUS, authName, auth, "Security-Client", secClient, "Security-Verify", strings.Join(secServer, ", "))
+	if rsp, err = sipTransaction(protC, protS, pcscf, req, c.Timeout); err != nil {
+		return
+	}
+	if rsp.status != 200 {
+		err = fmt.Errorf("IMS: protected REGISTER failed: %d %s", rsp.status, rsp.reason)
+		return
+	}
+	reg.Expires = c.Expires
+	if e, err_ := strconv.Atoi(rsp.get("Expires")); err_ == nil {
+		reg.Expires = e
+	}
+	reg.AssociatedURIs = sipURIList(rsp.getAll("P-Associated-URI"))
+	reg.ServiceRoute = sipURIList(rsp.getAll("Service-Route"))
+	logrus.Infof("IMS: registered %s for %ds", c.IMPU, reg.Expires)
+	return
+}
+
+func (c *IMSClient) securityClient(sa *IMSSecurityAssociation) string {
+	var mechs []string
+	for _, alg := range c.Algs {
+		for _, ealg := range c.EAlgs {
+			mechs = append(mechs, fmt.Sprintf("%s;alg=%s;ealg=%s;spi-c=%d;spi-s=%d;port-c=%d;port-s=%d",
+				imsSecMechanism, alg, ealg, sa.SPIUC, sa.SPIUS, sa.PortUC, sa.PortUS))
+		}
+	}
+	return strings.Join(mechs, ", ")
+}
+
+// selectMechanism picks the Security-Server entry with the highest preference
+// among the offered algorithms, see RFC 3329 2.3
+func (c *IMSClient) selectMechanism(sa *IMSSecurityAssociation, secServer []string) error {
+	best := -1.0
+	for _, v := range secServer {
+		for _, mech := range strings.Split(v, ",") {
+			params := strings.Split(strings.TrimSpace(mech), ";")
+			if strings.TrimSpace(params[0]) != imsSecMechanism {
+				continue
+			}
+			// an omitted ealg means DES-EDE3-CBC, TS 33.203 7.2
+			m := map[string]string{"ealg": IMS_EALG_DES_EDE3, "q": "0.001"}
+			for _, p := range params[1:] {
+				if kv := strings.SplitN(strings.TrimSpace(p), "=", 2); len(kv) == 2 {
+					m[strings.ToLower(kv[0])] = kv[1]
+				}
+			}
+			q, _ := strconv.ParseFloat(m["q"], 64)
+			if q <= best || !containsString(c.Algs, m["alg"]) || !containsString(c.EAlgs, m["ealg"]) {
+				continue
+			}
+			spiC, err1 := strconv.ParseUint(m["spi-c"], 10, 32)
+			spiS, err2 := strconv.ParseUint(m["spi-s"], 10, 32)
+			portC, err3 := strconv.ParseUint(m["port-c"], 10, 16)
+			portS, err4 := strconv.ParseUint(m["port-s"], 10, 16)
+			if err1 != nil || err2 != nil || err3 != nil || err4 != nil {
+				logrus.Warn("IMS: ignoring malformed Security-Server ", mech)
+				continue
+			}
+			best = q
+			sa.Alg, sa.EAlg = m["alg"], m["ealg"]
+			sa.SPIPC, sa.SPIPS = uint32(spiC), uint32(spiS)
+			sa.PortPC, sa.PortPS = uint16(portC), uint16(portS)
+		}
+	}
+	if best < 0 {
+		return errors.New("IMS: no acceptable Security-Server mechanism")
+	}
+	return nil
+}
+
+// register builds a REGISTER sent from conn, extra holds header name/value pairs
+func (c *IMSClient) register(conn *net.UDPConn, portUS uint16, authName, auth string, extra ...string) []byte {
+	c.cseq++
+	local := conn.LocalAddr().(*net.UDPAddr)
+	host := sipHost(c.LocalIP)
+	var b strings.Builder
+	fmt.Fprintf(&b, "REGISTER sip:%s SIP/2.0\r\n", c.Domain)
+	fmt.Fprintf(&b, "Via: SIP/2.0/UDP %s:%d;branch=z9hG4bK%s;rport\r\n", host, local.Port, hex.EncodeToString(randomBytes(8)))
+	fmt.Fprintf(&b, "Max-Forwards: 70\r\n")
+	fmt.Fprintf(&b, "From: <%s>;tag=%s\r\n", c.IMPU, c.tag)
+	fmt.Fprintf(&b, "To: <%s>\r\n", c.IMPU)
+	fmt.Fprintf(&b, "Call-ID: %s\r\n", c.callID)
+	fmt.Fprintf(&b, "CSeq: %d REGISTER\r\n", c.cseq)
+	fmt.Fprintf(&b, "Contact: <sip:%s:%d>;expires=%d\r\n", host, portUS, c.Expires)
+	fmt.Fprintf(&b, "%s: %s\r\n", authName, auth)
+	for i := 0; i+1 < len(extra); i += 2 {
+		fmt.Fprintf(&b, "%s: %s\r\n", extra[i], extra[i+1])
+	}
+	fmt.Fprintf(&b, "Require: sec-agree\r\nProxy-Require: sec-agree\r\nSupported: path, sec-agree\r\n")
+	fmt.Fprintf(&b, "Expires: %d\r\nContent-Length: 0\r\n\r\n", c.Expires)
+	return []byte(b.String())
+}
+
+func imsSPI() uint32 {
+	// SPIs 0 - 255 are reserved, RFC 4303 2.1
+	for {
+		if spi := binary.BigEndian.Uint32(randomBytes(4)); spi > 255 {
+			return spi
+		}
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// sipMessage is a minimal SIP message, enough for the REGISTER flow
+type sipMessage struct {
+	startLine string
+	status    int
+	reason    string
+	headers   [][2]string
+	body      []byte
+}
+
+var sipCompactHeaders = map[string]string{
+	"v": "via", "f": "from", "t": "to", "i": "call-id", "m": "contact", "l": "content-length",
+}
+
+func parseSIPMessage(buf []byte) (m *sipMessage, err error) {
+	s := string(buf)
+	end := strings.Index(s, "\r\n\r\n")
+	if end < 0 {
+		return nil, errors.New("SIP: incomplete message")
+	}
+	lines := strings.Split(s[:end], "\r\n")
+	m = &sipMessage{startLine: lines[0], body: buf[end+4:]}
+	if strings.HasPrefix(m.startLine, "SIP/2.0 ") {
+		f := strings.SplitN(m.startLine, " ", 3)
+		if m.status, err = strconv.Atoi(f[1]); err != nil {
+			return nil, fmt.Errorf("SIP: malformed status line %q", m.startLine)
+		}
+		if len(f) == 3 {
+			m.reason = f[2]
+		}
+	}
+	for _, l := range lines[1:] {
+		if (strings.HasPrefix(l, " ") || strings.HasPrefix(l, "\t")) && len(m.headers) > 0 {
+			m.headers[len(m.headers)-1][1] += " " + strings.TrimSpace(l)
+			continue
+		}
+		i := strings.IndexByte(l, ':')
+		if i <= 0 {
+			return nil, fmt.Errorf("SIP: malformed header %q", l)
+		}
+		name := strings.ToLower(strings.TrimSpace(l[:i]))
+		if long, ok := sipCompactHeaders[name]; ok {
+			name = long
+		}
+		m.headers = append(m.headers, [2]string{name, strings.TrimSpace(l[i+1:])})
+	}
+	return
+}
+
+func (m *sipMessage) getAll(name string) (values []string) {
+	name = strings.ToLower(name)
+	for _, h := range m.headers {
+		if h[0] == name {
+			values = append(values, h[1])
+		}
+	}
+	return
+}
+
+func (m *sipMessage) get(name string) string {
+	if v := m.getAll(name); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// sipTransaction sends req and waits for the final response on rconn
+func sipTransaction(wconn, rconn *net.UDPConn, to *net.UDPAddr, req []byte, timeout time.Duration) (rsp *sipMessage, err error) {
+	logrus.Debugf("SIP: sending to %s\n%s", to, req)
+	if _, err = wconn.WriteToUDP(req, to); err != nil {
+		return
+	}
+	rconn.SetReadDeadline(time.Now().Add(timeout))
+	defer rconn.SetReadDeadline(time.Time{})
+	buf := make([]byte, imsMaxMsgLen)
+	for {
+		var n int
+		if n, _, err = rconn.ReadFromUDP(buf); err != nil {
+			return
+		}
+		if rsp, err = parseSIPMessage(buf[:n]); err != nil {
+			logrus.Warn(err)
+			continue
+		}
+		if rsp.status >= 200 {
+			return
+		}
+	}
+}
+
+func sipHost(ip net.IP) string {
+	if ip == nil || ip.IsUnspecified() {
+		return "127.0.0.1"
+	}
+	if ip.To4() == nil {
+		return "[" + ip.String() + "]"
+	}
+	return ip.String()
+}
+
+// sipURIList extracts the URIs of name-addr lists such as P-Associated-URI
+func sipURIList(values []string) (uris []string) {
+	for _, v := range values {
+		for _, e := range strings.Split(v, ",") {
+			e = strings.TrimSpace(e)
+			if i := strings.IndexByte(e, '<'); i >= 0 {
+				if j := strings.IndexByte(e[i:], '>'); j > 0 {
+					e = e[i+1 : i+j]
+				}
+			}
+			if e != "" {
+				uris = append(uris, e)
+			}
+		}
+	}
+	return
+}
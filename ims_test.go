@@ -0,0 +1,198 @@
+package usim_go
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestDeriveIPsecKeys(t *testing.T) {
+	ik := unhex("000102030405060708090a0b0c0d0e0f")
+	ck := unhex("f0f1f2f3f4f5f6f7f8f9fafbfcfdfeff")
+	ikESP, ckESP, err := DeriveIPsecKeys(IMS_ALG_HMAC_SHA1_96, IMS_EALG_DES_EDE3, ik, ck)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(ikESP, append(ik, 0, 0, 0, 0)) {
+		t.Errorf("unexpected IK_ESP %x", ikESP)
+	}
+	if !bytes.Equal(ckESP, unhex("f0f1f2f3f4f5f6f7f8f9fafbfcfdfefff0f1f2f3f4f5f6f7")) {
+		t.Errorf("unexpected CK_ESP %x", ckESP)
+	}
+}
+
+// pcscfStandIn answers the two REGISTERs of the initial registration
+func pcscfStandIn(store *SubscriberStore, unprot, prot *net.UDPConn, done chan<- error) {
+	var q Quintet
+	buf := make([]byte, imsMaxMsgLen)
+	reply := func(conn *net.UDPConn, to *net.UDPAddr, req *sipMessage, status string, extra ...string) {
+		var b strings.Builder
+		fmt.Fprintf(&b, "SIP/2.0 %s\r\n", status)
+		for _, h := range []string{"Via", "From", "To", "Call-ID", "CSeq"} {
+			fmt.Fprintf(&b, "%s: %s\r\n", h, req.get(h))
+		}
+		for _, h := range extra {
+			fmt.Fprintf(&b, "%s\r\n", h)
+		}
+		b.WriteString("Content-Length: 0\r\n\r\n")
+		conn.WriteToUDP([]byte(b.String()), to)
+	}
+
+	n, from, err := unprot.ReadFromUDP(buf)
+	if err != nil {
+		done <- err
+		return
+	}
+	req, err := parseSIPMessage(buf[:n])
+	if err != nil {
+		done <- err
+		return
+	}
+	if !strings.Contains(req.get("Security-Client"), "alg=hmac-sha-1-96;ealg=aes-cbc") {
+		done <- fmt.Errorf("unexpected Security-Client %s", req.get("Security-Client"))
+		return
+	}
+	if err = store.Lookup("208930000000001", func(a *AuC) (err error) {
+		q, err = a.GenQuintet()
+		return
+	}); err != nil {
+		done <- err
+		return
+	}
+	nonce := base64.StdEncoding.EncodeToString(append(q.RAND[:], q.AUTN[:]...))
+	psPort := prot.LocalAddr().(*net.UDPAddr).Port
+	secServer := fmt.Sprintf("ipsec-3gpp;q=0.1;alg=hmac-md5-96;ealg=null;spi-c=1111;spi-s=2222;port-c=%d;port-s=%d, "+
+		"ipsec-3gpp;q=0.5;alg=hmac-sha-1-96;ealg=aes-cbc;spi-c=3333;spi-s=4444;port-c=%d;port-s=%d", psPort+1, psPort, psPort+1, psPort)
+	reply(unprot, from, req, "401 Unauthorized",
+		`WWW-Authenticate: Digest realm="ims.mnc093.mcc208.3gppnetwork.org", nonce="`+nonce+`", algorithm=AKAv1-MD5, qop="auth"`,
+		// ignored on a 401
+		`Proxy-Authenticate: Digest realm="proxy.invalid", nonce="AAAA", algorithm=AKAv1-MD5`,
+		"Security-Server: "+secServer)
+
+	if n, from, err = prot.ReadFromUDP(buf); err != nil {
+		done <- err
+		return
+	}
+	if req, err = parseSIPMessage(buf[:n]); err != nil {
+		done <- err
+		return
+	}
+	if req.get("Security-Verify") != secServer {
+		done <- fmt.Errorf("unexpected Security-Verify %s", req.get("Security-Verify"))
+		return
+	}
+	auth, err := parseDigestParams(strings.TrimPrefix(req.get("Authorization"), "Digest "))
+	if err != nil {
+		done <- err
+		return
+	}
+	expect := digestResponse(auth["username"], auth["realm"], q.XRES, "REGISTER", auth["uri"], nonce, auth["nc"], auth["cnonce"], auth["qop"], nil)
+	if auth["response"] != expect {
+		done <- fmt.Errorf("unexpected response %s", auth["response"])
+		return
+	}
+	// the response goes to port_us from the Via
+	via := req.get("Via")
+	var port int
+	fmt.Sscanf(via[strings.LastIndexByte(via[:strings.IndexByte(via, ';')], ':')+1:], "%d", &port)
+	from.Port = port
+	reply(prot, from, req, "200 OK", "Expires: 3600",
+		"P-Associated-URI: <sip:208930000000001@ims.mnc093.mcc208.3gppnetwork.org>, <tel:+33612345678>",
+		"Service-Route: <sip:orig@scscf.ims.mnc093.mcc208.3gppnetwork.org;lr>")
+	done <- nil
+}
+
+func TestIMSRegister(t *testing.T) {
+	u, store := testSubscriber(t)
+	lo := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}
+	unprot, err := net.ListenUDP("udp", lo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unprot.Close()
+	prot, err := net.ListenUDP("udp", lo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer prot.Close()
+	done := make(chan error, 1)
+	go pcscfStandIn(store, unprot, prot, done)
+
+	c, err := NewIMSClient(u, unprot.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.LocalIP = lo.IP
+	if c.IMPI != "208930000000001@ims.mnc093.mcc208.3gppnetwork.org" {
+		t.Errorf("unexpected IMPI %s", c.IMPI)
+	}
+	var setup *IMSSecurityAssociation
+	c.SetupSA = func(sa *IMSSecurityAssociation) error {
+		setup = sa
+		return nil
+	}
+	reg, err := c.Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+	if setup != reg.SA || reg.SA.Alg != IMS_ALG_HMAC_SHA1_96 || reg.SA.EAlg != IMS_EALG_AES_CBC ||
+		reg.SA.SPIPC != 3333 || reg.SA.SPIPS != 4444 || reg.SA.XfrmEnc() != "cbc(aes)" {
+		t.Errorf("unexpected SA %+v", reg.SA)
+	}
+	if len(reg.SA.IKESP) != 20 || len(reg.SA.CKESP) != 16 {
+		t.Errorf("unexpected SA keys %x %x", reg.SA.IKESP, reg.SA.CKESP)
+	}
+	if reg.Expires != 3600 || len(reg.AssociatedURIs) != 2 || reg.AssociatedURIs[1] != "tel:+33612345678" || len(reg.ServiceRoute) != 1 {
+		t.Errorf("unexpected registration %+v", reg)
+	}
+}
+
+func TestIMSClientIdentities(t *testing.T) {
+	u, _ := testSubscriber(t)
+	c, err := NewIMSClient(u, "127.0.0.1:5060")
+	if err != nil || c.IMPI != "208930000000001@ims.mnc093.mcc208.3gppnetwork.org" ||
+		c.IMPU != "sip:208930000000001@ims.mnc093.mcc208.3gppnetwork.org" || c.Domain != "ims.mnc093.mcc208.3gppnetwork.org" {
+		t.Fatalf("identities without ISIM %+v, %v", c, err)
+	}
+
+	// TS 31.103 4.2.2 - 4.2.4, identities in a TLV with tag '80'
+	if err = u.UpdateEFRaw("EF_IMPI", [][]byte{append([]byte{0x80, 0x11}, "alice@example.com"...)}); err != nil {
+		t.Fatal(err)
+	}
+	if err = u.UpdateEFRaw("EF_DOMAIN", [][]byte{unhex("800b6578616d706c652e636f6dffff")}); err != nil {
+		t.Fatal(err)
+	}
+	impu := append([]byte{0x80, 0x15}, "sip:alice@example.com"...)
+	if err = u.UpdateEFRaw("EF_IMPU", [][]byte{impu, append([]byte{0x80, 0x0c}, "tel:+3360001"...), bytes.Repeat([]byte{0xFF}, len(impu))}); err != nil {
+		t.Fatal(err)
+	}
+	if f, err := ReadEF[EFIMPU](u); err != nil || len(f.IMPUs) != 2 || f.IMPUs[1] != "tel:+3360001" {
+		t.Errorf("EF_IMPU %+v, %v", f, err)
+	}
+	c, err = NewIMSClient(u, "127.0.0.1:5060")
+	if err != nil || c.IMPI != "alice@example.com" || c.IMPU != "sip:alice@example.com" || c.Domain != "example.com" {
+		t.Fatalf("ISIM identities %+v, %v", c, err)
+	}
+
+	// an ISIM with unreadable identities is not replaced by the IMSI ones
+	if err = u.UpdateEFRaw("EF_IMPI", [][]byte{unhex("8010616c696365")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewIMSClient(u, "127.0.0.1:5060"); err == nil {
+		t.Error("truncated EF_IMPI accepted")
+	}
+	long := EFIMPU{IMPUs: []string{"sip:" + strings.Repeat("a", 200) + "@example.com"}}
+	records, _ := long.Encode()
+	if records[0][1] != 0x81 || records[0][2] != 216 {
+		t.Errorf("long EF_IMPU record %x", records[0][:3])
+	}
+	if err = long.Decode(records); err != nil || len(long.IMPUs) != 1 || len(long.IMPUs[0]) != 216 {
+		t.Errorf("long EF_IMPU %v", err)
+	}
+}
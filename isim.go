@@ -0,0 +1,140 @@
+package usim_go
+
+import (
+	"errors"
+	"fmt"
+)
+
+// IMS identities of the ISIM, TS 31.103 4.2.2 - 4.2.4. Each is coded as a
+// TLV with tag '80'.
+
+const isimIdentityTag = 0x80
+
+// decodeISIMIdentity decodes the identity TLV at the start of r, ok is false
+// for an empty record
+func decodeISIMIdentity(name string, r []byte) (s string, ok bool, err error) {
+	if isEmptyRecord(r) {
+		return
+	}
+	if len(r) < 2 || r[0] != isimIdentityTag {
+		return "", false, fmt.Errorf("%s: missing identity TLV", name)
+	}
+	n, off := int(r[1]), 2
+	if r[1] == 0x81 {
+		if len(r) < 3 {
+			return "", false, fmt.Errorf("%s: truncated length", name)
+		}
+		n, off = int(r[2]), 3
+	} else if r[1] > 0x81 {
+		return "", false, fmt.Errorf("%s: unsupported length %02X", name, r[1])
+	}
+	if off+n > len(r) {
+		return "", false, fmt.Errorf("%s: truncated identity", name)
+	}
+	return string(r[off : off+n]), true, nil
+}
+
+func encodeISIMIdentity(name, s string) ([]byte, error) {
+	switch {
+	case len(s) > 0xFF:
+		return nil, fmt.Errorf("%s: identity longer than 255 bytes", name)
+	case len(s) > 0x7F:
+		return append([]byte{isimIdentityTag, 0x81, byte(len(s))}, s...), nil
+	}
+	return append([]byte{isimIdentityTag, byte(len(s))}, s...), nil
+}
+
+// EFIMPI is EF_IMPI, the private user identity, TS 31.103 4.2.2
+type EFIMPI struct {
+	IMPI string
+}
+
+func (e *EFIMPI) Info() EFInfo { return efInfo("EF_IMPI") }
+
+func (e *EFIMPI) Decode(records [][]byte) error {
+	r, err := singleRecord("EF_IMPI", records, 0)
+	if err == nil {
+		e.IMPI, _, err = decodeISIMIdentity("EF_IMPI", r)
+	}
+	return err
+}
+
+func (e *EFIMPI) Encode() ([][]byte, error) {
+	r, err := encodeISIMIdentity("EF_IMPI", e.IMPI)
+	return [][]byte{r}, err
+}
+
+// EFDomain is EF_DOMAIN, the home network domain name, TS 31.103 4.2.3
+type EFDomain struct {
+	Domain string
+}
+
+func (e *EFDomain) Info() EFInfo { return efInfo("EF_DOMAIN") }
+
+func (e *EFDomain) Decode(records [][]byte) error {
+	r, err := singleRecord("EF_DOMAIN", records, 0)
+	if err == nil {
+		e.Domain, _, err = decodeISIMIdentity("EF_DOMAIN", r)
+	}
+	return err
+}
+
+func (e *EFDomain) Encode() ([][]byte, error) {
+	r, err := encodeISIMIdentity("EF_DOMAIN", e.Domain)
+	return [][]byte{r}, err
+}
+
+// EFIMPU is EF_IMPU, the public user identities, TS 31.103 4.2.4. The
+// identity of the first record is used for registration.
+type EFIMPU struct {
+	IMPUs []string
+}
+
+func (e *EFIMPU) Info() EFInfo { return efInfo("EF_IMPU") }
+
+func (e *EFIMPU) Decode(records [][]byte) error {
+	e.IMPUs = nil
+	for _, r := range records {
+		impu, ok, err := decodeISIMIdentity("EF_IMPU", r)
+		if err != nil {
+			return err
+		}
+		if ok {
+			e.IMPUs = append(e.IMPUs, impu)
+		}
+	}
+	return nil
+}
+
+func (e *EFIMPU) Encode() (records [][]byte, err error) {
+	for _, impu := range e.IMPUs {
+		var r []byte
+		if r, err = encodeISIMIdentity("EF_IMPU", impu); err != nil {
+			return
+		}
+		records = append(records, r)
+	}
+	return
+}
+
+// ISIMIdentities reads the private and the first public user identity and
+// the home network domain from the ISIM. err wraps FILE_NOT_FOUND if there
+// is no ISIM application.
+func (u *USIM) ISIMIdentities() (impi, impu, domain string, err error) {
+	private, err := ReadEF[EFIMPI](u)
+	if err != nil {
+		return
+	}
+	home, err := ReadEF[EFDomain](u)
+	if err != nil {
+		return
+	}
+	public, err := ReadEF[EFIMPU](u)
+	if err != nil {
+		return
+	}
+	if len(public.IMPUs) == 0 {
+		return "", "", "", errors.New("EF_IMPU: no public user identity")
+	}
+	return private.IMPI, public.IMPUs[0], home.Domain, nil
+}
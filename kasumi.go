@@ -0,0 +1,226 @@
+package usim_go
+
+import "encoding/binary"
+
+// KASUMI block cipher of TS 35.202 with the confidentiality and integrity
+// functions f8/f9 of TS 35.201 (UEA1/UIA1) and the KGCORE based A5/3, A5/4,
+// GEA3 and GEA4 of TS 55.216 and TS 55.226.
+
+const (
+	A5_BLOCK_BITS = 114
+
+	kasumiF8KeyModifier = 0x55
+	kasumiF9KeyModifier = 0xAA
+)
+
+type kasumi struct {
+	kl1, kl2, ko1, ko2, ko3, ki1, ki2, ki3 [8]uint16
+}
+
+var kasumiC = [8]uint16{0x0123, 0x4567, 0x89AB, 0xCDEF, 0xFEDC, 0xBA98, 0x7654, 0x3210}
+
+func rotl16(x uint16, k uint) uint16 {
+	return x<<k | x>>(16-k)
+}
+
+// newKASUMI runs the key schedule of TS 35.202 4.4 on the 128 bit key
+func newKASUMI(key []byte) *kasumi {
+	var k, kp [8]uint16
+	for i := range k {
+		k[i] = binary.BigEndian.Uint16(key[2*i:])
+		kp[i] = k[i] ^ kasumiC[i]
+	}
+	c := &kasumi{}
+	for i := 0; i < 8; i++ {
+		c.kl1[i] = rotl16(k[i], 1)
+		c.kl2[i] = kp[(i+2)%8]
+		c.ko1[i] = rotl16(k[(i+1)%8], 5)
+		c.ko2[i] = rotl16(k[(i+5)%8], 8)
+		c.ko3[i] = rotl16(k[(i+6)%8], 13)
+		c.ki1[i] = kp[(i+4)%8]
+		c.ki2[i] = kp[(i+3)%8]
+		c.ki3[i] = kp[(i+7)%8]
+	}
+	return c
+}
+
+func kasumiFI(in, subkey uint16) uint16 {
+	nine, seven := in>>7, in&0x7F
+	nine = kasumiS9[nine] ^ seven
+	seven = kasumiS7[seven] ^ nine&0x7F
+	seven ^= subkey >> 9
+	nine ^= subkey & 0x1FF
+	nine = kasumiS9[nine] ^ seven
+	seven = kasumiS7[seven] ^ nine&0x7F
+	return seven<<9 | nine
+}
+
+func (c *kasumi) fo(in uint32, i int) uint32 {
+	l, r := uint16(in>>16), uint16(in)
+	l = kasumiFI(l^c.ko1[i], c.ki1[i]) ^ r
+	r = kasumiFI(r^c.ko2[i], c.ki2[i]) ^ l
+	l = kasumiFI(l^c.ko3[i], c.ki3[i]) ^ r
+	return uint32(r)<<16 | uint32(l)
+}
+
+func (c *kasumi) fl(in uint32, i int) uint32 {
+	l, r := uint16(in>>16), uint16(in)
+	r ^= rotl16(l&c.kl1[i], 1)
+	l ^= rotl16(r|c.kl2[i], 1)
+	return uint32(l)<<16 | uint32(r)
+}
+
+// encrypt enciphers one 64 bit block
+func (c *kasumi) encrypt(b uint64) uint64 {
+	l, r := uint32(b>>32), uint32(b)
+	for i := 0; i < 8; i += 2 {
+		r ^= c.fo(c.fl(l, i), i)
+		l ^= c.fl(c.fo(r, i+1), i+1)
+	}
+	return uint64(l)<<32 | uint64(r)
+}
+
+func kasumiModKey(key []byte, m byte) []byte {
+	km := make([]byte, len(key))
+	for i := range key {
+		km[i] = key[i] ^ m
+	}
+	return km
+}
+
+// kgcore is the keystream generator of TS 55.216 4, it returns cl bits
+func kgcore(ca, cb byte, cc uint32, cd byte, ce uint16, ck []byte, cl int) []byte {
+	a := uint64(cc)<<32 | uint64(cb&0x1F)<<27 | uint64(cd&1)<<26 | uint64(ca)<<16 | uint64(ce)
+	a = newKASUMI(kasumiModKey(ck, kasumiF8KeyModifier)).encrypt(a)
+	c := newKASUMI(ck)
+	out := make([]byte, (cl+63)/64*8)
+	var ksb uint64
+	for n := 0; n*64 < cl; n++ {
+		ksb = c.encrypt(a ^ uint64(n) ^ ksb)
+		binary.BigEndian.PutUint64(out[8*n:], ksb)
+	}
+	out = out[:(cl+7)/8]
+	if cl%8 != 0 {
+		out[len(out)-1] &= 0xff << (8 - cl%8)
+	}
+	return out
+}
+
+// KASUMIF8 is the confidentiality function f8 of UEA1, the first length bits
+// of data are ciphered, see TS 35.201 3
+func KASUMIF8(key []byte, count uint32, bearer, dir byte, data []byte, length int) []byte {
+	out := kgcore(0, bearer, count, dir, 0, key, length)
+	for i := range out {
+		out[i] ^= data[i]
+	}
+	if length%8 != 0 {
+		out[len(out)-1] &= 0xff << (8 - length%8)
+	}
+	return out
+}
+
+// KASUMIF9 is the integrity function f9 of UIA1, see TS 35.201 4
+func KASUMIF9(key []byte, count, fresh uint32, dir byte, data []byte, length int) (mac [4]byte) {
+	// COUNT || FRESH || MESSAGE || DIRECTION || 1 || 0*
+	n := 64 + length + 2
+	ps := make([]byte, (n+63)/64*8)
+	binary.BigEndian.PutUint32(ps, count)
+	binary.BigEndian.PutUint32(ps[4:], fresh)
+	copy(ps[8:], data[:(length+7)/8])
+	if length%8 != 0 {
+		ps[8+length/8] &= 0xff << (8 - length%8)
+	}
+	bit := 64 + length
+	ps[bit/8] |= (dir & 1) << (7 - bit%8)
+	bit++
+	ps[bit/8] |= 0x80 >> (bit % 8)
+
+	c := newKASUMI(key)
+	var a, b uint64
+	for i := 0; i < len(ps); i += 8 {
+		a = c.encrypt(a ^ binary.BigEndian.Uint64(ps[i:]))
+		b ^= a
+	}
+	b = newKASUMI(kasumiModKey(key, kasumiF9KeyModifier)).encrypt(b)
+	binary.BigEndian.PutUint32(mac[:], uint32(b>>32))
+	return
+}
+
+// gsmKey returns the KGCORE key, Kc || Kc for a 64 bit Kc and Kc128 as is
+func gsmKey(kc []byte) []byte {
+	if len(kc) == 8 {
+		return append(append([]byte{}, kc...), kc...)
+	}
+	return kc
+}
+
+// A53 returns the two 114 bit A5/3 keystream blocks for the 22 bit TDMA frame
+// COUNT, BLOCK1 for downlink and BLOCK2 for uplink, see TS 55.216 4.3. A 128
+// bit Kc128 selects A5/4.
+func A53(kc []byte, count uint32) (block1, block2 []byte) {
+	co := kgcore(0x0F, 0, count&0x3FFFFF, 0, 0, gsmKey(kc), 2*A5_BLOCK_BITS)
+	block1 = append([]byte{}, co[:(A5_BLOCK_BITS+7)/8]...)
+	block1[len(block1)-1] &= 0xC0
+	block2 = make([]byte, (A5_BLOCK_BITS+7)/8)
+	for i := 0; i < A5_BLOCK_BITS; i++ {
+		j := A5_BLOCK_BITS + i
+		block2[i/8] |= (co[j/8] >> (7 - j%8) & 1) << (7 - i%8)
+	}
+	return
+}
+
+// GEA3 ciphers the LLC frame data with the 32 bit INPUT and direction, see
+// TS 55.216 5. A 128 bit Kc128 selects GEA4.
+func GEA3(kc []byte, input uint32, dir byte, data []byte) []byte {
+	out := kgcore(0xFF, 0, input, dir, 0, gsmKey(kc), 8*len(data))
+	for i := range out {
+		out[i] ^= data[i]
+	}
+	return out
+}
+
+var kasumiS7 = [128]uint16{
+	54, 50, 62, 56, 22, 34, 94, 96, 38, 6, 63, 93, 2, 18, 123, 33,
+	55, 113, 39, 114, 21, 67, 65, 12, 47, 73, 46, 27, 25, 111, 124, 81,
+	53, 9, 121, 79, 52, 60, 58, 48, 101, 127, 40, 120, 104, 70, 71, 43,
+	20, 122, 72, 61, 23, 109, 13, 100, 77, 1, 16, 7, 82, 10, 105, 98,
+	117, 116, 76, 11, 89, 106, 0, 125, 118, 99, 86, 69, 30, 57, 126, 87,
+	112, 51, 17, 5, 95, 14, 90, 84, 91, 8, 35, 103, 32, 97, 28, 66,
+	102, 31, 26, 45, 75, 4, 85, 92, 37, 74, 80, 49, 68, 29, 115, 44,
+	64, 107, 108, 24, 110, 83, 36, 78, 42, 19, 15, 41, 88, 119, 59, 3,
+}
+
+var kasumiS9 = [512]uint16{
+	167, 239, 161, 379, 391, 334, 9, 338, 38, 226, 48, 358, 452, 385, 90, 397,
+	183, 253, 147, 331, 415, 340, 51, 362, 306, 500, 262, 82, 216, 159, 356, 177,
+	175, 241, 489, 37, 206, 17, 0, 333, 44, 254, 378, 58, 143, 220, 81, 400,
+	95, 3, 315, 245, 54, 235, 218, 405, 472, 264, 172, 494, 371, 290, 399, 76,
+	165, 197, 395, 121, 257, 480, 423, 212, 240, 28, 462, 176, 406, 507, 288, 223,
+	501, 407, 249, 265, 89, 186, 221, 428, 164, 74, 440, 196, 458, 421, 350, 163,
+	232, 158, 134, 354, 13, 250, 491, 142, 191, 69, 193, 425, 152, 227, 366, 135,
+	344, 300, 276, 242, 437, 320, 113, 278, 11, 243, 87, 317, 36, 93, 496, 27,
+	487, 446, 482, 41, 68, 156, 457, 131, 326, 403, 339, 20, 39, 115, 442, 124,
+	475, 384, 508, 53, 112, 170, 479, 151, 126, 169, 73, 268, 279, 321, 168, 364,
+	363, 292, 46, 499, 393, 327, 324, 24, 456, 267, 157, 460, 488, 426, 309, 229,
+	439, 506, 208, 271, 349, 401, 434, 236, 16, 209, 359, 52, 56, 120, 199, 277,
+	465, 416, 252, 287, 246, 6, 83, 305, 420, 345, 153, 502, 65, 61, 244, 282,
+	173, 222, 418, 67, 386, 368, 261, 101, 476, 291, 195, 430, 49, 79, 166, 330,
+	280, 383, 373, 128, 382, 408, 155, 495, 367, 388, 274, 107, 459, 417, 62, 454,
+	132, 225, 203, 316, 234, 14, 301, 91, 503, 286, 424, 211, 347, 307, 140, 374,
+	35, 103, 125, 427, 19, 214, 453, 146, 498, 314, 444, 230, 256, 329, 198, 285,
+	50, 116, 78, 410, 10, 205, 510, 171, 231, 45, 139, 467, 29, 86, 505, 32,
+	72, 26, 342, 150, 313, 490, 431, 238, 411, 325, 149, 473, 40, 119, 174, 355,
+	185, 233, 389, 71, 448, 273, 372, 55, 110, 178, 322, 12, 469, 392, 369, 190,
+	1, 109, 375, 137, 181, 88, 75, 308, 260, 484, 98, 272, 370, 275, 412, 111,
+	336, 318, 4, 504, 492, 259, 304, 77, 337, 435, 21, 357, 303, 332, 483, 18,
+	47, 85, 25, 497, 474, 289, 100, 269, 296, 478, 270, 106, 31, 104, 433, 84,
+	414, 486, 394, 96, 99, 154, 511, 148, 413, 361, 409, 255, 162, 215, 302, 201,
+	266, 351, 343, 144, 441, 365, 108, 298, 251, 34, 182, 509, 138, 210, 335, 133,
+	311, 352, 328, 141, 396, 346, 123, 319, 450, 281, 429, 228, 443, 481, 92, 404,
+	485, 422, 248, 297, 23, 213, 130, 466, 22, 217, 283, 70, 294, 360, 419, 127,
+	312, 377, 7, 468, 194, 2, 117, 295, 463, 258, 224, 447, 247, 187, 80, 398,
+	284, 353, 105, 390, 299, 471, 470, 184, 57, 200, 348, 63, 204, 188, 33, 451,
+	97, 30, 310, 219, 94, 160, 129, 493, 64, 179, 263, 102, 189, 207, 114, 402,
+	438, 477, 387, 122, 192, 42, 381, 5, 145, 118, 180, 449, 293, 323, 136, 380,
+	43, 66, 60, 455, 341, 445, 202, 432, 8, 237, 15, 376, 436, 464, 59, 461,
+}
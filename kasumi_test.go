@@ -0,0 +1,72 @@
+package usim_go
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"testing"
+)
+
+func TestKASUMI(t *testing.T) {
+	// TS 35.203 KASUMI test set 1
+	c := newKASUMI(unhex("2bd6459f82c5b300952c49104881ff48"))
+	if b := c.encrypt(0xEA024714AD5C4D84); b != 0xDF1F9B251C0BF45F {
+		t.Errorf("unexpected KASUMI output %016x", b)
+	}
+}
+
+func TestKASUMIF8F9(t *testing.T) {
+	key := unhex("2bd6459f82c5b300952c49104881ff48")
+	// TS 35.203 f9 test set 1
+	mac := KASUMIF9(key, 0x38a6f056, 0x05d2ec49, 0, unhex("6b227737296f393c8079353edc87e2e805d2ec49a4f2d8e0"), 189)
+	if !bytes.Equal(mac[:], unhex("f63bd72c")) {
+		t.Errorf("unexpected MAC-I %x", mac)
+	}
+	pt := unhex("7ec61272743bf1614726446a6c38ced166f6ca76eb5430044286346cef130f92922b03450d3a9975e5bd2ea0eb55ad8e1b199e3ec4316020e9a1b285e762795359b7bdfd39bef4b2484583d5afe082aee638bf5fd5a606193901a08f4ab41aab9b134880")
+	ct := KASUMIF8(key, 0x72a4f20f, 0x0c, 1, pt, 798)
+	// TS 35.203 f8 test set 1, which is KGCORE with CA = 0 and CE = 0
+	if !bytes.Equal(ct, unhex("d1e2de70eef86c6964fb542bc2d460aabfaa10a4a093262b7d199e706fc2d4891553296910f3a973012682e41c4e2b02be2017b7253bbf9309de5819cb42e81956f4c99bc9765caf53b1d0bb8279826adbbc5522e915c120a618a5a7f5e897089339650c")) {
+		t.Errorf("unexpected ciphertext %x", ct)
+	}
+	if pt2 := KASUMIF8(key, 0x72a4f20f, 0x0c, 1, ct, 798); !bytes.Equal(pt2, pt) {
+		t.Error("f8 is not an involution")
+	}
+}
+
+func TestA53GEA3(t *testing.T) {
+	// TS 55.217 A5/3 test set 1
+	kc := unhex("2bd6459f82c5bc00")
+	b1, b2 := A53(kc, 0x24f20f)
+	if !bytes.Equal(b1, unhex("889eeaaf9ed1ba1abbd8436232e440")) || !bytes.Equal(b2, unhex("5ca3406aa244cf69cf047aada2df40")) {
+		t.Errorf("unexpected A5/3 blocks %x %x", b1, b2)
+	}
+	// A5/4 with Kc || Kc is A5/3
+	if b, _ := A53(append(kc, kc...), 0x24f20f); !bytes.Equal(b, b1) {
+		t.Error("A5/4 with repeated Kc differs from A5/3")
+	}
+
+	// TS 55.217 GEA3 test data, the keystream of 59 octets: KGCORE with CA = 0xFF
+	ks := GEA3(unhex("952c49104881ff48"), 0x5064db71, 0, make([]byte, 59))
+	if !bytes.Equal(ks, unhex("fdc03d738c8e14ff0320e59aaf75760799e9da78dd8f888471c4aeaac1849633a26cd84f459d265b83d7d9b9a0b1e54f4d75e331640df19e0db0e0")) {
+		t.Errorf("unexpected GEA3 keystream %x", ks)
+	}
+
+	// TS 33.102 B.5: the 128 least significant bits of HMAC-SHA-256(CK || IK, FC)
+	ck, ik := unhex("b40ba9a3c58b2a05bbf0d987b21bf8cb"), unhex("f769bcd751044604127672711c6d3441")
+	mac := hmac.New(sha256.New, append(append([]byte{}, ck...), ik...))
+	mac.Write([]byte{FC_KC128})
+	kc128 := DeriveKc128(ck, ik)
+	if !bytes.Equal(kc128, mac.Sum(nil)[16:]) {
+		t.Fatalf("unexpected Kc128 %x", kc128)
+	}
+	frame := []byte("LLC frame of some length")
+	for _, k := range [][]byte{kc, kc128} {
+		ct := GEA3(k, 0x8e9421a3, 1, frame)
+		if bytes.Equal(ct, frame) || !bytes.Equal(GEA3(k, 0x8e9421a3, 1, ct), frame) {
+			t.Errorf("GEA round trip failed with key %x", k)
+		}
+		if bytes.Equal(ct, GEA3(k, 0x8e9421a3, 0, frame)) {
+			t.Error("GEA keystream does not depend on the direction")
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package usim_go
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+// Key derivation functions of TS 33.220 Annex B.2 and the FC values
+// defined for them in TS 33.102, TS 33.401, TS 33.402, TS 33.501 and TS 33.535.
+
+const (
+	FC_KASME         = 0x10
+	FC_KENB          = 0x11
+	FC_ALG_KEY       = 0x15
+	FC_CK_IK_PRIME   = 0x20
+	FC_KC128         = 0x32
+	FC_KAUSF         = 0x6A
+	FC_RES_STAR      = 0x6B
+	FC_KSEAF         = 0x6C
+	FC_KAMF          = 0x6D
+	FC_KGNB          = 0x6E
+	FC_ALG_KEY_5G    = 0x69
+	FC_SOR_MAC_IAUSF = 0x77
+	FC_SOR_MAC_IUE   = 0x78
+	FC_UPU_MAC_IAUSF = 0x7B
+	FC_UPU_MAC_IUE   = 0x7C
+	FC_KAKMA         = 0x80
+	FC_A_TID         = 0x81
+	FC_KAF           = 0x82
+)
+
+// KDF computes HMAC-SHA-256(key, FC || P0 || L0 || P1 || L1 ...)
+func KDF(key []byte, fc byte, params ...[]byte) []byte {
+	s := []byte{fc}
+	var l [2]byte
+	for _, p := range params {
+		binary.BigEndian.PutUint16(l[:], uint16(len(p)))
+		s = append(s, p...)
+		s = append(s, l[:]...)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(s)
+	return mac.Sum(nil)
+}
+
+func ckik(ck, ik []byte) []byte {
+	key := make([]byte, 0, len(ck)+len(ik))
+	key = append(key, ck...)
+	return append(key, ik...)
+}
+
+// ServingNetworkName returns the 5G serving network name of TS 24.501 9.12.1
+func ServingNetworkName(mcc, mnc string) string {
+	return "5G:" + HomeNetworkDomain(mcc, mnc)
+}
+
+// DeriveKASME derives KASME from CK, IK, the serving network id and SQN ^ AK, see TS 33.401 A.2
+func DeriveKASME(ck, ik []byte, plmn [3]byte, sqnXorAK []byte) []byte {
+	return KDF(ckik(ck, ik), FC_KASME, plmn[:], sqnXorAK)
+}
+
+// DeriveCKIKPrime derives CK' and IK' for EAP-AKA', see TS 33.402 A.2
+func DeriveCKIKPrime(ck, ik []byte, netName string, sqnXorAK []byte) (ckPrime, ikPrime []byte) {
+	key := KDF(ckik(ck, ik), FC_CK_IK_PRIME, []byte(netName), sqnXorAK)
+	return key[:16], key[16:]
+}
+
+// DeriveKc128 derives the 128 bit Kc for A5/4 and GEA4 from CK and IK, see TS 33.102 B.5
+func DeriveKc128(ck, ik []byte) []byte {
+	return KDF(ckik(ck, ik), FC_KC128)[16:]
+}
+
+// DeriveKAUSF derives KAUSF from CK, IK for 5G AKA, see TS 33.501 A.2
+func DeriveKAUSF(ck, ik []byte, snName string, sqnXorAK []byte) []byte {
+	return KDF(ckik(ck, ik), FC_KAUSF, []byte(snName), sqnXorAK)
+}
+
+// DeriveKSEAF derives KSEAF from KAUSF, see TS 33.501 A.6
+func DeriveKSEAF(kausf []byte, snName string) []byte {
+	return KDF(kausf, FC_KSEAF, []byte(snName))
+}
+
+// DeriveKAMF derives KAMF from KSEAF, the SUPI (IMSI digits) and ABBA, see TS 33.501 A.7
+func DeriveKAMF(kseaf []byte, supi string, abba []byte) []byte {
+	return KDF(kseaf, FC_KAMF, []byte(supi), abba)
+}
+
+// DeriveKeNB derives KeNB from KASME and the uplink NAS COUNT, see TS 33.401 A.3
+func DeriveKeNB(kasme []byte, ulNASCount uint32) []byte {
+	var c [4]byte
+	binary.BigEndian.PutUint32(c[:], ulNASCount)
+	return KDF(kasme, FC_KENB, c[:])
+}
+
+// DeriveKgNB derives KgNB from KAMF, the uplink NAS COUNT and the access type
+// distinguisher (1 for 3GPP access), see TS 33.501 A.9
+func DeriveKgNB(kamf []byte, ulNASCount uint32, accessType byte) []byte {
+	var c [4]byte
+	binary.BigEndian.PutUint32(c[:], ulNASCount)
+	return KDF(kamf, FC_KGNB, c[:], []byte{accessType})
+}
+
+// DeriveAlgorithmKey derives the 128 bit NAS, RRC or UP key for the algorithm
+// type distinguisher and algorithm id from KASME or KeNB with FC_ALG_KEY
+// (TS 33.401 A.7) or from KAMF or KgNB with FC_ALG_KEY_5G (TS 33.501 A.8)
+func DeriveAlgorithmKey(key []byte, fc, distinguisher, alg byte) []byte {
+	return KDF(key, fc, []byte{distinguisher}, []byte{alg})[16:]
+}
+
+// DeriveRESStar derives RES* (or XRES*) from RES, see TS 33.501 A.4
+func DeriveRESStar(ck, ik []byte, snName string, rand, res []byte) []byte {
+	return KDF(ckik(ck, ik), FC_RES_STAR, []byte(snName), rand, res)[16:]
+}
+
+// DeriveHRESStar derives HRES* (or HXRES*) from RES*, see TS 33.501 A.5
+func DeriveHRESStar(rand, resStar []byte) []byte {
+	h := sha256.New()
+	h.Write(rand)
+	h.Write(resStar)
+	return h.Sum(nil)[16:]
+}
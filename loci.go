@@ -0,0 +1,285 @@
+package usim_go
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Location information files of TS 31.102 4.2.17 (EF_LOCI), 4.2.23
+// (EF_PSLOCI), 4.2.91 (EF_EPSLOCI) and 4.4.11.2/4.4.11.3 (EF_5GS3GPPLOCI,
+// EF_5GSN3GPPLOCI)
+
+// Update status of the location information files. For EF_EPSLOCI and the
+// 5GS files the value 2 is "roaming not allowed".
+const (
+	UPDATE_STATUS_UPDATED          = 0x00
+	UPDATE_STATUS_NOT_UPDATED      = 0x01
+	UPDATE_STATUS_PLMN_NOT_ALLOWED = 0x02
+	UPDATE_STATUS_AREA_NOT_ALLOWED = 0x03
+
+	// TMSI_INVALID is the TMSI or P-TMSI value meaning no valid identity
+	TMSI_INVALID = 0xFFFFFFFF
+	// LAC_DELETED marks a deleted LAI, TS 24.008 4.1.2.2
+	LAC_DELETED = 0xFFFE
+	// TAC_DELETED and TAC_5GS_DELETED mark a deleted TAI in EF_EPSLOCI and
+	// in the 5GS files
+	TAC_DELETED     = 0xFFFE
+	TAC_5GS_DELETED = 0xFFFFFE
+
+	efLOCILen    = 11
+	efPSLOCILen  = 14
+	efEPSLOCILen = 18
+	ef5GSLOCILen = 20
+)
+
+// LAI is a location area identification, PLMN is empty for a deleted LAI
+type LAI struct {
+	PLMN PLMNID
+	LAC  uint16
+}
+
+// TAI is a tracking area identification, the TAC has 2 octets in EF_EPSLOCI
+// and 3 octets in the 5GS files
+type TAI struct {
+	PLMN PLMNID
+	TAC  uint32
+}
+
+// decodeLocPLMN decodes the PLMN of a LAI or TAI, 'FFFFFF' leaves it empty
+func decodeLocPLMN(name string, b []byte) (PLMNID, error) {
+	p, _, err := decodePLMNID(b)
+	if err != nil {
+		return p, fmt.Errorf("%s: %v", name, err)
+	}
+	return p, nil
+}
+
+func encodeLocPLMN(name string, p PLMNID) ([]byte, error) {
+	if p.MCC == "" {
+		return []byte{0xFF, 0xFF, 0xFF}, nil
+	}
+	b, err := p.encode()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return b, nil
+}
+
+// EFLOCI is EF_LOCI, the CS location information
+type EFLOCI struct {
+	TMSI   uint32
+	LAI    LAI
+	Status byte
+}
+
+func (e *EFLOCI) Info() EFInfo { return efInfo("EF_LOCI") }
+
+func (e *EFLOCI) Decode(records [][]byte) (err error) {
+	r, err := singleRecord("EF_LOCI", records, efLOCILen)
+	if err != nil {
+		return
+	}
+	e.TMSI = binary.BigEndian.Uint32(r)
+	if e.LAI.PLMN, err = decodeLocPLMN("EF_LOCI", r[4:7]); err != nil {
+		return
+	}
+	e.LAI.LAC = binary.BigEndian.Uint16(r[7:])
+	e.Status = r[10] & 0x07
+	return
+}
+
+func (e *EFLOCI) Encode() ([][]byte, error) {
+	plmn, err := encodeLocPLMN("EF_LOCI", e.LAI.PLMN)
+	if err != nil {
+		return nil, err
+	}
+	r := binary.BigEndian.AppendUint32(nil, e.TMSI)
+	r = binary.BigEndian.AppendUint16(append(r, plmn...), e.LAI.LAC)
+	return [][]byte{append(r, 0xFF, e.Status)}, nil
+}
+
+// EFPSLOCI is EF_PSLOCI, the PS location information. The routing area is
+// the LAI with the RAC.
+type EFPSLOCI struct {
+	PTMSI          uint32
+	PTMSISignature [3]byte
+	LAI            LAI
+	RAC            byte
+	Status         byte
+}
+
+func (e *EFPSLOCI) Info() EFInfo { return efInfo("EF_PSLOCI") }
+
+func (e *EFPSLOCI) Decode(records [][]byte) (err error) {
+	r, err := singleRecord("EF_PSLOCI", records, efPSLOCILen)
+	if err != nil {
+		return
+	}
+	e.PTMSI = binary.BigEndian.Uint32(r)
+	copy(e.PTMSISignature[:], r[4:7])
+	if e.LAI.PLMN, err = decodeLocPLMN("EF_PSLOCI", r[7:10]); err != nil {
+		return
+	}
+	e.LAI.LAC = binary.BigEndian.Uint16(r[10:])
+	e.RAC, e.Status = r[12], r[13]&0x07
+	return
+}
+
+func (e *EFPSLOCI) Encode() ([][]byte, error) {
+	plmn, err := encodeLocPLMN("EF_PSLOCI", e.LAI.PLMN)
+	if err != nil {
+		return nil, err
+	}
+	r := binary.BigEndian.AppendUint32(nil, e.PTMSI)
+	r = append(append(r, e.PTMSISignature[:]...), plmn...)
+	r = binary.BigEndian.AppendUint16(r, e.LAI.LAC)
+	return [][]byte{append(r, e.RAC, e.Status)}, nil
+}
+
+// EFEPSLOCI is EF_EPSLOCI, GUTI is nil if the file holds none
+type EFEPSLOCI struct {
+	GUTI   *GUTI
+	TAI    TAI
+	Status byte
+}
+
+func (e *EFEPSLOCI) Info() EFInfo { return efInfo("EF_EPSLOCI") }
+
+func (e *EFEPSLOCI) Decode(records [][]byte) (err error) {
+	r, err := singleRecord("EF_EPSLOCI", records, efEPSLOCILen)
+	if err != nil {
+		return
+	}
+	e.GUTI = nil
+	if g, err := GUTIFromEPSLOCI(r); err == nil {
+		e.GUTI = &g
+	}
+	if e.TAI.PLMN, err = decodeLocPLMN("EF_EPSLOCI", r[12:15]); err != nil {
+		return
+	}
+	e.TAI.TAC = uint32(binary.BigEndian.Uint16(r[15:]))
+	e.Status = r[17] & 0x07
+	return
+}
+
+func (e *EFEPSLOCI) Encode() ([][]byte, error) {
+	r := bytes.Repeat([]byte{0xFF}, efEPSLOCIGUTILen)
+	if e.GUTI != nil {
+		id, err := EncodeEPSMobileIdentity(MobileIdentity{Type: EPS_IDENTITY_GUTI, GUTI: *e.GUTI})
+		if err != nil {
+			return nil, fmt.Errorf("EF_EPSLOCI: %v", err)
+		}
+		r = append([]byte{byte(len(id))}, id...)
+	}
+	plmn, err := encodeLocPLMN("EF_EPSLOCI", e.TAI.PLMN)
+	if err != nil {
+		return nil, err
+	}
+	if e.TAI.TAC > 0xFFFF {
+		return nil, fmt.Errorf("EF_EPSLOCI: TAC %X too long", e.TAI.TAC)
+	}
+	r = binary.BigEndian.AppendUint16(append(r, plmn...), uint16(e.TAI.TAC))
+	return [][]byte{append(r, e.Status)}, nil
+}
+
+// loci5GS is the content of EF_5GS3GPPLOCI and EF_5GSN3GPPLOCI, GUTI is nil
+// if the file holds none
+type loci5GS struct {
+	GUTI   *GUTI5G
+	TAI    TAI
+	Status byte
+}
+
+func (l *loci5GS) decode(name string, records [][]byte) (err error) {
+	r, err := singleRecord(name, records, ef5GSLOCILen)
+	if err != nil {
+		return
+	}
+	l.GUTI = nil
+	if g, err := GUTI5GFromLOCI(r); err == nil {
+		l.GUTI = &g
+	}
+	if l.TAI.PLMN, err = decodeLocPLMN(name, r[13:16]); err != nil {
+		return
+	}
+	l.TAI.TAC = uint32(r[16])<<16 | uint32(r[17])<<8 | uint32(r[18])
+	l.Status = r[19] & 0x07
+	return
+}
+
+func (l *loci5GS) encode(name string) ([][]byte, error) {
+	r := bytes.Repeat([]byte{0xFF}, ef5GSLOCIGUTILen)
+	if l.GUTI != nil {
+		id, err := Encode5GSMobileIdentity(MobileIdentity{Type: MOBILE_IDENTITY_5G_GUTI, GUTI5G: *l.GUTI})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		r = append(binary.BigEndian.AppendUint16(nil, uint16(len(id))), id...)
+	}
+	plmn, err := encodeLocPLMN(name, l.TAI.PLMN)
+	if err != nil {
+		return nil, err
+	}
+	if l.TAI.TAC > 0xFFFFFF {
+		return nil, fmt.Errorf("%s: TAC %X too long", name, l.TAI.TAC)
+	}
+	r = append(r, plmn...)
+	r = append(r, byte(l.TAI.TAC>>16), byte(l.TAI.TAC>>8), byte(l.TAI.TAC))
+	return [][]byte{append(r, l.Status)}, nil
+}
+
+// EF5GS3GPPLOCI is EF_5GS3GPPLOCI, the 5GS location information for 3GPP access
+type EF5GS3GPPLOCI struct{ loci5GS }
+
+func (e *EF5GS3GPPLOCI) Info() EFInfo                  { return efInfo("EF_5GS3GPPLOCI") }
+func (e *EF5GS3GPPLOCI) Decode(records [][]byte) error { return e.decode("EF_5GS3GPPLOCI", records) }
+func (e *EF5GS3GPPLOCI) Encode() ([][]byte, error)     { return e.encode("EF_5GS3GPPLOCI") }
+
+// EF5GSN3GPPLOCI is EF_5GSN3GPPLOCI, the 5GS location information for non-3GPP access
+type EF5GSN3GPPLOCI struct{ loci5GS }
+
+func (e *EF5GSN3GPPLOCI) Info() EFInfo                  { return efInfo("EF_5GSN3GPPLOCI") }
+func (e *EF5GSN3GPPLOCI) Decode(records [][]byte) error { return e.decode("EF_5GSN3GPPLOCI", records) }
+func (e *EF5GSN3GPPLOCI) Encode() ([][]byte, error)     { return e.encode("EF_5GSN3GPPLOCI") }
+
+// ResetLocation sets the location information files to the never registered
+// state: no TMSI, P-TMSI, GUTI or 5G-GUTI, deleted LAI, RAI and TAI and the
+// update status "not updated". Files that cannot be read, e.g. because the
+// service is not available, are skipped.
+func (u *USIM) ResetLocation() error {
+	deletedLAI := LAI{LAC: LAC_DELETED}
+	deletedTAI, deletedTAI5GS := TAI{TAC: TAC_DELETED}, TAI{TAC: TAC_5GS_DELETED}
+	files := []ElementaryFile{
+		&EFLOCI{TMSI: TMSI_INVALID, LAI: deletedLAI, Status: UPDATE_STATUS_NOT_UPDATED},
+		&EFPSLOCI{
+			PTMSI:          TMSI_INVALID,
+			PTMSISignature: [3]byte{0xFF, 0xFF, 0xFF},
+			LAI:            deletedLAI,
+			RAC:            0xFF,
+			Status:         UPDATE_STATUS_NOT_UPDATED,
+		},
+		&EFEPSLOCI{TAI: deletedTAI, Status: UPDATE_STATUS_NOT_UPDATED},
+		&EF5GS3GPPLOCI{loci5GS{TAI: deletedTAI5GS, Status: UPDATE_STATUS_NOT_UPDATED}},
+		&EF5GSN3GPPLOCI{loci5GS{TAI: deletedTAI5GS, Status: UPDATE_STATUS_NOT_UPDATED}},
+	}
+	reset := 0
+	for _, f := range files {
+		name := f.Info().Name
+		if _, err := u.ReadEFRaw(name); err != nil {
+			logrus.Debugf("%s not reset: %v", name, err)
+			continue
+		}
+		if err := UpdateEF(u, f); err != nil {
+			return err
+		}
+		reset++
+	}
+	if reset == 0 {
+		return errors.New("no location information file present")
+	}
+	return nil
+}
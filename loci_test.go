@@ -0,0 +1,107 @@
+package usim_go
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestEFLOCI(t *testing.T) {
+	raw := unhex("1234abcd" + "02f839" + "0102" + "ff" + "00")
+	var loci EFLOCI
+	if err := loci.Decode([][]byte{raw}); err != nil {
+		t.Fatal(err)
+	}
+	want := EFLOCI{TMSI: 0x1234ABCD, LAI: LAI{PLMNID{"208", "93"}, 0x0102}, Status: UPDATE_STATUS_UPDATED}
+	if loci != want {
+		t.Fatalf("EF_LOCI %+v", loci)
+	}
+	if out, err := loci.Encode(); err != nil || !bytes.Equal(out[0], raw) {
+		t.Errorf("EF_LOCI encoded %x, %v", out, err)
+	}
+}
+
+func TestEFPSLOCI(t *testing.T) {
+	raw := unhex("c0001122" + "aabbcc" + "02f839" + "0102" + "05" + "01")
+	var ps EFPSLOCI
+	if err := ps.Decode([][]byte{raw}); err != nil {
+		t.Fatal(err)
+	}
+	if ps.PTMSI != 0xC0001122 || ps.PTMSISignature != [3]byte{0xAA, 0xBB, 0xCC} || ps.RAC != 5 || ps.Status != UPDATE_STATUS_NOT_UPDATED {
+		t.Fatalf("EF_PSLOCI %+v", ps)
+	}
+	if out, err := ps.Encode(); err != nil || !bytes.Equal(out[0], raw) {
+		t.Errorf("EF_PSLOCI encoded %x, %v", out, err)
+	}
+}
+
+func TestEPSAnd5GSLOCI(t *testing.T) {
+	eps := EFEPSLOCI{
+		GUTI:   &GUTI{MCC: "208", MNC: "93", MMEGroupID: 0x8001, MMECode: 1, MTMSI: 0xC0000001},
+		TAI:    TAI{PLMNID{"208", "93"}, 0x0001},
+		Status: UPDATE_STATUS_UPDATED,
+	}
+	out, err := eps.Encode()
+	if err != nil || len(out[0]) != efEPSLOCILen {
+		t.Fatalf("EF_EPSLOCI %x, %v", out, err)
+	}
+	var eps2 EFEPSLOCI
+	if err = eps2.Decode(out); err != nil || !reflect.DeepEqual(eps2, eps) {
+		t.Errorf("EF_EPSLOCI %+v, %v", eps2, err)
+	}
+
+	raw := unhex("000b" + "f202f839cafec0c0000001" + "02f839" + "000001" + "02")
+	var l EF5GS3GPPLOCI
+	if err = l.Decode([][]byte{raw}); err != nil {
+		t.Fatal(err)
+	}
+	want := GUTI5G{MCC: "208", MNC: "93", AMFRegionID: 0xCA, AMFSetID: 0x3FB, TMSI: 0xC0000001}
+	if l.GUTI == nil || *l.GUTI != want || l.TAI.TAC != 1 || l.Status != UPDATE_STATUS_PLMN_NOT_ALLOWED {
+		t.Fatalf("EF_5GS3GPPLOCI %+v", l)
+	}
+	if out, err = l.Encode(); err != nil || !bytes.Equal(out[0], raw) {
+		t.Errorf("EF_5GS3GPPLOCI encoded %x, %v", out, err)
+	}
+}
+
+func TestResetLocation(t *testing.T) {
+	u, _ := testSubscriber(t)
+	if err := u.ResetLocation(); err == nil {
+		t.Fatal("reset without location files")
+	}
+	stale := []ElementaryFile{
+		&EFLOCI{TMSI: 0x1234ABCD, LAI: LAI{PLMNID{"208", "93"}, 0x0102}},
+		&EFEPSLOCI{GUTI: &GUTI{MCC: "208", MNC: "93", MMEGroupID: 1, MMECode: 1, MTMSI: 1}, TAI: TAI{PLMNID{"208", "93"}, 1}},
+		&EF5GS3GPPLOCI{loci5GS{GUTI: &GUTI5G{MCC: "208", MNC: "93", AMFRegionID: 1, AMFSetID: 1, TMSI: 1}, TAI: TAI{PLMNID{"208", "93"}, 1}}},
+	}
+	for _, f := range stale {
+		if err := UpdateEF(u, f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if in, _ := u.PLMNSelectionInput(); in.RPLMN != (PLMNID{"208", "93"}) {
+		t.Errorf("RPLMN %+v", in.RPLMN)
+	}
+	if err := u.ResetLocation(); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		"EF_LOCI":        "ffffffffffffff" + "fffe" + "ff01",
+		"EF_EPSLOCI":     "ffffffffffffffffffffffff" + "ffffff" + "fffe" + "01",
+		"EF_5GS3GPPLOCI": "ffffffffffffffffffffffffff" + "ffffff" + "fffffe" + "01",
+	} {
+		if records, _ := u.ReadEFRaw(name); !bytes.Equal(records[0], unhex(want)) {
+			t.Errorf("%s %x", name, records[0])
+		}
+	}
+	if _, err := u.ReadEFRaw("EF_PSLOCI"); err == nil {
+		t.Error("EF_PSLOCI created")
+	}
+	loci, err := ReadEF[EFLOCI](u)
+	if err != nil || loci.TMSI != TMSI_INVALID || loci.LAI.PLMN.MCC != "" || loci.Status != UPDATE_STATUS_NOT_UPDATED {
+		t.Errorf("EF_LOCI %+v, %v", loci, err)
+	}
+	if in, _ := u.PLMNSelectionInput(); in.RPLMN.MCC != "" {
+		t.Errorf("RPLMN %+v after reset", in.RPLMN)
+	}
+}
@@ -0,0 +1,291 @@
+package usim_go
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// EPS mobile identity of TS 24.301 9.9.3.12 and 5GS mobile identity of
+// TS 24.501 9.11.3.4. Encoders and decoders work on the value part of the IE.
+
+const (
+	EPS_IDENTITY_IMSI = 0x01
+	EPS_IDENTITY_IMEI = 0x03
+	EPS_IDENTITY_GUTI = 0x06
+
+	MOBILE_IDENTITY_NONE      = 0x00
+	MOBILE_IDENTITY_5G_GUTI   = 0x02
+	MOBILE_IDENTITY_IMEI      = 0x03
+	MOBILE_IDENTITY_5G_S_TMSI = 0x04
+	MOBILE_IDENTITY_IMEISV    = 0x05
+
+	// sizes of the GUTI fields of EF_EPSLOCI and EF_5GS3GPPLOCI, see TS 31.102 4.2.91 and 4.4.11.2
+	efEPSLOCIGUTILen = 12
+	ef5GSLOCIGUTILen = 13
+	mobileIdGUTILen  = 11
+	mobileIdSTMSILen = 7
+	mobileIdTypeMask = 0x07
+)
+
+// GUTI is the EPS globally unique temporary identity
+type GUTI struct {
+	MCC        string
+	MNC        string
+	MMEGroupID uint16
+	MMECode    byte
+	MTMSI      uint32
+}
+
+// GUTI5G is the 5G-GUTI, a 5G-S-TMSI leaves MCC, MNC and AMFRegionID empty
+type GUTI5G struct {
+	MCC         string
+	MNC         string
+	AMFRegionID byte
+	AMFSetID    uint16
+	AMFPointer  byte
+	TMSI        uint32
+}
+
+// MobileIdentity is a decoded mobile identity, Digits holds IMSI, IMEI or
+// IMEISV and the field matching Type holds the other identities
+type MobileIdentity struct {
+	Type   byte
+	Digits string
+	GUTI   GUTI
+	GUTI5G GUTI5G
+	SUCI   SUCI
+}
+
+// encodeBCD encodes digits as swapped semi-octets with a filler in the last octet
+func encodeBCD(digits string) []byte {
+	buf := make([]byte, (len(digits)+1)/2)
+	for i := range buf {
+		buf[i] = 0xF0 | (digits[2*i] - '0')
+		if 2*i+1 < len(digits) {
+			buf[i] = (digits[2*i+1]-'0')<<4 | buf[i]&0x0F
+		}
+	}
+	return buf
+}
+
+// encodeDigitIdentity encodes IMSI, IMEI and IMEISV, the first digit shares
+// the octet with the odd/even indicator and the type of identity
+func encodeDigitIdentity(typ byte, digits string) ([]byte, error) {
+	if !isDigits(digits) {
+		return nil, fmt.Errorf("mobile identity: invalid digits %q", digits)
+	}
+	first := (digits[0]-'0')<<4 | typ
+	if len(digits)%2 == 1 {
+		first |= 0x08
+	}
+	return append([]byte{first}, encodeBCD(digits[1:])...), nil
+}
+
+func decodeDigitIdentity(buf []byte) (string, error) {
+	digits := string('0'+buf[0]>>4) + decodeBCD(buf[1:])
+	if buf[0]&0x08 == 0 {
+		// an even number of digits leaves a filler in the last octet
+		digits = strings.TrimSuffix(digits, "f")
+	}
+	if !isDigits(digits) {
+		return "", fmt.Errorf("mobile identity: invalid digits %s", digits)
+	}
+	return digits, nil
+}
+
+// EncodeEPSMobileIdentity encodes an EPS mobile identity of type IMSI, IMEI or GUTI
+func EncodeEPSMobileIdentity(id MobileIdentity) ([]byte, error) {
+	switch id.Type {
+	case EPS_IDENTITY_IMSI, EPS_IDENTITY_IMEI:
+		return encodeDigitIdentity(id.Type, id.Digits)
+	case EPS_IDENTITY_GUTI:
+		plmn, err := EncodePLMN(id.GUTI.MCC, id.GUTI.MNC)
+		if err != nil {
+			return nil, err
+		}
+		buf := append([]byte{0xF0 | EPS_IDENTITY_GUTI}, plmn[:]...)
+		buf = binary.BigEndian.AppendUint16(buf, id.GUTI.MMEGroupID)
+		buf = append(buf, id.GUTI.MMECode)
+		return binary.BigEndian.AppendUint32(buf, id.GUTI.MTMSI), nil
+	}
+	return nil, fmt.Errorf("mobile identity: unsupported EPS identity type %d", id.Type)
+}
+
+// DecodeEPSMobileIdentity decodes the value part of an EPS mobile identity IE
+func DecodeEPSMobileIdentity(buf []byte) (id MobileIdentity, err error) {
+	if len(buf) == 0 {
+		return id, errors.New("mobile identity: empty")
+	}
+	id.Type = buf[0] & mobileIdTypeMask
+	switch id.Type {
+	case EPS_IDENTITY_IMSI, EPS_IDENTITY_IMEI:
+		id.Digits, err = decodeDigitIdentity(buf)
+	case EPS_IDENTITY_GUTI:
+		if len(buf) != mobileIdGUTILen {
+			return id, fmt.Errorf("mobile identity: invalid GUTI length %d", len(buf))
+		}
+		if id.GUTI.MCC, id.GUTI.MNC, err = decodePLMN(buf[1:4]); err != nil {
+			return
+		}
+		id.GUTI.MMEGroupID = binary.BigEndian.Uint16(buf[4:])
+		id.GUTI.MMECode = buf[6]
+		id.GUTI.MTMSI = binary.BigEndian.Uint32(buf[7:])
+	default:
+		err = fmt.Errorf("mobile identity: unsupported EPS identity type %d", id.Type)
+	}
+	return
+}
+
+// Encode returns the value part of a 5GS mobile identity IE of type SUCI
+func (s SUCI) Encode() ([]byte, error) {
+	plmn, err := EncodePLMN(s.MCC, s.MNC)
+	if err != nil {
+		return nil, err
+	}
+	if len(s.RoutingIndicator) == 0 || len(s.RoutingIndicator) > 4 || !isDigits(s.RoutingIndicator) {
+		return nil, fmt.Errorf("SUCI: invalid routing indicator %q", s.RoutingIndicator)
+	}
+	rid := []byte{0xFF, 0xFF}
+	copy(rid, encodeBCD(s.RoutingIndicator))
+	buf := append([]byte{s.SupiFormat<<4 | MOBILE_IDENTITY_SUCI}, plmn[:]...)
+	buf = append(buf, rid...)
+	buf = append(buf, byte(s.ProtectionScheme), s.HNPublicKeyID)
+	if s.ProtectionScheme == NullScheme {
+		if !isDigits(string(s.SchemeOutput)) {
+			return nil, errors.New("SUCI: null scheme output is not an MSIN")
+		}
+		return append(buf, encodeBCD(string(s.SchemeOutput))...), nil
+	}
+	return append(buf, s.SchemeOutput...), nil
+}
+
+// Encode5GSMobileIdentity encodes a 5GS mobile identity
+func Encode5GSMobileIdentity(id MobileIdentity) ([]byte, error) {
+	switch id.Type {
+	case MOBILE_IDENTITY_NONE:
+		return []byte{MOBILE_IDENTITY_NONE}, nil
+	case MOBILE_IDENTITY_SUCI:
+		return id.SUCI.Encode()
+	case MOBILE_IDENTITY_IMEI, MOBILE_IDENTITY_IMEISV:
+		return encodeDigitIdentity(id.Type, id.Digits)
+	case MOBILE_IDENTITY_5G_GUTI:
+		plmn, err := EncodePLMN(id.GUTI5G.MCC, id.GUTI5G.MNC)
+		if err != nil {
+			return nil, err
+		}
+		buf := append([]byte{0xF0 | MOBILE_IDENTITY_5G_GUTI}, plmn[:]...)
+		buf = append(buf, id.GUTI5G.AMFRegionID)
+		return id.GUTI5G.appendSTMSI(buf), nil
+	case MOBILE_IDENTITY_5G_S_TMSI:
+		return id.GUTI5G.appendSTMSI([]byte{0xF0 | MOBILE_IDENTITY_5G_S_TMSI}), nil
+	}
+	return nil, fmt.Errorf("mobile identity: unsupported 5GS identity type %d", id.Type)
+}
+
+// appendSTMSI appends AMF set ID, AMF pointer and 5G-TMSI
+func (g GUTI5G) appendSTMSI(buf []byte) []byte {
+	buf = binary.BigEndian.AppendUint16(buf, (g.AMFSetID&0x3FF)<<6|uint16(g.AMFPointer&0x3F))
+	return binary.BigEndian.AppendUint32(buf, g.TMSI)
+}
+
+func (g *GUTI5G) decodeSTMSI(buf []byte) {
+	v := binary.BigEndian.Uint16(buf)
+	g.AMFSetID, g.AMFPointer = v>>6, byte(v&0x3F)
+	g.TMSI = binary.BigEndian.Uint32(buf[2:])
+}
+
+// STMSI returns the 5G-S-TMSI of the 5G-GUTI
+func (g GUTI5G) STMSI() GUTI5G {
+	return GUTI5G{AMFSetID: g.AMFSetID, AMFPointer: g.AMFPointer, TMSI: g.TMSI}
+}
+
+// Decode5GSMobileIdentity decodes the value part of a 5GS mobile identity IE
+func Decode5GSMobileIdentity(buf []byte) (id MobileIdentity, err error) {
+	if len(buf) == 0 {
+		return id, errors.New("mobile identity: empty")
+	}
+	id.Type = buf[0] & mobileIdTypeMask
+	switch id.Type {
+	case MOBILE_IDENTITY_NONE:
+	case MOBILE_IDENTITY_SUCI:
+		id.SUCI, err = DecodeSUCI(buf)
+	case MOBILE_IDENTITY_IMEI, MOBILE_IDENTITY_IMEISV:
+		id.Digits, err = decodeDigitIdentity(buf)
+	case MOBILE_IDENTITY_5G_GUTI:
+		if len(buf) != mobileIdGUTILen {
+			return id, fmt.Errorf("mobile identity: invalid 5G-GUTI length %d", len(buf))
+		}
+		if id.GUTI5G.MCC, id.GUTI5G.MNC, err = decodePLMN(buf[1:4]); err != nil {
+			return
+		}
+		id.GUTI5G.AMFRegionID = buf[4]
+		id.GUTI5G.decodeSTMSI(buf[5:])
+	case MOBILE_IDENTITY_5G_S_TMSI:
+		if len(buf) != mobileIdSTMSILen {
+			return id, fmt.Errorf("mobile identity: invalid 5G-S-TMSI length %d", len(buf))
+		}
+		id.GUTI5G.decodeSTMSI(buf[1:])
+	default:
+		err = fmt.Errorf("mobile identity: unsupported 5GS identity type %d", id.Type)
+	}
+	return
+}
+
+// GUTIFromEPSLOCI returns the GUTI stored in the content of EF_EPSLOCI
+func GUTIFromEPSLOCI(ef []byte) (g GUTI, err error) {
+	if len(ef) < efEPSLOCIGUTILen || int(ef[0]) != mobileIdGUTILen {
+		return g, errors.New("EF_EPSLOCI: no GUTI")
+	}
+	id, err := DecodeEPSMobileIdentity(ef[1:efEPSLOCIGUTILen])
+	if err == nil && id.Type != EPS_IDENTITY_GUTI {
+		err = errors.New("EF_EPSLOCI: no GUTI")
+	}
+	return id.GUTI, err
+}
+
+// GUTI5GFromLOCI returns the 5G-GUTI stored in the content of EF_5GS3GPPLOCI or EF_5GSN3GPPLOCI
+func GUTI5GFromLOCI(ef []byte) (g GUTI5G, err error) {
+	if len(ef) < ef5GSLOCIGUTILen || int(binary.BigEndian.Uint16(ef)) != mobileIdGUTILen {
+		return g, errors.New("EF_5GSLOCI: no 5G-GUTI")
+	}
+	id, err := Decode5GSMobileIdentity(ef[2:ef5GSLOCIGUTILen])
+	if err == nil && id.Type != MOBILE_IDENTITY_5G_GUTI {
+		err = errors.New("EF_5GSLOCI: no 5G-GUTI")
+	}
+	return id.GUTI5G, err
+}
+
+// IMEI returns the configured IMEI
+func (u USIM) IMEI() string {
+	return fmt.Sprintf("%015d", u.imei)
+}
+
+// IMEISV returns the IMEISV built from the IMEI without check digit and the 2 digit software version
+func (u USIM) IMEISV(svn string) string {
+	return u.IMEI()[:14] + svn
+}
+
+// NullSUCI returns the SUCI of the IMSI with the null protection scheme
+func (u USIM) NullSUCI(routingIndicator string) SUCI {
+	imsi := u.IMSI()
+	return SUCI{
+		SupiFormat:       SUPI_FORMAT_IMSI,
+		MCC:              u.mccStr,
+		MNC:              u.mncStr,
+		RoutingIndicator: routingIndicator,
+		ProtectionScheme: NullScheme,
+		SchemeOutput:     []byte(imsi[len(u.mccStr)+len(u.mncStr):]),
+	}
+}
+
+// EPSMobileIdentity returns the IMSI as EPS mobile identity
+func (u USIM) EPSMobileIdentity() ([]byte, error) {
+	return EncodeEPSMobileIdentity(MobileIdentity{Type: EPS_IDENTITY_IMSI, Digits: u.IMSI()})
+}
+
+// IMEIMobileIdentity returns the IMEI as 5GS mobile identity, also valid as EPS mobile identity
+func (u USIM) IMEIMobileIdentity() ([]byte, error) {
+	return encodeDigitIdentity(MOBILE_IDENTITY_IMEI, u.IMEI())
+}
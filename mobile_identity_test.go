@@ -0,0 +1,58 @@
+package usim_go
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMobileIdentity(t *testing.T) {
+	u, _ := testSubscriber(t)
+	buf, err := u.EPSMobileIdentity()
+	if err != nil || !bytes.Equal(buf, unhex("2980390000000010")) {
+		t.Errorf("unexpected IMSI identity %x %v", buf, err)
+	}
+	if id, err := DecodeEPSMobileIdentity(buf); err != nil || id.Digits != "208930000000001" {
+		t.Errorf("unexpected IMSI %+v %v", id, err)
+	}
+
+	imeisv := MobileIdentity{Type: MOBILE_IDENTITY_IMEISV, Digits: "3560920407930101"}
+	if buf, err = Encode5GSMobileIdentity(imeisv); err != nil || !bytes.Equal(buf, unhex("3565900204970301f1")) {
+		t.Errorf("unexpected IMEISV identity %x %v", buf, err)
+	}
+	if id, err := Decode5GSMobileIdentity(buf); err != nil || id.Digits != imeisv.Digits {
+		t.Errorf("unexpected IMEISV %+v %v", id, err)
+	}
+
+	guti := MobileIdentity{Type: EPS_IDENTITY_GUTI, GUTI: GUTI{MCC: "208", MNC: "93", MMEGroupID: 0x8001, MMECode: 0x01, MTMSI: 0xC0000001}}
+	if buf, err = EncodeEPSMobileIdentity(guti); err != nil || !bytes.Equal(buf, unhex("f602f839800101c0000001")) {
+		t.Errorf("unexpected GUTI identity %x %v", buf, err)
+	}
+	if id, err := DecodeEPSMobileIdentity(buf); err != nil || id.GUTI != guti.GUTI {
+		t.Errorf("unexpected GUTI %+v %v", id, err)
+	}
+	if g, err := GUTIFromEPSLOCI(append(append([]byte{0x0B}, buf...), unhex("02f839000100")...)); err != nil || g != guti.GUTI {
+		t.Errorf("unexpected GUTI from EF_EPSLOCI %+v %v", g, err)
+	}
+
+	g5 := MobileIdentity{Type: MOBILE_IDENTITY_5G_GUTI, GUTI5G: GUTI5G{MCC: "208", MNC: "93", AMFRegionID: 0xCA, AMFSetID: 0x3F8, AMFPointer: 0x01, TMSI: 0x00000123}}
+	if buf, err = Encode5GSMobileIdentity(g5); err != nil || !bytes.Equal(buf, unhex("f202f839cafe0100000123")) {
+		t.Errorf("unexpected 5G-GUTI identity %x %v", buf, err)
+	}
+	if g, err := GUTI5GFromLOCI(append(append([]byte{0x00, 0x0B}, buf...), unhex("02f83900000101")...)); err != nil || g != g5.GUTI5G {
+		t.Errorf("unexpected 5G-GUTI from EF_5GS3GPPLOCI %+v %v", g, err)
+	}
+	if buf, err = Encode5GSMobileIdentity(MobileIdentity{Type: MOBILE_IDENTITY_5G_S_TMSI, GUTI5G: g5.GUTI5G.STMSI()}); err != nil || !bytes.Equal(buf, unhex("f4fe0100000123")) {
+		t.Errorf("unexpected 5G-S-TMSI identity %x %v", buf, err)
+	}
+
+	// null scheme SUCI with routing indicator 0
+	if buf, err = u.NullSUCI("0").Encode(); err != nil || !bytes.Equal(buf, unhex("0102f839f0ff00000000000010")) {
+		t.Errorf("unexpected SUCI identity %x %v", buf, err)
+	}
+	if id, err := Decode5GSMobileIdentity(buf); err != nil || id.SUCI.String() != "suci-0-208-93-0-0-0-0000000001" {
+		t.Errorf("unexpected SUCI %s %v", id.SUCI, err)
+	}
+	if u.IMEI() != "356092040793011" {
+		t.Errorf("unexpected IMEI %s", u.IMEI())
+	}
+}
@@ -0,0 +1,261 @@
+package usim_go
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+// NAS authentication procedure of the UE for EPS (TS 24.301 5.4.2) and 5GS
+// (TS 24.501 5.4.1.3, 5G AKA only).
+
+const (
+	NAS_PD_EMM   = 0x07
+	NAS_EPD_5GMM = 0x7E
+
+	NAS_SECURITY_HEADER_PLAIN = 0x00
+
+	EMM_AUTHENTICATION_REQUEST  = 0x52
+	EMM_AUTHENTICATION_RESPONSE = 0x53
+	EMM_AUTHENTICATION_REJECT   = 0x54
+	EMM_AUTHENTICATION_FAILURE  = 0x5C
+
+	MM5G_AUTHENTICATION_REQUEST  = 0x56
+	MM5G_AUTHENTICATION_RESPONSE = 0x57
+	MM5G_AUTHENTICATION_REJECT   = 0x58
+	MM5G_AUTHENTICATION_FAILURE  = 0x59
+
+	NAS_CAUSE_MAC_FAILURE               = 20
+	NAS_CAUSE_SYNCH_FAILURE             = 21
+	NAS_CAUSE_NON_EPS_AUTH_UNACCEPTABLE = 26 // non-5G authentication unacceptable in 5GMM
+
+	NAS_IEI_AUTH_FAILURE_PARAM  = 0x30
+	NAS_IEI_AUTH_RESPONSE_PARAM = 0x2D
+	NAS_IEI_RAND                = 0x21
+	NAS_IEI_AUTN                = 0x20
+	NAS_IEI_EAP_MESSAGE         = 0x78
+
+	// NAS_KSI_NO_KEY is the key set identifier value "no key is available"
+	NAS_KSI_NO_KEY = 0x07
+	// nasProtectedHeaderLen is the security header, MAC and sequence number before the plain message
+	nasProtectedHeaderLen = 6
+)
+
+// NASAuthResult holds the parameters and keys of the last successful authentication
+type NASAuthResult struct {
+	FiveG bool
+	// KSI is eKSI or ngKSI, TSC in bit 4
+	KSI   byte
+	RAND  [16]byte
+	AUTN  [16]byte
+	ABBA  []byte
+	RES   []byte
+	KASME []byte
+	KAUSF []byte
+	KSEAF []byte
+	KAMF  []byte
+}
+
+// NASAuthHandler answers EMM and 5GMM Authentication Request messages
+type NASAuthHandler struct {
+	Auth AKAAuthenticator
+	// SUPI is the IMSI used for KAMF
+	SUPI string
+	MCC  string
+	MNC  string
+	// Result is set after a successful authentication
+	Result *NASAuthResult
+	// Security is the NAS security context taken into use by SecurityModeCommand
+	Security *NASSecurityContext
+	// UESecurityCapability is the UE security capability value sent to the
+	// network, the Security Mode Command has to replay it. Nil skips the check.
+	UESecurityCapability []byte
+}
+
+// NewNASAuthHandler creates a handler for u in the serving network of its IMSI
+func NewNASAuthHandler(u *USIM) *NASAuthHandler {
+	return &NASAuthHandler{Auth: u, SUPI: u.IMSI(), MCC: u.mccStr, MNC: u.mncStr, UESecurityCapability: nasUESecurityCapability}
+}
+
+// Handle processes a NAS message and returns the encoded Authentication
+// Response or Authentication Failure. err is set for Authentication Reject
+// and for messages that are not authentication requests. Protected messages
+// are verified with the current NAS security context if there is one.
+func (h *NASAuthHandler) Handle(msg []byte) (resp []byte, err error) {
+	if len(msg) < 3 {
+		return nil, errors.New("NAS: message too short")
+	}
+	if h.Security != nil {
+		if msg, _, err = h.Security.Unprotect(msg); err != nil {
+			return
+		}
+	}
+	if msg[0] == NAS_EPD_5GMM {
+		if msg[1]&0x0F != NAS_SECURITY_HEADER_PLAIN {
+			if len(msg) < nasProtectedHeaderLen+3 {
+				return nil, errors.New("NAS: protected message too short")
+			}
+			logrus.Warn("NAS: integrity of protected 5GMM message not verified")
+			msg = msg[nasProtectedHeaderLen+1:]
+		}
+		return h.handle5GMM(msg)
+	}
+	if msg[0]&0x0F == NAS_PD_EMM {
+		if msg[0]>>4 != NAS_SECURITY_HEADER_PLAIN {
+			if len(msg) < nasProtectedHeaderLen+2 {
+				return nil, errors.New("NAS: protected message too short")
+			}
+			logrus.Warn("NAS: integrity of protected EMM message not verified")
+			msg = msg[nasProtectedHeaderLen:]
+		}
+		return h.handleEMM(msg)
+	}
+	return nil, fmt.Errorf("NAS: unsupported protocol discriminator 0x%02X", msg[0])
+}
+
+// handleEMM processes a plain EMM message, the header is 2 octets
+func (h *NASAuthHandler) handleEMM(msg []byte) (resp []byte, err error) {
+	switch msg[1] {
+	case EMM_AUTHENTICATION_REJECT:
+		h.Result = nil
+		return nil, errors.New("NAS: EMM authentication reject")
+	case EMM_AUTHENTICATION_REQUEST:
+	default:
+		return nil, fmt.Errorf("NAS: unexpected EMM message type 0x%02X", msg[1])
+	}
+	// eKSI, RAND (V) and AUTN (LV)
+	if len(msg) < 3+AKA_RAND_LEN+1+AKA_AUTN_LEN || int(msg[3+AKA_RAND_LEN]) != AKA_AUTN_LEN {
+		return nil, errors.New("NAS: malformed EMM authentication request")
+	}
+	r := &NASAuthResult{KSI: msg[2] & 0x0F}
+	copy(r.RAND[:], msg[3:])
+	copy(r.AUTN[:], msg[4+AKA_RAND_LEN:])
+
+	res, ik, ck, auts, cause := h.authenticate(r)
+	if cause != 0 {
+		return nasAuthFailure([]byte{NAS_PD_EMM, EMM_AUTHENTICATION_FAILURE}, cause, auts), nil
+	}
+	var mcc, mnc uint16
+	if mcc, mnc, err = convert_mcc_mnc(h.MCC, h.MNC); err != nil {
+		return
+	}
+	r.RES = res
+	r.KASME = DeriveKASME(ck, ik, encode_plmn(mcc, mnc), r.AUTN[:SQN_LEN])
+	h.Result = r
+	resp = []byte{NAS_PD_EMM, EMM_AUTHENTICATION_RESPONSE, byte(len(res))}
+	return append(resp, res...), nil
+}
+
+// handle5GMM processes a plain 5GMM message, the header is 3 octets
+func (h *NASAuthHandler) handle5GMM(msg []byte) (resp []byte, err error) {
+	if len(msg) < 3 {
+		return nil, errors.New("NAS: 5GMM message too short")
+	}
+	switch msg[2] {
+	case MM5G_AUTHENTICATION_REJECT:
+		h.Result = nil
+		return nil, errors.New("NAS: 5GMM authentication reject")
+	case MM5G_AUTHENTICATION_REQUEST:
+	default:
+		return nil, fmt.Errorf("NAS: unexpected 5GMM message type 0x%02X", msg[2])
+	}
+	// ngKSI, ABBA (LV) of at least 2 octets, TS 24.501 9.11.3.10, then the optional IEs
+	if len(msg) < 5 {
+		return nil, errors.New("NAS: malformed 5GMM authentication request")
+	}
+	abbaLen := int(msg[4])
+	if abbaLen < 2 || len(msg) < 5+abbaLen {
+		return nil, fmt.Errorf("NAS: invalid ABBA length %d", abbaLen)
+	}
+	r := &NASAuthResult{FiveG: true, KSI: msg[3] & 0x0F}
+	r.ABBA = append([]byte{}, msg[5:5+abbaLen]...)
+	var hasRAND, hasAUTN bool
+	for p := msg[5+abbaLen:]; len(p) > 0; {
+		switch iei := p[0]; {
+		case iei == NAS_IEI_RAND && len(p) >= 1+AKA_RAND_LEN:
+			copy(r.RAND[:], p[1:])
+			hasRAND, p = true, p[1+AKA_RAND_LEN:]
+		case iei == NAS_IEI_AUTN && len(p) >= 2+AKA_AUTN_LEN && int(p[1]) == AKA_AUTN_LEN:
+			copy(r.AUTN[:], p[2:])
+			hasAUTN, p = true, p[2+AKA_AUTN_LEN:]
+		case iei == NAS_IEI_EAP_MESSAGE:
+			return nil, errors.New("NAS: EAP based 5G authentication not supported")
+		default:
+			// unknown IEs in the non-imperative part are ignored, TS 24.501 7.6.1
+			n := nas5GMMIELen(p)
+			if n == 0 {
+				return nil, fmt.Errorf("NAS: truncated IE 0x%02X in 5GMM authentication request", iei)
+			}
+			logrus.Debugf("NAS: ignoring IE 0x%02X in 5GMM authentication request", iei)
+			p = p[n:]
+		}
+	}
+	if !hasRAND || !hasAUTN {
+		return nil, errors.New("NAS: 5GMM authentication request without RAND or AUTN")
+	}
+
+	res, ik, ck, auts, cause := h.authenticate(r)
+	if cause != 0 {
+		return nasAuthFailure([]byte{NAS_EPD_5GMM, NAS_SECURITY_HEADER_PLAIN, MM5G_AUTHENTICATION_FAILURE}, cause, auts), nil
+	}
+	snName := ServingNetworkName(h.MCC, h.MNC)
+	r.RES = DeriveRESStar(ck, ik, snName, r.RAND[:], res)
+	r.KAUSF = DeriveKAUSF(ck, ik, snName, r.AUTN[:SQN_LEN])
+	r.KSEAF = DeriveKSEAF(r.KAUSF, snName)
+	r.KAMF = DeriveKAMF(r.KSEAF, h.SUPI, r.ABBA)
+	h.Result = r
+	resp = []byte{NAS_EPD_5GMM, NAS_SECURITY_HEADER_PLAIN, MM5G_AUTHENTICATION_RESPONSE, NAS_IEI_AUTH_RESPONSE_PARAM, byte(len(r.RES))}
+	return append(resp, r.RES...), nil
+}
+
+// nas5GMMIELen returns the length of the optional IE at the start of p by the
+// format its IEI implies, TS 24.007 11.2.4: bit 8 set for type 1 and 2 (one
+// octet), 0x7X for TLV-E and TLV otherwise. It is 0 if p is too short.
+func nas5GMMIELen(p []byte) int {
+	n := 1
+	switch {
+	case p[0]&0x80 != 0:
+	case p[0]&0xF0 == 0x70:
+		if len(p) < 3 {
+			return 0
+		}
+		n = 3 + (int(p[1])<<8 | int(p[2]))
+	default:
+		if len(p) < 2 {
+			return 0
+		}
+		n = 2 + int(p[1])
+	}
+	if n > len(p) {
+		return 0
+	}
+	return n
+}
+
+// authenticate runs AKA and returns the failure cause, 0 on success
+func (h *NASAuthHandler) authenticate(r *NASAuthResult) (res, ik, ck, auts []byte, cause byte) {
+	res, ik, ck, auts, err := h.Auth.GenAuthResMilenage(r.RAND, r.AUTN)
+	if err == UNSYNC {
+		logrus.Info("NAS: synch failure")
+		return nil, nil, nil, auts, NAS_CAUSE_SYNCH_FAILURE
+	} else if err != nil {
+		logrus.Info("NAS: MAC failure: ", err)
+		return nil, nil, nil, nil, NAS_CAUSE_MAC_FAILURE
+	}
+	// the AMF separation bit marks EPS and 5G vectors, TS 33.401 6.1.1 and TS 33.501 6.1.3.2
+	if r.AUTN[6]&0x80 == 0 {
+		logrus.Info("NAS: AMF separation bit not set")
+		return nil, nil, nil, nil, NAS_CAUSE_NON_EPS_AUTH_UNACCEPTABLE
+	}
+	return res, ik, ck, nil, 0
+}
+
+func nasAuthFailure(header []byte, cause byte, auts []byte) []byte {
+	msg := append(header, cause)
+	if cause == NAS_CAUSE_SYNCH_FAILURE {
+		msg = append(msg, NAS_IEI_AUTH_FAILURE_PARAM, byte(len(auts)))
+		msg = append(msg, auts...)
+	}
+	return msg
+}
@@ -0,0 +1,88 @@
+package usim_go
+
+import (
+	"bytes"
+	"testing"
+)
+
+func emmAuthRequest(rand, autn [16]byte) []byte {
+	msg := []byte{NAS_PD_EMM, EMM_AUTHENTICATION_REQUEST, 0x01}
+	msg = append(msg, rand[:]...)
+	msg = append(msg, 16)
+	return append(msg, autn[:]...)
+}
+
+func TestNASAuthHandler(t *testing.T) {
+	u, store := testSubscriber(t)
+	h := NewNASAuthHandler(u)
+
+	var ev EUTRANVector
+	store.Lookup("208930000000001", func(a *AuC) (err error) {
+		ev, err = a.GenEUTRANVector("208", "93")
+		return
+	})
+	resp, err := h.Handle(emmAuthRequest(ev.RAND, ev.AUTN))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(resp, append([]byte{NAS_PD_EMM, EMM_AUTHENTICATION_RESPONSE, byte(len(ev.XRES))}, ev.XRES...)) {
+		t.Errorf("unexpected EMM response %x", resp)
+	}
+	if h.Result.KSI != 1 || !bytes.Equal(h.Result.KASME, ev.KASME[:]) {
+		t.Errorf("KASME mismatch %x", h.Result.KASME)
+	}
+
+	var v HEAV
+	store.Lookup("208930000000001", func(a *AuC) (err error) {
+		v, err = a.GenHEAV(ServingNetworkName("208", "93"))
+		return
+	})
+	msg := []byte{NAS_EPD_5GMM, NAS_SECURITY_HEADER_PLAIN, MM5G_AUTHENTICATION_REQUEST, 0x02, 0x02, 0x00, 0x00}
+	// unknown type 1, TLV and TLV-E IEs are skipped
+	msg = append(msg, 0xA1, 0x55, 0x01, 0xAA, 0x7A, 0x00, 0x01, 0xBB, NAS_IEI_RAND)
+	msg = append(msg, v.RAND[:]...)
+	msg = append(msg, NAS_IEI_AUTN, 16)
+	msg = append(msg, v.AUTN[:]...)
+	if resp, err = h.Handle(msg); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(resp[5:], v.XRESStar[:]) || resp[2] != MM5G_AUTHENTICATION_RESPONSE || !bytes.Equal(h.Result.KAUSF, v.KAUSF[:]) {
+		t.Errorf("unexpected 5GMM response %x", resp)
+	}
+	if !bytes.Equal(h.Result.KAMF, DeriveKAMF(DeriveKSEAF(v.KAUSF[:], ServingNetworkName("208", "93")), "208930000000001", []byte{0, 0})) {
+		t.Error("KAMF mismatch")
+	}
+
+	// MAC failure
+	bad := ev.AUTN
+	bad[15] ^= 0x01
+	if resp, err = h.Handle(emmAuthRequest(ev.RAND, bad)); err != nil || !bytes.Equal(resp, []byte{NAS_PD_EMM, EMM_AUTHENTICATION_FAILURE, NAS_CAUSE_MAC_FAILURE}) {
+		t.Errorf("unexpected MAC failure response %x %v", resp, err)
+	}
+
+	// vectors without the AMF separation bit are not for EPS
+	store.Add("208930000000001", "8BAF473F2F8FD09487CCCBD7097C6862", "", "8e27b6af0e692e750f32667a3b14605d", "0000", 0x2000)
+	var q Quintet
+	store.Lookup("208930000000001", func(a *AuC) (err error) {
+		q, err = a.GenQuintet()
+		return
+	})
+	if resp, err = h.Handle(emmAuthRequest(q.RAND, q.AUTN)); err != nil || resp[2] != NAS_CAUSE_NON_EPS_AUTH_UNACCEPTABLE {
+		t.Errorf("unexpected response %x %v", resp, err)
+	}
+
+	for _, m := range [][]byte{
+		{NAS_EPD_5GMM, 0x02, 0, 0, 0, 0, 0, 0, 0},
+		{NAS_EPD_5GMM, NAS_SECURITY_HEADER_PLAIN, MM5G_AUTHENTICATION_REQUEST, 0x02},
+		{NAS_EPD_5GMM, NAS_SECURITY_HEADER_PLAIN, MM5G_AUTHENTICATION_REQUEST, 0x02, 0x03, 0x00, 0x00},
+		{NAS_EPD_5GMM, NAS_SECURITY_HEADER_PLAIN, MM5G_AUTHENTICATION_REQUEST, 0x02, 0x02, 0x00, 0x00, 0x7A, 0x00, 0x02, 0xBB},
+	} {
+		if _, err = h.Handle(m); err == nil {
+			t.Errorf("malformed message %x accepted", m)
+		}
+	}
+
+	if _, err = h.Handle([]byte{NAS_EPD_5GMM, NAS_SECURITY_HEADER_PLAIN, MM5G_AUTHENTICATION_REJECT}); err == nil || h.Result != nil {
+		t.Error("authentication reject not reported")
+	}
+}
@@ -0,0 +1,255 @@
+package usim_go
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"errors"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+// NAS security of TS 33.401 and TS 33.501 with the security protected NAS
+// messages of TS 24.301 9.1 and TS 24.501 9.1.
+
+const (
+	NAS_SECURITY_HEADER_INTEGRITY            = 0x01
+	NAS_SECURITY_HEADER_INTEGRITY_CIPHERED   = 0x02
+	NAS_SECURITY_HEADER_INTEGRITY_NEW        = 0x03
+	NAS_SECURITY_HEADER_INTEGRITY_CIPHER_NEW = 0x04
+
+	NAS_SECURITY_MODE_COMMAND  = 0x5D
+	NAS_SECURITY_MODE_COMPLETE = 0x5E
+	NAS_SECURITY_MODE_REJECT   = 0x5F
+
+	// algorithm type distinguishers of TS 33.401 A.7 and TS 33.501 A.8
+	ALG_NAS_ENC = 0x01
+	ALG_NAS_INT = 0x02
+	ALG_RRC_ENC = 0x03
+	ALG_RRC_INT = 0x04
+	ALG_UP_ENC  = 0x05
+	ALG_UP_INT  = 0x06
+
+	ACCESS_TYPE_3GPP     = 0x01
+	ACCESS_TYPE_NON_3GPP = 0x02
+
+	NAS_CAUSE_UE_SECURITY_CAPABILITIES_MISMATCH = 23
+	NAS_CAUSE_SECURITY_MODE_REJECTED            = 24
+)
+
+// nasUESecurityCapability is the UE security capability of the algorithms
+// implemented here, EEA0-3 and 128-EIA1-3 or NEA0-3 and 128-NIA1-3, see TS
+// 24.301 9.9.3.36 and TS 24.501 9.11.3.54
+var nasUESecurityCapability = []byte{0xF0, 0x70}
+
+// NASCount is the 24 bit NAS COUNT, NAS overflow || NAS sequence number
+type NASCount uint32
+
+func (c NASCount) Overflow() uint16 {
+	return uint16(c >> 8)
+}
+
+func (c NASCount) SQN() byte {
+	return byte(c)
+}
+
+// Estimate returns the NAS COUNT of a received sequence number given the next
+// expected count c, the overflow is incremented when sqn wrapped around
+func (c NASCount) Estimate(sqn byte) NASCount {
+	n := c&0xFFFF00 | NASCount(sqn)
+	if n < c {
+		n += 0x100
+	}
+	return n & 0xFFFFFF
+}
+
+// NASSecurityContext holds the NAS keys, algorithms and counts of an EPS or 5G
+// NAS security context. UL and DL are the next counts to send and expect.
+type NASSecurityContext struct {
+	FiveG   bool
+	KSI     byte
+	EncAlg  byte
+	IntAlg  byte
+	KNASenc []byte
+	KNASint []byte
+	UL      NASCount
+	DL      NASCount
+	// Bearer is 0 in EPS and the NAS connection identifier in 5GS
+	Bearer byte
+	// Network marks the context of the MME or AMF, which sends downlink
+	Network bool
+	// KASME is KASME in EPS and KAMF in 5GS
+	KASME []byte
+}
+
+// NewNASSecurityContext derives the NAS keys for the selected algorithms from
+// KASME (EPS) or KAMF (5GS) of an authentication result
+func NewNASSecurityContext(r *NASAuthResult, encAlg, intAlg byte) *NASSecurityContext {
+	c := &NASSecurityContext{FiveG: r.FiveG, KSI: r.KSI, EncAlg: encAlg, IntAlg: intAlg, KASME: r.KASME}
+	fc := byte(FC_ALG_KEY)
+	if r.FiveG {
+		fc, c.KASME = FC_ALG_KEY_5G, r.KAMF
+	}
+	c.KNASenc = DeriveAlgorithmKey(c.KASME, fc, ALG_NAS_ENC, encAlg)
+	c.KNASint = DeriveAlgorithmKey(c.KASME, fc, ALG_NAS_INT, intAlg)
+	return c
+}
+
+// ASKey returns KeNB (EPS) or KgNB (5GS, 3GPP access) for the uplink NAS COUNT
+// of the message that triggered the AS security setup
+func (c *NASSecurityContext) ASKey(ulNASCount NASCount) []byte {
+	if c.FiveG {
+		return DeriveKgNB(c.KASME, uint32(ulNASCount), ACCESS_TYPE_3GPP)
+	}
+	return DeriveKeNB(c.KASME, uint32(ulNASCount))
+}
+
+func (c *NASSecurityContext) directions() (send, recv byte) {
+	if c.Network {
+		return DIRECTION_DOWNLINK, DIRECTION_UPLINK
+	}
+	return DIRECTION_UPLINK, DIRECTION_DOWNLINK
+}
+
+// nasMAC computes the NAS-MAC over the sequence number and the (ciphered) message
+func (c *NASSecurityContext) nasMAC(count NASCount, dir byte, sqnMsg []byte) ([4]byte, error) {
+	return EIA(c.IntAlg, c.KNASint, uint32(count), c.Bearer, dir, sqnMsg, len(sqnMsg)*8)
+}
+
+// Protect builds a security protected NAS message of header type sht around
+// the plain NAS message msg and increments the send count
+func (c *NASSecurityContext) Protect(msg []byte, sht byte) (out []byte, err error) {
+	if sht < NAS_SECURITY_HEADER_INTEGRITY || sht > NAS_SECURITY_HEADER_INTEGRITY_CIPHER_NEW {
+		return nil, fmt.Errorf("NAS: invalid security header type %d", sht)
+	}
+	send, _ := c.directions()
+	count := &c.UL
+	if c.Network {
+		count = &c.DL
+	}
+	sqnMsg := append([]byte{count.SQN()}, msg...)
+	if sht == NAS_SECURITY_HEADER_INTEGRITY_CIPHERED || sht == NAS_SECURITY_HEADER_INTEGRITY_CIPHER_NEW {
+		var ct []byte
+		if ct, err = EEA(c.EncAlg, c.KNASenc, uint32(*count), c.Bearer, send, msg, len(msg)*8); err != nil {
+			return
+		}
+		copy(sqnMsg[1:], ct)
+	}
+	mac, err := c.nasMAC(*count, send, sqnMsg)
+	if err != nil {
+		return
+	}
+	if c.FiveG {
+		out = []byte{NAS_EPD_5GMM, sht}
+	} else {
+		out = []byte{sht<<4 | NAS_PD_EMM}
+	}
+	out = append(out, mac[:]...)
+	*count = (*count + 1) & 0xFFFFFF
+	return append(out, sqnMsg...), nil
+}
+
+// Unprotect verifies and deciphers a security protected NAS message and
+// returns the plain NAS message. Plain messages are returned unchanged with
+// sht 0, whether they are acceptable is up to the caller.
+func (c *NASSecurityContext) Unprotect(msg []byte) (plain []byte, sht byte, err error) {
+	var hdr int
+	switch {
+	case len(msg) > 2 && msg[0] == NAS_EPD_5GMM && c.FiveG:
+		sht, hdr = msg[1]&0x0F, 2
+	case len(msg) > 1 && msg[0]&0x0F == NAS_PD_EMM && !c.FiveG:
+		sht, hdr = msg[0]>>4, 1
+	default:
+		return nil, 0, errors.New("NAS: message does not match the security context")
+	}
+	if sht == NAS_SECURITY_HEADER_PLAIN {
+		return msg, sht, nil
+	}
+	if sht > NAS_SECURITY_HEADER_INTEGRITY_CIPHER_NEW {
+		return nil, sht, fmt.Errorf("NAS: invalid security header type %d", sht)
+	}
+	if len(msg) < hdr+5+2 {
+		return nil, sht, errors.New("NAS: protected message too short")
+	}
+	_, recv := c.directions()
+	expect := &c.DL
+	if c.Network {
+		expect = &c.UL
+	}
+	sqnMsg := msg[hdr+4:]
+	count := expect.Estimate(sqnMsg[0])
+	mac, err := c.nasMAC(count, recv, sqnMsg)
+	if err != nil {
+		return
+	}
+	if c.IntAlg != EIA0 && !hmac.Equal(mac[:], msg[hdr:hdr+4]) {
+		logrus.Warnf("NAS: MAC mismatch for NAS COUNT %06X", uint32(count))
+		return nil, sht, errors.New("NAS: integrity check failed")
+	}
+	plain = sqnMsg[1:]
+	if sht == NAS_SECURITY_HEADER_INTEGRITY_CIPHERED || sht == NAS_SECURITY_HEADER_INTEGRITY_CIPHER_NEW {
+		if plain, err = EEA(c.EncAlg, c.KNASenc, uint32(count), c.Bearer, recv, plain, len(plain)*8); err != nil {
+			return
+		}
+	}
+	*expect = (count + 1) & 0xFFFFFF
+	return
+}
+
+// SecurityModeCommand takes a new NAS security context into use with the
+// algorithms selected by a protected EMM or 5GMM Security Mode Command and
+// returns the protected Security Mode Complete, see TS 24.301 5.4.3 and TS
+// 24.501 5.4.2. A Security Mode Reject is returned with err set if the
+// command cannot be accepted, e.g. if the replayed UE security capabilities
+// differ from h.UESecurityCapability.
+func (h *NASAuthHandler) SecurityModeCommand(msg []byte) (resp []byte, err error) {
+	if h.Result == nil {
+		return nil, errors.New("NAS: security mode command without authentication")
+	}
+	fiveG := len(msg) > 1 && msg[0] == NAS_EPD_5GMM
+	reject := []byte{NAS_PD_EMM, NAS_SECURITY_MODE_REJECT, NAS_CAUSE_SECURITY_MODE_REJECTED}
+	hdr, sht := 1, byte(0)
+	if fiveG {
+		reject = []byte{NAS_EPD_5GMM, NAS_SECURITY_HEADER_PLAIN, NAS_SECURITY_MODE_REJECT, NAS_CAUSE_SECURITY_MODE_REJECTED}
+		hdr, sht = 2, msg[1]&0x0F
+	} else if len(msg) > 0 {
+		sht = msg[0] >> 4
+	}
+	if fiveG != h.Result.FiveG {
+		return reject, errors.New("NAS: security mode command for another system")
+	}
+	// security header, MAC and SQN, then the plain header, selected algorithms,
+	// KSI and the replayed UE security capabilities
+	plainHdr := len(reject) - 1
+	if len(msg) < hdr+5+plainHdr+3 || sht != NAS_SECURITY_HEADER_INTEGRITY_NEW {
+		return reject, errors.New("NAS: malformed security mode command")
+	}
+	inner := msg[hdr+5:]
+	capEnd := plainHdr + 3 + int(inner[plainHdr+2])
+	if capEnd > len(inner) {
+		return reject, errors.New("NAS: malformed security mode command")
+	}
+	if inner[plainHdr-1] != NAS_SECURITY_MODE_COMMAND {
+		return reject, fmt.Errorf("NAS: unexpected message type 0x%02X", inner[plainHdr-1])
+	}
+	algs, ksi := inner[plainHdr], inner[plainHdr+1]&0x0F
+	if ksi != h.Result.KSI {
+		return reject, fmt.Errorf("NAS: security mode command for KSI %d, have %d", ksi, h.Result.KSI)
+	}
+	c := NewNASSecurityContext(h.Result, algs>>4&0x07, algs&0x07)
+	if _, _, err = c.Unprotect(msg); err != nil {
+		return reject, err
+	}
+	// TS 24.301 5.4.3.5 and TS 24.501 5.4.2.5
+	if replayed := inner[plainHdr+3 : capEnd]; h.UESecurityCapability != nil && !bytes.Equal(replayed, h.UESecurityCapability) {
+		reject[len(reject)-1] = NAS_CAUSE_UE_SECURITY_CAPABILITIES_MISMATCH
+		return reject, fmt.Errorf("NAS: replayed UE security capabilities %X differ from %X", replayed, h.UESecurityCapability)
+	}
+	h.Security = c
+	complete := []byte{NAS_PD_EMM, NAS_SECURITY_MODE_COMPLETE}
+	if fiveG {
+		complete = []byte{NAS_EPD_5GMM, NAS_SECURITY_HEADER_PLAIN, NAS_SECURITY_MODE_COMPLETE}
+	}
+	logrus.Infof("NAS: security mode complete with EEA%d/EIA%d", c.EncAlg, c.IntAlg)
+	return c.Protect(complete, NAS_SECURITY_HEADER_INTEGRITY_CIPHER_NEW)
+}
@@ -0,0 +1,98 @@
+package usim_go
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestNASCountEstimate(t *testing.T) {
+	for _, v := range []struct {
+		next NASCount
+		sqn  byte
+		want NASCount
+	}{
+		{0x000000, 0x00, 0x000000},
+		{0x000010, 0x12, 0x000012},
+		{0x0001FE, 0x01, 0x000201},
+		{0xFFFFFF, 0x00, 0x000000},
+	} {
+		if n := v.next.Estimate(v.sqn); n != v.want {
+			t.Errorf("Estimate(%06X, %02X) = %06X", uint32(v.next), v.sqn, uint32(n))
+		}
+	}
+}
+
+func TestNASProtect(t *testing.T) {
+	r := &NASAuthResult{FiveG: true, KAMF: unhex("2bd6459f82c5b300952c49104881ff482bd6459f82c5b300952c49104881ff48")}
+	msg := []byte{NAS_EPD_5GMM, NAS_SECURITY_HEADER_PLAIN, 0x41, 0x79, 0x00}
+	for alg := byte(0); alg < 4; alg++ {
+		ue, amf := NewNASSecurityContext(r, alg, alg), NewNASSecurityContext(r, alg, alg)
+		amf.Network = true
+		for i := 0; i < 300; i++ {
+			p, err := ue.Protect(msg, NAS_SECURITY_HEADER_INTEGRITY_CIPHERED)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if alg != EEA0 && bytes.Equal(p[7:], msg) {
+				t.Fatalf("NEA%d: message not ciphered", alg)
+			}
+			plain, sht, err := amf.Unprotect(p)
+			if err != nil || sht != NAS_SECURITY_HEADER_INTEGRITY_CIPHERED || !bytes.Equal(plain, msg) {
+				t.Fatalf("NEA%d/NIA%d: count %d: %x %v", alg, alg, i, plain, err)
+			}
+		}
+		if ue.UL != 300 || amf.UL != 300 {
+			t.Errorf("unexpected uplink NAS COUNT %d %d", ue.UL, amf.UL)
+		}
+		p, _ := ue.Protect(msg, NAS_SECURITY_HEADER_INTEGRITY)
+		p[3] ^= 0x01
+		if _, _, err := amf.Unprotect(p); (err == nil) != (alg == EIA0) {
+			t.Errorf("NIA%d: modified MAC not detected", alg)
+		}
+	}
+}
+
+func TestNASSecurityModeCommand(t *testing.T) {
+	u, store := testSubscriber(t)
+	h := NewNASAuthHandler(u)
+	var ev EUTRANVector
+	store.Lookup("208930000000001", func(a *AuC) (err error) {
+		ev, err = a.GenEUTRANVector("208", "93")
+		return
+	})
+	if _, err := h.Handle(emmAuthRequest(ev.RAND, ev.AUTN)); err != nil {
+		t.Fatal(err)
+	}
+
+	mme := NewNASSecurityContext(&NASAuthResult{KSI: 1, KASME: ev.KASME[:]}, EEA2, EIA2)
+	mme.Network = true
+	smc, _ := mme.Protect([]byte{NAS_PD_EMM, NAS_SECURITY_MODE_COMMAND, EEA2<<4 | EIA2, 0x01, 0x02, 0xF0, 0x70}, NAS_SECURITY_HEADER_INTEGRITY_NEW)
+	bad := append([]byte{}, smc...)
+	bad[len(bad)-1] ^= 0x01
+	if resp, err := h.SecurityModeCommand(bad); err == nil || resp[1] != NAS_SECURITY_MODE_REJECT {
+		t.Errorf("modified security mode command accepted: %x", resp)
+	}
+	// capabilities other than those sent, e.g. after a bidding down attack
+	mismatch, _ := mme.Protect([]byte{NAS_PD_EMM, NAS_SECURITY_MODE_COMMAND, EEA2<<4 | EIA2, 0x01, 0x02, 0x80, 0x20}, NAS_SECURITY_HEADER_INTEGRITY_NEW)
+	if resp, err := h.SecurityModeCommand(mismatch); err == nil || !bytes.Equal(resp, []byte{NAS_PD_EMM, NAS_SECURITY_MODE_REJECT, NAS_CAUSE_UE_SECURITY_CAPABILITIES_MISMATCH}) {
+		t.Errorf("security mode command with other capabilities accepted: %x", resp)
+	}
+	if h.Security != nil {
+		t.Fatal("security context taken into use after reject")
+	}
+	resp, err := h.SecurityModeCommand(smc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, sht, err := mme.Unprotect(resp)
+	if err != nil || sht != NAS_SECURITY_HEADER_INTEGRITY_CIPHER_NEW || !bytes.Equal(plain, []byte{NAS_PD_EMM, NAS_SECURITY_MODE_COMPLETE}) {
+		t.Errorf("unexpected security mode complete %x %v", plain, err)
+	}
+
+	// authentication requests are now verified with the new context
+	req, _ := mme.Protect(emmAuthRequest(ev.RAND, ev.AUTN), NAS_SECURITY_HEADER_INTEGRITY_CIPHERED)
+	req[2] ^= 0x01
+	if _, err = h.Handle(req); err == nil {
+		t.Error("modified protected message accepted")
+	}
+}
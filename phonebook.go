@@ -0,0 +1,605 @@
+package usim_go
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+// USIM phonebook of TS 31.102 4.4.2, the files of DF_PHONEBOOK are found
+// through the phonebook reference file EF_PBR
+
+// PBR templates of TS 31.102 4.4.2.1
+const (
+	// records of the file match the records of EF_ADN
+	PBR_TYPE1 = 0xA8
+	// records of the file are reached through EF_IAP
+	PBR_TYPE2 = 0xA9
+	// records of the file are reached through a record identifier, e.g. EF_EXT1
+	PBR_TYPE3 = 0xAA
+)
+
+// File tags of the PBR templates
+const (
+	PB_TAG_ADN   = 0xC0
+	PB_TAG_IAP   = 0xC1
+	PB_TAG_EXT1  = 0xC2
+	PB_TAG_SNE   = 0xC3
+	PB_TAG_ANR   = 0xC4
+	PB_TAG_PBC   = 0xC5
+	PB_TAG_GRP   = 0xC6
+	PB_TAG_AAS   = 0xC7
+	PB_TAG_GAS   = 0xC8
+	PB_TAG_UID   = 0xC9
+	PB_TAG_EMAIL = 0xCA
+	PB_TAG_CCP1  = 0xCB
+)
+
+var pbFileNames = map[byte]string{
+	PB_TAG_ADN:   "EF_ADN",
+	PB_TAG_IAP:   "EF_IAP",
+	PB_TAG_EXT1:  "EF_EXT1",
+	PB_TAG_SNE:   "EF_SNE",
+	PB_TAG_ANR:   "EF_ANR",
+	PB_TAG_PBC:   "EF_PBC",
+	PB_TAG_GRP:   "EF_GRP",
+	PB_TAG_AAS:   "EF_AAS",
+	PB_TAG_GAS:   "EF_GAS",
+	PB_TAG_UID:   "EF_UID",
+	PB_TAG_EMAIL: "EF_EMAIL",
+	PB_TAG_CCP1:  "EF_CCP1",
+}
+
+// PBRFile is a file of a PBR template, SFI is 0 if the file has none
+type PBRFile struct {
+	Template byte
+	Tag      byte
+	FID      uint16
+	SFI      byte
+}
+
+// PBRSet is one record of EF_PBR, the files of one set of ADN records
+type PBRSet struct {
+	Files []PBRFile
+}
+
+// file returns the first file with the tag
+func (s PBRSet) file(tag byte) (PBRFile, bool) {
+	for _, f := range s.Files {
+		if f.Tag == tag {
+			return f, true
+		}
+	}
+	return PBRFile{}, false
+}
+
+// filesOf returns the files with the tag in PBR order
+func (s PBRSet) filesOf(tag byte) (files []PBRFile) {
+	for _, f := range s.Files {
+		if f.Tag == tag {
+			files = append(files, f)
+		}
+	}
+	return
+}
+
+// iapIndex returns the position of a type 2 file in the EF_IAP records
+func (s PBRSet) iapIndex(f PBRFile) int {
+	n := 0
+	for _, g := range s.Files {
+		if g.Template != PBR_TYPE2 {
+			continue
+		}
+		if g == f {
+			return n
+		}
+		n++
+	}
+	return -1
+}
+
+// pbr holds the sets of EF_PBR, empty records are dropped
+type pbr struct {
+	Sets []PBRSet
+}
+
+func (p *pbr) decode(name string, records [][]byte) error {
+	p.Sets = nil
+	for n, r := range records {
+		if isEmptyRecord(r) {
+			continue
+		}
+		var set PBRSet
+		for i := 0; i+2 <= len(r) && r[i] != 0xFF; {
+			tmpl, l := r[i], int(r[i+1])
+			if i+2+l > len(r) {
+				return fmt.Errorf("%s: record %d: template %02X too long", name, n+1, tmpl)
+			}
+			v := r[i+2 : i+2+l]
+			for j := 0; j+2 <= len(v); {
+				tag, fl := v[j], int(v[j+1])
+				if fl < 2 || j+2+fl > len(v) {
+					return fmt.Errorf("%s: record %d: invalid file %02X", name, n+1, tag)
+				}
+				f := PBRFile{Template: tmpl, Tag: tag, FID: uint16(v[j+2])<<8 | uint16(v[j+3])}
+				if fl > 2 {
+					f.SFI = v[j+4]
+				}
+				set.Files = append(set.Files, f)
+				j += 2 + fl
+			}
+			i += 2 + l
+		}
+		p.Sets = append(p.Sets, set)
+	}
+	return nil
+}
+
+func (p *pbr) encode() ([][]byte, error) {
+	records := make([][]byte, len(p.Sets))
+	for n, set := range p.Sets {
+		var r []byte
+		for _, tmpl := range []byte{PBR_TYPE1, PBR_TYPE2, PBR_TYPE3} {
+			var v []byte
+			for _, f := range set.Files {
+				if f.Template != tmpl {
+					continue
+				}
+				if f.SFI != 0 {
+					v = append(v, f.Tag, 3, byte(f.FID>>8), byte(f.FID), f.SFI)
+				} else {
+					v = append(v, f.Tag, 2, byte(f.FID>>8), byte(f.FID))
+				}
+			}
+			if len(v) > 0 {
+				r = append(append(r, tmpl, byte(len(v))), v...)
+			}
+		}
+		records[n] = r
+	}
+	return records, nil
+}
+
+// EFPBR is EF_PBR of the global phonebook in DF_TELECOM, TS 31.102 4.4.2.1
+type EFPBR struct{ pbr }
+
+func (e *EFPBR) Info() EFInfo                  { return efInfo("EF_PBR") }
+func (e *EFPBR) Decode(records [][]byte) error { return e.decode("EF_PBR", records) }
+func (e *EFPBR) Encode() ([][]byte, error)     { return e.encode() }
+
+// EFPBRLocal is EF_PBR of the local phonebook in ADF_USIM
+type EFPBRLocal struct{ pbr }
+
+func (e *EFPBRLocal) Info() EFInfo                  { return efInfo("EF_PBR_LOCAL") }
+func (e *EFPBRLocal) Decode(records [][]byte) error { return e.decode("EF_PBR_LOCAL", records) }
+func (e *EFPBRLocal) Encode() ([][]byte, error)     { return e.encode() }
+
+// Contact is an entry of the USIM phonebook. Index counts the ADN records
+// over all PBR sets from 1, 0 for contacts not written yet. Numbers with a
+// leading "+" are international.
+type Contact struct {
+	Index             int
+	Name              string
+	Number            string
+	AdditionalNumbers []string
+	Emails            []string
+	SecondName        string
+}
+
+// phonebook is an opened DF_PHONEBOOK
+type phonebook struct {
+	u      *USIM
+	path   []uint16
+	prefix string
+	sets   []PBRSet
+}
+
+func (p *phonebook) info(f PBRFile) EFInfo {
+	return EFInfo{
+		Name:      fmt.Sprintf("%s/%s(%04X)", p.prefix, pbFileNames[f.Tag], f.FID),
+		FID:       f.FID,
+		Path:      p.path,
+		Structure: EF_LINEAR_FIXED,
+	}
+}
+
+func (p *phonebook) read(f PBRFile) ([][]byte, error) {
+	return p.u.readEFInfo(p.info(f))
+}
+
+func (p *phonebook) write(f PBRFile, records [][]byte) error {
+	return p.u.updateEFInfo(p.info(f), records)
+}
+
+// openPhonebook reads EF_PBR of the local phonebook if the USIM has one, and
+// of the global phonebook otherwise
+func (u *USIM) openPhonebook() (*phonebook, error) {
+	if u.HasService(SERVICE_LOCAL_PHONE_BOOK) {
+		f, err := ReadEF[EFPBRLocal](u)
+		if err == nil {
+			return &phonebook{u, pathLocalPhonebook, "ADF_USIM/DF_PHONEBOOK", f.Sets}, nil
+		}
+		logrus.Debug("local phonebook not available: ", err)
+	}
+	f, err := ReadEF[EFPBR](u)
+	if err != nil {
+		return nil, err
+	}
+	return &phonebook{u, pathPhonebook, "DF_PHONEBOOK", f.Sets}, nil
+}
+
+// pbSet holds the records of the files of one PBR set
+type pbSet struct {
+	PBRSet
+	records map[PBRFile][][]byte
+	dirty   map[PBRFile]bool
+}
+
+func (p *phonebook) load(set PBRSet) (*pbSet, error) {
+	s := &pbSet{set, map[PBRFile][][]byte{}, map[PBRFile]bool{}}
+	for _, f := range set.Files {
+		switch f.Tag {
+		case PB_TAG_ADN, PB_TAG_IAP, PB_TAG_EXT1, PB_TAG_SNE, PB_TAG_ANR, PB_TAG_EMAIL:
+		default:
+			continue
+		}
+		records, err := p.read(f)
+		if err != nil {
+			if f.Tag == PB_TAG_ADN {
+				return nil, err
+			}
+			logrus.Warnf("phonebook: %s not read: %v", p.info(f).Name, err)
+			continue
+		}
+		if f.Tag == PB_TAG_ADN {
+			for i, r := range records {
+				if len(r) < dialNumberLen {
+					return nil, fmt.Errorf("phonebook: ADN record %d too short", i+1)
+				}
+			}
+		}
+		s.records[f] = records
+	}
+	return s, nil
+}
+
+func (p *phonebook) store(s *pbSet) error {
+	for _, f := range s.Files {
+		if s.dirty[f] {
+			if err := p.write(f, s.records[f]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *pbSet) adn() [][]byte {
+	f, _ := s.file(PB_TAG_ADN)
+	return s.records[f]
+}
+
+func (s *pbSet) ext() [][]byte {
+	f, _ := s.file(PB_TAG_EXT1)
+	return s.records[f]
+}
+
+// linked returns the record of file f for ADN record rec, 0 if none
+func (s *pbSet) linked(f PBRFile, rec int) int {
+	if f.Template == PBR_TYPE1 {
+		return rec
+	}
+	iap, ok := s.file(PB_TAG_IAP)
+	if !ok || rec > len(s.records[iap]) {
+		return 0
+	}
+	i := s.iapIndex(f)
+	if r := s.records[iap][rec-1]; i < len(r) && r[i] != 0xFF && r[i] != 0 {
+		return int(r[i])
+	}
+	return 0
+}
+
+// dataLen is the length of a record of f without the ADN reference of type 2 files
+func dataLen(f PBRFile, r []byte) int {
+	if f.Template == PBR_TYPE2 && len(r) >= 2 {
+		return len(r) - 2
+	}
+	return len(r)
+}
+
+func (s *pbSet) contact(rec int) (c Contact, ok bool) {
+	d, empty, err := decodeDialNumber(s.adn()[rec-1], len(s.adn()[rec-1])-dialNumberLen)
+	if err != nil {
+		logrus.Warnf("phonebook: ADN record %d: %v", rec, err)
+		return c, false
+	}
+	if empty {
+		return c, false
+	}
+	if d.ext != noExtension {
+		digits, _, err := extChain(s.ext(), d.ext)
+		if err != nil {
+			logrus.Warnf("phonebook: ADN record %d: %v", rec, err)
+		}
+		d.Number += digits
+	}
+	c.Name, c.Number = d.Alpha, d.String()
+	for _, f := range s.filesOf(PB_TAG_ANR) {
+		if n := s.linked(f, rec); n > 0 && n <= len(s.records[f]) {
+			r := s.records[f][n-1]
+			if len(r) < 1+dialNumberLen {
+				continue
+			}
+			a, empty, err := decodeDialNumber(r[1:1+dialNumberLen], 0)
+			if err != nil || empty || a.Number == "" {
+				continue
+			}
+			if a.ext != noExtension {
+				digits, _, _ := extChain(s.ext(), a.ext)
+				a.Number += digits
+			}
+			c.AdditionalNumbers = append(c.AdditionalNumbers, a.String())
+		}
+	}
+	for _, f := range s.filesOf(PB_TAG_EMAIL) {
+		if n := s.linked(f, rec); n > 0 && n <= len(s.records[f]) {
+			r := s.records[f][n-1]
+			if e := decodeAlphaID(r[:dataLen(f, r)]); e != "" {
+				c.Emails = append(c.Emails, e)
+			}
+		}
+	}
+	if f, ok := s.file(PB_TAG_SNE); ok {
+		if n := s.linked(f, rec); n > 0 && n <= len(s.records[f]) {
+			r := s.records[f][n-1]
+			c.SecondName = decodeAlphaID(r[:dataLen(f, r)])
+		}
+	}
+	return c, true
+}
+
+// ReadPhonebook returns the contacts of the USIM phonebook with their
+// additional numbers, e-mail addresses and second names
+func (u *USIM) ReadPhonebook() (contacts []Contact, err error) {
+	p, err := u.openPhonebook()
+	if err != nil {
+		return
+	}
+	offset := 0
+	for _, set := range p.sets {
+		s, err := p.load(set)
+		if err != nil {
+			return nil, err
+		}
+		for rec := 1; rec <= len(s.adn()); rec++ {
+			if c, ok := s.contact(rec); ok {
+				c.Index = offset + rec
+				contacts = append(contacts, c)
+			}
+		}
+		offset += len(s.adn())
+	}
+	return
+}
+
+// clear empties the record of f linked to ADN record rec and its extension records
+func (s *pbSet) clear(f PBRFile, rec int) {
+	n := s.linked(f, rec)
+	if n == 0 || n > len(s.records[f]) {
+		return
+	}
+	r := s.records[f][n-1]
+	if f.Tag == PB_TAG_ANR && len(r) >= 1+dialNumberLen {
+		_, used, _ := extChain(s.ext(), r[dialNumberLen])
+		s.freeExt(used)
+	}
+	s.records[f][n-1] = bytes.Repeat([]byte{0xFF}, len(r))
+	s.dirty[f] = true
+	if f.Template == PBR_TYPE2 {
+		iap, _ := s.file(PB_TAG_IAP)
+		s.records[iap][rec-1][s.iapIndex(f)] = 0xFF
+		s.dirty[iap] = true
+	}
+}
+
+func (s *pbSet) freeExt(used []int) {
+	if len(used) > 0 {
+		ext, _ := s.file(PB_TAG_EXT1)
+		freeExt(s.ext(), used)
+		s.dirty[ext] = true
+	}
+}
+
+// allocExt stores the digits beyond the first 20 in EF_EXT1
+func (s *pbSet) allocExt(digits string) (byte, error) {
+	if digits == "" {
+		return noExtension, nil
+	}
+	ext, ok := s.file(PB_TAG_EXT1)
+	if !ok {
+		return noExtension, errors.New("phonebook: number needs EF_EXT1")
+	}
+	s.dirty[ext] = true
+	return allocExt(s.records[ext], digits)
+}
+
+// put writes data to the record of f linked to ADN record rec, type 2 files
+// take a free record which is linked through EF_IAP
+func (s *pbSet) put(f PBRFile, rec int, data []byte) error {
+	records := s.records[f]
+	n := s.linked(f, rec)
+	if n == 0 && f.Template == PBR_TYPE2 {
+		for i, r := range records {
+			if isEmptyRecord(r) {
+				n = i + 1
+				break
+			}
+		}
+		if n == 0 {
+			return fmt.Errorf("phonebook: no free record in %s", pbFileNames[f.Tag])
+		}
+		iap, ok := s.file(PB_TAG_IAP)
+		if !ok || rec > len(s.records[iap]) || s.iapIndex(f) >= len(s.records[iap][rec-1]) {
+			return errors.New("phonebook: EF_IAP missing")
+		}
+		s.records[iap][rec-1][s.iapIndex(f)] = byte(n)
+		s.dirty[iap] = true
+	}
+	if n == 0 || n > len(records) {
+		return fmt.Errorf("phonebook: no record %d in %s", rec, pbFileNames[f.Tag])
+	}
+	size := dataLen(f, records[n-1])
+	if len(data) > size {
+		return fmt.Errorf("phonebook: %d bytes do not fit %s", len(data), pbFileNames[f.Tag])
+	}
+	r := append(data, bytes.Repeat([]byte{0xFF}, size-len(data))...)
+	if f.Template == PBR_TYPE2 {
+		// ADN SFI and record identifier, TS 31.102 4.4.2.11 and 4.4.2.13
+		adn, _ := s.file(PB_TAG_ADN)
+		r = append(r, adn.SFI, byte(rec))
+	}
+	records[n-1] = r
+	s.dirty[f] = true
+	return nil
+}
+
+func (s *pbSet) setContact(rec int, c Contact) error {
+	adnFile, _ := s.file(PB_TAG_ADN)
+	adn := s.adn()
+	alphaLen := len(adn[rec-1]) - dialNumberLen
+	if alphaLen == 0 && c.Name != "" {
+		return fmt.Errorf("phonebook: EF_ADN records have no alpha identifier for %q", c.Name)
+	}
+	for _, f := range s.Files {
+		switch f.Tag {
+		case PB_TAG_ANR, PB_TAG_EMAIL, PB_TAG_SNE:
+			if _, ok := s.records[f]; ok {
+				s.clear(f, rec)
+			}
+		}
+	}
+	if d, _, err := decodeDialNumber(adn[rec-1], alphaLen); err == nil && d.ext != noExtension {
+		_, used, _ := extChain(s.ext(), d.ext)
+		s.freeExt(used)
+	}
+	s.dirty[adnFile] = true
+	if c.Name == "" && c.Number == "" {
+		adn[rec-1] = emptyDialNumber(alphaLen)
+		return nil
+	}
+	d, err := NewDialNumber(c.Name, c.Number)
+	if err != nil {
+		return err
+	}
+	if len(d.Number) > 2*dialNumberMaxBCD {
+		if d.ext, err = s.allocExt(d.Number[2*dialNumberMaxBCD:]); err != nil {
+			return err
+		}
+	}
+	if adn[rec-1], _, err = encodeDialNumber(d, alphaLen); err != nil {
+		return err
+	}
+	anrs := s.filesOf(PB_TAG_ANR)
+	if len(c.AdditionalNumbers) > len(anrs) {
+		return fmt.Errorf("phonebook: %d additional numbers, %d EF_ANR", len(c.AdditionalNumbers), len(anrs))
+	}
+	for i, number := range c.AdditionalNumbers {
+		a, err := NewDialNumber("", number)
+		if err != nil {
+			return err
+		}
+		if len(a.Number) > 2*dialNumberMaxBCD {
+			if a.ext, err = s.allocExt(a.Number[2*dialNumberMaxBCD:]); err != nil {
+				return err
+			}
+		}
+		r, _, err := encodeDialNumber(a, 0)
+		if err != nil {
+			return err
+		}
+		// no additional number type from EF_AAS
+		if err = s.put(anrs[i], rec, append([]byte{0x00}, r...)); err != nil {
+			return err
+		}
+	}
+	emails := s.filesOf(PB_TAG_EMAIL)
+	if len(c.Emails) > len(emails) {
+		return fmt.Errorf("phonebook: %d e-mail addresses, %d EF_EMAIL", len(c.Emails), len(emails))
+	}
+	for i, e := range c.Emails {
+		f := emails[i]
+		if err = s.put(f, rec, encodeAlphaID(e, s.size(f))); err != nil {
+			return err
+		}
+	}
+	if c.SecondName != "" {
+		f, ok := s.file(PB_TAG_SNE)
+		if !ok {
+			return errors.New("phonebook: no EF_SNE for the second name")
+		}
+		if err = s.put(f, rec, encodeAlphaID(c.SecondName, s.size(f))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// size is the data length of the records of f
+func (s *pbSet) size(f PBRFile) int {
+	if records := s.records[f]; len(records) > 0 {
+		return dataLen(f, records[0])
+	}
+	return 0
+}
+
+// UpdateContact writes the contact at c.Index, or at the first free ADN
+// record if c.Index is 0, and returns the index used
+func (u *USIM) UpdateContact(c Contact) (index int, err error) {
+	p, err := u.openPhonebook()
+	if err != nil {
+		return
+	}
+	offset := 0
+	for _, set := range p.sets {
+		s, err := p.load(set)
+		if err != nil {
+			return 0, err
+		}
+		adn := s.adn()
+		rec := 0
+		if c.Index > offset && c.Index <= offset+len(adn) {
+			rec = c.Index - offset
+		} else if c.Index == 0 {
+			for i, r := range adn {
+				if isEmptyRecord(r[len(r)-dialNumberLen:]) && isEmptyRecord(r[:len(r)-dialNumberLen]) {
+					rec = i + 1
+					break
+				}
+			}
+		}
+		if rec == 0 {
+			offset += len(adn)
+			continue
+		}
+		if err = s.setContact(rec, c); err != nil {
+			return 0, err
+		}
+		return offset + rec, p.store(s)
+	}
+	if c.Index == 0 {
+		return 0, errors.New("phonebook: full")
+	}
+	return 0, fmt.Errorf("phonebook: no contact %d", c.Index)
+}
+
+// DeleteContact clears the contact at index with its linked records
+func (u *USIM) DeleteContact(index int) error {
+	if index <= 0 {
+		return fmt.Errorf("phonebook: no contact %d", index)
+	}
+	_, err := u.UpdateContact(Contact{Index: index})
+	return err
+}
@@ -0,0 +1,127 @@
+package usim_go
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestEFPBR(t *testing.T) {
+	raw := unhex("a80dc0034f3a01c4024f11c3024f13" + "a905ca034f5004" + "aa04c2024f4a" + "ffff")
+	var pbr EFPBR
+	if err := pbr.Decode([][]byte{raw, unhex("ffffffff")}); err != nil {
+		t.Fatal(err)
+	}
+	want := []PBRFile{
+		{PBR_TYPE1, PB_TAG_ADN, 0x4F3A, 0x01},
+		{PBR_TYPE1, PB_TAG_ANR, 0x4F11, 0},
+		{PBR_TYPE1, PB_TAG_SNE, 0x4F13, 0},
+		{PBR_TYPE2, PB_TAG_EMAIL, 0x4F50, 0x04},
+		{PBR_TYPE3, PB_TAG_EXT1, 0x4F4A, 0},
+	}
+	if len(pbr.Sets) != 1 || !reflect.DeepEqual(pbr.Sets[0].Files, want) {
+		t.Fatalf("EF_PBR %+v", pbr.Sets)
+	}
+	out, err := pbr.Encode()
+	if err != nil || len(out) != 1 || !bytes.Equal(out[0], raw[:len(raw)-2]) {
+		t.Errorf("EF_PBR encoded %x, %v", out, err)
+	}
+}
+
+// testPhonebook creates a global phonebook of four contacts with type 1
+// ANR and SNE, type 2 EMAIL through EF_IAP and EF_EXT1
+func testPhonebook(t *testing.T) *USIM {
+	u, _ := testSubscriber(t)
+	set := PBRSet{Files: []PBRFile{
+		{PBR_TYPE1, PB_TAG_ADN, 0x4F3A, 0x01},
+		{PBR_TYPE1, PB_TAG_IAP, 0x4F25, 0},
+		{PBR_TYPE1, PB_TAG_ANR, 0x4F11, 0},
+		{PBR_TYPE1, PB_TAG_SNE, 0x4F13, 0},
+		{PBR_TYPE2, PB_TAG_EMAIL, 0x4F50, 0},
+		{PBR_TYPE3, PB_TAG_EXT1, 0x4F4A, 0},
+	}}
+	if err := UpdateEF(u, &EFPBR{pbr{[]PBRSet{set}}}); err != nil {
+		t.Fatal(err)
+	}
+	p := &phonebook{u, pathPhonebook, "DF_PHONEBOOK", []PBRSet{set}}
+	sizes := map[byte]int{PB_TAG_ADN: 8 + dialNumberLen, PB_TAG_IAP: 1, PB_TAG_ANR: 15, PB_TAG_SNE: 8, PB_TAG_EMAIL: 12, PB_TAG_EXT1: extRecordLen}
+	for _, f := range set.Files {
+		records := make([][]byte, 4)
+		for i := range records {
+			records[i] = bytes.Repeat([]byte{0xFF}, sizes[f.Tag])
+		}
+		if err := p.write(f, records); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return u
+}
+
+func TestPhonebookContacts(t *testing.T) {
+	u := testPhonebook(t)
+	c := Contact{
+		Name:              "Alice",
+		Number:            "+4912345678901234567890123",
+		AdditionalNumbers: []string{"0301234"},
+		Emails:            []string{"a@lab.test"},
+		SecondName:        "Al",
+	}
+	index, err := u.UpdateContact(c)
+	if err != nil || index != 1 {
+		t.Fatal(index, err)
+	}
+	if index, err = u.UpdateContact(Contact{Name: "Bob", Number: "112"}); err != nil || index != 2 {
+		t.Fatal(index, err)
+	}
+	contacts, err := u.ReadPhonebook()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Index = 1
+	want := []Contact{c, {Index: 2, Name: "Bob", Number: "112"}}
+	if !reflect.DeepEqual(contacts, want) {
+		t.Fatalf("contacts %+v", contacts)
+	}
+	p, _ := u.openPhonebook()
+	s, _ := p.load(p.sets[0])
+	email, _ := s.file(PB_TAG_EMAIL)
+	if !bytes.Equal(s.records[email][0], unhex("61006c61622e74657374"+"0101")) {
+		t.Errorf("EF_EMAIL %x", s.records[email][0])
+	}
+	if iap, _ := s.file(PB_TAG_IAP); s.records[iap][0][0] != 1 {
+		t.Errorf("EF_IAP %x", s.records[iap][0])
+	}
+	if ext := s.ext(); isEmptyRecord(ext[0]) {
+		t.Error("EF_EXT1 not used")
+	}
+
+	if err = u.DeleteContact(1); err != nil {
+		t.Fatal(err)
+	}
+	if contacts, _ = u.ReadPhonebook(); len(contacts) != 1 || contacts[0].Name != "Bob" {
+		t.Errorf("contacts after delete %+v", contacts)
+	}
+	s, _ = p.load(p.sets[0])
+	if !isEmptyRecord(s.records[email][0]) || !isEmptyRecord(s.ext()[0]) {
+		t.Error("linked records not cleared")
+	}
+}
+
+func TestPhonebookNoAlpha(t *testing.T) {
+	u := testPhonebook(t)
+	p, _ := u.openPhonebook()
+	adn, _ := p.sets[0].file(PB_TAG_ADN)
+	records := [][]byte{emptyDialNumber(0), emptyDialNumber(0)}
+	if err := p.write(adn, records); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := u.UpdateContact(Contact{Name: "Alice", Number: "112"}); err == nil {
+		t.Error("name written to EF_ADN without alpha identifier")
+	}
+	if index, err := u.UpdateContact(Contact{Number: "112"}); err != nil || index != 1 {
+		t.Fatal(index, err)
+	}
+	if contacts, _ := u.ReadPhonebook(); len(contacts) != 1 || contacts[0].Number != "112" {
+		t.Errorf("contacts %+v", contacts)
+	}
+}
@@ -0,0 +1,241 @@
+package usim_go
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// MCC/MNC table of ITU-T E.212 used where the MNC length is not read from
+// EF_AD, e.g. for soft profiles.
+
+// PLMNInfo describes a mobile country code or a network of the table
+type PLMNInfo struct {
+	MCC      string
+	MNC      string
+	Country  string
+	ISO      string
+	Operator string
+}
+
+type mccInfo struct {
+	country string
+	iso     string
+	// mncLen is the MNC length of the networks of the country, 0 where
+	// both 2 and 3 digit MNCs are in use
+	mncLen int
+}
+
+var mccTable = map[string]mccInfo{
+	"001": {"Test network", "", 2},
+	"202": {"Greece", "GR", 2},
+	"204": {"Netherlands", "NL", 2},
+	"206": {"Belgium", "BE", 2},
+	"208": {"France", "FR", 2},
+	"214": {"Spain", "ES", 2},
+	"216": {"Hungary", "HU", 2},
+	"222": {"Italy", "IT", 2},
+	"226": {"Romania", "RO", 2},
+	"228": {"Switzerland", "CH", 2},
+	"230": {"Czech Republic", "CZ", 2},
+	"232": {"Austria", "AT", 2},
+	"234": {"United Kingdom", "GB", 2},
+	"238": {"Denmark", "DK", 2},
+	"240": {"Sweden", "SE", 2},
+	"242": {"Norway", "NO", 2},
+	"244": {"Finland", "FI", 2},
+	"250": {"Russian Federation", "RU", 2},
+	"255": {"Ukraine", "UA", 2},
+	"260": {"Poland", "PL", 2},
+	"262": {"Germany", "DE", 2},
+	"268": {"Portugal", "PT", 2},
+	"272": {"Ireland", "IE", 2},
+	"286": {"Turkey", "TR", 2},
+	"302": {"Canada", "CA", 3},
+	"310": {"United States", "US", 3},
+	"311": {"United States", "US", 3},
+	"312": {"United States", "US", 3},
+	"313": {"United States", "US", 3},
+	"314": {"United States", "US", 3},
+	"315": {"United States", "US", 3},
+	"316": {"United States", "US", 3},
+	"330": {"Puerto Rico", "PR", 3},
+	"334": {"Mexico", "MX", 3},
+	"338": {"Jamaica", "JM", 3},
+	"342": {"Barbados", "BB", 3},
+	"344": {"Antigua and Barbuda", "AG", 3},
+	"346": {"Cayman Islands", "KY", 3},
+	"348": {"British Virgin Islands", "VG", 3},
+	"352": {"Grenada", "GD", 3},
+	"354": {"Montserrat", "MS", 3},
+	"356": {"Saint Kitts and Nevis", "KN", 3},
+	"358": {"Saint Lucia", "LC", 3},
+	"360": {"Saint Vincent and the Grenadines", "VC", 3},
+	"365": {"Anguilla", "AI", 3},
+	"366": {"Dominica", "DM", 3},
+	"376": {"Turks and Caicos Islands", "TC", 3},
+	"404": {"India", "IN", 2},
+	"405": {"India", "IN", 0},
+	"410": {"Pakistan", "PK", 2},
+	"420": {"Saudi Arabia", "SA", 2},
+	"424": {"United Arab Emirates", "AE", 2},
+	"425": {"Israel", "IL", 2},
+	"440": {"Japan", "JP", 2},
+	"450": {"Korea, Republic of", "KR", 2},
+	"452": {"Viet Nam", "VN", 2},
+	"454": {"Hong Kong", "HK", 2},
+	"460": {"China", "CN", 2},
+	"466": {"Taiwan", "TW", 2},
+	"502": {"Malaysia", "MY", 0},
+	"505": {"Australia", "AU", 2},
+	"510": {"Indonesia", "ID", 2},
+	"515": {"Philippines", "PH", 2},
+	"520": {"Thailand", "TH", 2},
+	"525": {"Singapore", "SG", 2},
+	"530": {"New Zealand", "NZ", 2},
+	"602": {"Egypt", "EG", 2},
+	"621": {"Nigeria", "NG", 2},
+	"655": {"South Africa", "ZA", 2},
+	"708": {"Honduras", "HN", 3},
+	"722": {"Argentina", "AR", 3},
+	"724": {"Brazil", "BR", 2},
+	"730": {"Chile", "CL", 2},
+	"732": {"Colombia", "CO", 3},
+	"750": {"Falkland Islands", "FK", 3},
+	"999": {"Private network", "", 2},
+}
+
+// plmnTable lists networks, including those whose MNC length differs from the country's
+var plmnTable = []PLMNInfo{
+	{MCC: "001", MNC: "01", Operator: "Test Network"},
+	{MCC: "208", MNC: "01", Operator: "Orange"},
+	{MCC: "208", MNC: "10", Operator: "SFR"},
+	{MCC: "208", MNC: "15", Operator: "Free Mobile"},
+	{MCC: "208", MNC: "20", Operator: "Bouygues Telecom"},
+	{MCC: "214", MNC: "01", Operator: "Vodafone"},
+	{MCC: "214", MNC: "03", Operator: "Orange"},
+	{MCC: "214", MNC: "07", Operator: "Movistar"},
+	{MCC: "222", MNC: "01", Operator: "TIM"},
+	{MCC: "222", MNC: "10", Operator: "Vodafone"},
+	{MCC: "222", MNC: "88", Operator: "WindTre"},
+	{MCC: "234", MNC: "10", Operator: "O2"},
+	{MCC: "234", MNC: "15", Operator: "Vodafone"},
+	{MCC: "234", MNC: "20", Operator: "Three"},
+	{MCC: "234", MNC: "30", Operator: "EE"},
+	{MCC: "262", MNC: "01", Operator: "Telekom"},
+	{MCC: "262", MNC: "02", Operator: "Vodafone"},
+	{MCC: "262", MNC: "03", Operator: "O2"},
+	{MCC: "302", MNC: "220", Operator: "Telus"},
+	{MCC: "302", MNC: "610", Operator: "Bell"},
+	{MCC: "302", MNC: "720", Operator: "Rogers"},
+	{MCC: "310", MNC: "260", Operator: "T-Mobile"},
+	{MCC: "310", MNC: "410", Operator: "AT&T"},
+	{MCC: "311", MNC: "480", Operator: "Verizon"},
+	{MCC: "334", MNC: "020", Operator: "Telcel"},
+	{MCC: "334", MNC: "030", Operator: "Movistar"},
+	{MCC: "334", MNC: "050", Operator: "AT&T"},
+	{MCC: "404", MNC: "10", Operator: "Airtel"},
+	{MCC: "404", MNC: "45", Operator: "Airtel"},
+	{MCC: "405", MNC: "840", Operator: "Jio"},
+	{MCC: "440", MNC: "10", Operator: "NTT docomo"},
+	{MCC: "440", MNC: "20", Operator: "SoftBank"},
+	{MCC: "440", MNC: "50", Operator: "KDDI"},
+	{MCC: "450", MNC: "05", Operator: "SK Telecom"},
+	{MCC: "450", MNC: "08", Operator: "KT"},
+	{MCC: "460", MNC: "00", Operator: "China Mobile"},
+	{MCC: "460", MNC: "01", Operator: "China Unicom"},
+	{MCC: "460", MNC: "11", Operator: "China Telecom"},
+	{MCC: "505", MNC: "01", Operator: "Telstra"},
+	{MCC: "505", MNC: "02", Operator: "Optus"},
+	{MCC: "505", MNC: "03", Operator: "Vodafone"},
+	{MCC: "722", MNC: "070", Operator: "Movistar"},
+	{MCC: "722", MNC: "310", Operator: "Claro"},
+	{MCC: "722", MNC: "340", Operator: "Personal"},
+	{MCC: "724", MNC: "02", Operator: "TIM"},
+	{MCC: "724", MNC: "05", Operator: "Claro"},
+	{MCC: "724", MNC: "06", Operator: "Vivo"},
+	{MCC: "732", MNC: "101", Operator: "Claro"},
+	{MCC: "732", MNC: "123", Operator: "Movistar"},
+}
+
+// LookupMCC returns the country of a mobile country code
+func LookupMCC(mcc string) (info PLMNInfo, ok bool) {
+	c, ok := mccTable[mcc]
+	if !ok {
+		return
+	}
+	return PLMNInfo{MCC: mcc, Country: c.country, ISO: c.iso}, true
+}
+
+// LookupPLMN returns the network of an MCC/MNC pair
+func LookupPLMN(mcc, mnc string) (info PLMNInfo, ok bool) {
+	for _, p := range plmnTable {
+		if p.MCC == mcc && p.MNC == mnc {
+			info, ok = p, true
+			break
+		}
+	}
+	if !ok {
+		return
+	}
+	c := mccTable[mcc]
+	info.Country, info.ISO = c.country, c.iso
+	return
+}
+
+// PLMNsOfCountry returns the networks of the table with the ISO 3166 country code
+func PLMNsOfCountry(iso string) (list []PLMNInfo) {
+	for _, p := range plmnTable {
+		if c := mccTable[p.MCC]; c.iso == iso {
+			p.Country, p.ISO = c.country, c.iso
+			list = append(list, p)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].MCC+list[i].MNC < list[j].MCC+list[j].MNC })
+	return
+}
+
+// MNCLength returns the length of the MNC of an IMSI, networks of the table
+// take precedence over the country default. Unknown countries use 2 digits,
+// it is 0 for other networks of countries with MNCs of both lengths.
+func MNCLength(imsi string) int {
+	if len(imsi) < 6 {
+		return 2
+	}
+	mcc := imsi[:3]
+	for _, p := range plmnTable {
+		if p.MCC == mcc && p.MNC == imsi[3:3+len(p.MNC)] {
+			return len(p.MNC)
+		}
+	}
+	if c, ok := mccTable[mcc]; ok {
+		return c.mncLen
+	}
+	return 2
+}
+
+// splitIMSI splits the MCC and the MNC of mncLen digits off an IMSI
+func splitIMSI(imsi string, mncLen int) (mcc, mnc string, err error) {
+	if len(imsi) != 15 || !isDigits(imsi) {
+		return "", "", errors.New("extract mcc/mnc failed")
+	}
+	if mncLen != 2 && mncLen != 3 {
+		return "", "", fmt.Errorf("invalid MNC length %d", mncLen)
+	}
+	return imsi[:3], imsi[3 : 3+mncLen], nil
+}
+
+// MCC returns the mobile country code of the IMSI
+func (u USIM) MCC() string {
+	return u.mccStr
+}
+
+// MNC returns the mobile network code of the IMSI with 2 or 3 digits
+func (u USIM) MNC() string {
+	return u.mncStr
+}
+
+// PLMN returns the home PLMN identity of TS 24.008 10.5.1.3
+func (u USIM) PLMN() [3]byte {
+	return encode_plmn(u.mcc, u.mnc)
+}
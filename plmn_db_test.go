@@ -0,0 +1,45 @@
+package usim_go
+
+import "testing"
+
+func TestMNCLength(t *testing.T) {
+	for imsi, want := range map[string]int{
+		"208930000000001": 2,
+		"310410123456789": 3,
+		"302720123456789": 3,
+		"334020123456789": 3,
+		"404450123456789": 2,
+		"405840123456789": 3,
+		"405010123456789": 0,
+		"502120123456789": 0,
+		"732101123456789": 3,
+		"901700000000001": 2,
+	} {
+		if n := MNCLength(imsi); n != want {
+			t.Errorf("MNCLength(%s) = %d", imsi, n)
+		}
+	}
+	if p, ok := LookupPLMN("310", "410"); !ok || p.Operator != "AT&T" || p.ISO != "US" {
+		t.Errorf("unexpected PLMN %+v", p)
+	}
+	if p, ok := LookupMCC("404"); !ok || p.Country != "India" {
+		t.Errorf("unexpected MCC %+v", p)
+	}
+	if list := PLMNsOfCountry("CA"); len(list) != 3 || list[0].MNC != "220" {
+		t.Errorf("unexpected Canadian networks %+v", list)
+	}
+}
+
+func TestUSIMPLMN(t *testing.T) {
+	u, _ := testSubscriber(t)
+	if u.MCC() != "208" || u.MNC() != "93" || u.PLMN() != [3]byte{0x02, 0xF8, 0x39} {
+		t.Errorf("unexpected PLMN %s %s %x", u.MCC(), u.MNC(), u.PLMN())
+	}
+	in, err := InitSoftUSIM(Milenage, "356092040793011", "405840000000001", "8BAF473F2F8FD09487CCCBD7097C6862", "", "8e27b6af0e692e750f32667a3b14605d", true)
+	if err != nil || in.MNC() != "840" {
+		t.Errorf("unexpected MNC %s %v", in.MNC(), err)
+	}
+	if _, err = InitSoftUSIM(Milenage, "356092040793011", "405010000000001", "8BAF473F2F8FD09487CCCBD7097C6862", "", "8e27b6af0e692e750f32667a3b14605d", true); err == nil {
+		t.Error("MNC length of 405-01 guessed")
+	}
+}
@@ -0,0 +1,255 @@
+package usim_go
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// PLMN selection files of TS 31.102 4.2.5, 4.2.16, 4.2.53, 4.2.54, 4.2.84 and 4.2.85
+
+// AccessTechnology is the access technology identifier bitmap of TS 31.102 4.2.5
+type AccessTechnology uint16
+
+const (
+	ACT_UTRAN          AccessTechnology = 0x8000
+	ACT_EUTRAN         AccessTechnology = 0x4000
+	ACT_EUTRAN_WB_S1   AccessTechnology = 0x2000
+	ACT_EUTRAN_NB_S1   AccessTechnology = 0x1000
+	ACT_NGRAN          AccessTechnology = 0x0800
+	ACT_GSM            AccessTechnology = 0x0080
+	ACT_GSM_COMPACT    AccessTechnology = 0x0040
+	ACT_CDMA2000_HRPD  AccessTechnology = 0x0020
+	ACT_CDMA2000_1XRTT AccessTechnology = 0x0010
+	ACT_EC_GSM_IOT     AccessTechnology = 0x0008
+	ACT_GSM_NON_EC     AccessTechnology = 0x0004
+
+	ACT_ALL = ACT_UTRAN | ACT_EUTRAN | ACT_NGRAN | ACT_GSM
+)
+
+var actNames = []struct {
+	act  AccessTechnology
+	name string
+}{
+	{ACT_UTRAN, "UTRAN"},
+	{ACT_EUTRAN, "E-UTRAN"},
+	{ACT_EUTRAN_WB_S1, "E-UTRAN WB-S1"},
+	{ACT_EUTRAN_NB_S1, "E-UTRAN NB-S1"},
+	{ACT_NGRAN, "NG-RAN"},
+	{ACT_GSM, "GSM"},
+	{ACT_GSM_COMPACT, "GSM COMPACT"},
+	{ACT_CDMA2000_HRPD, "cdma2000 HRPD"},
+	{ACT_CDMA2000_1XRTT, "cdma2000 1xRTT"},
+	{ACT_EC_GSM_IOT, "EC-GSM-IoT"},
+	{ACT_GSM_NON_EC, "GSM (non EC-GSM-IoT)"},
+}
+
+func (a AccessTechnology) String() string {
+	var names []string
+	for _, n := range actNames {
+		if a&n.act != 0 {
+			names = append(names, n.name)
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, "|")
+}
+
+// PLMNwAcT is an entry of a PLMN selector with access technology
+type PLMNwAcT struct {
+	PLMNID
+	AcT AccessTechnology
+}
+
+const (
+	plmnLen     = 3
+	plmnwAcTLen = 5
+)
+
+// plmnSelector is the list of a PLMN selector file. Size is the file size in
+// bytes, unused entries up to Size are written as 'FFFFFF0000'.
+type plmnSelector struct {
+	Entries []PLMNwAcT
+	Size    int
+}
+
+func (s *plmnSelector) decode(name string, records [][]byte) error {
+	r, err := singleRecord(name, records, 0)
+	if err != nil {
+		return err
+	}
+	s.Entries, s.Size = nil, len(r)
+	for i := 0; i+plmnwAcTLen <= len(r); i += plmnwAcTLen {
+		p, ok, err := decodePLMNID(r[i : i+plmnLen])
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		if ok {
+			act := AccessTechnology(r[i+3])<<8 | AccessTechnology(r[i+4])
+			s.Entries = append(s.Entries, PLMNwAcT{p, act})
+		}
+	}
+	return nil
+}
+
+func (s *plmnSelector) encode(name string) ([][]byte, error) {
+	var r []byte
+	for _, e := range s.Entries {
+		b, err := e.encode()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		r = append(r, b...)
+		r = append(r, byte(e.AcT>>8), byte(e.AcT))
+	}
+	return padPLMNList(name, r, s.Size, []byte{0xFF, 0xFF, 0xFF, 0x00, 0x00})
+}
+
+// padPLMNList fills a PLMN list up to size bytes with the unused entry
+func padPLMNList(name string, r []byte, size int, unused []byte) ([][]byte, error) {
+	if size == 0 {
+		return [][]byte{r}, nil
+	}
+	if len(r) > size {
+		return nil, fmt.Errorf("%s: %d entries do not fit %d bytes", name, len(r)/len(unused), size)
+	}
+	r = append(r, bytes.Repeat(unused, (size-len(r))/len(unused))...)
+	return [][]byte{r}, nil
+}
+
+// EFPLMNwAcT is EF_PLMNwAcT, the user controlled PLMN selector, TS 31.102 4.2.5
+type EFPLMNwAcT struct {
+	plmnSelector
+}
+
+func (e *EFPLMNwAcT) Info() EFInfo                  { return efInfo("EF_PLMNwAcT") }
+func (e *EFPLMNwAcT) Decode(records [][]byte) error { return e.decode("EF_PLMNwAcT", records) }
+func (e *EFPLMNwAcT) Encode() ([][]byte, error)     { return e.encode("EF_PLMNwAcT") }
+
+// EFOPLMNwAcT is EF_OPLMNwAcT, the operator controlled PLMN selector, TS 31.102 4.2.53
+type EFOPLMNwAcT struct {
+	plmnSelector
+}
+
+func (e *EFOPLMNwAcT) Info() EFInfo                  { return efInfo("EF_OPLMNwAcT") }
+func (e *EFOPLMNwAcT) Decode(records [][]byte) error { return e.decode("EF_OPLMNwAcT", records) }
+func (e *EFOPLMNwAcT) Encode() ([][]byte, error)     { return e.encode("EF_OPLMNwAcT") }
+
+// EFHPLMNwAcT is EF_HPLMNwAcT, the HPLMN selector, TS 31.102 4.2.54
+type EFHPLMNwAcT struct {
+	plmnSelector
+}
+
+func (e *EFHPLMNwAcT) Info() EFInfo                  { return efInfo("EF_HPLMNwAcT") }
+func (e *EFHPLMNwAcT) Decode(records [][]byte) error { return e.decode("EF_HPLMNwAcT", records) }
+func (e *EFHPLMNwAcT) Encode() ([][]byte, error)     { return e.encode("EF_HPLMNwAcT") }
+
+// plmnList is a list of PLMNs without access technology, unused entries are 'FFFFFF'
+type plmnList struct {
+	PLMNs []PLMNID
+	Size  int
+}
+
+func (l *plmnList) decode(name string, records [][]byte) error {
+	r, err := singleRecord(name, records, 0)
+	if err != nil {
+		return err
+	}
+	l.PLMNs, l.Size = nil, len(r)
+	for i := 0; i+plmnLen <= len(r); i += plmnLen {
+		p, ok, err := decodePLMNID(r[i : i+plmnLen])
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		if ok {
+			l.PLMNs = append(l.PLMNs, p)
+		}
+	}
+	return nil
+}
+
+func (l *plmnList) encode(name string) ([][]byte, error) {
+	var r []byte
+	for _, p := range l.PLMNs {
+		b, err := p.encode()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		r = append(r, b...)
+	}
+	return padPLMNList(name, r, l.Size, []byte{0xFF, 0xFF, 0xFF})
+}
+
+// EFFPLMN is EF_FPLMN, the forbidden PLMNs, TS 31.102 4.2.16
+type EFFPLMN struct {
+	plmnList
+}
+
+func (e *EFFPLMN) Info() EFInfo                  { return efInfo("EF_FPLMN") }
+func (e *EFFPLMN) Decode(records [][]byte) error { return e.decode("EF_FPLMN", records) }
+func (e *EFFPLMN) Encode() ([][]byte, error)     { return e.encode("EF_FPLMN") }
+
+// EFEHPLMN is EF_EHPLMN, the equivalent HPLMNs, TS 31.102 4.2.84
+type EFEHPLMN struct {
+	plmnList
+}
+
+func (e *EFEHPLMN) Info() EFInfo                  { return efInfo("EF_EHPLMN") }
+func (e *EFEHPLMN) Decode(records [][]byte) error { return e.decode("EF_EHPLMN", records) }
+func (e *EFEHPLMN) Encode() ([][]byte, error)     { return e.encode("EF_EHPLMN") }
+
+// EFLRPLMNSI is EF_LRPLMNSI, TS 31.102 4.2.85. HomeNetwork selects the home
+// network instead of the last registered PLMN at switch on.
+type EFLRPLMNSI struct {
+	HomeNetwork bool
+}
+
+func (e *EFLRPLMNSI) Info() EFInfo { return efInfo("EF_LRPLMNSI") }
+
+func (e *EFLRPLMNSI) Decode(records [][]byte) error {
+	r, err := singleRecord("EF_LRPLMNSI", records, 1)
+	if err == nil {
+		e.HomeNetwork = r[0]&0x01 != 0
+	}
+	return err
+}
+
+func (e *EFLRPLMNSI) Encode() ([][]byte, error) {
+	if e.HomeNetwork {
+		return [][]byte{{0x01}}, nil
+	}
+	return [][]byte{{0x00}}, nil
+}
+
+// ClearForbiddenPLMNs empties EF_FPLMN
+func (u *USIM) ClearForbiddenPLMNs() error {
+	f, err := ReadEF[EFFPLMN](u)
+	if err != nil {
+		return err
+	}
+	f.PLMNs = nil
+	return UpdateEF(u, &f)
+}
+
+// PreferOperatorPLMN puts the PLMN first in EF_OPLMNwAcT, an existing entry
+// for the PLMN is replaced. The last entry is dropped if the file is full.
+func (u *USIM) PreferOperatorPLMN(mcc, mnc string, act AccessTechnology) error {
+	sel, err := ReadEF[EFOPLMNwAcT](u)
+	if err != nil {
+		return err
+	}
+	p := PLMNwAcT{PLMNID{mcc, mnc}, act}
+	entries := []PLMNwAcT{p}
+	for _, e := range sel.Entries {
+		if e.PLMNID != p.PLMNID {
+			entries = append(entries, e)
+		}
+	}
+	if n := sel.Size / plmnwAcTLen; sel.Size > 0 && len(entries) > n {
+		entries = entries[:n]
+	}
+	sel.Entries = entries
+	return UpdateEF(u, &sel)
+}
@@ -0,0 +1,68 @@
+package usim_go
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestEFPLMNwAcT(t *testing.T) {
+	raw := unhex("02f839c080" + "130014" + "4000" + "ffffff0000")
+	var sel EFOPLMNwAcT
+	if err := sel.Decode([][]byte{raw}); err != nil {
+		t.Fatal(err)
+	}
+	want := []PLMNwAcT{
+		{PLMNID{"208", "93"}, ACT_UTRAN | ACT_EUTRAN | ACT_GSM},
+		{PLMNID{"310", "410"}, ACT_EUTRAN},
+	}
+	if !reflect.DeepEqual(sel.Entries, want) || sel.Size != 15 {
+		t.Fatalf("decoded %+v size %d", sel.Entries, sel.Size)
+	}
+	out, err := sel.Encode()
+	if err != nil || !bytes.Equal(out[0], raw) {
+		t.Errorf("encoded %x, %v", out, err)
+	}
+	if s := want[0].AcT.String(); s != "UTRAN|E-UTRAN|GSM" {
+		t.Errorf("AcT %s", s)
+	}
+	sel.Entries = append(sel.Entries, want...)
+	if _, err = sel.Encode(); err == nil {
+		t.Error("overfull selector encoded")
+	}
+}
+
+func TestPLMNSelectionFiles(t *testing.T) {
+	u, _ := testSubscriber(t)
+	fplmn := EFFPLMN{plmnList{PLMNs: []PLMNID{{"208", "01"}, {"208", "10"}}, Size: 12}}
+	if err := UpdateEF(u, &fplmn); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.ClearForbiddenPLMNs(); err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := u.ReadEFRaw("EF_FPLMN")
+	if !bytes.Equal(raw[0], bytes.Repeat([]byte{0xFF}, 12)) {
+		t.Errorf("EF_FPLMN after clear %x", raw[0])
+	}
+
+	sel := EFOPLMNwAcT{plmnSelector{Entries: []PLMNwAcT{{PLMNID{"208", "01"}, ACT_EUTRAN}, {PLMNID{"001", "01"}, ACT_GSM}}, Size: 10}}
+	if err := UpdateEF(u, &sel); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.PreferOperatorPLMN("001", "01", ACT_EUTRAN|ACT_NGRAN); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadEF[EFOPLMNwAcT](u)
+	want := []PLMNwAcT{{PLMNID{"001", "01"}, ACT_EUTRAN | ACT_NGRAN}, {PLMNID{"208", "01"}, ACT_EUTRAN}}
+	if err != nil || !reflect.DeepEqual(got.Entries, want) {
+		t.Errorf("EF_OPLMNwAcT %+v, %v", got.Entries, err)
+	}
+
+	if err = UpdateEF(u, &EFLRPLMNSI{HomeNetwork: true}); err != nil {
+		t.Fatal(err)
+	}
+	if l, err := ReadEF[EFLRPLMNSI](u); err != nil || !l.HomeNetwork {
+		t.Errorf("EF_LRPLMNSI %+v, %v", l, err)
+	}
+}
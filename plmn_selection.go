@@ -0,0 +1,336 @@
+package usim_go
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Automatic network selection mode of TS 23.122 4.4.3.1.1
+
+const (
+	// default higher priority PLMN search period if EF_HPPLMN is not present, TS 23.122 4.4.3.3.1
+	HPPLMN_DEFAULT_PERIOD = 60 * time.Minute
+
+	// high quality signal thresholds of TS 23.122 4.4.3.1.1 in dBm
+	HIGH_QUALITY_GSM    = -85
+	HIGH_QUALITY_UTRAN  = -95
+	HIGH_QUALITY_EUTRAN = -110
+	HIGH_QUALITY_NGRAN  = -110
+)
+
+// ratPreference is the order in which the MS tries the access technologies of
+// one PLMN, the specification leaves it to the implementation
+var ratPreference = []AccessTechnology{ACT_NGRAN, ACT_EUTRAN, ACT_UTRAN, ACT_GSM}
+
+// ScannedNetwork is a PLMN found on one access technology with its signal
+// level, RSSI (GSM), RSCP (UTRAN) or RSRP (E-UTRAN, NG-RAN) in dBm
+type ScannedNetwork struct {
+	PLMN   PLMNID
+	RAT    AccessTechnology
+	Signal int
+}
+
+// highQuality reports whether the signal is above the high quality threshold of its RAT
+func (n ScannedNetwork) highQuality() bool {
+	switch n.RAT {
+	case ACT_GSM:
+		return n.Signal >= HIGH_QUALITY_GSM
+	case ACT_UTRAN:
+		return n.Signal >= HIGH_QUALITY_UTRAN
+	case ACT_EUTRAN:
+		return n.Signal >= HIGH_QUALITY_EUTRAN
+	case ACT_NGRAN:
+		return n.Signal >= HIGH_QUALITY_NGRAN
+	}
+	return false
+}
+
+// PLMNSelectionInput holds the PLMN lists of the card and the MS state used
+// for automatic network selection
+type PLMNSelectionInput struct {
+	HPLMN         PLMNID
+	EHPLMNs       []PLMNID
+	HPLMNwAcT     []PLMNwAcT
+	UserPLMNs     []PLMNwAcT
+	OperatorPLMNs []PLMNwAcT
+	Forbidden     []PLMNID
+	// RPLMN is the last registered PLMN, empty if none
+	RPLMN PLMNID
+	// HomeAtSwitchOn is the Last RPLMN Selection Indication of EF_LRPLMNSI
+	HomeAtSwitchOn bool
+	// HPPLMN is the higher priority PLMN search period, nil if EF_HPPLMN is absent
+	HPPLMN *EFHPPLMN
+}
+
+// PLMNSelection is the result of SelectPLMN with the steps that led to it
+type PLMNSelection struct {
+	Found  bool
+	PLMN   PLMNID
+	RAT    AccessTechnology
+	Signal int
+	// Reason names the selection step that chose the PLMN
+	Reason string
+	// HigherPrioritySearch is the period of the search for higher priority
+	// PLMNs while on a VPLMN, 0 if no search is done
+	HigherPrioritySearch time.Duration
+	Trace                []string
+}
+
+func (s *PLMNSelection) tracef(format string, args ...interface{}) {
+	s.Trace = append(s.Trace, fmt.Sprintf(format, args...))
+}
+
+// plmnSearch walks the candidates of one selection run
+type plmnSearch struct {
+	in   PLMNSelectionInput
+	scan []ScannedNetwork
+	sel  *PLMNSelection
+}
+
+func containsPLMN(list []PLMNID, p PLMNID) bool {
+	for _, q := range list {
+		if q == p {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *plmnSearch) forbidden(p PLMNID) bool {
+	return containsPLMN(s.in.Forbidden, p)
+}
+
+// homePLMNs returns the EHPLMNs, or the HPLMN if the EHPLMN list is empty
+func (s *plmnSearch) homePLMNs() []PLMNID {
+	if len(s.in.EHPLMNs) > 0 {
+		return s.in.EHPLMNs
+	}
+	return []PLMNID{s.in.HPLMN}
+}
+
+func (s *plmnSearch) isHome(p PLMNID) bool {
+	return p == s.in.HPLMN || containsPLMN(s.in.EHPLMNs, p)
+}
+
+// find returns the best scanned network of the PLMN on one of the access
+// technologies, act 0 allows any access technology
+func (s *plmnSearch) find(p PLMNID, act AccessTechnology) (best ScannedNetwork, ok bool) {
+	if act == 0 {
+		act = ACT_ALL
+	}
+	for _, rat := range ratPreference {
+		if act&rat == 0 {
+			continue
+		}
+		for _, n := range s.scan {
+			if n.PLMN == p && n.RAT == rat && (!ok || n.Signal > best.Signal) {
+				best, ok = n, true
+			}
+		}
+		if ok {
+			return
+		}
+	}
+	return
+}
+
+func (s *plmnSearch) choose(n ScannedNetwork, reason string) bool {
+	if s.forbidden(n.PLMN) {
+		s.sel.tracef("%s on %s skipped: forbidden PLMN", n.PLMN, n.RAT)
+		return false
+	}
+	s.sel.Found, s.sel.PLMN, s.sel.RAT, s.sel.Signal, s.sel.Reason = true, n.PLMN, n.RAT, n.Signal, reason
+	s.sel.tracef("selected %s on %s (%d dBm): %s", n.PLMN, n.RAT, n.Signal, reason)
+	return true
+}
+
+func (s *plmnSearch) tryList(list []PLMNwAcT, reason string) bool {
+	for i, e := range list {
+		n, ok := s.find(e.PLMNID, e.AcT)
+		if !ok {
+			s.sel.tracef("%s entry %d %s (%s) not found", reason, i+1, e.PLMNID, e.AcT)
+			continue
+		}
+		if s.choose(n, fmt.Sprintf("%s entry %d", reason, i+1)) {
+			return true
+		}
+	}
+	return false
+}
+
+// tryHome tries the EHPLMNs in priority order with the access technologies of EF_HPLMNwAcT
+func (s *plmnSearch) tryHome() bool {
+	for i, p := range s.homePLMNs() {
+		act := AccessTechnology(0)
+		for _, e := range s.in.HPLMNwAcT {
+			act |= e.AcT
+		}
+		n, ok := s.find(p, act)
+		if !ok {
+			s.sel.tracef("home PLMN %d %s not found", i+1, p)
+			continue
+		}
+		reason := "HPLMN"
+		if len(s.in.EHPLMNs) > 0 {
+			reason = fmt.Sprintf("EHPLMN %d", i+1)
+		}
+		if s.choose(n, reason) {
+			return true
+		}
+	}
+	return false
+}
+
+// tryOthers tries the remaining networks, high quality ones first, then in
+// order of decreasing signal
+func (s *plmnSearch) tryOthers() bool {
+	others := append([]ScannedNetwork{}, s.scan...)
+	sort.SliceStable(others, func(i, j int) bool {
+		if hi, hj := others[i].highQuality(), others[j].highQuality(); hi != hj {
+			return hi
+		}
+		return others[i].Signal > others[j].Signal
+	})
+	for _, n := range others {
+		reason := "other PLMN in order of decreasing signal"
+		if n.highQuality() {
+			reason = "other PLMN with high quality signal"
+		}
+		if s.choose(n, reason) {
+			return true
+		}
+	}
+	return false
+}
+
+// SelectPLMN runs the automatic network selection at switch on over the
+// scanned networks: the RPLMN, the EHPLMNs (or the HPLMN), the user and the
+// operator controlled selectors, then other networks. Forbidden PLMNs are
+// never selected.
+func SelectPLMN(in PLMNSelectionInput, scan []ScannedNetwork) (sel PLMNSelection) {
+	s := plmnSearch{in: in, scan: scan, sel: &sel}
+	steps := []func() bool{
+		func() bool {
+			if in.RPLMN.MCC == "" {
+				sel.tracef("no RPLMN")
+				return false
+			}
+			if in.HomeAtSwitchOn && !s.isHome(in.RPLMN) {
+				sel.tracef("RPLMN %s skipped: last RPLMN selection indication is home network", in.RPLMN)
+				return false
+			}
+			n, ok := s.find(in.RPLMN, 0)
+			if !ok {
+				sel.tracef("RPLMN %s not found", in.RPLMN)
+				return false
+			}
+			return s.choose(n, "RPLMN")
+		},
+		s.tryHome,
+		func() bool { return s.tryList(in.UserPLMNs, "user controlled PLMN selector") },
+		func() bool { return s.tryList(in.OperatorPLMNs, "operator controlled PLMN selector") },
+		s.tryOthers,
+	}
+	for _, step := range steps {
+		if step() {
+			break
+		}
+	}
+	if !sel.Found {
+		sel.tracef("no suitable PLMN, limited service")
+		return
+	}
+	if s.isHome(sel.PLMN) {
+		return
+	}
+	sel.HigherPrioritySearch = HPPLMN_DEFAULT_PERIOD
+	if in.HPPLMN != nil {
+		sel.HigherPrioritySearch = in.HPPLMN.Period()
+	}
+	if sel.HigherPrioritySearch == 0 {
+		sel.tracef("on VPLMN, no search for higher priority PLMNs")
+	} else {
+		sel.tracef("on VPLMN, higher priority PLMN search every %s", sel.HigherPrioritySearch)
+	}
+	return
+}
+
+// PLMNSelectionInput reads the PLMN lists for SelectPLMN from the card, files
+// that are missing or whose service is not available are left empty
+func (u *USIM) PLMNSelectionInput() (in PLMNSelectionInput, err error) {
+	if u.mccStr == "" {
+		return in, errors.New("PLMN selection: HPLMN unknown")
+	}
+	in.HPLMN = PLMNID{u.mccStr, u.mncStr}
+	skipped := func(name string, err error) {
+		logrus.Debugf("PLMN selection: %s not used: %v", name, err)
+	}
+	if f, e := ReadEF[EFEHPLMN](u); e == nil {
+		in.EHPLMNs = f.PLMNs
+	} else {
+		skipped("EF_EHPLMN", e)
+	}
+	if f, e := ReadEF[EFHPLMNwAcT](u); e == nil {
+		in.HPLMNwAcT = f.Entries
+	} else {
+		skipped("EF_HPLMNwAcT", e)
+	}
+	if f, e := ReadEF[EFPLMNwAcT](u); e == nil {
+		in.UserPLMNs = f.Entries
+	} else {
+		skipped("EF_PLMNwAcT", e)
+	}
+	if f, e := ReadEF[EFOPLMNwAcT](u); e == nil {
+		in.OperatorPLMNs = f.Entries
+	} else {
+		skipped("EF_OPLMNwAcT", e)
+	}
+	if f, e := ReadEF[EFFPLMN](u); e == nil {
+		in.Forbidden = f.PLMNs
+	} else {
+		skipped("EF_FPLMN", e)
+	}
+	if f, e := ReadEF[EFLRPLMNSI](u); e == nil {
+		in.HomeAtSwitchOn = f.HomeNetwork
+	} else {
+		skipped("EF_LRPLMNSI", e)
+	}
+	if f, e := ReadEF[EFHPPLMN](u); e == nil {
+		in.HPPLMN = &f
+	} else {
+		skipped("EF_HPPLMN", e)
+	}
+	in.RPLMN = u.registeredPLMN(skipped)
+	return
+}
+
+// registeredPLMN returns the RPLMN of the first location information file,
+// in the order EF_EPSLOCI, EF_5GS3GPPLOCI, EF_PSLOCI and EF_LOCI, whose
+// update status is "updated"
+func (u *USIM) registeredPLMN(skipped func(string, error)) PLMNID {
+	if f, e := ReadEF[EFEPSLOCI](u); e != nil {
+		skipped("EF_EPSLOCI", e)
+	} else if f.Status == UPDATE_STATUS_UPDATED && f.GUTI != nil {
+		return PLMNID{f.GUTI.MCC, f.GUTI.MNC}
+	}
+	if f, e := ReadEF[EF5GS3GPPLOCI](u); e != nil {
+		skipped("EF_5GS3GPPLOCI", e)
+	} else if f.Status == UPDATE_STATUS_UPDATED && f.GUTI != nil && f.GUTI.MCC != "" {
+		return PLMNID{f.GUTI.MCC, f.GUTI.MNC}
+	}
+	if f, e := ReadEF[EFPSLOCI](u); e != nil {
+		skipped("EF_PSLOCI", e)
+	} else if f.Status == UPDATE_STATUS_UPDATED && f.LAI.PLMN.MCC != "" {
+		return f.LAI.PLMN
+	}
+	if f, e := ReadEF[EFLOCI](u); e != nil {
+		skipped("EF_LOCI", e)
+	} else if f.Status == UPDATE_STATUS_UPDATED && f.LAI.PLMN.MCC != "" {
+		return f.LAI.PLMN
+	}
+	return PLMNID{}
+}
@@ -0,0 +1,119 @@
+package usim_go
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSelectPLMN(t *testing.T) {
+	home, roam1, roam2, roam3 := PLMNID{"208", "93"}, PLMNID{"262", "01"}, PLMNID{"262", "02"}, PLMNID{"262", "03"}
+	scan := []ScannedNetwork{
+		{roam1, ACT_EUTRAN, -100},
+		{roam2, ACT_GSM, -70},
+		{roam2, ACT_EUTRAN, -120},
+		{roam3, ACT_EUTRAN, -90},
+	}
+	in := PLMNSelectionInput{
+		HPLMN:         home,
+		OperatorPLMNs: []PLMNwAcT{{roam2, ACT_EUTRAN}, {roam1, ACT_EUTRAN}},
+		Forbidden:     []PLMNID{roam3},
+		HPPLMN:        &EFHPPLMN{Interval: 5},
+	}
+
+	sel := SelectPLMN(in, scan)
+	if !sel.Found || sel.PLMN != roam2 || sel.RAT != ACT_EUTRAN || sel.Reason != "operator controlled PLMN selector entry 1" {
+		t.Fatalf("selected %+v", sel)
+	}
+	if sel.HigherPrioritySearch != 30*time.Minute {
+		t.Errorf("higher priority search %s", sel.HigherPrioritySearch)
+	}
+
+	// the user controlled selector takes precedence
+	in.UserPLMNs = []PLMNwAcT{{roam1, ACT_UTRAN}, {roam1, 0}}
+	if sel = SelectPLMN(in, scan); sel.PLMN != roam1 || sel.Reason != "user controlled PLMN selector entry 2" {
+		t.Errorf("selected %+v", sel)
+	}
+
+	// the home network wins over the lists, the RPLMN over the home network
+	scan = append(scan, ScannedNetwork{home, ACT_UTRAN, -80})
+	if sel = SelectPLMN(in, scan); sel.PLMN != home || sel.Reason != "HPLMN" || sel.HigherPrioritySearch != 0 {
+		t.Errorf("selected %+v", sel)
+	}
+	in.RPLMN = roam1
+	if sel = SelectPLMN(in, scan); sel.PLMN != roam1 || sel.Reason != "RPLMN" {
+		t.Errorf("selected %+v", sel)
+	}
+	in.HomeAtSwitchOn = true
+	if sel = SelectPLMN(in, scan); sel.PLMN != home {
+		t.Errorf("selected %+v", sel)
+	}
+
+	// without list entries the forbidden PLMN is skipped for other networks
+	in = PLMNSelectionInput{HPLMN: home, Forbidden: []PLMNID{roam3}}
+	sel = SelectPLMN(in, scan[:4])
+	if sel.PLMN != roam2 || sel.RAT != ACT_GSM || sel.HigherPrioritySearch != HPPLMN_DEFAULT_PERIOD {
+		t.Errorf("selected %+v\n%v", sel, sel.Trace)
+	}
+	if sel = SelectPLMN(in, scan[3:4]); sel.Found {
+		t.Errorf("forbidden PLMN selected %+v", sel)
+	}
+}
+
+func TestPLMNSelectionInput(t *testing.T) {
+	u, _ := testSubscriber(t)
+	fplmn := EFFPLMN{plmnList{PLMNs: []PLMNID{{"262", "03"}}}}
+	if err := UpdateEF(u, &fplmn); err != nil {
+		t.Fatal(err)
+	}
+	in, err := u.PLMNSelectionInput()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if in.HPLMN != (PLMNID{"208", "93"}) || len(in.Forbidden) != 1 || in.HPPLMN != nil {
+		t.Errorf("input %+v", in)
+	}
+}
+
+func TestPLMNSelectionInputRPLMN(t *testing.T) {
+	u, _ := testSubscriber(t)
+	rplmn := func() PLMNID {
+		in, err := u.PLMNSelectionInput()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return in.RPLMN
+	}
+	// 2G/3G only card
+	loci := EFLOCI{TMSI: 1, LAI: LAI{PLMNID{"262", "01"}, 1}, Status: UPDATE_STATUS_UPDATED}
+	if err := UpdateEF(u, &loci); err != nil {
+		t.Fatal(err)
+	}
+	if p := rplmn(); p != (PLMNID{"262", "01"}) {
+		t.Errorf("RPLMN from EF_LOCI %+v", p)
+	}
+	ps := EFPSLOCI{PTMSI: 1, LAI: LAI{PLMNID{"262", "02"}, 1}, Status: UPDATE_STATUS_NOT_UPDATED}
+	if err := UpdateEF(u, &ps); err != nil {
+		t.Fatal(err)
+	}
+	if p := rplmn(); p != (PLMNID{"262", "01"}) {
+		t.Errorf("RPLMN with EF_PSLOCI not updated %+v", p)
+	}
+	// 5GS entry before the 2G/3G ones, a stale EPS entry is skipped
+	l := EF5GS3GPPLOCI{loci5GS{GUTI: &GUTI5G{MCC: "262", MNC: "03", AMFRegionID: 1, AMFSetID: 1, TMSI: 1}, TAI: TAI{PLMNID{"262", "03"}, 1}}}
+	eps := EFEPSLOCI{GUTI: &GUTI{MCC: "262", MNC: "07", MMEGroupID: 1, MMECode: 1, MTMSI: 1}, TAI: TAI{PLMNID{"262", "07"}, 1}, Status: UPDATE_STATUS_NOT_UPDATED}
+	for _, f := range []ElementaryFile{&l, &eps} {
+		if err := UpdateEF(u, f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if p := rplmn(); p != (PLMNID{"262", "03"}) {
+		t.Errorf("RPLMN from EF_5GS3GPPLOCI %+v", p)
+	}
+	eps.Status = UPDATE_STATUS_UPDATED
+	if err := UpdateEF(u, &eps); err != nil {
+		t.Fatal(err)
+	}
+	if p := rplmn(); p != (PLMNID{"262", "07"}) {
+		t.Errorf("RPLMN from EF_EPSLOCI %+v", p)
+	}
+}
@@ -0,0 +1,346 @@
+package usim_go
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Minimal RADIUS (RFC 2865) server carrying EAP (RFC 3579) for the EAPServer.
+
+const (
+	RADIUS_ACCESS_REQUEST   = 1
+	RADIUS_ACCESS_ACCEPT    = 2
+	RADIUS_ACCESS_REJECT    = 3
+	RADIUS_ACCESS_CHALLENGE = 11
+
+	RADIUS_ATTR_USER_NAME             = 1
+	RADIUS_ATTR_STATE                 = 24
+	RADIUS_ATTR_VENDOR_SPECIFIC       = 26
+	RADIUS_ATTR_EAP_MESSAGE           = 79
+	RADIUS_ATTR_MESSAGE_AUTHENTICATOR = 80
+
+	RADIUS_VENDOR_MICROSOFT   = 311
+	RADIUS_MS_MPPE_SEND_KEY   = 16
+	RADIUS_MS_MPPE_RECV_KEY   = 17
+	RADIUS_HEADER_LEN         = 20
+	RADIUS_MAX_ATTR_DATA_LEN  = 253
+	RADIUS_MAX_PACKET_LEN     = 4096
+	RADIUS_AUTHENTICATOR_LEN  = 16
+	RADIUS_STATE_LEN          = 16
+	RADIUS_MESSAGE_AUTH_LEN   = 16
+	RADIUS_MPPE_KEY_LEN       = 32
+	RADIUS_MPPE_SALT_LEN      = 2
+	RADIUS_MPPE_MAX_BLOCK_LEN = 16
+
+	// RADIUS_SESSION_TIMEOUT is the default time an unfinished EAP
+	// conversation is kept after the last Access-Request
+	RADIUS_SESSION_TIMEOUT = 60 * time.Second
+)
+
+type RADIUSAttribute struct {
+	Type  uint8
+	Value []byte
+}
+
+type RADIUSPacket struct {
+	Code          uint8
+	Identifier    uint8
+	Authenticator [16]byte
+	Attributes    []RADIUSAttribute
+}
+
+// DecodeRADIUS decodes a RADIUS packet
+func DecodeRADIUS(buf []byte) (p RADIUSPacket, err error) {
+	if len(buf) < RADIUS_HEADER_LEN {
+		err = fmt.Errorf("RADIUS: packet too short (%d bytes)", len(buf))
+		return
+	}
+	length := int(binary.BigEndian.Uint16(buf[2:4]))
+	if length < RADIUS_HEADER_LEN || length > len(buf) {
+		err = fmt.Errorf("RADIUS: invalid length %d", length)
+		return
+	}
+	p.Code, p.Identifier = buf[0], buf[1]
+	copy(p.Authenticator[:], buf[4:20])
+	for pos := RADIUS_HEADER_LEN; pos < length; {
+		if pos+2 > length || buf[pos+1] < 2 || pos+int(buf[pos+1]) > length {
+			err = errors.New("RADIUS: invalid attribute length")
+			return
+		}
+		p.Attributes = append(p.Attributes, RADIUSAttribute{Type: buf[pos], Value: append([]byte{}, buf[pos+2:pos+int(buf[pos+1])]...)})
+		pos += int(buf[pos+1])
+	}
+	return
+}
+
+// Encode encodes the packet as is, authenticators are not computed
+func (p RADIUSPacket) Encode() []byte {
+	buf := []byte{p.Code, p.Identifier, 0, 0}
+	buf = append(buf, p.Authenticator[:]...)
+	for _, a := range p.Attributes {
+		buf = append(buf, a.Type, byte(len(a.Value)+2))
+		buf = append(buf, a.Value...)
+	}
+	binary.BigEndian.PutUint16(buf[2:4], uint16(len(buf)))
+	return buf
+}
+
+// Attr returns the value of the first attribute of type t
+func (p RADIUSPacket) Attr(t uint8) ([]byte, bool) {
+	for _, a := range p.Attributes {
+		if a.Type == t {
+			return a.Value, true
+		}
+	}
+	return nil, false
+}
+
+// EAPMessage joins the EAP-Message attributes
+func (p RADIUSPacket) EAPMessage() (msg []byte) {
+	for _, a := range p.Attributes {
+		if a.Type == RADIUS_ATTR_EAP_MESSAGE {
+			msg = append(msg, a.Value...)
+		}
+	}
+	return
+}
+
+// addEAPMessage splits an EAP packet into EAP-Message attributes
+func (p *RADIUSPacket) addEAPMessage(msg []byte) {
+	for len(msg) > 0 {
+		n := len(msg)
+		if n > RADIUS_MAX_ATTR_DATA_LEN {
+			n = RADIUS_MAX_ATTR_DATA_LEN
+		}
+		p.Attributes = append(p.Attributes, RADIUSAttribute{Type: RADIUS_ATTR_EAP_MESSAGE, Value: msg[:n]})
+		msg = msg[n:]
+	}
+}
+
+// messageAuthenticator computes HMAC-MD5 over the packet with a zero
+// Message-Authenticator, authenticator is placed in the header.
+func radiusMessageAuthenticator(buf []byte, authenticator [16]byte, secret []byte) (mac []byte, off int) {
+	tmp := append([]byte{}, buf...)
+	copy(tmp[4:20], authenticator[:])
+	off = -1
+	for pos := RADIUS_HEADER_LEN; pos+2 <= len(tmp) && tmp[pos+1] >= 2; pos += int(tmp[pos+1]) {
+		if tmp[pos] == RADIUS_ATTR_MESSAGE_AUTHENTICATOR && tmp[pos+1] == 2+RADIUS_MESSAGE_AUTH_LEN {
+			off = pos + 2
+			copy(tmp[off:off+RADIUS_MESSAGE_AUTH_LEN], make([]byte, RADIUS_MESSAGE_AUTH_LEN))
+		}
+	}
+	h := hmac.New(md5.New, secret)
+	h.Write(tmp)
+	return h.Sum(nil), off
+}
+
+// mppeKeyAttr builds an MS-MPPE-Send/Recv-Key vendor attribute, see RFC 2548 2.4.2
+func mppeKeyAttr(vendorType uint8, key, secret []byte, reqAuth [16]byte) (RADIUSAttribute, error) {
+	salt, err := readRandom(RADIUS_MPPE_SALT_LEN)
+	if err != nil {
+		return RADIUSAttribute{}, err
+	}
+	salt[0] |= 0x80
+	plain := append([]byte{byte(len(key))}, key...)
+	if pad := len(plain) % RADIUS_MPPE_MAX_BLOCK_LEN; pad != 0 {
+		plain = append(plain, make([]byte, RADIUS_MPPE_MAX_BLOCK_LEN-pad)...)
+	}
+	cipherText := make([]byte, len(plain))
+	prev := append(reqAuth[:], salt...)
+	for i := 0; i < len(plain); i += RADIUS_MPPE_MAX_BLOCK_LEN {
+		h := md5.New()
+		h.Write(secret)
+		h.Write(prev)
+		b := h.Sum(nil)
+		for j := 0; j < RADIUS_MPPE_MAX_BLOCK_LEN; j++ {
+			cipherText[i+j] = plain[i+j] ^ b[j]
+		}
+		prev = cipherText[i : i+RADIUS_MPPE_MAX_BLOCK_LEN]
+	}
+	v := make([]byte, 4, 6+len(salt)+len(cipherText))
+	binary.BigEndian.PutUint32(v, RADIUS_VENDOR_MICROSOFT)
+	v = append(v, vendorType, byte(2+len(salt)+len(cipherText)))
+	v = append(v, salt...)
+	v = append(v, cipherText...)
+	return RADIUSAttribute{Type: RADIUS_ATTR_VENDOR_SPECIFIC, Value: v}, nil
+}
+
+// RADIUSServer answers Access-Requests carrying EAP with an EAPServer
+type RADIUSServer struct {
+	Secret []byte
+	EAP    *EAPServer
+	// SessionTimeout is the time an unfinished conversation is kept
+	SessionTimeout time.Duration
+
+	mu        sync.Mutex
+	sessions  map[string]*radiusSession
+	nextSweep time.Time
+	conn      net.PacketConn
+}
+
+// radiusSession is an EAP conversation identified by its State attribute
+type radiusSession struct {
+	*EAPSession
+	expires time.Time
+}
+
+func NewRADIUSServer(secret string, eap *EAPServer) *RADIUSServer {
+	return &RADIUSServer{Secret: []byte(secret), EAP: eap, SessionTimeout: RADIUS_SESSION_TIMEOUT, sessions: map[string]*radiusSession{}}
+}
+
+// ListenAndServe listens on the UDP address addr, e.g. ":1812"
+func (r *RADIUSServer) ListenAndServe(addr string) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	return r.Serve(conn)
+}
+
+// Serve handles packets from conn until it is closed
+func (r *RADIUSServer) Serve(conn net.PacketConn) error {
+	r.mu.Lock()
+	r.conn = conn
+	r.mu.Unlock()
+	buf := make([]byte, RADIUS_MAX_PACKET_LEN)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		resp, err := r.Handle(buf[:n])
+		if err != nil {
+			logrus.Error("RADIUS: ", err)
+			continue
+		}
+		if _, err = conn.WriteTo(resp, addr); err != nil {
+			logrus.Error("RADIUS: ", err)
+		}
+	}
+}
+
+// Close closes the listening socket
+func (r *RADIUSServer) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.conn == nil {
+		return nil
+	}
+	return r.conn.Close()
+}
+
+// Handle processes an encoded Access-Request and returns the encoded reply
+func (r *RADIUSServer) Handle(buf []byte) ([]byte, error) {
+	req, err := DecodeRADIUS(buf)
+	if err != nil {
+		return nil, err
+	}
+	if req.Code != RADIUS_ACCESS_REQUEST {
+		return nil, fmt.Errorf("RADIUS: unexpected code %d", req.Code)
+	}
+	eapMsg := req.EAPMessage()
+	if eapMsg == nil {
+		return nil, errors.New("RADIUS: Access-Request without EAP-Message")
+	}
+	// RFC 3579 3.2, Message-Authenticator is mandatory with EAP-Message
+	mac, off := radiusMessageAuthenticator(buf, req.Authenticator, r.Secret)
+	if off < 0 || !hmac.Equal(mac, buf[off:off+RADIUS_MESSAGE_AUTH_LEN]) {
+		return nil, errors.New("RADIUS: invalid Message-Authenticator")
+	}
+
+	state, hasState := req.Attr(RADIUS_ATTR_STATE)
+	sess, state, err := r.session(state, hasState)
+	if err != nil {
+		return nil, err
+	}
+
+	var eapResp []byte
+	if p, err := DecodeEAP(eapMsg); err == nil && p.Type == EAP_TYPE_IDENTITY && !hasState {
+		// the identity response answers the authenticator's own EAP-Request/Identity
+		sess.identifier = p.Identifier
+	}
+	if eapResp, err = sess.Process(eapMsg); err != nil {
+		r.deleteSession(state)
+		return nil, err
+	}
+
+	reply := RADIUSPacket{Code: RADIUS_ACCESS_CHALLENGE, Identifier: req.Identifier}
+	reply.addEAPMessage(eapResp)
+	if done, success := sess.Done(); done {
+		r.deleteSession(state)
+		reply.Code = RADIUS_ACCESS_REJECT
+		if success {
+			reply.Code = RADIUS_ACCESS_ACCEPT
+			msk := sess.MSK()
+			recv, err := mppeKeyAttr(RADIUS_MS_MPPE_RECV_KEY, msk[:RADIUS_MPPE_KEY_LEN], r.Secret, req.Authenticator)
+			if err != nil {
+				return nil, err
+			}
+			send, err := mppeKeyAttr(RADIUS_MS_MPPE_SEND_KEY, msk[RADIUS_MPPE_KEY_LEN:2*RADIUS_MPPE_KEY_LEN], r.Secret, req.Authenticator)
+			if err != nil {
+				return nil, err
+			}
+			reply.Attributes = append(reply.Attributes, recv, send)
+			if id := sess.Identity(); id != "" {
+				reply.Attributes = append(reply.Attributes, RADIUSAttribute{Type: RADIUS_ATTR_USER_NAME, Value: []byte(id)})
+			}
+		}
+	} else {
+		reply.Attributes = append(reply.Attributes, RADIUSAttribute{Type: RADIUS_ATTR_STATE, Value: state})
+	}
+	reply.Attributes = append(reply.Attributes, RADIUSAttribute{Type: RADIUS_ATTR_MESSAGE_AUTHENTICATOR, Value: make([]byte, RADIUS_MESSAGE_AUTH_LEN)})
+	out := reply.Encode()
+	mac, off = radiusMessageAuthenticator(out, req.Authenticator, r.Secret)
+	copy(out[off:], mac)
+	// Response Authenticator = MD5(Code+ID+Length+RequestAuth+Attributes+Secret)
+	copy(out[4:20], req.Authenticator[:])
+	h := md5.New()
+	h.Write(out)
+	h.Write(r.Secret)
+	copy(out[4:20], h.Sum(nil))
+	return out, nil
+}
+
+// session returns the conversation of state or starts a new one with a new
+// state. Conversations idle for longer than SessionTimeout are dropped.
+func (r *RADIUSServer) session(state []byte, hasState bool) (*EAPSession, []byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	if now.After(r.nextSweep) {
+		for k, s := range r.sessions {
+			if now.After(s.expires) {
+				delete(r.sessions, k)
+			}
+		}
+		r.nextSweep = now.Add(r.SessionTimeout)
+	}
+	s := r.sessions[string(state)]
+	if !hasState || s == nil || now.After(s.expires) {
+		var err error
+		if state, err = readRandom(RADIUS_STATE_LEN); err != nil {
+			return nil, nil, err
+		}
+		s = &radiusSession{}
+		if s.EAPSession, _, err = r.EAP.NewSession(); err != nil {
+			return nil, nil, err
+		}
+		r.sessions[string(state)] = s
+	}
+	s.expires = now.Add(r.SessionTimeout)
+	return s.EAPSession, state, nil
+}
+
+func (r *RADIUSServer) deleteSession(state []byte) {
+	r.mu.Lock()
+	delete(r.sessions, string(state))
+	r.mu.Unlock()
+}
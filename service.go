@@ -0,0 +1,409 @@
+package usim_go
+
+import (
+	"errors"
+	"fmt"
+
+	smartcard "github.com/sf1/go-card/smartcard"
+	"github.com/sirupsen/logrus"
+)
+
+// Service tables EF_UST and EF_EST of TS 31.102 4.2.8 and 4.2.47, EF_IST of TS 31.103 4.2.7
+
+// Service is a service number of the USIM service table
+type Service int
+
+const (
+	SERVICE_LOCAL_PHONE_BOOK   Service = 1
+	SERVICE_FDN                Service = 2
+	SERVICE_EXT2               Service = 3
+	SERVICE_SDN                Service = 4
+	SERVICE_EXT3               Service = 5
+	SERVICE_BDN                Service = 6
+	SERVICE_EXT4               Service = 7
+	SERVICE_OCI_OCT            Service = 8
+	SERVICE_ICI_ICT            Service = 9
+	SERVICE_SMS                Service = 10
+	SERVICE_SMSR               Service = 11
+	SERVICE_SMSP               Service = 12
+	SERVICE_AOC                Service = 13
+	SERVICE_CCP2               Service = 14
+	SERVICE_CBMI               Service = 15
+	SERVICE_CBMIR              Service = 16
+	SERVICE_GID1               Service = 17
+	SERVICE_GID2               Service = 18
+	SERVICE_SPN                Service = 19
+	SERVICE_PLMNWACT           Service = 20
+	SERVICE_MSISDN             Service = 21
+	SERVICE_IMG                Service = 22
+	SERVICE_SOLSA              Service = 23
+	SERVICE_EMLPP              Service = 24
+	SERVICE_AAEM               Service = 25
+	SERVICE_GSM_ACCESS         Service = 27
+	SERVICE_SMS_PP_DOWNLOAD    Service = 28
+	SERVICE_SMS_CB_DOWNLOAD    Service = 29
+	SERVICE_CALL_CONTROL       Service = 30
+	SERVICE_MO_SMS_CONTROL     Service = 31
+	SERVICE_RUN_AT_COMMAND     Service = 32
+	SERVICE_EST                Service = 34
+	SERVICE_ACL                Service = 35
+	SERVICE_DCK                Service = 36
+	SERVICE_CNL                Service = 37
+	SERVICE_GSM_SECURITY       Service = 38
+	SERVICE_OPLMNWACT          Service = 42
+	SERVICE_HPLMNWACT          Service = 43
+	SERVICE_EXT5               Service = 44
+	SERVICE_PNN                Service = 45
+	SERVICE_OPL                Service = 46
+	SERVICE_MBDN               Service = 47
+	SERVICE_MWIS               Service = 48
+	SERVICE_CFIS               Service = 49
+	SERVICE_SPDI               Service = 51
+	SERVICE_MMS                Service = 52
+	SERVICE_GPRS_CALL_CONTROL  Service = 54
+	SERVICE_PSEUDONYM          Service = 59
+	SERVICE_WLAN_REAUTH_ID     Service = 66
+	SERVICE_GBA                Service = 68
+	SERVICE_MBMS_SECURITY      Service = 69
+	SERVICE_EHPLMN             Service = 71
+	SERVICE_EHPLMN_PRESENT_IND Service = 73
+	SERVICE_LAST_RPLMN_IND     Service = 74
+	SERVICE_EPS_MM_INFO        Service = 85
+	SERVICE_ALLOWED_CSG        Service = 86
+	SERVICE_EPS_CALL_CONTROL   Service = 87
+	SERVICE_HPLMN_DIRECT       Service = 88
+	SERVICE_ECALL              Service = 89
+	SERVICE_SM_OVER_IP         Service = 91
+	SERVICE_UICC_IMS           Service = 95
+	SERVICE_NAS_CONFIG         Service = 96
+	SERVICE_PWS_CONFIG         Service = 97
+	SERVICE_5GS_MM_INFO        Service = 122
+	SERVICE_5G_SECURITY        Service = 123
+	SERVICE_SUCI_PRIVACY       Service = 124
+	SERVICE_SUCI_BY_USIM       Service = 125
+	SERVICE_UAC_AIC            Service = 126
+)
+
+var serviceNames = map[Service]string{
+	SERVICE_LOCAL_PHONE_BOOK:   "Local Phone Book",
+	SERVICE_FDN:                "Fixed Dialling Numbers",
+	SERVICE_EXT2:               "Extension 2",
+	SERVICE_SDN:                "Service Dialling Numbers",
+	SERVICE_EXT3:               "Extension 3",
+	SERVICE_BDN:                "Barred Dialling Numbers",
+	SERVICE_EXT4:               "Extension 4",
+	SERVICE_OCI_OCT:            "Outgoing Call Information",
+	SERVICE_ICI_ICT:            "Incoming Call Information",
+	SERVICE_SMS:                "Short Message Storage",
+	SERVICE_SMSR:               "Short Message Status Reports",
+	SERVICE_SMSP:               "Short Message Service Parameters",
+	SERVICE_AOC:                "Advice of Charge",
+	SERVICE_CCP2:               "Capability Configuration Parameters 2",
+	SERVICE_CBMI:               "Cell Broadcast Message Identifier",
+	SERVICE_CBMIR:              "Cell Broadcast Message Identifier Ranges",
+	SERVICE_GID1:               "Group Identifier Level 1",
+	SERVICE_GID2:               "Group Identifier Level 2",
+	SERVICE_SPN:                "Service Provider Name",
+	SERVICE_PLMNWACT:           "User controlled PLMN selector with Access Technology",
+	SERVICE_MSISDN:             "MSISDN",
+	SERVICE_IMG:                "Image",
+	SERVICE_SOLSA:              "Support of Localised Service Areas",
+	SERVICE_EMLPP:              "Enhanced Multi-Level Precedence and Pre-emption",
+	SERVICE_AAEM:               "Automatic Answer for eMLPP",
+	SERVICE_GSM_ACCESS:         "GSM Access",
+	SERVICE_SMS_PP_DOWNLOAD:    "Data download via SMS-PP",
+	SERVICE_SMS_CB_DOWNLOAD:    "Data download via SMS-CB",
+	SERVICE_CALL_CONTROL:       "Call Control by USIM",
+	SERVICE_MO_SMS_CONTROL:     "MO-SMS Control by USIM",
+	SERVICE_RUN_AT_COMMAND:     "RUN AT COMMAND",
+	SERVICE_EST:                "Enabled Services Table",
+	SERVICE_ACL:                "APN Control List",
+	SERVICE_DCK:                "Depersonalisation Control Keys",
+	SERVICE_CNL:                "Co-operative Network List",
+	SERVICE_GSM_SECURITY:       "GSM security context",
+	SERVICE_OPLMNWACT:          "Operator controlled PLMN selector with Access Technology",
+	SERVICE_HPLMNWACT:          "HPLMN selector with Access Technology",
+	SERVICE_EXT5:               "Extension 5",
+	SERVICE_PNN:                "PLMN Network Name",
+	SERVICE_OPL:                "Operator PLMN List",
+	SERVICE_MBDN:               "Mailbox Dialling Numbers",
+	SERVICE_MWIS:               "Message Waiting Indication Status",
+	SERVICE_CFIS:               "Call Forwarding Indication Status",
+	SERVICE_SPDI:               "Service Provider Display Information",
+	SERVICE_MMS:                "Multimedia Messaging Service",
+	SERVICE_GPRS_CALL_CONTROL:  "Call control on GPRS by USIM",
+	SERVICE_PSEUDONYM:          "Pseudonym",
+	SERVICE_WLAN_REAUTH_ID:     "WLAN Reauthentication Identity",
+	SERVICE_GBA:                "Generic Bootstrapping Architecture",
+	SERVICE_MBMS_SECURITY:      "MBMS security",
+	SERVICE_EHPLMN:             "Equivalent HPLMN",
+	SERVICE_EHPLMN_PRESENT_IND: "Equivalent HPLMN Presentation Indication",
+	SERVICE_LAST_RPLMN_IND:     "Last RPLMN Selection Indication",
+	SERVICE_EPS_MM_INFO:        "EPS Mobility Management Information",
+	SERVICE_ALLOWED_CSG:        "Allowed CSG Lists",
+	SERVICE_EPS_CALL_CONTROL:   "Call control on EPS PDN connection by USIM",
+	SERVICE_HPLMN_DIRECT:       "HPLMN Direct Access",
+	SERVICE_ECALL:              "eCall Data",
+	SERVICE_SM_OVER_IP:         "SM over IP",
+	SERVICE_UICC_IMS:           "UICC access to IMS",
+	SERVICE_NAS_CONFIG:         "Non-Access Stratum configuration",
+	SERVICE_PWS_CONFIG:         "PWS configuration",
+	SERVICE_5GS_MM_INFO:        "5GS Mobility Management Information",
+	SERVICE_5G_SECURITY:        "5G Security Parameters",
+	SERVICE_SUCI_PRIVACY:       "Subscription identifier privacy support",
+	SERVICE_SUCI_BY_USIM:       "SUCI calculation by the USIM",
+	SERVICE_UAC_AIC:            "UAC Access Identities support",
+}
+
+func (s Service) String() string {
+	if n, ok := serviceNames[s]; ok {
+		return n
+	}
+	return fmt.Sprintf("Service %d", int(s))
+}
+
+// ESTService is a service number of the enabled services table
+type ESTService int
+
+const (
+	EST_SERVICE_FDN ESTService = 1
+	EST_SERVICE_BDN ESTService = 2
+	EST_SERVICE_ACL ESTService = 3
+)
+
+// ustService returns the UST service that must be available to enable s
+func (s ESTService) ustService() Service {
+	switch s {
+	case EST_SERVICE_FDN:
+		return SERVICE_FDN
+	case EST_SERVICE_BDN:
+		return SERVICE_BDN
+	case EST_SERVICE_ACL:
+		return SERVICE_ACL
+	}
+	return 0
+}
+
+func (s ESTService) String() string {
+	return fmt.Sprintf("EST %s", s.ustService())
+}
+
+// ISIMService is a service number of the ISIM service table
+type ISIMService int
+
+const (
+	ISIM_SERVICE_PCSCF_ADDRESS   ISIMService = 1
+	ISIM_SERVICE_GBA             ISIMService = 2
+	ISIM_SERVICE_HTTP_DIGEST     ISIMService = 3
+	ISIM_SERVICE_GBA_LOCAL_KEY   ISIMService = 4
+	ISIM_SERVICE_PCSCF_LBO       ISIMService = 5
+	ISIM_SERVICE_SMS             ISIMService = 6
+	ISIM_SERVICE_SMSR            ISIMService = 7
+	ISIM_SERVICE_SM_OVER_IP      ISIMService = 8
+	ISIM_SERVICE_IMS_CONTROL     ISIMService = 9
+	ISIM_SERVICE_UICC_IMS        ISIMService = 10
+	ISIM_SERVICE_URI             ISIMService = 11
+	ISIM_SERVICE_MEDIA_TYPE      ISIMService = 12
+	ISIM_SERVICE_DISCONNECT      ISIMService = 13
+	ISIM_SERVICE_URI_MO_SMS      ISIMService = 14
+	ISIM_SERVICE_MCPTT           ISIMService = 15
+	ISIM_SERVICE_URI_SMS_PP      ISIMService = 16
+	ISIM_SERVICE_FROM_PREFERRED  ISIMService = 17
+	ISIM_SERVICE_IMS_CONFIG_DATA ISIMService = 18
+	ISIM_SERVICE_XCAP_CONFIG     ISIMService = 19
+)
+
+// SERVICE_NOT_AVAILABLE is wrapped by errors for EFs whose service is not
+// available in the USIM service table
+var SERVICE_NOT_AVAILABLE = errors.New("service not available")
+
+// ServiceTable is the bit string of a service table, bit b0 of the first
+// byte is service n°1
+type ServiceTable []byte
+
+// Has reports whether service n is available (UST, IST) or enabled (EST)
+func (t ServiceTable) Has(n int) bool {
+	n--
+	return n >= 0 && n/8 < len(t) && t[n/8]>>(n%8)&1 != 0
+}
+
+// Set marks service n, the table grows as needed
+func (t *ServiceTable) Set(n int, on bool) {
+	n--
+	if n < 0 {
+		return
+	}
+	for len(*t) <= n/8 {
+		*t = append(*t, 0)
+	}
+	if on {
+		(*t)[n/8] |= 1 << (n % 8)
+	} else {
+		(*t)[n/8] &^= 1 << (n % 8)
+	}
+}
+
+// Numbers returns the available service numbers in ascending order
+func (t ServiceTable) Numbers() (list []int) {
+	for n := 1; n <= len(t)*8; n++ {
+		if t.Has(n) {
+			list = append(list, n)
+		}
+	}
+	return
+}
+
+// EFUST is EF_UST, TS 31.102 4.2.8
+type EFUST struct {
+	Table ServiceTable
+}
+
+func (e *EFUST) Info() EFInfo { return efInfo("EF_UST") }
+
+func (e *EFUST) Decode(records [][]byte) error {
+	r, err := singleRecord("EF_UST", records, 1)
+	e.Table = r
+	return err
+}
+
+func (e *EFUST) Encode() ([][]byte, error) {
+	return [][]byte{e.Table}, nil
+}
+
+func (e EFUST) Has(s Service) bool {
+	return e.Table.Has(int(s))
+}
+
+// Services returns the available services
+func (e EFUST) Services() (list []Service) {
+	for _, n := range e.Table.Numbers() {
+		list = append(list, Service(n))
+	}
+	return
+}
+
+// EFEST is EF_EST, TS 31.102 4.2.47
+type EFEST struct {
+	Table ServiceTable
+}
+
+func (e *EFEST) Info() EFInfo { return efInfo("EF_EST") }
+
+func (e *EFEST) Decode(records [][]byte) error {
+	r, err := singleRecord("EF_EST", records, 1)
+	e.Table = r
+	return err
+}
+
+func (e *EFEST) Encode() ([][]byte, error) {
+	return [][]byte{e.Table}, nil
+}
+
+func (e EFEST) Enabled(s ESTService) bool {
+	return e.Table.Has(int(s))
+}
+
+// EFIST is EF_IST of the ISIM, TS 31.103 4.2.7
+type EFIST struct {
+	Table ServiceTable
+}
+
+func (e *EFIST) Info() EFInfo { return efInfo("EF_IST") }
+
+func (e *EFIST) Decode(records [][]byte) error {
+	r, err := singleRecord("EF_IST", records, 1)
+	e.Table = r
+	return err
+}
+
+func (e *EFIST) Encode() ([][]byte, error) {
+	return [][]byte{e.Table}, nil
+}
+
+func (e EFIST) Has(s ISIMService) bool {
+	return e.Table.Has(int(s))
+}
+
+// serviceTable returns the USIM service table. It is cached once read, or
+// once the card or profile is known to have no EF_UST; other read errors
+// are retried on the next call. ok is false if no table is available.
+func (u *USIM) serviceTable() (t ServiceTable, ok bool) {
+	if !u.ustRead {
+		ust, err := ReadEF[EFUST](u)
+		if err != nil {
+			logrus.Debug("EF_UST not available, EFs are read without service check: ", err)
+			u.ustRead = errors.Is(err, FILE_NOT_FOUND)
+			return nil, false
+		}
+		u.ust, u.ustRead = ust.Table, true
+	}
+	return u.ust, u.ust != nil
+}
+
+// HasService reports whether service s is available in the USIM service table
+func (u *USIM) HasService(s Service) bool {
+	t, ok := u.serviceTable()
+	return ok && t.Has(int(s))
+}
+
+// Services returns the services available in the USIM service table
+func (u *USIM) Services() ([]Service, error) {
+	if _, ok := u.serviceTable(); !ok {
+		return nil, errors.New("EF_UST not available")
+	}
+	return EFUST{Table: u.ust}.Services(), nil
+}
+
+// checkService fails with SERVICE_NOT_AVAILABLE if the EF depends on a service
+// missing from EF_UST. Without a service table every EF is tried.
+func (u *USIM) checkService(info EFInfo) error {
+	s := info.Service()
+	if s == 0 {
+		return nil
+	}
+	if t, ok := u.serviceTable(); ok && !t.Has(int(s)) {
+		return fmt.Errorf("%s: %w (%s)", info.Name, SERVICE_NOT_AVAILABLE, s)
+	}
+	return nil
+}
+
+// ServiceEnabled reports whether service s is enabled in EF_EST
+func (u *USIM) ServiceEnabled(s ESTService) (bool, error) {
+	est, err := ReadEF[EFEST](u)
+	if err != nil {
+		return false, err
+	}
+	return est.Enabled(s), nil
+}
+
+// SetServiceEnabled enables or disables an EST service (FDN, BDN or ACL),
+// which is protected by PIN2. PIN2 is not checked for soft profiles.
+func (u *USIM) SetServiceEnabled(s ESTService, enable bool, pin2 string) (err error) {
+	if !u.HasService(s.ustService()) {
+		return fmt.Errorf("%s: %w", s, SERVICE_NOT_AVAILABLE)
+	}
+	est, err := ReadEF[EFEST](u)
+	if err != nil {
+		return
+	}
+	est.Table.Set(int(s), enable)
+	if u.soft {
+		return UpdateEF(u, &est)
+	}
+	var card *smartcard.Card
+	if card, err = u.reader.Connect(); err != nil {
+		logrus.Error(err)
+		return
+	}
+	defer card.Disconnect()
+	if err = verify_pin(card, u.aid, USIM_PIN2_REF, pin2); err != nil {
+		return
+	}
+	logrus.Infof("%s enabled: %v", s, enable)
+	return updateEF(card, u.aid, est.Info(), [][]byte{est.Table})
+}
+
+// ISIMServices reads the ISIM service table
+func (u *USIM) ISIMServices() (EFIST, error) {
+	return ReadEF[EFIST](u)
+}
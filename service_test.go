@@ -0,0 +1,95 @@
+package usim_go
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestServiceTable(t *testing.T) {
+	// FDN, SMS, GID1, EST and 5GS MM information
+	ust := EFUST{}
+	if err := ust.Decode([][]byte{unhex("02020100020000000000000000000002")}); err != nil {
+		t.Fatal(err)
+	}
+	want := []Service{SERVICE_FDN, SERVICE_SMS, SERVICE_GID1, SERVICE_EST, SERVICE_5GS_MM_INFO}
+	if got := ust.Services(); !reflect.DeepEqual(got, want) {
+		t.Errorf("services %v, want %v", got, want)
+	}
+	if ust.Has(SERVICE_BDN) || !ust.Has(SERVICE_FDN) {
+		t.Error("UST Has")
+	}
+	var tbl ServiceTable
+	tbl.Set(10, true)
+	tbl.Set(3, true)
+	tbl.Set(3, false)
+	if !reflect.DeepEqual(tbl, ServiceTable{0x00, 0x02}) {
+		t.Errorf("Set %x", []byte(tbl))
+	}
+	if SERVICE_SPN.String() != "Service Provider Name" || Service(200).String() != "Service 200" {
+		t.Error("service names")
+	}
+}
+
+func TestServiceGating(t *testing.T) {
+	u, _ := testSubscriber(t)
+	gid := EFGID1{Data: []byte{0x01}}
+	// without EF_UST every EF is tried
+	if err := UpdateEF(u, &gid); err != nil {
+		t.Fatal(err)
+	}
+	var ust ServiceTable
+	ust.Set(int(SERVICE_FDN), true)
+	ust.Set(int(SERVICE_EST), true)
+	if err := UpdateEF(u, &EFUST{Table: ust}); err != nil {
+		t.Fatal(err)
+	}
+	if !u.HasService(SERVICE_FDN) || u.HasService(SERVICE_GID1) {
+		t.Fatal("HasService")
+	}
+	if _, err := ReadEF[EFGID1](u); !errors.Is(err, SERVICE_NOT_AVAILABLE) {
+		t.Errorf("EF_GID1 read without service: %v", err)
+	}
+	if _, err := ReadEF[EFAD](u); err != nil {
+		t.Errorf("EF_AD: %v", err)
+	}
+
+	if err := UpdateEF(u, &EFEST{Table: ServiceTable{0}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.SetServiceEnabled(EST_SERVICE_FDN, true, "1234"); err != nil {
+		t.Fatal(err)
+	}
+	if on, err := u.ServiceEnabled(EST_SERVICE_FDN); err != nil || !on {
+		t.Errorf("FDN enabled %v, %v", on, err)
+	}
+	if err := u.SetServiceEnabled(EST_SERVICE_BDN, true, "1234"); !errors.Is(err, SERVICE_NOT_AVAILABLE) {
+		t.Errorf("BDN enabled without service: %v", err)
+	}
+}
+
+func TestServiceTableCache(t *testing.T) {
+	u, _ := testSubscriber(t)
+	var ust ServiceTable
+	ust.Set(int(SERVICE_FDN), true)
+
+	// an unreadable EF_UST is read again on the next check
+	u.files = map[string][][]byte{"EF_UST": {}}
+	if u.HasService(SERVICE_FDN) || u.ustRead {
+		t.Fatal("failed EF_UST read cached")
+	}
+	u.files["EF_UST"] = [][]byte{ust}
+	if !u.HasService(SERVICE_FDN) {
+		t.Error("EF_UST not read again")
+	}
+
+	// a missing EF_UST is not looked for again
+	u, _ = testSubscriber(t)
+	if _, ok := u.serviceTable(); ok || !u.ustRead {
+		t.Fatal("missing EF_UST not cached")
+	}
+	u.files = map[string][][]byte{"EF_UST": {ust}}
+	if u.HasService(SERVICE_FDN) {
+		t.Error("EF_UST read again")
+	}
+}
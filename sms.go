@@ -0,0 +1,686 @@
+package usim_go
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Short messages stored in EF_SMS, TS 31.102 4.2.25, with the TPDUs of
+// TS 23.040 9.2.2.1 (SMS-DELIVER) and 9.2.2.2 (SMS-SUBMIT)
+
+// Status byte of an EF_SMS record
+const (
+	SMS_STATUS_FREE    = 0x00
+	SMS_STATUS_READ    = 0x01
+	SMS_STATUS_UNREAD  = 0x03
+	SMS_STATUS_SENT    = 0x05
+	SMS_STATUS_TO_SEND = 0x07
+
+	smsRecordLen = 176
+)
+
+// TP-MTI of TS 23.040 9.2.3.1
+const (
+	SMS_DELIVER = 0x00
+	SMS_SUBMIT  = 0x01
+)
+
+// Character sets of the TP-DCS, TS 23.038 4
+const (
+	SMS_ALPHABET_GSM7 = 0
+	SMS_ALPHABET_8BIT = 1
+	SMS_ALPHABET_UCS2 = 2
+)
+
+// TP-VPF of SMS-SUBMIT, TS 23.040 9.2.3.3
+const (
+	SMS_VPF_NONE     = 0x00
+	SMS_VPF_ENHANCED = 0x01
+	SMS_VPF_RELATIVE = 0x02
+	SMS_VPF_ABSOLUTE = 0x03
+)
+
+const (
+	tpUDHI = 0x40
+	// type of address for alphanumeric addresses, TS 23.040 9.1.2.5
+	tonAlphanumeric = 0x50
+
+	udhConcat8  = 0x00
+	udhConcat16 = 0x08
+)
+
+// smsAlphabet returns the character set of a TP-DCS
+func smsAlphabet(dcs byte) int {
+	switch {
+	case dcs&0x80 == 0:
+		// general data coding, bit 5 is compression which is not supported
+		if a := int(dcs>>2) & 0x03; a != 3 {
+			return a
+		}
+	case dcs&0xF0 == 0xE0:
+		return SMS_ALPHABET_UCS2
+	case dcs&0xF0 == 0xF0 && dcs&0x04 != 0:
+		return SMS_ALPHABET_8BIT
+	}
+	return SMS_ALPHABET_GSM7
+}
+
+// decodeSMSAddress decodes a TP address whose length counts digits, or the
+// RP address of the service centre whose length counts octets. n is the
+// number of octets used.
+func decodeSMSAddress(b []byte, octetLen bool) (addr string, n int, err error) {
+	if len(b) < 1 {
+		return "", 0, errors.New("SMS: address missing")
+	}
+	l := int(b[0])
+	if octetLen {
+		if l == 0 || l == 0xFF {
+			return "", 1, nil
+		}
+		n = 1 + l
+	} else {
+		n = 2 + (l+1)/2
+	}
+	if n > len(b) || n < 2 {
+		return "", 0, fmt.Errorf("SMS: address length %d too long", l)
+	}
+	toa, digits := b[1], b[2:n]
+	if toa&0x70 == tonAlphanumeric {
+		septets := len(digits) * 8 / 7
+		if !octetLen {
+			septets = l * 4 / 7
+		}
+		return decodeGSM7(unpackGSM7(digits, septets)), n, nil
+	}
+	addr = decodeDialDigits(digits)
+	if toa&0x70 == TON_INTERNATIONAL {
+		addr = "+" + addr
+	}
+	return
+}
+
+// encodeSMSAddress encodes an address, a leading "+" makes it international
+func encodeSMSAddress(addr string, octetLen bool) ([]byte, error) {
+	toa := byte(TONNPI_DEFAULT)
+	if len(addr) > 0 && addr[0] == '+' {
+		toa, addr = TONNPI_INTERNATIONAL, addr[1:]
+	}
+	if octetLen && addr == "" {
+		return []byte{0x00}, nil
+	}
+	bcd, err := encodeDialDigits(addr)
+	if err != nil {
+		return nil, err
+	}
+	l := len(addr)
+	if octetLen {
+		l = len(bcd) + 1
+	}
+	return append([]byte{byte(l), toa}, bcd...), nil
+}
+
+func semiOctet(b byte) int { return int(b&0x0F)*10 + int(b>>4) }
+
+func toSemiOctet(v int) byte { return byte(v%10)<<4 | byte(v/10%10) }
+
+// decodeSCTS decodes a service centre time stamp, TS 23.040 9.2.3.11
+func decodeSCTS(b []byte) time.Time {
+	tz := (int(b[6]&0x07)*10 + int(b[6]>>4)) * 15 * 60
+	if b[6]&0x08 != 0 {
+		tz = -tz
+	}
+	return time.Date(2000+semiOctet(b[0]), time.Month(semiOctet(b[1])), semiOctet(b[2]),
+		semiOctet(b[3]), semiOctet(b[4]), semiOctet(b[5]), 0, time.FixedZone("", tz))
+}
+
+func encodeSCTS(t time.Time) []byte {
+	_, offset := t.Zone()
+	sign := byte(0)
+	if offset < 0 {
+		sign, offset = 0x08, -offset
+	}
+	tz := toSemiOctet(offset / (15 * 60))
+	return []byte{
+		toSemiOctet(t.Year() % 100), toSemiOctet(int(t.Month())), toSemiOctet(t.Day()),
+		toSemiOctet(t.Hour()), toSemiOctet(t.Minute()), toSemiOctet(t.Second()), tz | sign,
+	}
+}
+
+// SMSConcat is the concatenated short message information element of TS 23.040 9.2.3.24.1
+type SMSConcat struct {
+	Ref   uint16
+	Total byte
+	Seq   byte
+}
+
+// SMSTPDU is a decoded SMS-DELIVER or SMS-SUBMIT. Address is the originating
+// address of a DELIVER and the destination address of a SUBMIT. Text holds
+// the GSM 7 bit or UCS2 user data, Data the 8 bit user data.
+type SMSTPDU struct {
+	Type    byte
+	Address string
+	PID     byte
+	DCS     byte
+	// Timestamp is the service centre time stamp of an SMS-DELIVER
+	Timestamp time.Time
+	// MR, VPF and VP are the message reference and validity period of an SMS-SUBMIT
+	MR                  byte
+	VPF                 byte
+	VP                  []byte
+	StatusReportRequest bool
+	Concat              *SMSConcat
+	Text                string
+	Data                []byte
+}
+
+// DecodeSMSTPDU decodes an SMS-DELIVER or SMS-SUBMIT TPDU
+func DecodeSMSTPDU(b []byte) (m SMSTPDU, err error) {
+	if len(b) < 1 {
+		return m, errors.New("SMS: empty TPDU")
+	}
+	first := b[0]
+	m.Type = first & 0x03
+	i := 1
+	switch m.Type {
+	case SMS_DELIVER:
+		m.StatusReportRequest = first&0x20 != 0
+	case SMS_SUBMIT:
+		m.StatusReportRequest = first&0x20 != 0
+		m.VPF = first >> 3 & 0x03
+		if len(b) < 2 {
+			return m, errors.New("SMS: TPDU too short")
+		}
+		m.MR, i = b[1], 2
+	default:
+		return m, fmt.Errorf("SMS: unsupported TP-MTI %d", m.Type)
+	}
+	var n int
+	if m.Address, n, err = decodeSMSAddress(b[i:], false); err != nil {
+		return
+	}
+	i += n
+	if i+2 > len(b) {
+		return m, errors.New("SMS: TPDU too short")
+	}
+	m.PID, m.DCS = b[i], b[i+1]
+	i += 2
+	if m.Type == SMS_DELIVER {
+		if i+7 > len(b) {
+			return m, errors.New("SMS: TPDU too short")
+		}
+		m.Timestamp = decodeSCTS(b[i : i+7])
+		i += 7
+	} else {
+		n = map[byte]int{SMS_VPF_NONE: 0, SMS_VPF_RELATIVE: 1, SMS_VPF_ENHANCED: 7, SMS_VPF_ABSOLUTE: 7}[m.VPF]
+		if i+n > len(b) {
+			return m, errors.New("SMS: TPDU too short")
+		}
+		m.VP = append([]byte{}, b[i:i+n]...)
+		i += n
+	}
+	if i >= len(b) {
+		return m, errors.New("SMS: user data length missing")
+	}
+	udl, ud := int(b[i]), b[i+1:]
+	alphabet := smsAlphabet(m.DCS)
+	// skip is the number of septets or octets taken by the user data header
+	skip := 0
+	if first&tpUDHI != 0 && len(ud) > 0 {
+		hl := int(ud[0]) + 1
+		if hl > len(ud) {
+			return m, errors.New("SMS: user data header too long")
+		}
+		m.Concat = decodeUDH(ud[1:hl])
+		skip = hl
+		if alphabet == SMS_ALPHABET_GSM7 {
+			skip = (hl*8 + 6) / 7
+		}
+	}
+	switch alphabet {
+	case SMS_ALPHABET_GSM7:
+		if (udl*7+7)/8 > len(ud) || skip > udl {
+			return m, errors.New("SMS: user data too short")
+		}
+		m.Text = decodeGSM7(unpackGSM7(ud, udl)[skip:])
+	default:
+		if udl > len(ud) || skip > udl {
+			return m, errors.New("SMS: user data too short")
+		}
+		if alphabet == SMS_ALPHABET_UCS2 {
+			m.Text = decodeUCS2(ud[skip:udl])
+		} else {
+			m.Data = append([]byte{}, ud[skip:udl]...)
+		}
+	}
+	return
+}
+
+// decodeUDH returns the concatenation element of a user data header, other
+// information elements are ignored
+func decodeUDH(h []byte) *SMSConcat {
+	for i := 0; i+2 <= len(h); {
+		iei, l := h[i], int(h[i+1])
+		if i+2+l > len(h) {
+			break
+		}
+		v := h[i+2 : i+2+l]
+		switch {
+		case iei == udhConcat8 && l == 3:
+			return &SMSConcat{uint16(v[0]), v[1], v[2]}
+		case iei == udhConcat16 && l == 4:
+			return &SMSConcat{uint16(v[0])<<8 | uint16(v[1]), v[2], v[3]}
+		}
+		i += 2 + l
+	}
+	return nil
+}
+
+// Encode encodes the TPDU, the user data is taken from Text or, for 8 bit
+// data coding, from Data
+func (m SMSTPDU) Encode() ([]byte, error) {
+	var udh []byte
+	if c := m.Concat; c != nil {
+		if c.Ref > 0xFF {
+			udh = []byte{6, udhConcat16, 4, byte(c.Ref >> 8), byte(c.Ref), c.Total, c.Seq}
+		} else {
+			udh = []byte{5, udhConcat8, 3, byte(c.Ref), c.Total, c.Seq}
+		}
+	}
+	first := m.Type
+	if m.StatusReportRequest {
+		first |= 0x20
+	}
+	if udh != nil {
+		first |= tpUDHI
+	}
+	b := []byte{first}
+	switch m.Type {
+	case SMS_DELIVER:
+		// TP-MMS, no more messages waiting
+		b[0] |= 0x04
+	case SMS_SUBMIT:
+		b[0] |= m.VPF << 3
+		b = append(b, m.MR)
+	default:
+		return nil, fmt.Errorf("SMS: unsupported TP-MTI %d", m.Type)
+	}
+	addr, err := encodeSMSAddress(m.Address, false)
+	if err != nil {
+		return nil, err
+	}
+	b = append(append(b, addr...), m.PID, m.DCS)
+	if m.Type == SMS_DELIVER {
+		b = append(b, encodeSCTS(m.Timestamp)...)
+	} else {
+		b = append(b, m.VP...)
+	}
+	var udl int
+	var ud []byte
+	switch smsAlphabet(m.DCS) {
+	case SMS_ALPHABET_GSM7:
+		septets, err := encodeGSM7(m.Text)
+		if err != nil {
+			return nil, err
+		}
+		// the header is followed by fill bits up to a septet boundary
+		skip := (len(udh)*8 + 6) / 7
+		septets = append(make([]byte, skip), septets...)
+		udl, ud = len(septets), packGSM7(septets)
+		copy(ud, udh)
+		if udl > 160 {
+			return nil, fmt.Errorf("SMS: %d septets of user data", udl)
+		}
+	case SMS_ALPHABET_UCS2:
+		ud = append(udh, encodeUCS2(m.Text)...)
+		udl = len(ud)
+	default:
+		ud = append(udh, m.Data...)
+		udl = len(ud)
+	}
+	if len(ud) > 140 {
+		return nil, fmt.Errorf("SMS: %d octets of user data", len(ud))
+	}
+	return append(append(b, byte(udl)), ud...), nil
+}
+
+// StoredSMS is a used record of EF_SMS with the service centre address and
+// the TPDU of the message
+type StoredSMS struct {
+	Record int
+	Status byte
+	SMSC   string
+	TPDU   []byte
+}
+
+// Unread reports whether the message was received and not read yet
+func (s StoredSMS) Unread() bool { return s.Status&0x07 == SMS_STATUS_UNREAD }
+
+// Message decodes the TPDU
+func (s StoredSMS) Message() (SMSTPDU, error) { return DecodeSMSTPDU(s.TPDU) }
+
+func decodeStoredSMS(r []byte) (s StoredSMS, err error) {
+	if len(r) < 2 {
+		return s, errors.New("SMS: record too short")
+	}
+	s.Status = r[0]
+	smsc, n, err := decodeSMSAddress(r[1:], true)
+	if err != nil {
+		return
+	}
+	s.SMSC = smsc
+	s.TPDU = append([]byte{}, bytes.TrimRight(r[1+n:], "\xff")...)
+	return
+}
+
+func encodeStoredSMS(s StoredSMS) ([]byte, error) {
+	smsc, err := encodeSMSAddress(s.SMSC, true)
+	if err != nil {
+		return nil, err
+	}
+	r := append(append([]byte{s.Status}, smsc...), s.TPDU...)
+	if len(r) > smsRecordLen {
+		return nil, fmt.Errorf("SMS: %d bytes do not fit a record", len(r))
+	}
+	return append(r, bytes.Repeat([]byte{0xFF}, smsRecordLen-len(r))...), nil
+}
+
+// EFSMS is EF_SMS, Messages are the used records and Records the number of records
+type EFSMS struct {
+	Messages []StoredSMS
+	Records  int
+}
+
+func (e *EFSMS) Info() EFInfo { return efInfo("EF_SMS") }
+
+func (e *EFSMS) Decode(records [][]byte) error {
+	e.Messages, e.Records = nil, len(records)
+	for i, r := range records {
+		if len(r) == 0 || r[0]&0x01 == 0 {
+			continue
+		}
+		s, err := decodeStoredSMS(r)
+		if err != nil {
+			return fmt.Errorf("EF_SMS: record %d: %v", i+1, err)
+		}
+		s.Record = i + 1
+		e.Messages = append(e.Messages, s)
+	}
+	return nil
+}
+
+func (e *EFSMS) Encode() ([][]byte, error) {
+	n := e.Records
+	for _, s := range e.Messages {
+		if s.Record > n {
+			n = s.Record
+		}
+	}
+	records := make([][]byte, n)
+	for _, s := range e.Messages {
+		if s.Record < 1 {
+			return nil, errors.New("EF_SMS: message without record number")
+		}
+		if records[s.Record-1] != nil {
+			return nil, fmt.Errorf("EF_SMS: record %d used twice", s.Record)
+		}
+		r, err := encodeStoredSMS(s)
+		if err != nil {
+			return nil, fmt.Errorf("EF_SMS: record %d: %v", s.Record, err)
+		}
+		records[s.Record-1] = r
+	}
+	for i := range records {
+		if records[i] == nil {
+			records[i] = append([]byte{SMS_STATUS_FREE}, bytes.Repeat([]byte{0xFF}, smsRecordLen-1)...)
+		}
+	}
+	return records, nil
+}
+
+// EFSMSS is EF_SMSS, TS 31.102 4.2.28
+type EFSMSS struct {
+	LastMR         byte
+	MemoryExceeded bool
+}
+
+func (e *EFSMSS) Info() EFInfo { return efInfo("EF_SMSS") }
+
+func (e *EFSMSS) Decode(records [][]byte) error {
+	r, err := singleRecord("EF_SMSS", records, 2)
+	if err == nil {
+		// the flag is set when b1 is 0
+		e.LastMR, e.MemoryExceeded = r[0], r[1]&0x01 == 0
+	}
+	return err
+}
+
+func (e *EFSMSS) Encode() ([][]byte, error) {
+	flag := byte(0xFF)
+	if e.MemoryExceeded {
+		flag = 0xFE
+	}
+	return [][]byte{{e.LastMR, flag}}, nil
+}
+
+// Parameter indicators of EF_SMSP, a set bit marks an absent parameter
+const (
+	SMSP_NO_DESTINATION = 0x01
+	SMSP_NO_SC_ADDRESS  = 0x02
+	SMSP_NO_PID         = 0x04
+	SMSP_NO_DCS         = 0x08
+	SMSP_NO_VP          = 0x10
+
+	smspLen     = 28
+	smspAddrLen = 12
+)
+
+// SMSParameters is a record of EF_SMSP
+type SMSParameters struct {
+	Record      int
+	Alpha       string
+	Indicators  byte
+	Destination string
+	SCAddress   string
+	PID         byte
+	DCS         byte
+	VP          byte
+}
+
+// EFSMSP is EF_SMSP, TS 31.102 4.2.27
+type EFSMSP struct {
+	Entries  []SMSParameters
+	AlphaLen int
+	Records  int
+}
+
+func (e *EFSMSP) Info() EFInfo { return efInfo("EF_SMSP") }
+
+func (e *EFSMSP) Decode(records [][]byte) error {
+	e.Entries, e.Records = nil, len(records)
+	for i, r := range records {
+		if len(r) < smspLen {
+			return fmt.Errorf("EF_SMSP: record %d too short", i+1)
+		}
+		// empty records tell the record size as well
+		e.AlphaLen = len(r) - smspLen
+		if isEmptyRecord(r) {
+			continue
+		}
+		p := SMSParameters{Record: i + 1, Alpha: decodeAlphaID(r[:e.AlphaLen])}
+		v := r[e.AlphaLen:]
+		p.Indicators = v[0]
+		var err error
+		if p.Indicators&SMSP_NO_DESTINATION == 0 {
+			if p.Destination, _, err = decodeSMSAddress(v[1:1+smspAddrLen], false); err != nil {
+				return fmt.Errorf("EF_SMSP: record %d: %v", i+1, err)
+			}
+		}
+		if p.Indicators&SMSP_NO_SC_ADDRESS == 0 {
+			if p.SCAddress, _, err = decodeSMSAddress(v[13:13+smspAddrLen], true); err != nil {
+				return fmt.Errorf("EF_SMSP: record %d: %v", i+1, err)
+			}
+		}
+		p.PID, p.DCS, p.VP = v[25], v[26], v[27]
+		e.Entries = append(e.Entries, p)
+	}
+	return nil
+}
+
+func (e *EFSMSP) Encode() ([][]byte, error) {
+	n := e.Records
+	for _, p := range e.Entries {
+		if p.Record > n {
+			n = p.Record
+		}
+	}
+	records := make([][]byte, n)
+	for i := range records {
+		records[i] = bytes.Repeat([]byte{0xFF}, e.AlphaLen+smspLen)
+	}
+	for _, p := range e.Entries {
+		if p.Record < 1 {
+			return nil, errors.New("EF_SMSP: entry without record number")
+		}
+		if p.Alpha != "" && e.AlphaLen == 0 {
+			return nil, fmt.Errorf("EF_SMSP: records have no alpha identifier for %q", p.Alpha)
+		}
+		r := append(encodeAlphaID(p.Alpha, e.AlphaLen), p.Indicators)
+		for _, a := range []struct {
+			absent  byte
+			addr    string
+			octetLn bool
+		}{{SMSP_NO_DESTINATION, p.Destination, false}, {SMSP_NO_SC_ADDRESS, p.SCAddress, true}} {
+			field := bytes.Repeat([]byte{0xFF}, smspAddrLen)
+			if p.Indicators&a.absent == 0 {
+				b, err := encodeSMSAddress(a.addr, a.octetLn)
+				if err != nil || len(b) > smspAddrLen {
+					return nil, fmt.Errorf("EF_SMSP: record %d: invalid address %q", p.Record, a.addr)
+				}
+				copy(field, b)
+			}
+			r = append(r, field...)
+		}
+		records[p.Record-1] = append(r, p.PID, p.DCS, p.VP)
+	}
+	return records, nil
+}
+
+// ListSMS returns the messages stored in EF_SMS
+func (u *USIM) ListSMS() ([]StoredSMS, error) {
+	f, err := ReadEF[EFSMS](u)
+	return f.Messages, err
+}
+
+// ReadSMS returns the message of a record, a received unread message is
+// marked as read
+func (u *USIM) ReadSMS(record int) (s StoredSMS, err error) {
+	f, err := ReadEF[EFSMS](u)
+	if err != nil {
+		return
+	}
+	for i, m := range f.Messages {
+		if m.Record != record {
+			continue
+		}
+		if m.Unread() {
+			f.Messages[i].Status = SMS_STATUS_READ
+			err = UpdateEF(u, &f)
+		}
+		return f.Messages[i], err
+	}
+	return s, fmt.Errorf("EF_SMS: record %d is free", record)
+}
+
+// WriteSMS stores a TPDU in the first free record of EF_SMS and returns the
+// record. If EF_SMS is full the memory capacity exceeded flag of EF_SMSS is set.
+func (u *USIM) WriteSMS(status byte, smsc string, tpdu []byte) (record int, err error) {
+	if status&0x01 == 0 {
+		return 0, fmt.Errorf("EF_SMS: invalid status %02X", status)
+	}
+	f, err := ReadEF[EFSMS](u)
+	if err != nil {
+		return
+	}
+	used := map[int]bool{}
+	for _, m := range f.Messages {
+		used[m.Record] = true
+	}
+	for record = 1; record <= f.Records && used[record]; record++ {
+	}
+	if record > f.Records {
+		if e := u.setSMSMemoryExceeded(true); e != nil {
+			return 0, fmt.Errorf("EF_SMS: full, EF_SMSS not updated: %v", e)
+		}
+		return 0, errors.New("EF_SMS: full")
+	}
+	f.Messages = append(f.Messages, StoredSMS{Record: record, Status: status, SMSC: smsc, TPDU: tpdu})
+	return record, UpdateEF(u, &f)
+}
+
+// DeleteSMS frees a record of EF_SMS and clears the memory capacity
+// exceeded flag of EF_SMSS
+func (u *USIM) DeleteSMS(record int) error {
+	f, err := ReadEF[EFSMS](u)
+	if err != nil {
+		return err
+	}
+	for i, m := range f.Messages {
+		if m.Record == record {
+			f.Messages = append(f.Messages[:i], f.Messages[i+1:]...)
+			if err = UpdateEF(u, &f); err != nil {
+				return err
+			}
+			return u.setSMSMemoryExceeded(false)
+		}
+	}
+	return fmt.Errorf("EF_SMS: record %d is free", record)
+}
+
+func (u *USIM) setSMSMemoryExceeded(exceeded bool) error {
+	s, err := ReadEF[EFSMSS](u)
+	if err != nil || s.MemoryExceeded == exceeded {
+		return err
+	}
+	s.MemoryExceeded = exceeded
+	return UpdateEF(u, &s)
+}
+
+// SMSCAddress returns the service centre address of the first EF_SMSP record
+func (u *USIM) SMSCAddress() (string, error) {
+	f, err := ReadEF[EFSMSP](u)
+	if err != nil {
+		return "", err
+	}
+	for _, p := range f.Entries {
+		if p.Record == 1 && p.Indicators&SMSP_NO_SC_ADDRESS == 0 {
+			return p.SCAddress, nil
+		}
+	}
+	return "", errors.New("EF_SMSP: no service centre address")
+}
+
+// SetSMSCAddress writes the service centre address to the first EF_SMSP
+// record, the other parameters of the record are kept
+func (u *USIM) SetSMSCAddress(addr string) error {
+	f, err := ReadEF[EFSMSP](u)
+	if err != nil {
+		return err
+	}
+	for i := range f.Entries {
+		if p := &f.Entries[i]; p.Record == 1 {
+			p.SCAddress, p.Indicators = addr, p.Indicators&^SMSP_NO_SC_ADDRESS
+			return UpdateEF(u, &f)
+		}
+	}
+	f.Entries = append(f.Entries, SMSParameters{
+		Record:     1,
+		Indicators: 0xFF &^ SMSP_NO_SC_ADDRESS,
+		SCAddress:  addr,
+		PID:        0xFF,
+		DCS:        0xFF,
+		VP:         0xFF,
+	})
+	return UpdateEF(u, &f)
+}
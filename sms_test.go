@@ -0,0 +1,142 @@
+package usim_go
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestDecodeSMSDeliver(t *testing.T) {
+	record := unhex("03" + "0791448720003023" + "240dd0e474d81c0ebb010000111011315214000ae8329bfd4697d9ec37")
+	s, err := decodeStoredSMS(record)
+	if err != nil || s.SMSC != "+447802000332" || !s.Unread() {
+		t.Fatalf("stored %+v, %v", s, err)
+	}
+	m, err := s.Message()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Date(2011, 1, 11, 13, 25, 41, 0, time.UTC)
+	if m.Type != SMS_DELIVER || m.Address != "diafaan" || m.Text != "hellohello" || !m.Timestamp.Equal(ts) {
+		t.Errorf("SMS-DELIVER %+v", m)
+	}
+	// TP-UDL of 10 septets with the last octet missing
+	if _, err = DecodeSMSTPDU(unhex("240dd0e474d81c0ebb010000111011315214000ae8329bfd4697d9ec")); err == nil {
+		t.Error("short user data accepted")
+	}
+}
+
+func TestSMSConcatenated(t *testing.T) {
+	for _, m := range []SMSTPDU{
+		{Type: SMS_SUBMIT, Address: "+33612345678", VPF: SMS_VPF_RELATIVE, VP: []byte{0xA7}, MR: 7, Concat: &SMSConcat{0x42, 2, 1}, Text: "part one {}"},
+		{Type: SMS_SUBMIT, Address: "112", DCS: 0x08, Concat: &SMSConcat{0x1234, 3, 3}, Text: "Привет"},
+		{Type: SMS_DELIVER, Address: "+4915", DCS: 0x04, Timestamp: time.Date(2024, 5, 6, 7, 8, 9, 0, time.FixedZone("", -90*60)), Data: []byte{1, 2, 3}},
+	} {
+		b, err := m.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, err := DecodeSMSTPDU(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.Text != m.Text || d.Address != m.Address || !bytes.Equal(d.Data, m.Data) || d.MR != m.MR || !bytes.Equal(d.VP, m.VP) {
+			t.Errorf("%x decoded %+v", b, d)
+		}
+		if (m.Concat == nil) != (d.Concat == nil) || m.Concat != nil && *m.Concat != *d.Concat {
+			t.Errorf("concatenation %+v", d.Concat)
+		}
+		if m.Type == SMS_DELIVER && !d.Timestamp.Equal(m.Timestamp) {
+			t.Errorf("time stamp %s", d.Timestamp)
+		}
+	}
+	// 8 bit reference, the text starts after one fill bit
+	b, _ := SMSTPDU{Type: SMS_SUBMIT, Address: "1", Concat: &SMSConcat{1, 2, 1}, Text: "A"}.Encode()
+	if !bytes.Equal(b, unhex("4100"+"0181f1"+"0000"+"08"+"05000301020182")) {
+		t.Errorf("SMS-SUBMIT %x", b)
+	}
+}
+
+func TestSMSStorage(t *testing.T) {
+	u, _ := testSubscriber(t)
+	free := append([]byte{SMS_STATUS_FREE}, bytes.Repeat([]byte{0xFF}, smsRecordLen-1)...)
+	if err := u.UpdateEFRaw("EF_SMS", [][]byte{free, free}); err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateEF(u, &EFSMSS{LastMR: 3}); err != nil {
+		t.Fatal(err)
+	}
+	tpdu, _ := SMSTPDU{Type: SMS_DELIVER, Address: "+33600000000", Text: "test"}.Encode()
+	for i := 1; i <= 2; i++ {
+		if rec, err := u.WriteSMS(SMS_STATUS_UNREAD, "+33609001390", tpdu); err != nil || rec != i {
+			t.Fatal(rec, err)
+		}
+	}
+	if _, err := u.WriteSMS(SMS_STATUS_UNREAD, "", tpdu); err == nil {
+		t.Fatal("write to full EF_SMS")
+	}
+	if s, _ := ReadEF[EFSMSS](u); !s.MemoryExceeded || s.LastMR != 3 {
+		t.Errorf("EF_SMSS %+v", s)
+	}
+	s, err := u.ReadSMS(2)
+	if err != nil || s.Status != SMS_STATUS_READ || s.SMSC != "+33609001390" {
+		t.Fatalf("read %+v, %v", s, err)
+	}
+	if list, _ := u.ListSMS(); len(list) != 2 || !list[0].Unread() || list[1].Unread() {
+		t.Errorf("list %+v", list)
+	}
+	if err = u.DeleteSMS(1); err != nil {
+		t.Fatal(err)
+	}
+	records, _ := u.ReadEFRaw("EF_SMS")
+	if !bytes.Equal(records[0], free) {
+		t.Errorf("deleted record %x", records[0])
+	}
+	if s, _ := ReadEF[EFSMSS](u); s.MemoryExceeded {
+		t.Error("memory capacity exceeded flag not cleared")
+	}
+}
+
+func TestSMSCAddress(t *testing.T) {
+	u, _ := testSubscriber(t)
+	raw := unhex("536d7363" + "fd" + "ffffffffffffffffffffffff" + "07913306091093f0ffffffff" + "0000a7")
+	if err := u.UpdateEFRaw("EF_SMSP", [][]byte{raw}); err != nil {
+		t.Fatal(err)
+	}
+	if addr, err := u.SMSCAddress(); err != nil || addr != "+33609001390" {
+		t.Fatalf("SMSC %q, %v", addr, err)
+	}
+	if err := u.SetSMSCAddress("+447802000332"); err != nil {
+		t.Fatal(err)
+	}
+	f, err := ReadEF[EFSMSP](u)
+	if err != nil || len(f.Entries) != 1 || f.Entries[0].SCAddress != "+447802000332" || f.Entries[0].Alpha != "Smsc" || f.Entries[0].VP != 0xA7 {
+		t.Errorf("EF_SMSP %+v, %v", f.Entries, err)
+	}
+	records, _ := u.ReadEFRaw("EF_SMSP")
+	if len(records[0]) != len(raw) {
+		t.Errorf("EF_SMSP record %x", records[0])
+	}
+}
+
+func TestSMSCAddressEmptyRecord(t *testing.T) {
+	u, _ := testSubscriber(t)
+	empty := bytes.Repeat([]byte{0xFF}, 12+smspLen)
+	if err := u.UpdateEFRaw("EF_SMSP", [][]byte{empty}); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.SetSMSCAddress("+33609001390"); err != nil {
+		t.Fatal(err)
+	}
+	records, _ := u.ReadEFRaw("EF_SMSP")
+	if len(records) != 1 || len(records[0]) != len(empty) {
+		t.Fatalf("EF_SMSP records %x", records)
+	}
+	if addr, err := u.SMSCAddress(); err != nil || addr != "+33609001390" {
+		t.Errorf("SMSC %q, %v", addr, err)
+	}
+	f := EFSMSP{Entries: []SMSParameters{{Record: 1, Alpha: "Smsc", Indicators: 0xFF}}, Records: 1}
+	if _, err := f.Encode(); err == nil {
+		t.Error("alpha identifier encoded without alpha field")
+	}
+}
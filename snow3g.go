@@ -0,0 +1,205 @@
+package usim_go
+
+import "encoding/binary"
+
+// SNOW 3G stream cipher of TS 35.216 (ETSI/SAGE UEA2 & UIA2 Document 2), used
+// by 128-EEA1/128-EIA1 and UEA2/UIA2.
+
+type snow3g struct {
+	s          [16]uint32
+	r1, r2, r3 uint32
+}
+
+func mulx(v, c byte) byte {
+	if v&0x80 != 0 {
+		return v<<1 ^ c
+	}
+	return v << 1
+}
+
+func mulxPow(v byte, i int, c byte) byte {
+	for ; i > 0; i-- {
+		v = mulx(v, c)
+	}
+	return v
+}
+
+func snow3gMulAlpha(c byte) uint32 {
+	return uint32(mulxPow(c, 23, 0xa9))<<24 | uint32(mulxPow(c, 245, 0xa9))<<16 |
+		uint32(mulxPow(c, 48, 0xa9))<<8 | uint32(mulxPow(c, 239, 0xa9))
+}
+
+func snow3gDivAlpha(c byte) uint32 {
+	return uint32(mulxPow(c, 16, 0xa9))<<24 | uint32(mulxPow(c, 39, 0xa9))<<16 |
+		uint32(mulxPow(c, 6, 0xa9))<<8 | uint32(mulxPow(c, 64, 0xa9))
+}
+
+var snow3gMulAlphaTab, snow3gDivAlphaTab [256]uint32
+
+func init() {
+	for i := 0; i < 256; i++ {
+		snow3gMulAlphaTab[i] = snow3gMulAlpha(byte(i))
+		snow3gDivAlphaTab[i] = snow3gDivAlpha(byte(i))
+	}
+}
+
+// snow3gS applies the S-box box followed by the column mixing with MULx(., c)
+func snow3gS(w uint32, box *[256]byte, c byte) uint32 {
+	s0, s1, s2, s3 := box[w>>24], box[w>>16&0xff], box[w>>8&0xff], box[w&0xff]
+	r0 := mulx(s0, c) ^ s1 ^ s2 ^ mulx(s3, c) ^ s3
+	r1 := mulx(s0, c) ^ s0 ^ mulx(s1, c) ^ s2 ^ s3
+	r2 := s0 ^ mulx(s1, c) ^ s1 ^ mulx(s2, c) ^ s3
+	r3 := s0 ^ s1 ^ mulx(s2, c) ^ s2 ^ mulx(s3, c)
+	return uint32(r0)<<24 | uint32(r1)<<16 | uint32(r2)<<8 | uint32(r3)
+}
+
+func (g *snow3g) clockLFSR(f uint32) {
+	s := &g.s
+	v := s[0]<<8 ^ snow3gMulAlphaTab[s[0]>>24] ^ s[2] ^ s[11]>>8 ^ snow3gDivAlphaTab[s[11]&0xff] ^ f
+	copy(s[:15], s[1:])
+	s[15] = v
+}
+
+func (g *snow3g) clockFSM() (f uint32) {
+	f = (g.s[15] + g.r1) ^ g.r2
+	r := g.r2 + (g.r3 ^ g.s[5])
+	g.r3 = snow3gS(g.r2, &snow3gSQ, 0x69)
+	g.r2 = snow3gS(g.r1, &snow3gSR, 0x1b)
+	g.r1 = r
+	return
+}
+
+// newSNOW3G initialises SNOW 3G with the key k = k0..k3 and iv = IV0..IV3
+func newSNOW3G(k, iv [4]uint32) *snow3g {
+	g := &snow3g{}
+	const one = 0xffffffff
+	g.s = [16]uint32{
+		k[0] ^ one, k[1] ^ one, k[2] ^ one, k[3] ^ one,
+		k[0], k[1], k[2], k[3],
+		k[0] ^ one, k[1] ^ one ^ iv[3], k[2] ^ one ^ iv[2], k[3] ^ one,
+		k[0] ^ iv[1], k[1], k[2], k[3] ^ iv[0],
+	}
+	for i := 0; i < 32; i++ {
+		g.clockLFSR(g.clockFSM())
+	}
+	g.clockFSM()
+	g.clockLFSR(0)
+	return g
+}
+
+func (g *snow3g) keystream(n int) []uint32 {
+	z := make([]uint32, n)
+	for i := range z {
+		z[i] = g.clockFSM() ^ g.s[0]
+		g.clockLFSR(0)
+	}
+	return z
+}
+
+// snow3gKey loads a 128 bit key, k3 is the most significant word
+func snow3gKey(key []byte) (k [4]uint32) {
+	for i := 0; i < 4; i++ {
+		k[3-i] = binary.BigEndian.Uint32(key[4*i:])
+	}
+	return
+}
+
+// SNOW3GF8 is the confidentiality function f8 of UEA2 and 128-EEA1, the first
+// length bits of data are ciphered, see TS 35.215 3
+func SNOW3GF8(key []byte, count uint32, bearer, dir byte, data []byte, length int) []byte {
+	b := uint32(bearer)<<27 | uint32(dir&1)<<26
+	g := newSNOW3G(snow3gKey(key), [4]uint32{b, count, b, count})
+	return xorKeystream(g.keystream((length+31)/32), data, length)
+}
+
+// SNOW3GF9 is the integrity function f9 of UIA2, see TS 35.215 4
+func SNOW3GF9(key []byte, count, fresh uint32, dir byte, data []byte, length int) (mac [4]byte) {
+	d := uint32(dir & 1)
+	g := newSNOW3G(snow3gKey(key), [4]uint32{fresh ^ d<<15, count ^ d<<31, fresh, count})
+	z := g.keystream(5)
+	p := uint64(z[0])<<32 | uint64(z[1])
+	q := uint64(z[2])<<32 | uint64(z[3])
+	var eval uint64
+	for i := 0; i < length; i += 64 {
+		var m [8]byte
+		end := i/8 + 8
+		if end > (length+7)/8 {
+			end = (length + 7) / 8
+		}
+		copy(m[:], data[i/8:end])
+		block := binary.BigEndian.Uint64(m[:])
+		if rem := length - i; rem < 64 {
+			block &= ^uint64(0) << (64 - rem)
+		}
+		eval = mul64(eval^block, p)
+	}
+	eval = mul64(eval^uint64(length), q)
+	binary.BigEndian.PutUint32(mac[:], uint32(eval>>32)^z[4])
+	return
+}
+
+// mul64 multiplies in GF(2^64) with the reduction constant 0x1b
+func mul64(v, p uint64) (r uint64) {
+	for i := 0; i < 64; i++ {
+		if p>>i&1 != 0 {
+			r ^= v
+		}
+		if v>>63 != 0 {
+			v = v<<1 ^ 0x1b
+		} else {
+			v <<= 1
+		}
+	}
+	return
+}
+
+// xorKeystream XORs the first length bits of data with the keystream words z,
+// the remaining bits of the last byte are cleared
+func xorKeystream(z []uint32, data []byte, length int) []byte {
+	n := (length + 7) / 8
+	out := make([]byte, n)
+	for i := 0; i < n; i++ {
+		out[i] = data[i] ^ byte(z[i/4]>>(24-8*(i%4)))
+	}
+	if length%8 != 0 {
+		out[n-1] &= 0xff << (8 - length%8)
+	}
+	return out
+}
+
+var snow3gSR = [256]byte{
+	0x63, 0x7C, 0x77, 0x7B, 0xF2, 0x6B, 0x6F, 0xC5, 0x30, 0x01, 0x67, 0x2B, 0xFE, 0xD7, 0xAB, 0x76,
+	0xCA, 0x82, 0xC9, 0x7D, 0xFA, 0x59, 0x47, 0xF0, 0xAD, 0xD4, 0xA2, 0xAF, 0x9C, 0xA4, 0x72, 0xC0,
+	0xB7, 0xFD, 0x93, 0x26, 0x36, 0x3F, 0xF7, 0xCC, 0x34, 0xA5, 0xE5, 0xF1, 0x71, 0xD8, 0x31, 0x15,
+	0x04, 0xC7, 0x23, 0xC3, 0x18, 0x96, 0x05, 0x9A, 0x07, 0x12, 0x80, 0xE2, 0xEB, 0x27, 0xB2, 0x75,
+	0x09, 0x83, 0x2C, 0x1A, 0x1B, 0x6E, 0x5A, 0xA0, 0x52, 0x3B, 0xD6, 0xB3, 0x29, 0xE3, 0x2F, 0x84,
+	0x53, 0xD1, 0x00, 0xED, 0x20, 0xFC, 0xB1, 0x5B, 0x6A, 0xCB, 0xBE, 0x39, 0x4A, 0x4C, 0x58, 0xCF,
+	0xD0, 0xEF, 0xAA, 0xFB, 0x43, 0x4D, 0x33, 0x85, 0x45, 0xF9, 0x02, 0x7F, 0x50, 0x3C, 0x9F, 0xA8,
+	0x51, 0xA3, 0x40, 0x8F, 0x92, 0x9D, 0x38, 0xF5, 0xBC, 0xB6, 0xDA, 0x21, 0x10, 0xFF, 0xF3, 0xD2,
+	0xCD, 0x0C, 0x13, 0xEC, 0x5F, 0x97, 0x44, 0x17, 0xC4, 0xA7, 0x7E, 0x3D, 0x64, 0x5D, 0x19, 0x73,
+	0x60, 0x81, 0x4F, 0xDC, 0x22, 0x2A, 0x90, 0x88, 0x46, 0xEE, 0xB8, 0x14, 0xDE, 0x5E, 0x0B, 0xDB,
+	0xE0, 0x32, 0x3A, 0x0A, 0x49, 0x06, 0x24, 0x5C, 0xC2, 0xD3, 0xAC, 0x62, 0x91, 0x95, 0xE4, 0x79,
+	0xE7, 0xC8, 0x37, 0x6D, 0x8D, 0xD5, 0x4E, 0xA9, 0x6C, 0x56, 0xF4, 0xEA, 0x65, 0x7A, 0xAE, 0x08,
+	0xBA, 0x78, 0x25, 0x2E, 0x1C, 0xA6, 0xB4, 0xC6, 0xE8, 0xDD, 0x74, 0x1F, 0x4B, 0xBD, 0x8B, 0x8A,
+	0x70, 0x3E, 0xB5, 0x66, 0x48, 0x03, 0xF6, 0x0E, 0x61, 0x35, 0x57, 0xB9, 0x86, 0xC1, 0x1D, 0x9E,
+	0xE1, 0xF8, 0x98, 0x11, 0x69, 0xD9, 0x8E, 0x94, 0x9B, 0x1E, 0x87, 0xE9, 0xCE, 0x55, 0x28, 0xDF,
+	0x8C, 0xA1, 0x89, 0x0D, 0xBF, 0xE6, 0x42, 0x68, 0x41, 0x99, 0x2D, 0x0F, 0xB0, 0x54, 0xBB, 0x16,
+}
+var snow3gSQ = [256]byte{
+	0x25, 0x24, 0x73, 0x67, 0xD7, 0xAE, 0x5C, 0x30, 0xA4, 0xEE, 0x6E, 0xCB, 0x7D, 0xB5, 0x82, 0xDB,
+	0xE4, 0x8E, 0x48, 0x49, 0x4F, 0x5D, 0x6A, 0x78, 0x70, 0x88, 0xE8, 0x5F, 0x5E, 0x84, 0x65, 0xE2,
+	0xD8, 0xE9, 0xCC, 0xED, 0x40, 0x2F, 0x11, 0x28, 0x57, 0xD2, 0xAC, 0xE3, 0x4A, 0x15, 0x1B, 0xB9,
+	0xB2, 0x80, 0x85, 0xA6, 0x2E, 0x02, 0x47, 0x29, 0x07, 0x4B, 0x0E, 0xC1, 0x51, 0xAA, 0x89, 0xD4,
+	0xCA, 0x01, 0x46, 0xB3, 0xEF, 0xDD, 0x44, 0x7B, 0xC2, 0x7F, 0xBE, 0xC3, 0x9F, 0x20, 0x4C, 0x64,
+	0x83, 0xA2, 0x68, 0x42, 0x13, 0xB4, 0x41, 0xCD, 0xBA, 0xC6, 0xBB, 0x6D, 0x4D, 0x71, 0x21, 0xF4,
+	0x8D, 0xB0, 0xE5, 0x93, 0xFE, 0x8F, 0xE6, 0xCF, 0x43, 0x45, 0x31, 0x22, 0x37, 0x36, 0x96, 0xFA,
+	0xBC, 0x0F, 0x08, 0x52, 0x1D, 0x55, 0x1A, 0xC5, 0x4E, 0x23, 0x69, 0x7A, 0x92, 0xFF, 0x5B, 0x5A,
+	0xEB, 0x9A, 0x1C, 0xA9, 0xD1, 0x7E, 0x0D, 0xFC, 0x50, 0x8A, 0xB6, 0x62, 0xF5, 0x0A, 0xF8, 0xDC,
+	0x03, 0x3C, 0x0C, 0x39, 0xF1, 0xB8, 0xF3, 0x3D, 0xF2, 0xD5, 0x97, 0x66, 0x81, 0x32, 0xA0, 0x00,
+	0x06, 0xCE, 0xF6, 0xEA, 0xB7, 0x17, 0xF7, 0x8C, 0x79, 0xD6, 0xA7, 0xBF, 0x8B, 0x3F, 0x1F, 0x53,
+	0x63, 0x75, 0x35, 0x2C, 0x60, 0xFD, 0x27, 0xD3, 0x94, 0xA5, 0x7C, 0xA1, 0x05, 0x58, 0x2D, 0xBD,
+	0xD9, 0xC7, 0xAF, 0x6B, 0x54, 0x0B, 0xE0, 0x38, 0x04, 0xC8, 0x9D, 0xE7, 0x14, 0xB1, 0x87, 0x9C,
+	0xDF, 0x6F, 0xF9, 0xDA, 0x2A, 0xC4, 0x59, 0x16, 0x74, 0x91, 0xAB, 0x26, 0x61, 0x76, 0x34, 0x2B,
+	0xAD, 0x99, 0xFB, 0x72, 0xEC, 0x33, 0x12, 0xDE, 0x98, 0x3B, 0xC0, 0x9B, 0x3E, 0x18, 0x10, 0x3A,
+	0x56, 0xE1, 0x77, 0xC9, 0x1E, 0x9E, 0x95, 0xA3, 0x90, 0x19, 0xA8, 0x6C, 0x09, 0xD0, 0xF0, 0x86,
+}
@@ -0,0 +1,262 @@
+package usim_go
+
+import (
+	"crypto/hmac"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Steering of Roaming and UE Parameters Update protection of TS 33.501
+// Annex A.17 and A.19, containers of TS 24.501 9.11.3.51 and 9.11.3.53A.
+
+const (
+	SOR_DATA_TYPE_ACK   = 0x01
+	SOR_LIST_INDICATION = 0x02
+	SOR_LIST_TYPE_PLMN  = 0x04
+	SOR_ACK_REQUESTED   = 0x08
+
+	UPU_DATA_TYPE_ACK      = 0x01
+	UPU_ACK_REQUESTED      = 0x02
+	UPU_REG_REQUESTED      = 0x04
+	UPU_ROUTING_INDICATOR  = 0x01
+	UPU_DEFAULT_CONF_NSSAI = 0x02
+
+	SOR_UPU_MAC_LEN = 16
+	// acknowledgement values used as P0 of SoR-MAC-IUE and UPU-MAC-IUE
+	sorUPUAck = 0x01
+)
+
+// SoRContainer is the content of the SOR transparent container. Steering
+// holds the PLMN list or the secured packet and is empty in acknowledgements.
+type SoRContainer struct {
+	Header   byte
+	MAC      [SOR_UPU_MAC_LEN]byte
+	Counter  uint16
+	Steering []byte
+}
+
+// UPUContainer is the content of the UE parameters update transparent
+// container. Data holds the encoded update data sets.
+type UPUContainer struct {
+	Header  byte
+	MAC     [SOR_UPU_MAC_LEN]byte
+	Counter uint16
+	Data    []byte
+}
+
+// SoRPLMN is an entry of the list of preferred PLMN/access technology combinations
+type SoRPLMN struct {
+	PLMN       [3]byte
+	AccessTech uint16
+}
+
+// macLSB128 truncates the KDF output to the 128 least significant bits
+func macLSB128(kdf []byte) (mac [SOR_UPU_MAC_LEN]byte) {
+	copy(mac[:], kdf[len(kdf)-SOR_UPU_MAC_LEN:])
+	return
+}
+
+func counterBytes(counter uint16) []byte {
+	var c [2]byte
+	binary.BigEndian.PutUint16(c[:], counter)
+	return c[:]
+}
+
+// SoRMACIAUSF computes SoR-MAC-IAUSF, see TS 33.501 A.17
+func SoRMACIAUSF(kausf []byte, header byte, counter uint16, steering []byte) [SOR_UPU_MAC_LEN]byte {
+	params := [][]byte{{header}, counterBytes(counter)}
+	if len(steering) > 0 {
+		params = append(params, steering)
+	}
+	return macLSB128(KDF(kausf, FC_SOR_MAC_IAUSF, params...))
+}
+
+// SoRMACIUE computes SoR-MAC-IUE, see TS 33.501 A.18
+func SoRMACIUE(kausf []byte, counter uint16) [SOR_UPU_MAC_LEN]byte {
+	return macLSB128(KDF(kausf, FC_SOR_MAC_IUE, []byte{sorUPUAck}, counterBytes(counter)))
+}
+
+// UPUMACIAUSF computes UPU-MAC-IAUSF, see TS 33.501 A.19
+func UPUMACIAUSF(kausf []byte, data []byte, counter uint16) [SOR_UPU_MAC_LEN]byte {
+	return macLSB128(KDF(kausf, FC_UPU_MAC_IAUSF, data, counterBytes(counter)))
+}
+
+// UPUMACIUE computes UPU-MAC-IUE, see TS 33.501 A.20
+func UPUMACIUE(kausf []byte, counter uint16) [SOR_UPU_MAC_LEN]byte {
+	return macLSB128(KDF(kausf, FC_UPU_MAC_IUE, []byte{sorUPUAck}, counterBytes(counter)))
+}
+
+// EncodeSoRPLMNList encodes the list of PLMN ID and access technology identifiers
+func EncodeSoRPLMNList(list []SoRPLMN) []byte {
+	buf := make([]byte, 0, 5*len(list))
+	for _, p := range list {
+		buf = append(buf, p.PLMN[:]...)
+		buf = append(buf, byte(p.AccessTech>>8), byte(p.AccessTech))
+	}
+	return buf
+}
+
+// DecodeSoRPLMNList decodes a list encoded with EncodeSoRPLMNList
+func DecodeSoRPLMNList(buf []byte) (list []SoRPLMN, err error) {
+	if len(buf)%5 != 0 {
+		return nil, errors.New("SoR: invalid PLMN list length")
+	}
+	for i := 0; i < len(buf); i += 5 {
+		var p SoRPLMN
+		copy(p.PLMN[:], buf[i:i+3])
+		p.AccessTech = binary.BigEndian.Uint16(buf[i+3:])
+		list = append(list, p)
+	}
+	return
+}
+
+// NewSoRContainer builds steering information protected with SoR-MAC-IAUSF
+// as the AUSF does. list is nil for "no list", plmnList selects the list type.
+func NewSoRContainer(kausf []byte, counter uint16, list []byte, plmnList, ackRequested bool) (c SoRContainer) {
+	c.Counter, c.Steering = counter, list
+	if list != nil {
+		c.Header |= SOR_LIST_INDICATION
+		if plmnList {
+			c.Header |= SOR_LIST_TYPE_PLMN
+		}
+	}
+	if ackRequested {
+		c.Header |= SOR_ACK_REQUESTED
+	}
+	c.MAC = SoRMACIAUSF(kausf, c.Header, c.Counter, c.Steering)
+	return
+}
+
+// Encode returns the value part of the SOR transparent container
+func (c SoRContainer) Encode() []byte {
+	buf := append([]byte{c.Header}, c.MAC[:]...)
+	if c.Header&SOR_DATA_TYPE_ACK != 0 {
+		return buf
+	}
+	buf = append(buf, counterBytes(c.Counter)...)
+	return append(buf, c.Steering...)
+}
+
+// DecodeSoRContainer decodes the value part of a SOR transparent container
+func DecodeSoRContainer(buf []byte) (c SoRContainer, err error) {
+	if len(buf) < 1+SOR_UPU_MAC_LEN {
+		err = fmt.Errorf("SoR: container too short (%d bytes)", len(buf))
+		return
+	}
+	c.Header = buf[0]
+	copy(c.MAC[:], buf[1:])
+	if c.Header&SOR_DATA_TYPE_ACK != 0 {
+		return
+	}
+	if len(buf) < 3+SOR_UPU_MAC_LEN {
+		err = errors.New("SoR: container without CounterSoR")
+		return
+	}
+	c.Counter = binary.BigEndian.Uint16(buf[1+SOR_UPU_MAC_LEN:])
+	c.Steering = buf[3+SOR_UPU_MAC_LEN:]
+	return
+}
+
+// Verify checks SoR-MAC-IAUSF of steering information
+func (c SoRContainer) Verify(kausf []byte) error {
+	if c.Header&SOR_DATA_TYPE_ACK != 0 {
+		return errors.New("SoR: acknowledgement has no SoR-MAC-IAUSF")
+	}
+	mac := SoRMACIAUSF(kausf, c.Header, c.Counter, c.Steering)
+	if !hmac.Equal(mac[:], c.MAC[:]) {
+		return errors.New("SoR: SoR-MAC-IAUSF mismatch")
+	}
+	return nil
+}
+
+// Ack returns the acknowledgement carrying SoR-MAC-IUE
+func (c SoRContainer) Ack(kausf []byte) SoRContainer {
+	return SoRContainer{Header: SOR_DATA_TYPE_ACK, MAC: SoRMACIUE(kausf, c.Counter), Counter: c.Counter}
+}
+
+// EncodeUPUDataSet encodes a UE parameters update data set of the given type
+func EncodeUPUDataSet(typ byte, data []byte) []byte {
+	buf := []byte{typ & 0x0F, byte(len(data) >> 8), byte(len(data))}
+	return append(buf, data...)
+}
+
+// EncodeUPURoutingIndicator encodes the routing indicator update data, 1 - 4 digits
+func EncodeUPURoutingIndicator(rid string) ([]byte, error) {
+	if len(rid) == 0 || len(rid) > 4 {
+		return nil, fmt.Errorf("UPU: invalid routing indicator %q", rid)
+	}
+	out := []byte{0xFF, 0xFF}
+	for i := 0; i < len(rid); i++ {
+		d := rid[i] - '0'
+		if d > 9 {
+			return nil, fmt.Errorf("UPU: invalid routing indicator %q", rid)
+		}
+		if i%2 == 0 {
+			out[i/2] = out[i/2]&0xF0 | d
+		} else {
+			out[i/2] = out[i/2]&0x0F | d<<4
+		}
+	}
+	return EncodeUPUDataSet(UPU_ROUTING_INDICATOR, out), nil
+}
+
+// NewUPUContainer builds UE parameters update data protected with UPU-MAC-IAUSF as the AUSF does
+func NewUPUContainer(kausf []byte, counter uint16, data []byte, ackRequested, regRequested bool) (c UPUContainer) {
+	c.Counter, c.Data = counter, data
+	if ackRequested {
+		c.Header |= UPU_ACK_REQUESTED
+	}
+	if regRequested {
+		c.Header |= UPU_REG_REQUESTED
+	}
+	c.MAC = UPUMACIAUSF(kausf, c.Data, c.Counter)
+	return
+}
+
+// Encode returns the value part of the UE parameters update transparent container
+func (c UPUContainer) Encode() []byte {
+	buf := append([]byte{c.Header}, c.MAC[:]...)
+	if c.Header&UPU_DATA_TYPE_ACK != 0 {
+		return buf
+	}
+	buf = append(buf, counterBytes(c.Counter)...)
+	return append(buf, c.Data...)
+}
+
+// DecodeUPUContainer decodes the value part of a UE parameters update transparent container
+func DecodeUPUContainer(buf []byte) (c UPUContainer, err error) {
+	if len(buf) < 1+SOR_UPU_MAC_LEN {
+		err = fmt.Errorf("UPU: container too short (%d bytes)", len(buf))
+		return
+	}
+	c.Header = buf[0]
+	copy(c.MAC[:], buf[1:])
+	if c.Header&UPU_DATA_TYPE_ACK != 0 {
+		return
+	}
+	if len(buf) < 3+SOR_UPU_MAC_LEN {
+		err = errors.New("UPU: container without CounterUPU")
+		return
+	}
+	c.Counter = binary.BigEndian.Uint16(buf[1+SOR_UPU_MAC_LEN:])
+	c.Data = buf[3+SOR_UPU_MAC_LEN:]
+	return
+}
+
+// Verify checks UPU-MAC-IAUSF of UE parameters update data
+func (c UPUContainer) Verify(kausf []byte) error {
+	if c.Header&UPU_DATA_TYPE_ACK != 0 {
+		return errors.New("UPU: acknowledgement has no UPU-MAC-IAUSF")
+	}
+	mac := UPUMACIAUSF(kausf, c.Data, c.Counter)
+	if !hmac.Equal(mac[:], c.MAC[:]) {
+		return errors.New("UPU: UPU-MAC-IAUSF mismatch")
+	}
+	return nil
+}
+
+// Ack returns the acknowledgement carrying UPU-MAC-IUE
+func (c UPUContainer) Ack(kausf []byte) UPUContainer {
+	return UPUContainer{Header: UPU_DATA_TYPE_ACK, MAC: UPUMACIUE(kausf, c.Counter), Counter: c.Counter}
+}
@@ -0,0 +1,63 @@
+package usim_go
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSoRUPU(t *testing.T) {
+	u, store := testSubscriber(t)
+	snName := ServingNetworkName("208", "93")
+	var v HEAV
+	if err := store.Lookup("208930000000001", func(a *AuC) (err error) {
+		v, err = a.GenHEAV(snName)
+		return
+	}); err != nil {
+		t.Fatal(err)
+	}
+	resStar, kausf, _, err := u.GenAuth5G(v.RAND, v.AUTN, snName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(resStar, v.XRESStar[:]) || !bytes.Equal(kausf, v.KAUSF[:]) {
+		t.Fatalf("5G AKA mismatch")
+	}
+
+	mcc, mnc, _ := convert_mcc_mnc("208", "01")
+	list := EncodeSoRPLMNList([]SoRPLMN{{PLMN: encode_plmn(mcc, mnc), AccessTech: 0x8000}})
+	sor := NewSoRContainer(v.KAUSF[:], 1, list, true, true)
+	dec, err := DecodeSoRContainer(sor.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = dec.Verify(kausf); err != nil {
+		t.Fatal(err)
+	}
+	plmns, err := DecodeSoRPLMNList(dec.Steering)
+	if err != nil || len(plmns) != 1 || plmns[0].AccessTech != 0x8000 {
+		t.Errorf("unexpected PLMN list %v %v", plmns, err)
+	}
+	ack, err := DecodeSoRContainer(dec.Ack(kausf).Encode())
+	if err != nil || ack.MAC != SoRMACIUE(v.KAUSF[:], 1) {
+		t.Errorf("unexpected SoR ack %+v %v", ack, err)
+	}
+	dec.Counter++
+	if dec.Verify(kausf) == nil {
+		t.Error("modified SoR container accepted")
+	}
+
+	rid, err := EncodeUPURoutingIndicator("12")
+	if err != nil || !bytes.Equal(rid, []byte{0x01, 0x00, 0x02, 0x21, 0xFF}) {
+		t.Fatalf("unexpected routing indicator %x %v", rid, err)
+	}
+	upu, err := DecodeUPUContainer(NewUPUContainer(v.KAUSF[:], 7, rid, true, false).Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = upu.Verify(kausf); err != nil {
+		t.Fatal(err)
+	}
+	if upu.Ack(kausf).MAC != UPUMACIUE(v.KAUSF[:], 7) {
+		t.Error("unexpected UPU ack")
+	}
+}
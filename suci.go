@@ -0,0 +1,289 @@
+package usim_go
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SUCI de-concealment as performed by the SIDF, see TS 33.501 Annex C
+// and TS 23.003 clause 2.2B.
+
+type ProtectionScheme uint8
+
+const (
+	NullScheme ProtectionScheme = iota
+	ProfileA
+	ProfileB
+)
+
+func (p ProtectionScheme) String() string {
+	switch p {
+	case NullScheme:
+		return "null"
+	case ProfileA:
+		return "profile-a"
+	case ProfileB:
+		return "profile-b"
+	}
+	return fmt.Sprintf("scheme-%d", uint8(p))
+}
+
+const (
+	SUPI_FORMAT_IMSI = 0
+	SUPI_FORMAT_NAI  = 1
+
+	MOBILE_IDENTITY_SUCI = 0x01
+
+	SUCI_MAC_LEN            = 8
+	SUCI_ENC_KEY_LEN        = 16
+	SUCI_ICB_LEN            = 16
+	SUCI_MAC_KEY_LEN        = 32
+	SUCI_PROFILE_A_PUB_LEN  = 32
+	SUCI_PROFILE_B_PUB_LEN  = 33
+	SUCI_PROFILE_B_PRIV_LEN = 32
+)
+
+type SUCI struct {
+	SupiFormat       uint8
+	MCC              string
+	MNC              string
+	RoutingIndicator string
+	ProtectionScheme ProtectionScheme
+	HNPublicKeyID    uint8
+	SchemeOutput     []byte
+}
+
+// ParseSUCI parses the string form suci-0-<mcc>-<mnc>-<routing indicator>-<scheme>-<key id>-<scheme output>
+func ParseSUCI(suci string) (s SUCI, err error) {
+	parts := strings.Split(suci, "-")
+	if len(parts) != 8 || parts[0] != "suci" {
+		err = errors.New("SUCI: malformed string form")
+		return
+	}
+	var v uint64
+	if v, err = strconv.ParseUint(parts[1], 10, 8); err != nil {
+		err = fmt.Errorf("SUCI: invalid SUPI type %q", parts[1])
+		return
+	}
+	s.SupiFormat = uint8(v)
+	s.MCC, s.MNC, s.RoutingIndicator = parts[2], parts[3], parts[4]
+	if v, err = strconv.ParseUint(parts[5], 10, 4); err != nil {
+		err = fmt.Errorf("SUCI: invalid protection scheme %q", parts[5])
+		return
+	}
+	s.ProtectionScheme = ProtectionScheme(v)
+	if v, err = strconv.ParseUint(parts[6], 10, 8); err != nil {
+		err = fmt.Errorf("SUCI: invalid HN public key id %q", parts[6])
+		return
+	}
+	s.HNPublicKeyID = uint8(v)
+	if s.ProtectionScheme == NullScheme {
+		s.SchemeOutput = []byte(parts[7])
+	} else if s.SchemeOutput, err = hex.DecodeString(parts[7]); err != nil {
+		err = errors.New("SUCI: scheme output is not hex encoded")
+		return
+	}
+	return
+}
+
+// DecodeSUCI decodes the value part of a NAS 5GS mobile identity IE of type SUCI
+func DecodeSUCI(buf []byte) (s SUCI, err error) {
+	if len(buf) < 8 {
+		err = fmt.Errorf("SUCI: mobile identity too short (%d bytes)", len(buf))
+		return
+	}
+	if buf[0]&0x07 != MOBILE_IDENTITY_SUCI {
+		err = fmt.Errorf("SUCI: unexpected type of identity %d", buf[0]&0x07)
+		return
+	}
+	s.SupiFormat = (buf[0] >> 4) & 0x07
+	if s.MCC, s.MNC, err = decodePLMN(buf[1:4]); err != nil {
+		return
+	}
+	s.RoutingIndicator = strings.TrimRight(decodeBCD(buf[4:6]), "f")
+	if s.RoutingIndicator == "" {
+		s.RoutingIndicator = "0"
+	}
+	s.ProtectionScheme = ProtectionScheme(buf[6] & 0x0f)
+	s.HNPublicKeyID = buf[7]
+	if s.ProtectionScheme == NullScheme {
+		s.SchemeOutput = []byte(strings.TrimRight(decodeBCD(buf[8:]), "f"))
+	} else {
+		s.SchemeOutput = append([]byte{}, buf[8:]...)
+	}
+	return
+}
+
+func (s SUCI) String() string {
+	output := string(s.SchemeOutput)
+	if s.ProtectionScheme != NullScheme {
+		output = hex.EncodeToString(s.SchemeOutput)
+	}
+	return fmt.Sprintf("suci-%d-%s-%s-%s-%d-%d-%s", s.SupiFormat, s.MCC, s.MNC, s.RoutingIndicator,
+		s.ProtectionScheme, s.HNPublicKeyID, output)
+}
+
+// DeconcealSUCI recovers the SUPI from a SUCI, using hnKeys to look up the
+// home network private key for the HN public key identifier.
+func DeconcealSUCI(s SUCI, hnKeys map[uint8][]byte) (supi string, err error) {
+	if s.SupiFormat != SUPI_FORMAT_IMSI {
+		err = fmt.Errorf("SUCI: unsupported SUPI format %d", s.SupiFormat)
+		return
+	}
+	var msin []byte
+	switch s.ProtectionScheme {
+	case NullScheme:
+		msin = s.SchemeOutput
+	case ProfileA, ProfileB:
+		priv, ok := hnKeys[s.HNPublicKeyID]
+		if !ok {
+			err = fmt.Errorf("SUCI: no home network key for key id %d", s.HNPublicKeyID)
+			return
+		}
+		var plain []byte
+		if plain, err = suciDecrypt(s.ProtectionScheme, priv, s.SchemeOutput); err != nil {
+			return
+		}
+		msin = []byte(strings.TrimRight(decodeBCD(plain), "f"))
+	default:
+		err = fmt.Errorf("SUCI: unsupported protection scheme %d", s.ProtectionScheme)
+		return
+	}
+	// TS 23.003 2.2: the IMSI has at most 15 digits
+	if n := len(msin); n == 0 || len(s.MCC)+len(s.MNC)+n > 15 || strings.Trim(string(msin), "0123456789") != "" {
+		err = fmt.Errorf("SUCI: invalid de-concealed MSIN %q", msin)
+		return
+	}
+	supi = "imsi-" + s.MCC + s.MNC + string(msin)
+	return
+}
+
+// DeconcealSUCIString is DeconcealSUCI for the string form
+func DeconcealSUCIString(suci string, hnKeys map[uint8][]byte) (string, error) {
+	s, err := ParseSUCI(suci)
+	if err != nil {
+		return "", err
+	}
+	return DeconcealSUCI(s, hnKeys)
+}
+
+func suciDecrypt(scheme ProtectionScheme, priv, output []byte) (plain []byte, err error) {
+	var pubLen int
+	var curve ecdh.Curve
+	switch scheme {
+	case ProfileA:
+		pubLen, curve = SUCI_PROFILE_A_PUB_LEN, ecdh.X25519()
+	case ProfileB:
+		pubLen, curve = SUCI_PROFILE_B_PUB_LEN, ecdh.P256()
+	}
+	if len(output) <= pubLen+SUCI_MAC_LEN {
+		err = fmt.Errorf("SUCI: scheme output too short (%d bytes)", len(output))
+		return
+	}
+	ephPub := output[:pubLen]
+	cipherText := output[pubLen : len(output)-SUCI_MAC_LEN]
+	macTag := output[len(output)-SUCI_MAC_LEN:]
+
+	var hnPriv *ecdh.PrivateKey
+	if hnPriv, err = curve.NewPrivateKey(priv); err != nil {
+		err = fmt.Errorf("SUCI: invalid home network private key: %v", err)
+		return
+	}
+	uePub := ephPub
+	if scheme == ProfileB {
+		if uePub, err = decompressP256(ephPub); err != nil {
+			return
+		}
+	}
+	var pub *ecdh.PublicKey
+	if pub, err = curve.NewPublicKey(uePub); err != nil {
+		err = fmt.Errorf("SUCI: invalid ephemeral public key: %v", err)
+		return
+	}
+	var shared []byte
+	if shared, err = hnPriv.ECDH(pub); err != nil {
+		return
+	}
+
+	keys := ansiX963KDF(shared, ephPub, SUCI_ENC_KEY_LEN+SUCI_ICB_LEN+SUCI_MAC_KEY_LEN)
+	encKey := keys[:SUCI_ENC_KEY_LEN]
+	icb := keys[SUCI_ENC_KEY_LEN : SUCI_ENC_KEY_LEN+SUCI_ICB_LEN]
+	macKey := keys[SUCI_ENC_KEY_LEN+SUCI_ICB_LEN:]
+
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(cipherText)
+	if !hmac.Equal(mac.Sum(nil)[:SUCI_MAC_LEN], macTag) {
+		err = errors.New("SUCI: MAC tag verification failed")
+		return
+	}
+	var block cipher.Block
+	if block, err = aes.NewCipher(encKey); err != nil {
+		return
+	}
+	plain = make([]byte, len(cipherText))
+	cipher.NewCTR(block, icb).XORKeyStream(plain, cipherText)
+	return
+}
+
+// ansiX963KDF is the ANSI X9.63 key derivation function with SHA-256
+func ansiX963KDF(z, sharedInfo []byte, keyLen int) []byte {
+	var out []byte
+	var counter [4]byte
+	for i := uint32(1); len(out) < keyLen; i++ {
+		binary.BigEndian.PutUint32(counter[:], i)
+		h := sha256.New()
+		h.Write(z)
+		h.Write(counter[:])
+		h.Write(sharedInfo)
+		out = h.Sum(out)
+	}
+	return out[:keyLen]
+}
+
+// decompressP256 converts a compressed P-256 point into the uncompressed form
+func decompressP256(pub []byte) ([]byte, error) {
+	x, y := elliptic.UnmarshalCompressed(elliptic.P256(), pub)
+	if x == nil {
+		return nil, errors.New("SUCI: invalid compressed P-256 public key")
+	}
+	out := make([]byte, 65)
+	out[0] = 0x04
+	x.FillBytes(out[1:33])
+	y.FillBytes(out[33:])
+	return out, nil
+}
+
+// decodeBCD decodes swapped semi-octets, filler digits are returned as 'f'
+func decodeBCD(buf []byte) string {
+	tmp := append([]byte{}, buf...)
+	swapHex(tmp)
+	return hex.EncodeToString(tmp)
+}
+
+// decodePLMN decodes the 3 octet MCC/MNC field of TS 24.008 10.5.1.3
+func decodePLMN(buf []byte) (mcc, mnc string, err error) {
+	if len(buf) < 3 {
+		err = errors.New("PLMN: too short")
+		return
+	}
+	digits := decodeBCD(buf[:3])
+	mcc = digits[0:3]
+	mnc = digits[4:6]
+	if digits[3] != 'f' {
+		mnc += digits[3:4]
+	}
+	if strings.ContainsRune(mcc+mnc, 'f') {
+		err = fmt.Errorf("PLMN: invalid digits %s", digits)
+	}
+	return
+}
@@ -0,0 +1,48 @@
+package usim_go
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// test data from TS 33.501 Annex C.4
+func TestDeconcealSUCI(t *testing.T) {
+	privA, _ := hex.DecodeString("c53c22208b61860b06c62e5406a7b330c2b577aa5558981510d128247d38bd1d")
+	privB, _ := hex.DecodeString("F1AB1074477EBCC7F554EA1C5FC368B1616730155E0041AC447D6301975FECDA")
+	keys := map[uint8][]byte{1: privA, 2: privB}
+	cases := []struct {
+		suci string
+		supi string
+	}{
+		{"suci-0-274-012-0-0-0-001002086", "imsi-274012001002086"},
+		{"suci-0-274-01-0-0-0-123456789", "imsi-27401123456789"},
+		{"suci-0-274-012-0-1-1-b2e92f836055a255837debf850b528997ce0201cb82adfe4be1f587d07d8457dcb02352410cddd9e730ef3fa87", "imsi-274012001002086"},
+		{"suci-0-274-012-0-2-2-039aab8376597021e855679a9778ea0b67396e68c66df32c0f41e9acca2da9b9d146a33fc2716ac7dae96aa30a4d", "imsi-274012001002086"},
+	}
+	for _, c := range cases {
+		supi, err := DeconcealSUCIString(c.suci, keys)
+		if err != nil {
+			t.Error(c.suci, err)
+		} else if supi != c.supi {
+			t.Errorf("expect %s, got %s", c.supi, supi)
+		}
+	}
+	if _, err := DeconcealSUCIString("suci-0-274-012-0-0-0-0010020861", keys); err == nil {
+		t.Error("expect IMSI length failure")
+	}
+	// corrupted MAC tag
+	if _, err := DeconcealSUCIString("suci-0-274-012-0-1-1-b2e92f836055a255837debf850b528997ce0201cb82adfe4be1f587d07d8457dcb02352410cddd9e730ef3fa88", keys); err == nil {
+		t.Error("expect MAC failure")
+	}
+}
+
+func TestDecodeSUCI(t *testing.T) {
+	buf, _ := hex.DecodeString("01722410f0ff0100b2e92f836055a255837debf850b528997ce0201cb82adfe4be1f587d07d8457dcb02352410cddd9e730ef3fa87")
+	s, err := DecodeSUCI(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.MCC != "274" || s.MNC != "012" || s.ProtectionScheme != ProfileA || s.HNPublicKeyID != 0 || s.RoutingIndicator != "0" {
+		t.Errorf("unexpected SUCI %s", s)
+	}
+}
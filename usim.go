@@ -1,7 +1,6 @@
 package usim_go
 
 import (
-	"encoding/base64"
 	"errors"
 	"fmt"
 
@@ -50,11 +49,19 @@ type USIM struct {
 	res        []byte
 	auts       []byte
 	ak_xor_sqn []byte
+	// GBA_U bootstrapped key of the soft card
+	gbaKs   []byte
+	gbaRand []byte
 	//
 	ctx      *smartcard.Context
 	reader   *smartcard.Reader
 	cardType int
 	aid      []byte
+	// files holds the elementary files of the soft card by EF name
+	files map[string][][]byte
+	// cached USIM service table
+	ust     ServiceTable
+	ustRead bool
 }
 
 func InitSoftUSIM(algo Algo, imei string, imsi string, k string, op string, opc string, soft bool) (u USIM, err error) {
@@ -93,6 +100,14 @@ func InitSoftUSIM(algo Algo, imei string, imsi string, k string, op string, opc
 			return u, err
 		}
 	}
+	if u.soft {
+		if err = UpdateEF(&u, &EFIMSI{IMSI: imsi}); err != nil {
+			return
+		}
+		if err = UpdateEF(&u, &EFAD{MNCLength: len(u.mncStr)}); err != nil {
+			return
+		}
+	}
 	if u.using_op {
 		u.compute_opc()
 	}
@@ -152,15 +167,13 @@ func InitPcscUSIM(seq int) (u USIM, err error) {
 		return
 	}
 	logrus.Debug("IMSI: ", imsi)
-	//
-	var msisdn string
-	if msisdn, err = getMSISDN(card); err != nil {
-		logrus.Error(err)
-		// return
+	mncLen, err := getMNCLen(card)
+	if err != nil {
+		logrus.Info("MNC length not available, using the PLMN table: ", err)
+		mncLen = MNCLength(imsi)
 	}
-	u.msisdn = msisdn
 	// mcc mnc
-	if u.mccStr, u.mncStr, err = extract_mcc_mnc(imsi); err != nil {
+	if u.mccStr, u.mncStr, err = splitIMSI(imsi, mncLen); err != nil {
 		err = errors.New("failed to extract mcc and mnc")
 		return u, err
 	} else {
@@ -171,6 +184,9 @@ func InitPcscUSIM(seq int) (u USIM, err error) {
 	if u.aid, err = selectAid(card); err != nil {
 		logrus.Error(err)
 	}
+	if u.msisdn, err = getMSISDN(card, u.aid); err != nil {
+		logrus.Error(err)
+	}
 	return u, nil
 }
 
@@ -206,8 +222,11 @@ func (u *USIM) GenAuthResMilenage(rand, autn [16]byte) (rest, ik, ck, auts []byt
 
 func (u *USIM) GenGSMAlg(rand []byte) (xres, kc []byte, err error) {
 	if u.soft {
-		logrus.Println(u.opc, u.k, rand)
-		milenage.Gsm_milenage(u.opc[:], u.k[:], rand, xres, kc)
+		xres, kc = make([]byte, 4), make([]byte, 8)
+		if milenage.Gsm_milenage(u.opc[:], u.k[:], rand, xres, kc) != 0 {
+			err = errors.New("GSM-Milenage failed")
+			return
+		}
 	} else {
 		var card *smartcard.Card
 		if card, err = u.reader.Connect(); err != nil {
@@ -223,11 +242,90 @@ func (u *USIM) GenGSMAlg(rand []byte) (xres, kc []byte, err error) {
 	return
 }
 
-// extract_rand_autn unmarshal nonce and extract rand, autn
+// GenAuth5G runs 5G AKA for the serving network snName (see ServingNetworkName)
+// and returns RES* and KAUSF. err is UNSYNC with auts set on synchronisation failure.
+func (u *USIM) GenAuth5G(rand, autn [16]byte, snName string) (resStar, kausf, auts []byte, err error) {
+	var res, ik, ck []byte
+	if res, ik, ck, auts, err = u.GenAuthResMilenage(rand, autn); err != nil {
+		return
+	}
+	resStar = DeriveRESStar(ck, ik, snName, rand[:], res)
+	kausf = DeriveKAUSF(ck, ik, snName, autn[:SQN_LEN])
+	return
+}
+
+// GBABootstrap runs AUTHENTICATE in GBA bootstrapping mode (GBA_U). autn
+// carries MAC*, Ks stays on the card. err is UNSYNC with auts set on
+// synchronisation failure.
+func (u *USIM) GBABootstrap(rand, autn [16]byte) (res, auts []byte, err error) {
+	if u.soft {
+		ik := make([]byte, IK_LEN)
+		if err = milenage.F2345(u.opc[:], u.k[:], rand[:], make([]byte, 8), make([]byte, CK_LEN), ik, make([]byte, AK_LEN), make([]byte, AK_LEN)); err != nil {
+			return
+		}
+		gbaUnmaskMAC(autn[8:], ik)
+		var ck []byte
+		if res, ik, ck, auts, err = u.GenAuthResMilenage(rand, autn); err != nil {
+			return
+		}
+		u.gbaKs, u.gbaRand = ckik(ck, ik), append([]byte{}, rand[:]...)
+		return res, nil, nil
+	}
+	var card *smartcard.Card
+	if card, err = u.reader.Connect(); err != nil {
+		logrus.Error(err)
+		return
+	}
+	defer card.Disconnect()
+	data := []byte{byte(USIM_GBA_BOOTSTRAPPING), byte(AKA_RAND_LEN)}
+	data = append(data, rand[:]...)
+	data = append(data, byte(AKA_AUTN_LEN))
+	data = append(data, autn[:]...)
+	if res, err = GBAAuthenticate(card, u.aid, data); err == UNSYNC {
+		logrus.Info(err)
+		auts, res = res, nil
+	} else if err != nil {
+		logrus.Error(err)
+	}
+	return
+}
+
+// GBANAFDerivation runs AUTHENTICATE in NAF derivation mode and returns Ks_ext_NAF
+func (u *USIM) GBANAFDerivation(nafID []byte, impi string) (ksExtNAF []byte, err error) {
+	if len(nafID) > 255 || len(impi) > 255 {
+		err = errors.New("GBA: NAF_ID or IMPI too long")
+		return
+	}
+	if u.soft {
+		if u.gbaKs == nil {
+			err = errors.New("GBA: no bootstrapped key")
+			return
+		}
+		return DeriveKsNAF(u.gbaKs, GBA_ME, u.gbaRand, impi, nafID), nil
+	}
+	var card *smartcard.Card
+	if card, err = u.reader.Connect(); err != nil {
+		logrus.Error(err)
+		return
+	}
+	defer card.Disconnect()
+	data := []byte{byte(USIM_GBA_NAF_DERIVATION), byte(len(nafID))}
+	data = append(data, nafID...)
+	data = append(data, byte(len(impi)))
+	data = append(data, impi...)
+	if ksExtNAF, err = GBAAuthenticate(card, u.aid, data); err != nil {
+		logrus.Error(err)
+	}
+	return
+}
+
+// extract_rand_autn unmarshal nonce and extract rand, autn. Invalid nonces
+// yield zero values, use ParseAKANonce to get the error.
 func ExtractRandAutn(nonce string) (rand, autn [16]byte) {
-	sDec, _ := base64.StdEncoding.DecodeString(nonce)
-	copy(rand[:], sDec[:16])
-	copy(autn[:], sDec[16:])
+	var err error
+	if rand, autn, _, err = ParseAKANonce(nonce); err != nil {
+		logrus.Error(err)
+	}
 	return rand, autn
 }
 func (u *USIM) Close() {
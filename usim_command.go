@@ -38,13 +38,27 @@ var (
 	SIM_CMD_GET_RESPONSE = []byte{0xa0, 0xc0, 0x00, 0x00}
 	SIM_CMD_READ_BIN     = []byte{0xa0, 0xb0, 0x00, 0x00}
 	SIM_CMD_READ_RECORD  = []byte{0xa0, 0xb2, 0x00, 0x00}
+	SIM_CMD_UPDATE_BIN   = []byte{0xa0, 0xd6, 0x00, 0x00}
+	SIM_CMD_UPDATE_REC   = []byte{0xa0, 0xdc, 0x00, 0x00}
 	SIM_CMD_VERIFY_CHV1  = []byte{0xa0, 0x20, 0x00, 0x01, 0x08}
 
 	/* USIM commands */
 	USIM_CLA              = 0x00
 	USIM_CMD_RUN_UMTS_ALG = []byte{0x00, 0x88, 0x00, 0x81, 0x22}
 	USIM_CMD_GET_RESPONSE = []byte{0x00, 0xc0, 0x00, 0x00}
+	/* AUTHENTICATE in GBA security context, see TS 31.102 7.1.2.4 */
+	USIM_CMD_AUTHENTICATE_GBA = []byte{0x00, 0x88, 0x00, 0x84}
+	USIM_GBA_BOOTSTRAPPING    = 0xDD
+	USIM_GBA_NAF_DERIVATION   = 0xDE
+	/* VERIFY PIN, P2 is the key reference, see TS 102 221 11.1.9 */
+	USIM_CMD_VERIFY = []byte{0x00, 0x20, 0x00, 0x00, 0x08}
+	USIM_PIN1_REF   = 0x01
+	USIM_PIN2_REF   = 0x81
+	/* application codes of the AID, see TS 101 220 annex E */
+	USIM_APP_CODE = []byte{0x10, 0x02}
+	ISIM_APP_CODE = []byte{0x10, 0x04}
 
+	SIM_RECORD_MODE_PREVIOUS = 0x03
 	SIM_RECORD_MODE_ABSOLUTE = 0x04
 
 	USIM_FSP_TEMPL_TAG = 0x62
@@ -1,6 +1,7 @@
 package usim_go
 
 import (
+	"bytes"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -174,6 +175,31 @@ func getIMSI(ctx *smartcard.Card) (string, error) {
 	return hex.EncodeToString(resp[:fLen])[3:], nil
 }
 
+// getMNCLen reads the length of the MNC from byte 4 of EF_AD, see TS 31.102
+// 4.2.18. It must be called while the USIM ADF (or DF_GSM) is selected.
+func getMNCLen(ctx *smartcard.Card) (mncLen int, err error) {
+	var resp []byte
+	logrus.Debug("SCARD: reading MNC length from EF_AD")
+	if resp, err = _select_file(ctx, SCARD_FILE_GSM_EF_AD, cardType, nil); err != nil {
+		return 0, errors.New("reading SCARD_FILE_GSM_EF_AD failed")
+	}
+	var fLen int
+	if fLen, err = parse_fsp_templ(resp, USIM_TLV_FILE_SIZE); err != nil {
+		return 0, errors.New("get USIM_TLV_FILE_SIZE failed")
+	}
+	if fLen < 4 {
+		return 0, fmt.Errorf("EF_AD without MNC length (%d bytes)", fLen)
+	}
+	if resp, err = read_file(ctx, fLen, cardType); err != nil {
+		return 0, err
+	}
+	mncLen = int(resp[3] & 0x0F)
+	if mncLen != 2 && mncLen != 3 {
+		return 0, fmt.Errorf("EF_AD: invalid MNC length %d", mncLen)
+	}
+	return
+}
+
 func getICCID(ctx *smartcard.Card) (iccid string, err error) {
 	var resp []byte
 	var cmd = []int{
@@ -203,43 +229,30 @@ func getICCID(ctx *smartcard.Card) (iccid string, err error) {
 	return
 }
 
-func getMSISDN(ctx *smartcard.Card) (msisdn string, err error) {
-	var resp []byte
-	var cmd1 = []int{
-		SCARD_FILE_MF,
-		SCARD_FILE_TELECOMM_DF,
-		SCARD_FILE_GSM_EF_MSISDN,
-	}
-
-	for _, cmd_ := range cmd1 {
-		if _, err = _select_file(ctx, cmd_, SCARD_USIM, nil); err != nil {
-			aid := []byte{0xa0, 0x00, 0x00, 0x00, 0x87, 0x10, 0x02, 0xff, 0x44, 0xff, 0x12, 0x89, 0x00, 0x00, 0x01, 0x00}
-			_select_file(ctx, SCARD_FILE_MF, SCARD_USIM, nil)
-			_select_file(ctx, 0, SCARD_USIM, aid)
-			_select_file(ctx, SCARD_FILE_GSM_EF_MSISDN, SCARD_USIM, nil)
-			return "", errors.New("reading MSISDN failed")
-
+// getMSISDN returns the first number of EF_MSISDN of the USIM, or of
+// DF_TELECOM for cards without one, see TS 31.102 4.2.26
+func getMSISDN(ctx *smartcard.Card, aid []byte) (msisdn string, err error) {
+	for _, name := range []string{"EF_MSISDN", "EF_MSISDN_TELECOM"} {
+		var records [][]byte
+		if records, err = readEF(ctx, aid, efInfo(name)); err != nil {
+			logrus.Debugf("SCARD: %s not read: %v", name, err)
+			continue
 		}
+		var f dialNumberFile
+		if err = f.decode(name, records); err != nil {
+			return
+		}
+		if len(f.Entries) == 0 {
+			return "", fmt.Errorf("%s is empty", name)
+		}
+		if f.Entries[0].ext != noExtension {
+			if ext, e := readEF(ctx, aid, efInfo(dialNumberExt[name])); e == nil {
+				resolveExt(name, f.Entries[:1], ext)
+			}
+		}
+		return f.Entries[0].Number, nil
 	}
-	var rLen int
-	if rLen, err = get_record_len(ctx, 1, SIM_RECORD_MODE_ABSOLUTE); err != nil {
-		logrus.Error(err)
-		return
-	}
-	logrus.Debug("record length ", rLen)
-	if resp, err = get_record(ctx, rLen, 1, SIM_RECORD_MODE_ABSOLUTE); err != nil {
-		logrus.Error(err)
-		return
-	}
-	resp = resp[16:]
-	swapHex(resp[2:])
-	dailLen := int((resp[0]-2)*2 + 1)
-	if dailLen <= len(hex.EncodeToString(resp[2:])) {
-		msisdn = hex.EncodeToString(resp[2:])[:dailLen]
-	} else {
-		err = errors.New("reading MSISDN failed")
-	}
-	return
+	return "", fmt.Errorf("reading MSISDN failed: %v", err)
 }
 
 func AKAVerify(ctx *smartcard.Card, simType int, aid []byte, rand, auth []byte) (res, ik, ck, auts []byte, err error) {
@@ -295,6 +308,61 @@ func AKAVerify(ctx *smartcard.Card, simType int, aid []byte, rand, auth []byte)
 	return
 }
 
+// GBAAuthenticate sends AUTHENTICATE in GBA security context, data starts with
+// the mode tag (bootstrapping or NAF derivation). The response data is returned.
+func GBAAuthenticate(ctx *smartcard.Card, aid []byte, data []byte) (resp []byte, err error) {
+	var cmd []byte
+	var getResp []byte
+	cmd = append(cmd, USIM_CMD_AUTHENTICATE_GBA...)
+	cmd = append(cmd, byte(len(data)))
+	cmd = append(cmd, data...)
+	getResp = append(getResp, USIM_CMD_GET_RESPONSE...)
+
+	_select_file(ctx, SCARD_FILE_MF, SCARD_USIM, nil)
+	_select_file(ctx, 0, SCARD_USIM, aid)
+	logrus.Debug("Sending command:\n", hex.Dump(cmd))
+	if resp, err = ctx.Transmit(cmd); err != nil {
+		logrus.Error("GBAAuthenticate: sending command failed")
+		return
+	}
+	logrus.Debug("Got response:\n", hex.Dump(resp))
+	if len(resp) == 2 && resp[0] == 0x98 && resp[1] == 0x62 {
+		err = errors.New("SCARD: GBA auth failed - MAC != XMAC")
+		return
+	}
+	if len(resp) == 2 && resp[0] == 0x6A && resp[1] == 0x88 {
+		err = errors.New("SCARD: GBA bootstrapping data not available")
+		return
+	}
+	if len(resp) != 2 || resp[0] != 0x61 {
+		err = fmt.Errorf("SCARD: unexpected response for GBA auth request (len=%d resp=%X)", len(resp), resp)
+		return
+	}
+	getResp = append(getResp, resp[1])
+	if resp, err = ctx.Transmit(getResp); err != nil {
+		errStr := "reading response failed"
+		logrus.Error(errStr, err)
+		err = errors.New(errStr)
+		return
+	}
+	if len(resp) < 4 || int(resp[1]) > len(resp)-4 {
+		err = errors.New("SCARD: invalid GBA response")
+		return
+	}
+	if resp[0] == 0xdc && int(resp[1]) == AKA_AUTS_LEN {
+		logrus.Debug("SCARD: GBA Synchronization-Failure")
+		resp = resp[2 : 2+AKA_AUTS_LEN]
+		err = UNSYNC
+		return
+	}
+	if resp[0] != 0xdb {
+		err = fmt.Errorf("SCARD: unexpected GBA response tag 0x%02X", resp[0])
+		return
+	}
+	resp = resp[2 : 2+int(resp[1])]
+	return
+}
+
 func parseAKA(buf []byte) (res, ck, ik []byte, err error) {
 	// fmt.Println(hex.Dump(buf))
 	/* RES */
@@ -379,3 +447,264 @@ func selectAid(ctx *smartcard.Card) (aid []byte, err error) {
 	aid = resp[4 : 4+efdir_.aid_len]
 	return
 }
+
+// parseFCP extracts the file structure, size and record layout from the FCP
+// template returned by SELECT, see TS 102 221 11.1.1.3
+func parseFCP(buf []byte) (structure EFStructure, size, recLen, nrec int, err error) {
+	if len(buf) < 2 || int(buf[0]) != USIM_FSP_TEMPL_TAG {
+		err = errors.New("SCARD: file header did not start with FSP template tag")
+		return
+	}
+	end := 2 + int(buf[1])
+	if end > len(buf) {
+		end = len(buf)
+	}
+	for pos := 2; pos+2 <= end; {
+		tag, l := int(buf[pos]), int(buf[pos+1])
+		pos += 2
+		if pos+l > end {
+			break
+		}
+		v := buf[pos : pos+l]
+		switch tag {
+		case USIM_TLV_FILE_DESC:
+			switch v[0] & 0x07 {
+			case 0x01:
+				structure = EF_TRANSPARENT
+			case 0x02:
+				structure = EF_LINEAR_FIXED
+			case 0x06:
+				structure = EF_CYCLIC
+			}
+			if l >= 5 {
+				recLen, nrec = int(v[2])<<8|int(v[3]), int(v[4])
+			}
+		case USIM_TLV_FILE_SIZE:
+			for _, b := range v {
+				size = size<<8 | int(b)
+			}
+		}
+		pos += l
+	}
+	if structure != EF_TRANSPARENT && recLen == 0 {
+		err = errors.New("SCARD: record file without record length")
+	}
+	return
+}
+
+// selectEF selects the file along its path from the MF, ADF_USIM is selected by aid
+func selectEF(ctx *smartcard.Card, aid []byte, info EFInfo) (resp []byte, err error) {
+	if _, err = _select_file(ctx, SCARD_FILE_MF, SCARD_USIM, nil); err != nil {
+		return
+	}
+	for _, df := range info.Path {
+		if df == EF_PATH_ADF_USIM {
+			_, err = _select_file(ctx, 0, SCARD_USIM, aid)
+		} else if df == EF_PATH_ADF_ISIM {
+			var isimAid []byte
+			if isimAid, err = findAppAid(ctx, ISIM_APP_CODE); err == nil {
+				_, err = _select_file(ctx, 0, SCARD_USIM, isimAid)
+			}
+		} else {
+			_, err = _select_file(ctx, int(df), SCARD_USIM, nil)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("SCARD: selecting DF %04X of %s failed: %w", df, info.Name, err)
+		}
+	}
+	if resp, err = _select_file(ctx, int(info.FID), SCARD_USIM, nil); err != nil {
+		err = fmt.Errorf("SCARD: selecting %s failed: %w", info.Name, err)
+	}
+	return
+}
+
+func read_binary(ctx *smartcard.Card, size int) (data []byte, err error) {
+	for off := 0; off < size; {
+		n := size - off
+		if n > 0xFF {
+			n = 0xFF
+		}
+		cmd := append([]byte{}, SIM_CMD_READ_BIN...)
+		cmd[0], cmd[2], cmd[3] = byte(USIM_CLA), byte(off>>8), byte(off)
+		cmd = append(cmd, byte(n))
+		var resp []byte
+		if resp, err = ctx.Transmit(cmd); err != nil {
+			return nil, errors.New("transmit read binary cmd failed")
+		}
+		if len(resp) != n+2 || resp[n] != 0x90 || resp[n+1] != 0x00 {
+			return nil, fmt.Errorf("SCARD: unexpected read binary response %X", resp)
+		}
+		data = append(data, resp[:n]...)
+		off += n
+	}
+	return
+}
+
+func checkUpdateStatus(resp []byte) error {
+	if len(resp) != 2 {
+		return fmt.Errorf("SCARD: unexpected update response %X", resp)
+	}
+	if resp[0] == 0x98 && resp[1] == 0x04 || resp[0] == 0x69 && resp[1] == 0x82 {
+		return errors.New("SCARD: Security status not satisfied")
+	}
+	if resp[0] != 0x90 || resp[1] != 0x00 {
+		return fmt.Errorf("SCARD: update returned unexpected status %02x %02x", resp[0], resp[1])
+	}
+	return nil
+}
+
+func update_binary(ctx *smartcard.Card, data []byte) error {
+	for off := 0; off < len(data); {
+		n := len(data) - off
+		if n > 0xFF {
+			n = 0xFF
+		}
+		cmd := append([]byte{}, SIM_CMD_UPDATE_BIN...)
+		cmd[0], cmd[2], cmd[3] = byte(USIM_CLA), byte(off>>8), byte(off)
+		cmd = append(cmd, byte(n))
+		cmd = append(cmd, data[off:off+n]...)
+		resp, err := ctx.Transmit(cmd)
+		if err != nil {
+			return errors.New("transmit update binary cmd failed")
+		}
+		if err = checkUpdateStatus(resp); err != nil {
+			return err
+		}
+		off += n
+	}
+	return nil
+}
+
+func update_record(ctx *smartcard.Card, recnum, mode int, data []byte) error {
+	cmd := append([]byte{}, SIM_CMD_UPDATE_REC...)
+	cmd[0], cmd[2], cmd[3] = byte(USIM_CLA), byte(recnum), byte(mode)
+	cmd = append(cmd, byte(len(data)))
+	cmd = append(cmd, data...)
+	resp, err := ctx.Transmit(cmd)
+	if err != nil {
+		return errors.New("transmit update record cmd failed")
+	}
+	return checkUpdateStatus(resp)
+}
+
+// readEF reads a transparent file as a single record or all records of a record file
+func readEF(ctx *smartcard.Card, aid []byte, info EFInfo) (records [][]byte, err error) {
+	var resp []byte
+	if resp, err = selectEF(ctx, aid, info); err != nil {
+		return
+	}
+	structure, size, recLen, nrec, err := parseFCP(resp)
+	if err != nil {
+		return
+	}
+	logrus.Debugf("SCARD: %s structure %d size %d record length %d", info.Name, structure, size, recLen)
+	if structure == EF_TRANSPARENT {
+		var data []byte
+		if data, err = read_binary(ctx, size); err != nil {
+			return
+		}
+		return [][]byte{data}, nil
+	}
+	if nrec == 0 && recLen > 0 {
+		nrec = size / recLen
+	}
+	for rec := 1; rec <= nrec; rec++ {
+		if resp, err = get_record(ctx, recLen, rec, SIM_RECORD_MODE_ABSOLUTE); err != nil {
+			return nil, fmt.Errorf("SCARD: reading record %d of %s failed: %v", rec, info.Name, err)
+		}
+		records = append(records, resp[:recLen])
+	}
+	return
+}
+
+// updateEF writes a transparent file or the records of a record file. Records
+// are padded with 'FF' to the record length, records beyond the given ones
+// are left unchanged. Cyclic files get the records written oldest first.
+func updateEF(ctx *smartcard.Card, aid []byte, info EFInfo, records [][]byte) (err error) {
+	var resp []byte
+	if resp, err = selectEF(ctx, aid, info); err != nil {
+		return
+	}
+	structure, size, recLen, nrec, err := parseFCP(resp)
+	if err != nil {
+		return
+	}
+	if structure == EF_TRANSPARENT {
+		if len(records) != 1 || len(records[0]) > size {
+			return fmt.Errorf("SCARD: %s content does not fit %d bytes", info.Name, size)
+		}
+		return update_binary(ctx, records[0])
+	}
+	if nrec == 0 && recLen > 0 {
+		nrec = size / recLen
+	}
+	if len(records) > nrec {
+		return fmt.Errorf("SCARD: %s has %d records, %d given", info.Name, nrec, len(records))
+	}
+	for i := range records {
+		r, mode, recnum := records[i], SIM_RECORD_MODE_ABSOLUTE, i+1
+		if structure == EF_CYCLIC {
+			// record 1 is the most recent one, write the oldest first
+			r, mode, recnum = records[len(records)-1-i], SIM_RECORD_MODE_PREVIOUS, 0
+		}
+		if len(r) > recLen {
+			return fmt.Errorf("SCARD: record of %s longer than %d bytes", info.Name, recLen)
+		}
+		rec := append(append([]byte{}, r...), bytes.Repeat([]byte{0xFF}, recLen-len(r))...)
+		if err = update_record(ctx, recnum, mode, rec); err != nil {
+			return fmt.Errorf("SCARD: updating %s failed: %v", info.Name, err)
+		}
+	}
+	return
+}
+
+// findAppAid returns the AID of the application with the given application
+// code from EF_DIR, see TS 102 221 13.1
+func findAppAid(ctx *smartcard.Card, code []byte) (aid []byte, err error) {
+	records, err := readEF(ctx, nil, efInfo("EF_DIR"))
+	if err != nil {
+		return
+	}
+	for _, r := range records {
+		if len(r) < 4 || r[0] != 0x61 || r[2] != 0x4F || int(r[3]) > len(r)-4 || r[3] < 7 {
+			continue
+		}
+		if a := r[4 : 4+int(r[3])]; bytes.Equal(a[5:7], code) {
+			return a, nil
+		}
+	}
+	return nil, fmt.Errorf("SCARD: application %X %w in EF_DIR", code, FILE_NOT_FOUND)
+}
+
+// verify_pin presents a PIN to the application aid, key reference 0x01 is
+// PIN1 and 0x81 PIN2 of the USIM
+func verify_pin(ctx *smartcard.Card, aid []byte, ref int, pin string) (err error) {
+	if len(pin) < 4 || len(pin) > 8 || !isDigits(pin) {
+		return errors.New("SCARD: PIN must have 4 to 8 digits")
+	}
+	if _, err = _select_file(ctx, SCARD_FILE_MF, SCARD_USIM, nil); err != nil {
+		return
+	}
+	if _, err = _select_file(ctx, 0, SCARD_USIM, aid); err != nil {
+		return
+	}
+	cmd := append([]byte{}, USIM_CMD_VERIFY...)
+	cmd[3] = byte(ref)
+	cmd = append(cmd, pin...)
+	cmd = append(cmd, bytes.Repeat([]byte{0xFF}, 8-len(pin))...)
+	resp, err := ctx.Transmit(cmd)
+	if err != nil {
+		return errors.New("transmit verify cmd failed")
+	}
+	switch {
+	case len(resp) != 2:
+		return fmt.Errorf("SCARD: unexpected verify response %X", resp)
+	case resp[0] == 0x90 && resp[1] == 0x00:
+		return nil
+	case resp[0] == 0x63 && resp[1]&0xF0 == 0xC0:
+		return fmt.Errorf("SCARD: wrong PIN, %d attempts left", resp[1]&0x0F)
+	case resp[0] == 0x69 && resp[1] == 0x83:
+		return errors.New("SCARD: PIN blocked")
+	}
+	return fmt.Errorf("SCARD: verify returned unexpected status %02x %02x", resp[0], resp[1])
+}
@@ -107,7 +107,11 @@ func TestReadMSISDN(t *testing.T) {
 		logrus.Fatalln("[Card Connect]", err)
 	}
 	defer card.Disconnect()
-	if resp, err = getMSISDN(card); err != nil {
+	aid, err := selectAid(card)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err = getMSISDN(card, aid); err != nil {
 		t.Error(err)
 	}
 	logrus.Debug(resp)
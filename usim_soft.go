@@ -7,8 +7,8 @@ import "C"
 import (
 	"encoding/hex"
 	"errors"
+	"fmt"
 	"log"
-	"strings"
 	"unsafe"
 )
 
@@ -69,17 +69,11 @@ func convert_op(op string) (op_ [16]byte, err error) {
 }
 
 func extract_mcc_mnc(imsi string) (mcc, mnc string, err error) {
-	if len(imsi) != 15 {
-		err = errors.New("extract mcc/mnc failed")
-		return
+	n := MNCLength(imsi)
+	if n == 0 {
+		return "", "", fmt.Errorf("MNC length of %s unknown", imsi[:6])
 	}
-	mccLen := 3
-	mncLen := 2
-	// US MCC uses 3 MNC digits
-	if strings.Compare(imsi[:3], "310") == 0 || strings.Compare(imsi[:3], "311") == 0 || strings.Compare(imsi[:3], "312") == 0 || strings.Compare(imsi[:3], "313") == 0 || strings.Compare(imsi[:3], "310") == 0 || strings.Compare(imsi[:3], "316") == 0 {
-		mncLen = 3
-	}
-	return imsi[:mccLen], imsi[mccLen : mccLen+mncLen], nil
+	return splitIMSI(imsi, n)
 }
 
 // convert_mcc_mnc
@@ -113,6 +107,20 @@ func convert_mcc_mnc(mcc, mnc string) (mcc_ uint16, mnc_ uint16, err error) {
 	return mcc_, mnc_, nil
 }
 
+// encode_plmn encodes BCD MCC/MNC into the 3 octet PLMN identity of TS 24.008 10.5.1.3
+func encode_plmn(mcc, mnc uint16) (plmn [3]byte) {
+	var mnc1, mnc2, mnc3 uint16
+	if mnc&0x0F00 == 0x0F00 {
+		mnc1, mnc2, mnc3 = (mnc>>4)&0x0F, mnc&0x0F, 0x0F
+	} else {
+		mnc1, mnc2, mnc3 = (mnc>>8)&0x0F, (mnc>>4)&0x0F, mnc&0x0F
+	}
+	plmn[0] = byte(((mcc>>4)&0x0F)<<4 | (mcc>>8)&0x0F)
+	plmn[1] = byte(mnc3<<4 | mcc&0x0F)
+	plmn[2] = byte(mnc2<<4 | mnc1)
+	return
+}
+
 func (u *USIM) compute_opc() {
 	C.compute_opc((*C.uchar)(&u.k[0]), (*C.uchar)(&u.op[0]), (*C.uchar)(&u.opc[0]))
 }
@@ -0,0 +1,217 @@
+package usim_go
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Responder for the external SIM interface of wpa_supplicant (external_sim=1).
+// wpa_supplicant asks for GSM/UMTS authentication on its control interface:
+//
+//	CTRL-REQ-SIM-<id>:GSM-AUTH:<RAND1>:<RAND2>[:<RAND3>] needed for SSID <ssid>
+//	CTRL-REQ-SIM-<id>:UMTS-AUTH:<RAND>:<AUTN> needed for SSID <ssid>
+//
+// and expects one of
+//
+//	CTRL-RSP-SIM-<id>:GSM-AUTH:<Kc1>:<SRES1>:<Kc2>:<SRES2>[:<Kc3>:<SRES3>]
+//	CTRL-RSP-SIM-<id>:UMTS-AUTH:<IK>:<CK>:<RES>
+//	CTRL-RSP-SIM-<id>:UMTS-AUTS:<AUTS>
+//
+// Any other answer, UMTS-FAIL is used here, makes wpa_supplicant reject the authentication.
+
+const (
+	WPAS_CTRL_REQ_SIM = "CTRL-REQ-SIM-"
+	WPAS_CTRL_RSP_SIM = "CTRL-RSP-SIM-"
+	WPAS_GSM_AUTH     = "GSM-AUTH"
+	WPAS_UMTS_AUTH    = "UMTS-AUTH"
+	WPAS_UMTS_AUTS    = "UMTS-AUTS"
+	WPAS_UMTS_FAIL    = "UMTS-FAIL"
+
+	wpasCtrlBufLen  = 4096
+	wpasCtrlTimeout = 5 * time.Second
+)
+
+type ExtSIMResponder struct {
+	usim     *USIM
+	ctrlPath string
+
+	mu        sync.Mutex
+	conn      *net.UnixConn
+	localPath string
+}
+
+// NewExtSIMResponder creates a responder for the wpa_supplicant control
+// socket ctrlPath, e.g. /var/run/wpa_supplicant/wlan0
+func NewExtSIMResponder(u *USIM, ctrlPath string) *ExtSIMResponder {
+	return &ExtSIMResponder{usim: u, ctrlPath: ctrlPath}
+}
+
+// HandleRequest answers a CTRL-REQ-SIM event, ok is false for other events
+func (r *ExtSIMResponder) HandleRequest(event string) (cmd string, ok bool, err error) {
+	// strip the "<level>" prefix of unsolicited messages
+	if strings.HasPrefix(event, "<") {
+		if i := strings.IndexByte(event, '>'); i > 0 {
+			event = event[i+1:]
+		}
+	}
+	if !strings.HasPrefix(event, WPAS_CTRL_REQ_SIM) {
+		return "", false, nil
+	}
+	event = strings.TrimPrefix(event, WPAS_CTRL_REQ_SIM)
+	if i := strings.Index(event, " needed for SSID"); i >= 0 {
+		event = event[:i]
+	}
+	fields := strings.Split(strings.TrimSpace(event), ":")
+	if len(fields) < 2 {
+		return "", true, fmt.Errorf("wpa_supplicant: malformed SIM request %q", event)
+	}
+	id, method, params := fields[0], fields[1], fields[2:]
+	var rsp string
+	switch method {
+	case WPAS_GSM_AUTH:
+		rsp, err = r.gsmAuth(params)
+	case WPAS_UMTS_AUTH:
+		rsp, err = r.umtsAuth(params)
+	default:
+		err = fmt.Errorf("wpa_supplicant: unsupported SIM request %s", method)
+	}
+	if err != nil {
+		return "", true, err
+	}
+	return WPAS_CTRL_RSP_SIM + id + ":" + rsp, true, nil
+}
+
+func (r *ExtSIMResponder) gsmAuth(rands []string) (string, error) {
+	if len(rands) < EAP_SIM_MIN_RAND || len(rands) > EAP_SIM_MAX_RAND {
+		return "", fmt.Errorf("wpa_supplicant: GSM-AUTH with %d RANDs", len(rands))
+	}
+	rsp := WPAS_GSM_AUTH
+	for _, v := range rands {
+		rand, err := hex.DecodeString(v)
+		if err != nil || len(rand) != GSM_RAND_LEN {
+			return "", fmt.Errorf("wpa_supplicant: invalid RAND %q", v)
+		}
+		sres, kc, err := r.usim.GenGSMAlg(rand)
+		if err != nil {
+			return "", err
+		}
+		rsp += ":" + hex.EncodeToString(kc) + ":" + hex.EncodeToString(sres)
+	}
+	return rsp, nil
+}
+
+func (r *ExtSIMResponder) umtsAuth(params []string) (string, error) {
+	if len(params) != 2 {
+		return "", errors.New("wpa_supplicant: UMTS-AUTH needs RAND and AUTN")
+	}
+	var rand, autn [16]byte
+	for i, v := range params {
+		b, err := hex.DecodeString(v)
+		if err != nil || len(b) != 16 {
+			return "", fmt.Errorf("wpa_supplicant: invalid UMTS-AUTH parameter %q", v)
+		}
+		if i == 0 {
+			copy(rand[:], b)
+		} else {
+			copy(autn[:], b)
+		}
+	}
+	res, ik, ck, auts, err := r.usim.GenAuthResMilenage(rand, autn)
+	if err == UNSYNC {
+		return WPAS_UMTS_AUTS + ":" + hex.EncodeToString(auts), nil
+	} else if err != nil {
+		logrus.Error("wpa_supplicant: ", err)
+		return WPAS_UMTS_FAIL, nil
+	}
+	return fmt.Sprintf("%s:%s:%s:%s", WPAS_UMTS_AUTH, hex.EncodeToString(ik), hex.EncodeToString(ck), hex.EncodeToString(res)), nil
+}
+
+// connect opens the control socket and attaches to the event monitor
+func (r *ExtSIMResponder) connect() (err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.localPath = filepath.Join(os.TempDir(), fmt.Sprintf("usim_go_wpa_ctrl_%d_%d", os.Getpid(), time.Now().UnixNano()))
+	laddr := &net.UnixAddr{Name: r.localPath, Net: "unixgram"}
+	raddr := &net.UnixAddr{Name: r.ctrlPath, Net: "unixgram"}
+	if r.conn, err = net.DialUnix("unixgram", laddr, raddr); err != nil {
+		return
+	}
+	if _, err = r.conn.Write([]byte("ATTACH")); err != nil {
+		return
+	}
+	buf := make([]byte, wpasCtrlBufLen)
+	r.conn.SetReadDeadline(time.Now().Add(wpasCtrlTimeout))
+	defer r.conn.SetReadDeadline(time.Time{})
+	for {
+		var n int
+		if n, err = r.conn.Read(buf); err != nil {
+			return fmt.Errorf("wpa_supplicant: ATTACH failed: %v", err)
+		}
+		switch strings.TrimSpace(string(buf[:n])) {
+		case "OK":
+			logrus.Info("wpa_supplicant: attached to ", r.ctrlPath)
+			return nil
+		case "FAIL":
+			return errors.New("wpa_supplicant: ATTACH rejected")
+		}
+	}
+}
+
+// Run attaches to wpa_supplicant and answers SIM requests until Close is
+// called, wpa_supplicant terminates or the socket fails.
+func (r *ExtSIMResponder) Run() error {
+	if err := r.connect(); err != nil {
+		r.Close()
+		return err
+	}
+	defer r.Close()
+	r.mu.Lock()
+	conn := r.conn
+	r.mu.Unlock()
+	buf := make([]byte, wpasCtrlBufLen)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return err
+		}
+		event := string(buf[:n])
+		if strings.Contains(event, "CTRL-EVENT-TERMINATING") {
+			return errors.New("wpa_supplicant: terminating")
+		}
+		cmd, ok, err := r.HandleRequest(event)
+		if !ok {
+			continue
+		}
+		if err != nil {
+			logrus.Error(err)
+			continue
+		}
+		logrus.Debug("wpa_supplicant: ", cmd)
+		if _, err = conn.Write([]byte(cmd)); err != nil {
+			return err
+		}
+	}
+}
+
+// Close detaches from wpa_supplicant and removes the local socket
+func (r *ExtSIMResponder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.conn == nil {
+		return nil
+	}
+	r.conn.Write([]byte("DETACH"))
+	err := r.conn.Close()
+	os.Remove(r.localPath)
+	r.conn = nil
+	return err
+}
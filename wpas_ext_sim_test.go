@@ -0,0 +1,83 @@
+package usim_go
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExtSIMResponder(t *testing.T) {
+	u, store := testSubscriber(t)
+	var q Quintet
+	var tr Triplet
+	store.Lookup("208930000000001", func(a *AuC) (err error) {
+		if q, err = a.GenQuintet(); err != nil {
+			return
+		}
+		tr, err = a.GenTriplet()
+		return
+	})
+
+	// fake wpa_supplicant control socket
+	ctrlPath := filepath.Join(t.TempDir(), "wlan0")
+	wpas, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: ctrlPath, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wpas.Close()
+	r := NewExtSIMResponder(u, ctrlPath)
+	done := make(chan error, 1)
+	go func() { done <- r.Run() }()
+
+	buf := make([]byte, 4096)
+	var client *net.UnixAddr
+	read := func() string {
+		if err := wpas.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+			t.Fatal(err)
+		}
+		n, from, err := wpas.ReadFromUnix(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client = from
+		return string(buf[:n])
+	}
+	write := func(msg string) {
+		if _, err := wpas.WriteToUnix([]byte(msg), client); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if msg := read(); msg != "ATTACH" {
+		t.Fatalf("expect ATTACH, got %q", msg)
+	}
+	write("OK\n")
+
+	req := fmt.Sprintf("<3>CTRL-REQ-SIM-1:UMTS-AUTH:%x:%x needed for SSID test", q.RAND, q.AUTN)
+	write(req)
+	expect := fmt.Sprintf("CTRL-RSP-SIM-1:UMTS-AUTH:%x:%x:%x", q.IK, q.CK, q.XRES)
+	if msg := read(); msg != expect {
+		t.Errorf("expect %s, got %s", expect, msg)
+	}
+
+	req = fmt.Sprintf("<3>CTRL-REQ-SIM-2:GSM-AUTH:%x:%x needed for SSID test", tr.RAND, tr.RAND)
+	write(req)
+	kcSres := hex.EncodeToString(tr.Kc[:]) + ":" + hex.EncodeToString(tr.SRES[:])
+	expect = "CTRL-RSP-SIM-2:GSM-AUTH:" + kcSres + ":" + kcSres
+	if msg := read(); msg != expect {
+		t.Errorf("expect %s, got %s", expect, msg)
+	}
+
+	write("<2>CTRL-EVENT-TERMINATING")
+	select {
+	case err = <-done:
+		if err == nil || !strings.Contains(err.Error(), "terminating") {
+			t.Error("unexpected Run result ", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return")
+	}
+}
@@ -0,0 +1,174 @@
+package usim_go
+
+import "encoding/binary"
+
+// ZUC stream cipher of ETSI/SAGE 128-EEA3 & 128-EIA3 Document 2, used by
+// 128-EEA3 and 128-EIA3.
+
+var zucD = [16]uint32{
+	0x44D7, 0x26BC, 0x626B, 0x135E, 0x5789, 0x35E2, 0x7135, 0x09AF,
+	0x4D78, 0x2F13, 0x6BC4, 0x1AF1, 0x5E26, 0x3C4D, 0x789A, 0x47AC,
+}
+
+type zuc struct {
+	s              [16]uint32
+	r1, r2         uint32
+	x0, x1, x2, x3 uint32
+}
+
+func zucAdd(a, b uint32) uint32 {
+	c := a + b
+	return c&0x7FFFFFFF + c>>31
+}
+
+func zucRot31(x uint32, k uint) uint32 {
+	return (x<<k | x>>(31-k)) & 0x7FFFFFFF
+}
+
+func rotl32(x uint32, k uint) uint32 {
+	return x<<k | x>>(32-k)
+}
+
+func zucL1(x uint32) uint32 {
+	return x ^ rotl32(x, 2) ^ rotl32(x, 10) ^ rotl32(x, 18) ^ rotl32(x, 24)
+}
+
+func zucL2(x uint32) uint32 {
+	return x ^ rotl32(x, 8) ^ rotl32(x, 14) ^ rotl32(x, 22) ^ rotl32(x, 30)
+}
+
+func zucS(x uint32) uint32 {
+	return uint32(zucS0[x>>24])<<24 | uint32(zucS1[x>>16&0xff])<<16 | uint32(zucS0[x>>8&0xff])<<8 | uint32(zucS1[x&0xff])
+}
+
+func (z *zuc) bitReorganization() {
+	s := &z.s
+	z.x0 = (s[15]&0x7FFF8000)<<1 | s[14]&0xFFFF
+	z.x1 = (s[11]&0xFFFF)<<16 | s[9]>>15
+	z.x2 = (s[7]&0xFFFF)<<16 | s[5]>>15
+	z.x3 = (s[2]&0xFFFF)<<16 | s[0]>>15
+}
+
+func (z *zuc) f() (w uint32) {
+	w = (z.x0 ^ z.r1) + z.r2
+	w1 := z.r1 + z.x1
+	w2 := z.r2 ^ z.x2
+	z.r1 = zucS(zucL1(w1<<16 | w2>>16))
+	z.r2 = zucS(zucL2(w2<<16 | w1>>16))
+	return
+}
+
+func (z *zuc) clockLFSR(u uint32) {
+	s := &z.s
+	v := s[0]
+	v = zucAdd(v, zucRot31(s[0], 8))
+	v = zucAdd(v, zucRot31(s[4], 20))
+	v = zucAdd(v, zucRot31(s[10], 21))
+	v = zucAdd(v, zucRot31(s[13], 17))
+	v = zucAdd(v, zucRot31(s[15], 15))
+	v = zucAdd(v, u)
+	if v == 0 {
+		v = 0x7FFFFFFF
+	}
+	copy(s[:15], s[1:])
+	s[15] = v
+}
+
+func newZUC(key, iv []byte) *zuc {
+	z := &zuc{}
+	for i := 0; i < 16; i++ {
+		z.s[i] = uint32(key[i])<<23 | zucD[i]<<8 | uint32(iv[i])
+	}
+	for i := 0; i < 32; i++ {
+		z.bitReorganization()
+		z.clockLFSR(z.f() >> 1)
+	}
+	z.bitReorganization()
+	z.f()
+	z.clockLFSR(0)
+	return z
+}
+
+func (z *zuc) keystream(n int) []uint32 {
+	out := make([]uint32, n)
+	for i := range out {
+		z.bitReorganization()
+		out[i] = z.f() ^ z.x3
+		z.clockLFSR(0)
+	}
+	return out
+}
+
+// ZUCEEA3 is the confidentiality algorithm 128-EEA3, the first length bits of data are ciphered
+func ZUCEEA3(key []byte, count uint32, bearer, dir byte, data []byte, length int) []byte {
+	var iv [16]byte
+	binary.BigEndian.PutUint32(iv[:], count)
+	iv[4] = bearer<<3 | (dir&1)<<2
+	copy(iv[8:], iv[:8])
+	return xorKeystream(newZUC(key, iv[:]).keystream((length+31)/32), data, length)
+}
+
+// ZUCEIA3 is the integrity algorithm 128-EIA3 over the first length bits of data
+func ZUCEIA3(key []byte, count uint32, bearer, dir byte, data []byte, length int) (mac [4]byte) {
+	var iv [16]byte
+	binary.BigEndian.PutUint32(iv[:], count)
+	iv[4] = bearer << 3
+	copy(iv[8:], iv[:8])
+	iv[8] ^= (dir & 1) << 7
+	iv[14] ^= (dir & 1) << 7
+	z := newZUC(key, iv[:]).keystream((length+64+31)/32 + 1)
+	word := func(i int) uint32 {
+		j, k := i/32, uint(i%32)
+		if k == 0 {
+			return z[j]
+		}
+		return z[j]<<k | z[j+1]>>(32-k)
+	}
+	var t uint32
+	for i := 0; i < length; i++ {
+		if data[i/8]>>(7-i%8)&1 != 0 {
+			t ^= word(i)
+		}
+	}
+	t ^= word(length)
+	l := (length + 64 + 31) / 32
+	binary.BigEndian.PutUint32(mac[:], t^z[l-1])
+	return
+}
+
+var zucS0 = [256]byte{
+	0x3E, 0x72, 0x5B, 0x47, 0xCA, 0xE0, 0x00, 0x33, 0x04, 0xD1, 0x54, 0x98, 0x09, 0xB9, 0x6D, 0xCB,
+	0x7B, 0x1B, 0xF9, 0x32, 0xAF, 0x9D, 0x6A, 0xA5, 0xB8, 0x2D, 0xFC, 0x1D, 0x08, 0x53, 0x03, 0x90,
+	0x4D, 0x4E, 0x84, 0x99, 0xE4, 0xCE, 0xD9, 0x91, 0xDD, 0xB6, 0x85, 0x48, 0x8B, 0x29, 0x6E, 0xAC,
+	0xCD, 0xC1, 0xF8, 0x1E, 0x73, 0x43, 0x69, 0xC6, 0xB5, 0xBD, 0xFD, 0x39, 0x63, 0x20, 0xD4, 0x38,
+	0x76, 0x7D, 0xB2, 0xA7, 0xCF, 0xED, 0x57, 0xC5, 0xF3, 0x2C, 0xBB, 0x14, 0x21, 0x06, 0x55, 0x9B,
+	0xE3, 0xEF, 0x5E, 0x31, 0x4F, 0x7F, 0x5A, 0xA4, 0x0D, 0x82, 0x51, 0x49, 0x5F, 0xBA, 0x58, 0x1C,
+	0x4A, 0x16, 0xD5, 0x17, 0xA8, 0x92, 0x24, 0x1F, 0x8C, 0xFF, 0xD8, 0xAE, 0x2E, 0x01, 0xD3, 0xAD,
+	0x3B, 0x4B, 0xDA, 0x46, 0xEB, 0xC9, 0xDE, 0x9A, 0x8F, 0x87, 0xD7, 0x3A, 0x80, 0x6F, 0x2F, 0xC8,
+	0xB1, 0xB4, 0x37, 0xF7, 0x0A, 0x22, 0x13, 0x28, 0x7C, 0xCC, 0x3C, 0x89, 0xC7, 0xC3, 0x96, 0x56,
+	0x07, 0xBF, 0x7E, 0xF0, 0x0B, 0x2B, 0x97, 0x52, 0x35, 0x41, 0x79, 0x61, 0xA6, 0x4C, 0x10, 0xFE,
+	0xBC, 0x26, 0x95, 0x88, 0x8A, 0xB0, 0xA3, 0xFB, 0xC0, 0x18, 0x94, 0xF2, 0xE1, 0xE5, 0xE9, 0x5D,
+	0xD0, 0xDC, 0x11, 0x66, 0x64, 0x5C, 0xEC, 0x59, 0x42, 0x75, 0x12, 0xF5, 0x74, 0x9C, 0xAA, 0x23,
+	0x0E, 0x86, 0xAB, 0xBE, 0x2A, 0x02, 0xE7, 0x67, 0xE6, 0x44, 0xA2, 0x6C, 0xC2, 0x93, 0x9F, 0xF1,
+	0xF6, 0xFA, 0x36, 0xD2, 0x50, 0x68, 0x9E, 0x62, 0x71, 0x15, 0x3D, 0xD6, 0x40, 0xC4, 0xE2, 0x0F,
+	0x8E, 0x83, 0x77, 0x6B, 0x25, 0x05, 0x3F, 0x0C, 0x30, 0xEA, 0x70, 0xB7, 0xA1, 0xE8, 0xA9, 0x65,
+	0x8D, 0x27, 0x1A, 0xDB, 0x81, 0xB3, 0xA0, 0xF4, 0x45, 0x7A, 0x19, 0xDF, 0xEE, 0x78, 0x34, 0x60,
+}
+var zucS1 = [256]byte{
+	0x55, 0xC2, 0x63, 0x71, 0x3B, 0xC8, 0x47, 0x86, 0x9F, 0x3C, 0xDA, 0x5B, 0x29, 0xAA, 0xFD, 0x77,
+	0x8C, 0xC5, 0x94, 0x0C, 0xA6, 0x1A, 0x13, 0x00, 0xE3, 0xA8, 0x16, 0x72, 0x40, 0xF9, 0xF8, 0x42,
+	0x44, 0x26, 0x68, 0x96, 0x81, 0xD9, 0x45, 0x3E, 0x10, 0x76, 0xC6, 0xA7, 0x8B, 0x39, 0x43, 0xE1,
+	0x3A, 0xB5, 0x56, 0x2A, 0xC0, 0x6D, 0xB3, 0x05, 0x22, 0x66, 0xBF, 0xDC, 0x0B, 0xFA, 0x62, 0x48,
+	0xDD, 0x20, 0x11, 0x06, 0x36, 0xC9, 0xC1, 0xCF, 0xF6, 0x27, 0x52, 0xBB, 0x69, 0xF5, 0xD4, 0x87,
+	0x7F, 0x84, 0x4C, 0xD2, 0x9C, 0x57, 0xA4, 0xBC, 0x4F, 0x9A, 0xDF, 0xFE, 0xD6, 0x8D, 0x7A, 0xEB,
+	0x2B, 0x53, 0xD8, 0x5C, 0xA1, 0x14, 0x17, 0xFB, 0x23, 0xD5, 0x7D, 0x30, 0x67, 0x73, 0x08, 0x09,
+	0xEE, 0xB7, 0x70, 0x3F, 0x61, 0xB2, 0x19, 0x8E, 0x4E, 0xE5, 0x4B, 0x93, 0x8F, 0x5D, 0xDB, 0xA9,
+	0xAD, 0xF1, 0xAE, 0x2E, 0xCB, 0x0D, 0xFC, 0xF4, 0x2D, 0x46, 0x6E, 0x1D, 0x97, 0xE8, 0xD1, 0xE9,
+	0x4D, 0x37, 0xA5, 0x75, 0x5E, 0x83, 0x9E, 0xAB, 0x82, 0x9D, 0xB9, 0x1C, 0xE0, 0xCD, 0x49, 0x89,
+	0x01, 0xB6, 0xBD, 0x58, 0x24, 0xA2, 0x5F, 0x38, 0x78, 0x99, 0x15, 0x90, 0x50, 0xB8, 0x95, 0xE4,
+	0xD0, 0x91, 0xC7, 0xCE, 0xED, 0x0F, 0xB4, 0x6F, 0xA0, 0xCC, 0xF0, 0x02, 0x4A, 0x79, 0xC3, 0xDE,
+	0xA3, 0xEF, 0xEA, 0x51, 0xE6, 0x6B, 0x18, 0xEC, 0x1B, 0x2C, 0x80, 0xF7, 0x74, 0xE7, 0xFF, 0x21,
+	0x5A, 0x6A, 0x54, 0x1E, 0x41, 0x31, 0x92, 0x35, 0xC4, 0x33, 0x07, 0x0A, 0xBA, 0x7E, 0x0E, 0x34,
+	0x88, 0xB1, 0x98, 0x7C, 0xF3, 0x3D, 0x60, 0x6C, 0x7B, 0xCA, 0xD3, 0x1F, 0x32, 0x65, 0x04, 0x28,
+	0x64, 0xBE, 0x85, 0x9B, 0x2F, 0x59, 0x8A, 0xD7, 0xB0, 0x25, 0xAC, 0xAF, 0x12, 0x03, 0xE2, 0xF2,
+}